@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"net"
+	"net/http"
+	"os"
+)
+
+// InternalTokenHeader carries INTERNAL_TOKEN on the calls the HTTP service
+// and the ws server make to each other's /internal endpoints.
+const InternalTokenHeader = "X-Internal-Token"
+
+// InternalToken returns the secret the two services share. While
+// INTERNAL_TOKEN is unset every internal call is refused.
+func InternalToken() string {
+	return os.Getenv("INTERNAL_TOKEN")
+}
+
+// IsInternal tells the other service apart from everyone else. Coming
+// from the same host is not enough on its own: a reverse proxy on the
+// host makes every proxied request look local.
+func IsInternal(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil || !net.ParseIP(host).IsLoopback() {
+		return false
+	}
+	token := InternalToken()
+	return token != "" && hmac.Equal([]byte(r.Header.Get(InternalTokenHeader)), []byte(token))
+}
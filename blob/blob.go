@@ -0,0 +1,68 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	"go-gather/db"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Storage keeps uploaded files. Keys are chosen by the server and only use
+// letters, digits, '-', '_', '.' and '/'.
+type Storage interface {
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	// Get returns ErrNotFound for keys that were never stored.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+var (
+	instance Storage
+	once     sync.Once
+)
+
+// Get returns the storage selected by BLOB_DRIVER: "local" (the default)
+// keeps files under BLOB_DIR, "s3" talks to any S3-compatible service
+// configured through the S3_* variables.
+func Get() Storage {
+	once.Do(func() {
+		db.LoadEnv()
+
+		driver := os.Getenv("BLOB_DRIVER")
+		switch driver {
+		case "", "local":
+			dir := os.Getenv("BLOB_DIR")
+			if dir == "" {
+				dir = "uploads"
+			}
+			local, err := NewLocal(dir)
+			if err != nil {
+				log.Fatalf("Unable to open blob directory %s: %v", dir, err)
+			}
+			instance = local
+			log.Printf("Storing uploads in %s", dir)
+		case "s3":
+			s3, err := NewS3(S3ConfigFromEnv())
+			if err != nil {
+				log.Fatalf("Unable to configure S3 storage: %v", err)
+			}
+			instance = s3
+			log.Println("Storing uploads in S3")
+		default:
+			log.Fatalf("Unknown BLOB_DRIVER %q", driver)
+		}
+	})
+	return instance
+}
+
+// Set replaces the storage returned by Get, for tests.
+func Set(s Storage) {
+	once.Do(func() {})
+	instance = s
+}
@@ -0,0 +1,100 @@
+// Package clientip works out which address a request came from. Behind a
+// reverse proxy that is in the X-Forwarded-For header, but anyone can send
+// that header, so it is only believed when the proxy is a known one.
+package clientip
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	trusted     []*net.IPNet
+	trustedOnce sync.Once
+)
+
+// trustedProxies reads TRUSTED_PROXIES once: a comma-separated list of
+// addresses or CIDR ranges, such as "10.0.0.0/8,127.0.0.1". It is empty
+// by default, which ignores X-Forwarded-For altogether.
+func trustedProxies() []*net.IPNet {
+	trustedOnce.Do(func() {
+		trusted = parseProxies(os.Getenv("TRUSTED_PROXIES"))
+	})
+	return trusted
+}
+
+func parseProxies(value string) []*net.IPNet {
+	var proxies []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Ignoring invalid TRUSTED_PROXIES entry %q", entry)
+			continue
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies
+}
+
+// FromRequest returns the IP the request came from. When the connection is
+// from a trusted proxy, X-Forwarded-For is read from the right, skipping
+// the proxies, and the first address not among them is the client's.
+func FromRequest(r *http.Request) string {
+	return fromRequest(r, trustedProxies())
+}
+
+func fromRequest(r *http.Request, proxies []*net.IPNet) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !isTrusted(ip, proxies) {
+		return ip
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			// A proxy we trust would not have written this, so whatever
+			// is left of it cannot be trusted either.
+			return ip
+		}
+		ip = hop
+		if !isTrusted(hop, proxies) {
+			return hop
+		}
+	}
+	return ip
+}
+
+func isTrusted(ip string, proxies []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range proxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
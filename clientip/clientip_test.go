@@ -0,0 +1,35 @@
+package clientip
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFromRequest(t *testing.T) {
+	proxies := parseProxies("10.0.0.0/8, 127.0.0.1")
+
+	for _, test := range []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"untrusted sender ignored", "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.1.2.3:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed entry left of the client", "10.1.2.3:5000", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of proxies", "127.0.0.1:5000", []string{"198.51.100.1, 10.0.0.5"}, "198.51.100.1"},
+		{"repeated headers", "10.1.2.3:5000", []string{"1.2.3.4", "198.51.100.1"}, "198.51.100.1"},
+		{"garbage stops the walk", "10.1.2.3:5000", []string{"198.51.100.1, nonsense"}, "10.1.2.3"},
+		{"proxy without header", "10.1.2.3:5000", nil, "10.1.2.3"},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = test.remoteAddr
+		for _, value := range test.forwarded {
+			req.Header.Add("X-Forwarded-For", value)
+		}
+		if got := fromRequest(req, proxies); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS audit_log;
+DROP TABLE IF EXISTS room_bans;
+DROP TABLE IF EXISTS room_settings;
+DROP TABLE IF EXISTS join_requests;
+DROP TABLE IF EXISTS room_invites;
+DROP TABLE IF EXISTS room_members;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,78 @@
+-- Everything the server used to create on startup. IF NOT EXISTS lets
+-- databases that predate migrations adopt this version as-is.
+CREATE TABLE IF NOT EXISTS users (
+	user_id SERIAL PRIMARY KEY,
+	email VARCHAR(255) NOT NULL UNIQUE,
+	password VARCHAR(255) NOT NULL,
+	rooms TEXT[]
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS room_members (
+	room_id VARCHAR(255) NOT NULL,
+	email VARCHAR(255) NOT NULL,
+	role VARCHAR(32) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (room_id, email)
+);
+
+CREATE TABLE IF NOT EXISTS room_invites (
+	token VARCHAR(64) PRIMARY KEY,
+	room_id VARCHAR(255) NOT NULL,
+	role VARCHAR(32) NOT NULL,
+	created_by VARCHAR(255) NOT NULL,
+	max_uses INTEGER NOT NULL DEFAULT 1,
+	uses INTEGER NOT NULL DEFAULT 0,
+	expires_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS join_requests (
+	id SERIAL PRIMARY KEY,
+	room_id VARCHAR(255) NOT NULL,
+	email VARCHAR(255) NOT NULL,
+	message TEXT NOT NULL DEFAULT '',
+	status VARCHAR(16) NOT NULL DEFAULT 'pending',
+	decided_by VARCHAR(255),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	decided_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS room_settings (
+	room_id VARCHAR(255) PRIMARY KEY,
+	guest_access BOOLEAN NOT NULL DEFAULT FALSE,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS room_bans (
+	room_id VARCHAR(255) NOT NULL,
+	email VARCHAR(255) NOT NULL,
+	banned_by VARCHAR(255) NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	expires_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (room_id, email)
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id BIGSERIAL PRIMARY KEY,
+	action VARCHAR(64) NOT NULL,
+	actor VARCHAR(255) NOT NULL DEFAULT '',
+	target VARCHAR(255) NOT NULL DEFAULT '',
+	room_id VARCHAR(255) NOT NULL DEFAULT '',
+	ip VARCHAR(64) NOT NULL DEFAULT '',
+	details JSONB,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at);
+CREATE INDEX IF NOT EXISTS audit_log_room_id_idx ON audit_log (room_id, created_at);
+
+CREATE TABLE IF NOT EXISTS messages (
+	id BIGSERIAL PRIMARY KEY,
+	room_id VARCHAR(255) NOT NULL,
+	sender_id VARCHAR(255) NOT NULL,
+	body TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS messages_room_id_idx ON messages (room_id, id);
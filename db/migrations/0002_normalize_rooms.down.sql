@@ -0,0 +1,14 @@
+ALTER TABLE users ADD COLUMN rooms TEXT[];
+
+-- Owners and moderators lose their role on the way down; the old schema
+-- only knew plain access.
+UPDATE users SET rooms = grants.rooms
+FROM (
+	SELECT email, array_agg(room_id ORDER BY room_id) AS rooms
+	FROM room_members GROUP BY email
+) grants
+WHERE users.email = grants.email;
+
+DROP INDEX IF EXISTS room_members_email_idx;
+ALTER TABLE room_members DROP CONSTRAINT IF EXISTS room_members_room_id_fkey;
+DROP TABLE rooms;
@@ -0,0 +1,24 @@
+-- Rooms become first-class rows and users.rooms is folded into
+-- room_members, where every legacy grant becomes a plain membership.
+CREATE TABLE rooms (
+	id VARCHAR(255) PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO rooms (id)
+SELECT room_id FROM room_members
+UNION
+SELECT DISTINCT unnest(rooms) FROM users WHERE rooms IS NOT NULL
+UNION
+SELECT room_id FROM room_settings
+ON CONFLICT DO NOTHING;
+
+INSERT INTO room_members (room_id, email, role)
+SELECT DISTINCT unnest(rooms), email, 'member' FROM users WHERE rooms IS NOT NULL
+ON CONFLICT (room_id, email) DO NOTHING;
+
+ALTER TABLE room_members
+	ADD CONSTRAINT room_members_room_id_fkey FOREIGN KEY (room_id) REFERENCES rooms (id) ON DELETE CASCADE;
+CREATE INDEX room_members_email_idx ON room_members (email);
+
+ALTER TABLE users DROP COLUMN rooms;
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS messages_channel_idx;
+CREATE INDEX IF NOT EXISTS messages_room_id_idx ON messages (room_id, id);
+
+ALTER TABLE messages
+	DROP COLUMN sender_name,
+	DROP COLUMN channel;
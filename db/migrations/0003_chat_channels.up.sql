@@ -0,0 +1,8 @@
+-- Chat messages are addressed to a channel inside their room: '' for the
+-- whole room, 'zone:<zone>' or 'dm:<user>|<user>'.
+ALTER TABLE messages
+	ADD COLUMN channel VARCHAR(512) NOT NULL DEFAULT '',
+	ADD COLUMN sender_name VARCHAR(255) NOT NULL DEFAULT '';
+
+DROP INDEX IF EXISTS messages_room_id_idx;
+CREATE INDEX messages_channel_idx ON messages (room_id, channel, id);
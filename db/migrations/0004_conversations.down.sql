@@ -0,0 +1,6 @@
+-- Room-scoped direct messages cannot be restored since conversations have
+-- no room; their messages are dropped along with the conversations.
+DELETE FROM messages WHERE room_id = '' AND channel LIKE 'dm:%';
+
+DROP TABLE IF EXISTS conversation_members;
+DROP TABLE IF EXISTS conversations;
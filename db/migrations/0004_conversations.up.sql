@@ -0,0 +1,39 @@
+-- Direct messages move out of rooms into conversations. Their messages
+-- keep living in the messages table with an empty room_id and the channel
+-- 'dm:<conversation id>'.
+CREATE TABLE conversations (
+	id BIGSERIAL PRIMARY KEY,
+	title VARCHAR(255) NOT NULL DEFAULT '',
+	-- '<email>|<email>' for one-to-one conversations, NULL for groups.
+	direct_key VARCHAR(511) UNIQUE,
+	created_by VARCHAR(255) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE conversation_members (
+	conversation_id BIGINT NOT NULL REFERENCES conversations (id) ON DELETE CASCADE,
+	email VARCHAR(255) NOT NULL,
+	last_read_id BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (conversation_id, email)
+);
+CREATE INDEX conversation_members_email_idx ON conversation_members (email);
+
+-- Room-scoped direct messages used the channel 'dm:<email>|<email>'.
+-- Give each pair a conversation and move its messages there.
+INSERT INTO conversations (direct_key, created_by, created_at)
+SELECT substring(channel FROM 4), MIN(sender_id), MIN(created_at)
+FROM messages
+WHERE room_id <> '' AND channel LIKE 'dm:%'
+GROUP BY substring(channel FROM 4)
+ON CONFLICT (direct_key) DO NOTHING;
+
+INSERT INTO conversation_members (conversation_id, email)
+SELECT id, split_part(direct_key, '|', 1) FROM conversations WHERE direct_key IS NOT NULL
+UNION
+SELECT id, split_part(direct_key, '|', 2) FROM conversations WHERE direct_key IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+UPDATE messages m
+SET room_id = '', channel = 'dm:' || c.id
+FROM conversations c
+WHERE m.room_id <> '' AND m.channel = 'dm:' || c.direct_key;
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS message_reactions;
+
+DROP INDEX IF EXISTS messages_reply_to_idx;
+ALTER TABLE messages
+	DROP COLUMN deleted_at,
+	DROP COLUMN edited_at,
+	DROP COLUMN reply_to;
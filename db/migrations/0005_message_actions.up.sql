@@ -0,0 +1,13 @@
+ALTER TABLE messages
+	ADD COLUMN reply_to BIGINT REFERENCES messages (id) ON DELETE SET NULL,
+	ADD COLUMN edited_at TIMESTAMPTZ,
+	ADD COLUMN deleted_at TIMESTAMPTZ;
+CREATE INDEX messages_reply_to_idx ON messages (reply_to) WHERE reply_to IS NOT NULL;
+
+CREATE TABLE message_reactions (
+	message_id BIGINT NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+	user_id VARCHAR(255) NOT NULL,
+	emoji VARCHAR(64) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (message_id, user_id, emoji)
+);
@@ -0,0 +1,8 @@
+CREATE TABLE read_markers (
+	room_id VARCHAR(255) NOT NULL,
+	channel VARCHAR(512) NOT NULL,
+	user_id VARCHAR(255) NOT NULL,
+	message_id BIGINT NOT NULL,
+	read_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (room_id, channel, user_id)
+);
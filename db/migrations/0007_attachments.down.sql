@@ -0,0 +1,3 @@
+ALTER TABLE messages DROP COLUMN attachment_ids;
+
+DROP TABLE IF EXISTS attachments;
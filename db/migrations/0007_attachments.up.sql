@@ -0,0 +1,16 @@
+CREATE TABLE attachments (
+	id VARCHAR(64) PRIMARY KEY,
+	room_id VARCHAR(255) NOT NULL,
+	uploader_id VARCHAR(255) NOT NULL,
+	file_name VARCHAR(255) NOT NULL,
+	content_type VARCHAR(255) NOT NULL,
+	size BIGINT NOT NULL,
+	width INTEGER NOT NULL DEFAULT 0,
+	height INTEGER NOT NULL DEFAULT 0,
+	blob_key VARCHAR(512) NOT NULL,
+	thumbnail_key VARCHAR(512) NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX attachments_room_id_idx ON attachments (room_id);
+
+ALTER TABLE messages ADD COLUMN attachment_ids TEXT[] NOT NULL DEFAULT '{}';
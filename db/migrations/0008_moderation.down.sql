@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS message_reviews;
+DROP TABLE IF EXISTS room_filters;
@@ -0,0 +1,19 @@
+CREATE TABLE room_filters (
+	room_id VARCHAR(255) PRIMARY KEY,
+	config JSONB NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE message_reviews (
+	id BIGSERIAL PRIMARY KEY,
+	room_id VARCHAR(255) NOT NULL,
+	message_id BIGINT NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+	sender_id VARCHAR(255) NOT NULL,
+	body TEXT NOT NULL,
+	reasons TEXT[] NOT NULL DEFAULT '{}',
+	status VARCHAR(16) NOT NULL DEFAULT 'pending',
+	reviewed_by VARCHAR(255),
+	reviewed_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX message_reviews_room_status_idx ON message_reviews (room_id, status, id);
@@ -0,0 +1,11 @@
+ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE user_tokens (
+	token_hash VARCHAR(64) PRIMARY KEY,
+	email VARCHAR(255) NOT NULL REFERENCES users (email) ON DELETE CASCADE,
+	purpose VARCHAR(32) NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	used_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX user_tokens_email_idx ON user_tokens (email, purpose);
@@ -0,0 +1,8 @@
+CREATE TABLE user_identities (
+	issuer VARCHAR(512) NOT NULL,
+	subject VARCHAR(255) NOT NULL,
+	email VARCHAR(255) NOT NULL REFERENCES users (email) ON DELETE CASCADE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (issuer, subject)
+);
+CREATE INDEX user_identities_email_idx ON user_identities (email);
@@ -0,0 +1,10 @@
+CREATE TABLE user_profiles (
+	email VARCHAR(255) PRIMARY KEY REFERENCES users (email) ON DELETE CASCADE,
+	public_id VARCHAR(32) NOT NULL UNIQUE,
+	display_name VARCHAR(64) NOT NULL,
+	avatar VARCHAR(64) NOT NULL DEFAULT '',
+	appearance JSONB NOT NULL DEFAULT '{}',
+	status VARCHAR(255) NOT NULL DEFAULT '',
+	availability VARCHAR(16) NOT NULL DEFAULT 'available',
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
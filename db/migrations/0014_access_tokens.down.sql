@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS access_tokens;
+ALTER TABLE user_profiles DROP COLUMN bot;
+DROP INDEX IF EXISTS users_bot_owner_idx;
+ALTER TABLE users DROP COLUMN bot_owner;
+ALTER TABLE users DROP COLUMN is_bot;
@@ -0,0 +1,20 @@
+ALTER TABLE users ADD COLUMN is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN bot_owner VARCHAR(255) REFERENCES users (email) ON DELETE CASCADE;
+CREATE INDEX users_bot_owner_idx ON users (bot_owner) WHERE bot_owner IS NOT NULL;
+
+ALTER TABLE user_profiles ADD COLUMN bot BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE access_tokens (
+	id VARCHAR(32) PRIMARY KEY,
+	token_hash VARCHAR(64) NOT NULL UNIQUE,
+	email VARCHAR(255) NOT NULL REFERENCES users (email) ON DELETE CASCADE,
+	name VARCHAR(64) NOT NULL,
+	scopes TEXT[] NOT NULL,
+	rooms TEXT[] NOT NULL DEFAULT '{}',
+	created_by VARCHAR(255) NOT NULL,
+	expires_at TIMESTAMPTZ,
+	last_used_at TIMESTAMPTZ,
+	revoked_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX access_tokens_email_idx ON access_tokens (email);
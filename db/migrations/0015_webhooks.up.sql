@@ -0,0 +1,24 @@
+CREATE TABLE room_webhooks (
+	id VARCHAR(32) PRIMARY KEY,
+	room_id VARCHAR(255) NOT NULL,
+	url TEXT NOT NULL,
+	secret VARCHAR(128) NOT NULL,
+	events TEXT[] NOT NULL,
+	created_by VARCHAR(255) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX room_webhooks_room_id_idx ON room_webhooks (room_id);
+
+CREATE TABLE webhook_deliveries (
+	id VARCHAR(32) PRIMARY KEY,
+	webhook_id VARCHAR(32) NOT NULL REFERENCES room_webhooks (id) ON DELETE CASCADE,
+	event VARCHAR(64) NOT NULL,
+	payload TEXT NOT NULL,
+	status VARCHAR(16) NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	status_code INTEGER NOT NULL DEFAULT 0,
+	error TEXT NOT NULL DEFAULT '',
+	last_attempt_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, created_at DESC);
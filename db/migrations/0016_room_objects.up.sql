@@ -0,0 +1,13 @@
+CREATE TABLE room_objects (
+	id VARCHAR(32) PRIMARY KEY,
+	room_id VARCHAR(255) NOT NULL,
+	type VARCHAR(32) NOT NULL,
+	x INTEGER NOT NULL,
+	y INTEGER NOT NULL,
+	properties JSONB NOT NULL DEFAULT '{}',
+	state JSONB NOT NULL DEFAULT '{}',
+	created_by VARCHAR(255) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX room_objects_room_id_idx ON room_objects (room_id);
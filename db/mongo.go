@@ -3,26 +3,48 @@ package db
 import (
 	"context"
 	"fmt"
-	"log"
 	"os"
+	"sync"
 	"time"
 
-	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func getConnection() (*mongo.Client, error) {
-	// MongoDB connection URI
+var (
+	mongoClient *mongo.Client
+	mongoLock   sync.Mutex
+)
 
-	err := godotenv.Load(".env")
+// getConnection returns the client shared by every collection. A client
+// keeps its own connection pool, so one per process is enough; a failed
+// connect is retried on the next call.
+func getConnection() (*mongo.Client, error) {
+	mongoLock.Lock()
+	defer mongoLock.Unlock()
 
+	if mongoClient != nil {
+		return mongoClient, nil
+	}
+	client, err := connectMongo()
 	if err != nil {
-		log.Fatalf("Error loading .env file")
+		return nil, err
 	}
+	mongoClient = client
+	return client, nil
+}
+
+// connectMongo connects to URI. Like the SQL connection it reads .env
+// through LoadEnv, so a deployment configured only through the
+// environment works too.
+func connectMongo() (*mongo.Client, error) {
+	LoadEnv()
 
 	uri := os.Getenv("URI")
+	if uri == "" {
+		return nil, fmt.Errorf("URI environment variable is not set")
+	}
 
 	clientOptions := options.Client().ApplyURI(uri)
 	client, err := mongo.NewClient(clientOptions)
@@ -68,13 +90,10 @@ func GetCollection(collectionName string) (*mongo.Collection, error) {
 		return nil, err
 	}
 
-	err = godotenv.Load(".env")
-
-	if err != nil {
-		log.Fatalf("Error loading .env file")
-	}
-
 	dbName := os.Getenv("DB_NAME")
+	if dbName == "" {
+		return nil, fmt.Errorf("DB_NAME environment variable is not set")
+	}
 
 	collection := dbConnection.Database(dbName).Collection(collectionName)
 	return collection, nil
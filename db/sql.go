@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
@@ -13,11 +14,21 @@ import (
 
 var dbInstance *sql.DB
 
+var loadEnvOnce sync.Once
+
+// LoadEnv reads the .env file one directory up, once per process. Variables
+// already set in the environment win.
+func LoadEnv() {
+	loadEnvOnce.Do(func() {
+		err := godotenv.Load(filepath.Join("..", ".env"))
+		if err != nil {
+			log.Printf("Error loading .env file: %v", err)
+		}
+	})
+}
+
 func ConnectionString() string {
-	err := godotenv.Load(filepath.Join("..", ".env"))
-	if err != nil {
-		log.Printf("Error loading .env file: %v", err)
-	}
+	LoadEnv()
 
 	SQL_URI := os.Getenv("SQL_URI")
 	if SQL_URI == "" {
@@ -46,44 +57,10 @@ func connect() *sql.DB {
 	return db
 }
 
-func createTable(db *sql.DB, tableName string) {
-	var exists bool
-	err := db.QueryRow(`
-		SELECT EXISTS (
-			SELECT FROM information_schema.tables 
-			WHERE table_schema = 'public'
-			AND table_name = $1
-		);
-	`, tableName).Scan(&exists)
-
-	if err != nil {
-		log.Fatalf("Error checking if table exists: %v", err)
-	}
-
-	if !exists {
-		query := `
-		CREATE TABLE ` + tableName + ` (
-			user_id SERIAL PRIMARY KEY,
-			email VARCHAR(255) NOT NULL UNIQUE,
-			password VARCHAR(255) NOT NULL,
-			rooms TEXT[]
-		);
-		`
-
-		_, err := db.Exec(query)
-		if err != nil {
-			log.Fatalf("Failed to create table: %v", err)
-		}
-
-		log.Println("Table '" + tableName + "' created successfully.")
-	} else {
-		log.Println("Table '" + tableName + "' already exists.")
-	}
-}
-
 func GetInstance() *sql.DB {
 	if dbInstance == nil {
 		dbInstance = connect()
+		warnPendingMigrations(dbInstance)
 	}
 	return dbInstance
 }
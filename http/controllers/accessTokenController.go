@@ -0,0 +1,380 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-gather/auth"
+	"go-gather/http/middleware"
+	"go-gather/http/models"
+	"go-gather/store"
+	"go-gather/types"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxAccessTokens       = 50
+	maxAccessTokenDays    = 365
+	maxAccessTokenRooms   = 50
+	maxAccessTokenNameLen = 64
+	maxBots               = 10
+)
+
+var accessTokenScopes = map[string]bool{
+	models.ScopeAPI: true,
+	models.ScopeWS:  true,
+}
+
+func newAccessTokenSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return auth.AccessTokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func newRandomID(prefix string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(b), nil
+}
+
+// ownedAccounts returns the caller's email followed by those of their bots,
+// which are the accounts they may hold tokens for.
+func ownedAccounts(email string) ([]string, error) {
+	bots, err := store.Get().Users.ListBots(email)
+	if err != nil {
+		return nil, err
+	}
+	accounts := []string{email}
+	for _, bot := range bots {
+		accounts = append(accounts, bot.Email)
+	}
+	return accounts, nil
+}
+
+// CreateAccessToken issues a personal access token for the caller or one
+// of their bots. The secret is only ever shown in this response.
+func CreateAccessToken(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("CreateAccessToken Called!")
+	email := middleware.GetEmail(r)
+
+	var body struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		Rooms         []string `json:"rooms"`
+		ExpiresInDays int      `json:"expiresInDays"`
+		Bot           string   `json:"bot"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if problem := checkProfileText("name", name, maxAccessTokenNameLen); problem != "" {
+		writeError(w, http.StatusBadRequest, problem)
+		return
+	}
+	if len(body.Scopes) == 0 {
+		writeError(w, http.StatusBadRequest, "At least one scope is required")
+		return
+	}
+	for _, scope := range body.Scopes {
+		if !accessTokenScopes[scope] {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Unknown scope %q", scope))
+			return
+		}
+	}
+	if len(body.Rooms) > maxAccessTokenRooms {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("A token can be limited to at most %d rooms", maxAccessTokenRooms))
+		return
+	}
+	if body.ExpiresInDays < 0 || body.ExpiresInDays > maxAccessTokenDays {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("expiresInDays must be between 0 and %d", maxAccessTokenDays))
+		return
+	}
+
+	owner := email
+	if body.Bot != "" {
+		bot, err := store.Get().Users.GetByEmail(body.Bot)
+		if err != nil || !bot.IsBot || bot.BotOwner != email {
+			writeError(w, http.StatusNotFound, "Bot not found")
+			return
+		}
+		owner = bot.Email
+	}
+
+	accounts, err := ownedAccounts(email)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load access tokens")
+		return
+	}
+	existing, err := store.Get().AccessTokens.ListByEmails(accounts)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load access tokens")
+		return
+	}
+	active := 0
+	now := time.Now()
+	for _, token := range existing {
+		if token.Active(now) {
+			active++
+		}
+	}
+	if active >= maxAccessTokens {
+		writeError(w, http.StatusConflict, fmt.Sprintf("At most %d active tokens, revoke one first", maxAccessTokens))
+		return
+	}
+
+	secret, err := newAccessTokenSecret()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create access token")
+		return
+	}
+	id, err := newRandomID("tok-")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create access token")
+		return
+	}
+	token := &models.AccessToken{
+		ID:        id,
+		Hash:      models.HashAccessToken(secret),
+		Email:     owner,
+		Name:      name,
+		Scopes:    body.Scopes,
+		Rooms:     body.Rooms,
+		CreatedBy: email,
+	}
+	if body.ExpiresInDays > 0 {
+		expiresAt := now.AddDate(0, 0, body.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+	if err := store.Get().AccessTokens.Create(token); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create access token")
+		return
+	}
+	recordAudit(r, types.AuditEvent{
+		Action:  types.AuditAccessTokenCreated,
+		Actor:   email,
+		Target:  owner,
+		Details: map[string]interface{}{"tokenId": token.ID, "scopes": token.Scopes, "rooms": token.Rooms},
+	})
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"success": true,
+		"message": "Store the token somewhere safe, it will not be shown again",
+		"token":   secret,
+		"details": token,
+	})
+}
+
+// ListAccessTokens lists the tokens of the caller and their bots, without
+// the secrets.
+func ListAccessTokens(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("ListAccessTokens Called!")
+
+	accounts, err := ownedAccounts(middleware.GetEmail(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load access tokens")
+		return
+	}
+	tokens, err := store.Get().AccessTokens.ListByEmails(accounts)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load access tokens")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"tokens":  tokens,
+	})
+}
+
+// RevokeAccessToken stops a token from working. Revoked tokens stay listed
+// so their last use can still be looked at.
+func RevokeAccessToken(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("RevokeAccessToken Called!")
+	email := middleware.GetEmail(r)
+
+	tokens := store.Get().AccessTokens
+	token, err := tokens.Get(mux.Vars(r)["tokenId"])
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Access token not found")
+		return
+	}
+	if token.Email != email && token.CreatedBy != email {
+		writeError(w, http.StatusNotFound, "Access token not found")
+		return
+	}
+
+	err = tokens.Revoke(token.ID)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusConflict, "Access token is already revoked")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to revoke access token")
+		return
+	}
+	recordAudit(r, types.AuditEvent{
+		Action:  types.AuditAccessTokenRevoked,
+		Actor:   email,
+		Target:  token.Email,
+		Details: map[string]interface{}{"tokenId": token.ID},
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Access token revoked",
+	})
+}
+
+// CreateBot creates a bot account owned by the caller. Bots cannot sign in;
+// they act through access tokens the owner creates for them and need to
+// be added to rooms like anyone else.
+func CreateBot(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("CreateBot Called!")
+	email := middleware.GetEmail(r)
+
+	var body struct {
+		Name   string `json:"name"`
+		Avatar string `json:"avatar"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if problem := checkProfileText("name", name, maxDisplayNameLength); problem != "" {
+		writeError(w, http.StatusBadRequest, problem)
+		return
+	}
+	if body.Avatar != "" && !assetKeyPattern.MatchString(body.Avatar) {
+		writeError(w, http.StatusBadRequest, "Invalid avatar")
+		return
+	}
+
+	users := store.Get().Users
+	bots, err := users.ListBots(email)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create bot")
+		return
+	}
+	if len(bots) >= maxBots {
+		writeError(w, http.StatusConflict, fmt.Sprintf("At most %d bots per user", maxBots))
+		return
+	}
+
+	localPart, err := newRandomID("bot-")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create bot")
+		return
+	}
+	bot := &models.User{
+		Email:    localPart + "@" + models.BotEmailDomain,
+		Rooms:    []string{},
+		IsBot:    true,
+		BotOwner: email,
+	}
+	if err := users.Create(bot); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create bot")
+		return
+	}
+
+	profile := &models.Profile{Email: bot.Email}
+	profile.ID, err = newPublicID()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create bot")
+		return
+	}
+	profile.DisplayName = name
+	profile.Avatar = body.Avatar
+	profile.Availability = models.AvailabilityAvailable
+	profile.Bot = true
+	if err := store.Get().Profiles.Create(profile); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create bot")
+		return
+	}
+	recordAudit(r, types.AuditEvent{Action: types.AuditBotCreated, Actor: email, Target: bot.Email})
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"success": true,
+		"email":   bot.Email,
+		"profile": profile.Profile,
+	})
+}
+
+// ListBots lists the caller's bots with their profiles.
+func ListBots(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("ListBots Called!")
+
+	bots, err := store.Get().Users.ListBots(middleware.GetEmail(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load bots")
+		return
+	}
+	emails := make([]string, len(bots))
+	for i, bot := range bots {
+		emails[i] = bot.Email
+	}
+	profiles := []models.Profile{}
+	if len(emails) > 0 {
+		if profiles, err = store.Get().Profiles.List(emails); err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to load bots")
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"bots":    profiles,
+	})
+}
+
+// VerifyAccessToken lets the ws server accept access tokens in the
+// handshake. The token needs the ws scope and has to allow the room.
+func VerifyAccessToken(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Token  string `json:"token"`
+		RoomID string `json:"roomId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+		writeError(w, http.StatusBadRequest, "token is required")
+		return
+	}
+
+	token, err := middleware.LookupAccessToken(body.Token, models.ScopeWS)
+	if err != nil || !token.AllowsRoom(body.RoomID) {
+		writeError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+	user, err := store.Get().Users.GetByEmail(token.Email)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"email":   user.Email,
+		"bot":     user.IsBot,
+	})
+}
@@ -0,0 +1,260 @@
+package controller
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"go-gather/http/middleware"
+	"go-gather/http/models"
+	"go-gather/mail"
+	"go-gather/store"
+	"go-gather/types"
+)
+
+const (
+	verifyEmailTTL    = 48 * time.Hour
+	resetPasswordTTL  = time.Hour
+	minPasswordLength = 8
+	// accountMailCooldown is the least time between two verification or
+	// reset emails to the same address.
+	accountMailCooldown = time.Minute
+)
+
+// appURL is where the links in account emails point; the web app reads
+// the token from the query string and posts it back here.
+func appURL() string {
+	if value := os.Getenv("APP_URL"); value != "" {
+		return value
+	}
+	return "http://localhost:3000"
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueToken stores a new single-use token and returns the secret to mail.
+func issueToken(email, purpose string, ttl time.Duration) (string, error) {
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(secretBytes)
+
+	err := store.Get().Tokens.Create(&models.UserToken{
+		Hash:      hashToken(secret),
+		Email:     email,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+var (
+	accountMailLock sync.Mutex
+	accountMailSent = make(map[string]time.Time) // purpose + " " + email -> last sent
+)
+
+// mayMail reports whether another account email of the purpose may go to
+// the address now, and if so remembers that one is going.
+func mayMail(email, purpose string) bool {
+	accountMailLock.Lock()
+	defer accountMailLock.Unlock()
+
+	now := time.Now()
+	for key, sent := range accountMailSent {
+		if now.Sub(sent) > accountMailCooldown {
+			delete(accountMailSent, key)
+		}
+	}
+
+	key := purpose + " " + email
+	if _, recent := accountMailSent[key]; recent {
+		return false
+	}
+	accountMailSent[key] = now
+	return true
+}
+
+// sendAccountMail issues a token and mails its link in the background, so
+// the response takes as long whether or not anything was sent.
+func sendAccountMail(email, purpose string, ttl time.Duration, subject, intro, path string) {
+	if !mayMail(email, purpose) {
+		return
+	}
+
+	go func() {
+		secret, err := issueToken(email, purpose, ttl)
+		if err != nil {
+			log.Printf("Failed to issue %s token for %s: %v", purpose, email, err)
+			return
+		}
+
+		link := fmt.Sprintf("%s%s?token=%s", appURL(), path, url.QueryEscape(secret))
+		body := fmt.Sprintf("%s\n\n%s\n\nThe link expires in %s. If you did not ask for this, you can ignore this email.\n",
+			intro, link, ttl)
+		if err := mail.Get().Send(mail.Message{To: email, Subject: subject, Body: body}); err != nil {
+			log.Printf("Failed to send %s email to %s: %v", purpose, email, err)
+		}
+	}()
+}
+
+func sendVerificationMail(email string) {
+	sendAccountMail(email, models.TokenVerifyEmail, verifyEmailTTL,
+		"Confirm your email address",
+		"Open this link to confirm your email address:",
+		"/verify-email")
+}
+
+// VerifyEmail confirms the address a verification link was sent to.
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("VerifyEmail Called!")
+
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+		writeError(w, http.StatusBadRequest, "token is required")
+		return
+	}
+
+	token, err := store.Get().Tokens.Consume(hashToken(body.Token), models.TokenVerifyEmail)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusBadRequest, "This link is invalid or has expired")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to verify email")
+		return
+	}
+
+	if err := store.Get().Users.SetEmailVerified(token.Email); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to verify email")
+		return
+	}
+	store.Get().Tokens.DeleteByEmail(token.Email, models.TokenVerifyEmail)
+	recordAudit(r, types.AuditEvent{Action: types.AuditEmailVerified, Actor: token.Email})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Email verified",
+		"email":   token.Email,
+	})
+}
+
+// ResendVerification mails the signed-in user a fresh verification link.
+func ResendVerification(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("ResendVerification Called!")
+	email := middleware.GetEmail(r)
+
+	user, err := store.Get().Users.GetByEmail(email)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if user.EmailVerified {
+		writeError(w, http.StatusConflict, "Email is already verified")
+		return
+	}
+
+	sendVerificationMail(email)
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"success": true,
+		"message": "Verification email sent",
+	})
+}
+
+// RequestPasswordReset mails a reset link if the address has an account.
+// The answer is the same either way so it cannot be used to probe emails.
+func RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("RequestPasswordReset Called!")
+
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Email == "" {
+		writeError(w, http.StatusBadRequest, "email is required")
+		return
+	}
+
+	// Bots have no password to reset and no mailbox to send a link to.
+	if user, err := store.Get().Users.GetByEmail(body.Email); err == nil && !user.IsBot {
+		sendAccountMail(body.Email, models.TokenResetPassword, resetPasswordTTL,
+			"Reset your password",
+			"Someone asked to reset the password of your account. Open this link to choose a new one:",
+			"/reset-password")
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"success": true,
+		"message": "If that email has an account, a reset link is on its way",
+	})
+}
+
+// ConfirmPasswordReset sets a new password using the token from a reset
+// link. It also lifts any sign-in lockout, since the user just proved they
+// own the address.
+func ConfirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("ConfirmPasswordReset Called!")
+
+	var body struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+		writeError(w, http.StatusBadRequest, "token is required")
+		return
+	}
+	if len(body.Password) < minPasswordLength {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Password must be at least %d characters", minPasswordLength))
+		return
+	}
+
+	user := models.User{Password: body.Password}
+	if !user.HashPassword() {
+		writeError(w, http.StatusBadRequest, "Password cannot be used")
+		return
+	}
+
+	tokens := store.Get().Tokens
+	token, err := tokens.Consume(hashToken(body.Token), models.TokenResetPassword)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusBadRequest, "This link is invalid or has expired")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to reset password")
+		return
+	}
+
+	users := store.Get().Users
+	if err := users.SetPasswordHash(token.Email, user.PasswordHash); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to reset password")
+		return
+	}
+	users.ResetFailedLogins(token.Email)
+	// A reset link also proves the user reads mail at this address.
+	users.SetEmailVerified(token.Email)
+	tokens.DeleteByEmail(token.Email, models.TokenResetPassword)
+	recordAudit(r, types.AuditEvent{Action: types.AuditPasswordReset, Actor: token.Email})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Password updated",
+	})
+}
@@ -0,0 +1,289 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"go-gather/blob"
+	"go-gather/http/middleware"
+	"go-gather/http/models"
+	"go-gather/media"
+	"go-gather/store"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultMaxUploadSize     = 10 << 20
+	maxAttachmentsPerMessage = 10
+)
+
+// allowedUploadTypes is checked against the sniffed content type, never
+// the one the client claims.
+var allowedUploadTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"application/zip": true,
+	"text/plain":      true,
+}
+
+// thumbnailTypes are the image types the media package can decode.
+var thumbnailTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// maxUploadSize reads MAX_UPLOAD_BYTES, falling back to 10 MiB.
+func maxUploadSize() int64 {
+	if value := os.Getenv("MAX_UPLOAD_BYTES"); value != "" {
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultMaxUploadSize
+}
+
+// UploadAttachment stores the multipart "file" field of the request as an
+// attachment of the room. Chat messages can then reference it by ID.
+func UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("UploadAttachment Called!")
+
+	limit := maxUploadSize()
+	// Leave room for the multipart framing around the file.
+	r.Body = http.MaxBytesReader(w, r.Body, limit+64<<10)
+
+	file, header, err := r.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Files are limited to %d bytes", limit))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "A file field is required")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, limit+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Failed to read upload")
+		return
+	}
+	if int64(len(data)) > limit {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Files are limited to %d bytes", limit))
+		return
+	}
+	if len(data) == 0 {
+		writeError(w, http.StatusBadRequest, "File is empty")
+		return
+	}
+
+	contentType := http.DetectContentType(data)
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if !allowedUploadTypes[mediaType] {
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("Files of type %s are not allowed", mediaType))
+		return
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to store file")
+		return
+	}
+
+	attachment := &models.Attachment{
+		ID:          hex.EncodeToString(idBytes),
+		RoomID:      mux.Vars(r)["roomId"],
+		UploaderID:  middleware.GetEmail(r),
+		FileName:    cleanFileName(header.Filename),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+	}
+	attachment.BlobKey = "attachments/" + attachment.ID
+
+	var thumbnail *media.Thumbnail
+	if thumbnailTypes[mediaType] {
+		thumbnail, err = media.MakeThumbnail(data)
+		if err != nil {
+			// The file is still worth keeping; it just gets no preview.
+			log.Printf("No thumbnail for attachment %s: %v", attachment.ID, err)
+		} else {
+			attachment.Width = thumbnail.Width
+			attachment.Height = thumbnail.Height
+			attachment.ThumbnailKey = attachment.BlobKey + ".thumb"
+			attachment.HasThumbnail = true
+		}
+	}
+
+	storage := blob.Get()
+	ctx := r.Context()
+	if err := storage.Put(ctx, attachment.BlobKey, bytes.NewReader(data), attachment.Size, contentType); err != nil {
+		log.Println("Storing upload failed", err)
+		writeError(w, http.StatusInternalServerError, "Failed to store file")
+		return
+	}
+	if thumbnail != nil {
+		err := storage.Put(ctx, attachment.ThumbnailKey, bytes.NewReader(thumbnail.Data), int64(len(thumbnail.Data)), thumbnail.ContentType)
+		if err != nil {
+			log.Println("Storing thumbnail failed", err)
+			attachment.ThumbnailKey = ""
+			attachment.HasThumbnail = false
+		}
+	}
+
+	if err := store.Get().Attachments.Create(attachment); err != nil {
+		// Do not leave orphaned blobs behind.
+		storage.Delete(context.Background(), attachment.BlobKey)
+		if attachment.ThumbnailKey != "" {
+			storage.Delete(context.Background(), attachment.ThumbnailKey)
+		}
+		writeError(w, http.StatusInternalServerError, "Failed to store file")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"success":    true,
+		"attachment": attachment,
+	})
+}
+
+// cleanFileName keeps the base name of what the client sent, without
+// control characters, for display and Content-Disposition only.
+func cleanFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	if len(name) > 255 {
+		name = name[:255]
+	}
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	return name
+}
+
+// loadAttachment fetches the {attachmentId} attachment, answering 404
+// unless it was uploaded to the {roomId} room the caller belongs to.
+func loadAttachment(w http.ResponseWriter, r *http.Request) (*models.Attachment, bool) {
+	vars := mux.Vars(r)
+	attachment, err := store.Get().Attachments.Get(vars["attachmentId"])
+	if err == store.ErrNotFound || (err == nil && attachment.RoomID != vars["roomId"]) {
+		writeError(w, http.StatusNotFound, "Attachment not found")
+		return nil, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load attachment")
+		return nil, false
+	}
+	return attachment, true
+}
+
+func GetAttachment(w http.ResponseWriter, r *http.Request) {
+	attachment, ok := loadAttachment(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"attachment": attachment,
+	})
+}
+
+func DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	attachment, ok := loadAttachment(w, r)
+	if !ok {
+		return
+	}
+
+	// Only images are shown inline; everything else is a download so the
+	// browser never renders uploaded documents on our origin.
+	disposition := "attachment"
+	if thumbnailTypes[attachment.ContentType] || attachment.ContentType == "image/webp" {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.FileName}))
+	serveBlob(w, r, attachment.BlobKey, attachment.ContentType, attachment.Size)
+}
+
+func DownloadThumbnail(w http.ResponseWriter, r *http.Request) {
+	attachment, ok := loadAttachment(w, r)
+	if !ok {
+		return
+	}
+	if !attachment.HasThumbnail {
+		writeError(w, http.StatusNotFound, "Attachment has no thumbnail")
+		return
+	}
+
+	// Thumbnails of opaque images are JPEGs and of the rest PNGs; let the
+	// content decide.
+	serveBlob(w, r, attachment.ThumbnailKey, "", -1)
+}
+
+// serveBlob streams a stored blob. An empty contentType is sniffed from
+// the first bytes and a negative size leaves Content-Length unset.
+func serveBlob(w http.ResponseWriter, r *http.Request, key, contentType string, size int64) {
+	body, err := blob.Get().Get(r.Context(), key)
+	if err == blob.ErrNotFound {
+		writeError(w, http.StatusNotFound, "File is missing from storage")
+		return
+	}
+	if err != nil {
+		log.Printf("Reading blob %s failed: %v", key, err)
+		writeError(w, http.StatusInternalServerError, "Failed to read file")
+		return
+	}
+	defer body.Close()
+
+	var reader io.Reader = body
+	if contentType == "" {
+		head := make([]byte, 512)
+		n, _ := io.ReadFull(body, head)
+		contentType = http.DetectContentType(head[:n])
+		reader = io.MultiReader(bytes.NewReader(head[:n]), body)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	if size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	io.Copy(w, reader)
+}
+
+// checkAttachments makes sure a message only references attachments its
+// sender uploaded to the same room.
+func checkAttachments(roomID, senderID string, ids []string) string {
+	if len(ids) > maxAttachmentsPerMessage {
+		return fmt.Sprintf("Messages are limited to %d attachments", maxAttachmentsPerMessage)
+	}
+	for _, id := range ids {
+		attachment, err := store.Get().Attachments.Get(id)
+		if err != nil || attachment.RoomID != roomID || attachment.UploaderID != senderID {
+			return fmt.Sprintf("Unknown attachment %s", id)
+		}
+	}
+	return ""
+}
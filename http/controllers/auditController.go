@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-gather/clientip"
+	"go-gather/store"
+	"go-gather/types"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 500
+)
+
+// recordAudit stores the event, stamping it with the caller's IP. Failures
+// are logged by the store and never fail the request being audited.
+func recordAudit(r *http.Request, event types.AuditEvent) {
+	if event.IP == "" {
+		event.IP = clientIP(r)
+	}
+	store.Get().Audit.Record(&event)
+}
+
+// clientIP is the address sign-in lockouts and the audit log are keyed
+// by. X-Forwarded-For only counts when a trusted proxy sent it.
+func clientIP(r *http.Request) string {
+	return clientip.FromRequest(r)
+}
+
+// GetAuditLog lists audit events for admins. Supported query parameters are
+// user, roomId, action, from and to (RFC 3339) and limit.
+func GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := store.AuditFilter{
+		User:   query.Get("user"),
+		RoomID: query.Get("roomId"),
+		Action: query.Get("action"),
+		Limit:  defaultAuditLimit,
+	}
+
+	for name, target := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, name+" must be an RFC 3339 timestamp")
+			return
+		}
+		*target = &t
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		filter.Limit = min(limit, maxAuditLimit)
+	}
+
+	events, err := store.Get().Audit.Query(filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to query audit log")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"events":  events,
+	})
+}
+
+// RecordAuditEvent is the ws server's way of adding to the audit log.
+// Joins and leaves also go out to the room's webhooks.
+func RecordAuditEvent(w http.ResponseWriter, r *http.Request) {
+	var event types.AuditEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil || event.Action == "" {
+		writeError(w, http.StatusBadRequest, "Invalid audit event")
+		return
+	}
+
+	if err := store.Get().Audit.Record(&event); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to record audit event")
+		return
+	}
+	dispatchPresence(&event)
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"success": true})
+}
@@ -4,16 +4,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	netmail "net/mail"
+	"strings"
 	"time"
 
-	"go-gather/db"
+	"go-gather/auth"
+	"go-gather/http/middleware"
 	"go-gather/http/models"
-
-	"github.com/golang-jwt/jwt/v5"
+	"go-gather/store"
+	"go-gather/types"
 )
 
-var jwtSecret = []byte("your_jwt_secret_key") // TODO: move to env
-
 func HomeHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Println("HomeHandler Called!")
 	w.Header().Set("Content-Type", "application/json")
@@ -41,10 +42,9 @@ func SignUp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Initialize empty rooms array if not provided
-	if user.Rooms == nil {
-		user.Rooms = []string{}
-	}
+	// Rooms are joined through invites and join requests, never by asking
+	// at sign-up.
+	user.Rooms = nil
 
 	if user.Email == "" || user.Password == "" {
 		w.Header().Set("Content-Type", "application/json")
@@ -55,12 +55,28 @@ func SignUp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fmt.Println("User Req body: ", user)
+	// Only a bare address will do; "Name <a@b.c>" parses but is not one.
+	if address, err := netmail.ParseAddress(user.Email); err != nil || address.Address != user.Email {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"message": "Invalid email address",
+		})
+		return
+	}
+
+	if strings.HasSuffix(user.Email, "@"+models.BotEmailDomain) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"message": "Invalid email address",
+		})
+		return
+	}
 
-	db := db.GetInstance()
-	defer db.Close()
+	fmt.Println("Sign-up for: ", user.Email)
 
-	if !user.CreateUser(db) {
+	if !user.HashPassword() || store.Get().Users.Create(&user) != nil {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success": false,
@@ -70,12 +86,15 @@ func SignUp(w http.ResponseWriter, r *http.Request) {
 	}
 
 	fmt.Println("User created successfully")
+	recordAudit(r, types.AuditEvent{Action: types.AuditSignUp, Actor: user.Email})
+	sendVerificationMail(user.Email)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"message": "User created successfully",
-		"email":   user.Email,
+		"success":       true,
+		"message":       "User created successfully, check your email to verify the address",
+		"email":         user.Email,
+		"emailVerified": false,
 	})
 }
 
@@ -109,35 +128,99 @@ func SignIn(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fmt.Printf("Login Attempt: %+v\n", user)
+	fmt.Printf("Login Attempt: %s\n", user.Email)
+
+	now := time.Now()
+	if until := ipLoginFailures.lockedUntil(clientIP(r), now); until.After(now) {
+		tooManySignInAttempts(w, until)
+		return
+	}
+
+	users := store.Get().Users
 
-	db := db.GetInstance()
-	defer db.Close()
+	// Unknown emails and wrong passwords must look the same from outside:
+	// same answer, same bcrypt cost, and lockouts for both.
+	stored, err := users.GetByEmail(user.Email)
+	accountExists := err == nil
+	var lockedUntil time.Time
+	if !accountExists {
+		lockedUntil = unknownAccountFailures.lockedUntil(user.Email, now)
+	} else if stored.LockedUntil != nil {
+		lockedUntil = *stored.LockedUntil
+	}
+	if lockedUntil.After(now) {
+		tooManySignInAttempts(w, lockedUntil)
+		return
+	}
 
-	if !user.Authenticate(db) {
+	if !accountExists {
+		burnPasswordCheck(user.Password)
+	}
+	if !accountExists || !stored.CheckPassword(user.Password) {
+		recordFailedSignIn(r, user.Email, accountExists)
+		recordAudit(r, types.AuditEvent{Action: types.AuditSignInFailed, Actor: user.Email})
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
+	if stored.FailedLogins > 0 || stored.LockedUntil != nil {
+		users.ResetFailedLogins(user.Email)
+	}
+
+	completeSignIn(w, r, stored, nil)
+}
+
+// completeSignIn issues the account token once the user has proved who
+// they are, by password or otherwise, or asks for the second factor first
+// if they have one. details go into the audit entry.
+func completeSignIn(w http.ResponseWriter, r *http.Request, user *models.User, details map[string]interface{}) {
+	if user.TwoFactor.Enabled {
+		startTwoFactorChallenge(w, user)
+		return
+	}
+	issueAccessToken(w, r, user, details)
+}
+
+// issueAccessToken answers a finished sign-in with the account token.
+func issueAccessToken(w http.ResponseWriter, r *http.Request, user *models.User, details map[string]interface{}) {
+	tokenString, err := auth.Sign(auth.Claims{Email: user.Email}, time.Hour*24)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, types.AuditEvent{Action: types.AuditSignIn, Actor: user.Email, Details: details})
+
+	rooms, err := store.Get().Users.GetRooms(user.Email)
+	if err != nil {
+		rooms = []string{}
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"email": user.Email,
-		"exp":   time.Now().Add(time.Hour * 24).Unix(),
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":       true,
+		"token":         tokenString,
+		"rooms":         rooms,
+		"emailVerified": user.EmailVerified,
 	})
+}
+
+// RefreshToken trades a still-valid token for a fresh one so clients can
+// stay signed in without sending the password again.
+func RefreshToken(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("RefreshToken Called!")
+	email := middleware.GetEmail(r)
 
-	tokenString, err := token.SignedString(jwtSecret)
+	tokenString, err := auth.Sign(auth.Claims{Email: email}, time.Hour*24)
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
-	fmt.Println("Token Generated: ", tokenString)
 
-	rooms := user.GetRoomsOfUser(db)
+	recordAudit(r, types.AuditEvent{Action: types.AuditTokenRefresh, Actor: email})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
 		"token":   tokenString,
-		"rooms":   rooms,
 	})
 }
 
@@ -153,20 +236,36 @@ func Authenticate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var user models.User
-	user.Email = email
-	user.Password = ""
-	user.Rooms = []string{}
+	store := store.Get()
 
-	db := db.GetInstance()
-	// defer db.Close()
+	rooms, err := store.Users.GetRooms(email)
+	if err != nil {
+		rooms = []string{}
+	}
 
-	rooms := user.GetRoomsOfUser(db)
+	// When a roomId is given, also report the user's role in that room so
+	// the ws server can enforce permissions without a second round trip.
+	// A banned user gets no role, which keeps them out of the room, and so
+	// does a ban that could not be looked up.
+	role := ""
+	banned := false
+	if roomID := r.URL.Query().Get("roomId"); roomID != "" {
+		banned, err = isBanned(roomID, email)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to check bans")
+			return
+		}
+		if membership, err := store.Memberships.Get(roomID, email); err == nil && !banned {
+			role = string(membership.Role)
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success":      true,
 		"rooms":        rooms,
+		"role":         role,
+		"banned":       banned,
 		"emailAddress": email,
 	})
 }
@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"go-gather/http/middleware"
+	"go-gather/store"
+	"go-gather/types"
+
+	"github.com/gorilla/mux"
+)
+
+// RecordBan is called by the ws server once a moderator's ban command has
+// passed its permission checks there.
+func RecordBan(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("RecordBan Called!")
+
+	var ban types.Ban
+	if err := json.NewDecoder(r.Body).Decode(&ban); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	ban.RoomID = mux.Vars(r)["roomId"]
+
+	if ban.UserID == "" || ban.BannedBy == "" {
+		writeError(w, http.StatusBadRequest, "userId and bannedBy are required")
+		return
+	}
+
+	if err := store.Get().Bans.Save(&ban); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to save ban")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"success": true,
+		"ban":     ban,
+	})
+}
+
+func ListBans(w http.ResponseWriter, r *http.Request) {
+	bans, err := store.Get().Bans.ListActive(mux.Vars(r)["roomId"])
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to list bans")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"bans":    bans,
+	})
+}
+
+func Unban(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	err := store.Get().Bans.Delete(vars["roomId"], vars["email"])
+	if err == nil {
+		recordAudit(r, types.AuditEvent{
+			Action: types.AuditUnban,
+			Actor:  middleware.GetEmail(r),
+			Target: vars["email"],
+			RoomID: vars["roomId"],
+		})
+	}
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, "Ban not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to lift ban")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
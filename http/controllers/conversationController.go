@@ -0,0 +1,336 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"go-gather/http/middleware"
+	"go-gather/http/models"
+	"go-gather/http/notifier"
+	"go-gather/store"
+	"go-gather/types"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// maxConversationSize caps group threads, creator included.
+	maxConversationSize  = 10
+	maxDirectMessageSize = 2000
+)
+
+func CreateConversation(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("CreateConversation Called!")
+
+	var body struct {
+		Participants []string `json:"participants"`
+		Title        string   `json:"title"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	email := middleware.GetEmail(r)
+	participants := []string{email}
+	seen := map[string]bool{email: true}
+	for _, participant := range body.Participants {
+		if participant == "" || seen[participant] {
+			continue
+		}
+		seen[participant] = true
+		participants = append(participants, participant)
+	}
+
+	if len(participants) < 2 {
+		writeError(w, http.StatusBadRequest, "At least one other participant is required")
+		return
+	}
+	if len(participants) > maxConversationSize {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Conversations are limited to %d participants", maxConversationSize))
+		return
+	}
+	for _, participant := range participants[1:] {
+		if _, err := store.Get().Users.GetByEmail(participant); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Unknown user %s", participant))
+			return
+		}
+	}
+
+	// Two people without a title is a direct conversation, of which there
+	// is only ever one per pair.
+	var conversation *models.Conversation
+	var err error
+	if len(participants) == 2 && body.Title == "" {
+		conversation, err = findOrCreateDirect(email, participants[1])
+	} else {
+		conversation = &models.Conversation{Title: body.Title, Participants: participants, CreatedBy: email}
+		err = store.Get().Conversations.Create(conversation)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create conversation")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"success":      true,
+		"conversation": conversation,
+	})
+}
+
+func findOrCreateDirect(from, to string) (*models.Conversation, error) {
+	conversations := store.Get().Conversations
+
+	conversation, err := conversations.FindDirect(from, to)
+	if err != store.ErrNotFound {
+		return conversation, err
+	}
+
+	conversation = &models.Conversation{Direct: true, Participants: []string{from, to}, CreatedBy: from}
+	err = conversations.Create(conversation)
+	if err == store.ErrConflict {
+		// Someone else started it at the same time.
+		return conversations.FindDirect(from, to)
+	}
+	return conversation, err
+}
+
+func ListConversations(w http.ResponseWriter, r *http.Request) {
+	conversations, err := store.Get().Conversations.ListByUser(middleware.GetEmail(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to list conversations")
+		return
+	}
+
+	unread := 0
+	for _, conversation := range conversations {
+		unread += conversation.Unread
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":       true,
+		"conversations": conversations,
+		"unread":        unread,
+	})
+}
+
+// loadConversation fetches the {conversationId} conversation and makes sure
+// the caller takes part in it. Outsiders get a 404 so ids cannot be probed.
+func loadConversation(w http.ResponseWriter, r *http.Request) (*models.Conversation, bool) {
+	conversation, err := store.Get().Conversations.Get(mux.Vars(r)["conversationId"])
+	if err == store.ErrNotFound || (err == nil && !conversation.HasParticipant(middleware.GetEmail(r))) {
+		writeError(w, http.StatusNotFound, "Conversation not found")
+		return nil, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load conversation")
+		return nil, false
+	}
+	return conversation, true
+}
+
+func ListConversationMessages(w http.ResponseWriter, r *http.Request) {
+	conversation, ok := loadConversation(w, r)
+	if !ok {
+		return
+	}
+	listMessages(w, r, "", types.ConversationChannel(conversation.ID))
+}
+
+func SendConversationMessage(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("SendConversationMessage Called!")
+
+	conversation, ok := loadConversation(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Body string `json:"body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	message, status, errMessage := sendDirectMessage(conversation, middleware.GetEmail(r), body.Body)
+	if message == nil {
+		writeError(w, status, errMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"success":     true,
+		"chatMessage": message,
+	})
+}
+
+// sendDirectMessage stores the message and pushes a dm-received event to
+// every other participant that is online, wherever they are connected.
+// Offline participants see it through their unread counts.
+func sendDirectMessage(conversation *models.Conversation, sender, body string) (*types.ChatMessage, int, string) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return nil, http.StatusBadRequest, "Message cannot be empty"
+	}
+	if utf8.RuneCountInString(body) > maxDirectMessageSize {
+		return nil, http.StatusBadRequest, "Message is too long"
+	}
+
+	message := &types.ChatMessage{
+		Channel:  types.ConversationChannel(conversation.ID),
+		SenderID: sender,
+		Body:     body,
+	}
+	if err := store.Get().Messages.Create(message); err != nil {
+		return nil, http.StatusInternalServerError, "Failed to save message"
+	}
+
+	// The sender has obviously read their own message.
+	store.Get().Conversations.MarkRead(conversation.ID, sender, message.ID)
+
+	for _, participant := range conversation.Participants {
+		if participant == sender {
+			continue
+		}
+		unread, err := store.Get().Conversations.UnreadCount(conversation.ID, participant)
+		if err != nil {
+			unread = 0
+		}
+		notifier.Notify(types.Notification{
+			Type:    "dm-received",
+			UserIDs: []string{participant},
+			Data: map[string]interface{}{
+				"conversationId": conversation.ID,
+				"message":        message,
+				"unread":         unread,
+			},
+		})
+	}
+	return message, http.StatusCreated, ""
+}
+
+// MarkConversationRead moves the caller's read position to messageId, or to
+// the latest message when none is given.
+func MarkConversationRead(w http.ResponseWriter, r *http.Request) {
+	conversation, ok := loadConversation(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		MessageID string `json:"messageId"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	if body.MessageID == "" {
+		latest, err := store.Get().Messages.List(store.MessageQuery{
+			Channel: types.ConversationChannel(conversation.ID),
+			Limit:   1,
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to load messages")
+			return
+		}
+		if len(latest) == 0 {
+			writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "unread": 0})
+			return
+		}
+		body.MessageID = latest[0].ID
+	}
+
+	email := middleware.GetEmail(r)
+	err := store.Get().Conversations.MarkRead(conversation.ID, email, body.MessageID)
+	if err == store.ErrBadCursor {
+		writeError(w, http.StatusBadRequest, "Invalid messageId")
+		return
+	}
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, "Conversation not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to mark conversation read")
+		return
+	}
+
+	unread, err := store.Get().Conversations.UnreadCount(conversation.ID, email)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to count unread messages")
+		return
+	}
+
+	notifyOthers(conversation, email, "read-receipt", map[string]interface{}{
+		"conversationId": conversation.ID,
+		"userId":         email,
+		"messageId":      body.MessageID,
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"unread":  unread,
+	})
+}
+
+// RecordDirectMessage is the ws server's send-dm. The sender is trusted
+// since the ws server authenticated the connection.
+func RecordDirectMessage(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		SenderID string `json:"senderId"`
+		types.DirectMessageData
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.SenderID == "" {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var conversation *models.Conversation
+	var err error
+	switch {
+	case body.ConversationID != "":
+		conversation, err = store.Get().Conversations.Get(body.ConversationID)
+		if err == nil && !conversation.HasParticipant(body.SenderID) {
+			err = store.ErrNotFound
+		}
+	case body.To != "" && body.To != body.SenderID:
+		if _, err = store.Get().Users.GetByEmail(body.To); err == nil {
+			conversation, err = findOrCreateDirect(body.SenderID, body.To)
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "conversationId or to is required")
+		return
+	}
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, "Conversation not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load conversation")
+		return
+	}
+
+	message, status, errMessage := sendDirectMessage(conversation, body.SenderID, body.Body)
+	if message == nil {
+		writeError(w, status, errMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"success":        true,
+		"conversationId": conversation.ID,
+		"chatMessage":    message,
+	})
+}
+
+func notifyOthers(conversation *models.Conversation, sender, notificationType string, data interface{}) {
+	others := []string{}
+	for _, participant := range conversation.Participants {
+		if participant != sender {
+			others = append(others, participant)
+		}
+	}
+	notifier.Notify(types.Notification{Type: notificationType, UserIDs: others, Data: data})
+}
@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-gather/auth"
+	"go-gather/http/notifier"
+	"go-gather/store"
+	"go-gather/types"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	guestTokenTTL         = 4 * time.Hour
+	maxGuestDisplayLength = 32
+)
+
+func GetRoomSettings(w http.ResponseWriter, r *http.Request) {
+	settings, err := store.Get().Rooms.GetSettings(mux.Vars(r)["roomId"])
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load room settings")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"settings": settings,
+	})
+}
+
+func UpdateRoomSettings(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("UpdateRoomSettings Called!")
+	roomID := mux.Vars(r)["roomId"]
+
+	var body struct {
+		GuestAccess *bool `json:"guestAccess"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	rooms := store.Get().Rooms
+
+	settings, err := rooms.GetSettings(roomID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load room settings")
+		return
+	}
+
+	hadGuestAccess := settings.GuestAccess
+	if body.GuestAccess != nil {
+		settings.GuestAccess = *body.GuestAccess
+	}
+
+	if err := rooms.SaveSettings(settings); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to save room settings")
+		return
+	}
+
+	// Turning guest access off also removes the guests already inside.
+	if hadGuestAccess && !settings.GuestAccess {
+		notifier.Notify(types.Notification{
+			Type:   "guest-access-revoked",
+			RoomID: roomID,
+			Data:   map[string]string{"roomId": roomID},
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"settings": settings,
+	})
+}
+
+// CheckGuestAccess tells the ws server whether a guest may still join. A
+// guest token outlives the setting it was issued under, and a banned guest
+// keeps their token, so every guest join asks again.
+func CheckGuestAccess(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	settings, err := store.Get().Rooms.GetSettings(vars["roomId"])
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load room settings")
+		return
+	}
+	banned, err := isBanned(vars["roomId"], vars["guestId"])
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to check bans")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":     true,
+		"guestAccess": settings.GuestAccess,
+		"banned":      banned,
+	})
+}
+
+// IssueGuestToken hands out a short-lived token for a room that has guest
+// access enabled. No account is needed; the token is the guest's identity.
+func IssueGuestToken(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("IssueGuestToken Called!")
+	roomID := mux.Vars(r)["roomId"]
+
+	var body struct {
+		DisplayName string `json:"displayName"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	displayName := strings.TrimSpace(body.DisplayName)
+	if displayName == "" || len(displayName) > maxGuestDisplayLength {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("displayName must be 1-%d characters", maxGuestDisplayLength))
+		return
+	}
+
+	settings, err := store.Get().Rooms.GetSettings(roomID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load room settings")
+		return
+	}
+	if !settings.GuestAccess {
+		writeError(w, http.StatusForbidden, "Guest access is disabled for this room")
+		return
+	}
+
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+	guestID := "guest-" + hex.EncodeToString(idBytes)
+
+	claims := auth.Claims{
+		Guest:  true,
+		Name:   displayName,
+		RoomID: roomID,
+	}
+	claims.Subject = guestID
+
+	tokenString, err := auth.Sign(claims, guestTokenTTL)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"success":     true,
+		"token":       tokenString,
+		"guestId":     guestID,
+		"displayName": displayName,
+		"roomId":      roomID,
+		"expiresIn":   int(guestTokenTTL.Seconds()),
+	})
+}
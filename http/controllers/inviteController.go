@@ -0,0 +1,342 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-gather/http/middleware"
+	"go-gather/http/models"
+	"go-gather/http/notifier"
+	"go-gather/roles"
+	"go-gather/store"
+	"go-gather/types"
+
+	"github.com/gorilla/mux"
+)
+
+func CreateInvite(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("CreateInvite Called!")
+	roomID := mux.Vars(r)["roomId"]
+
+	var body struct {
+		Role      string `json:"role"`
+		MaxUses   *int   `json:"maxUses"`
+		ExpiresIn int    `json:"expiresIn"` // seconds, 0 for no expiry
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if body.Role == "" {
+		body.Role = string(roles.Member)
+	}
+	role, ok := roles.Parse(body.Role)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Unknown role")
+		return
+	}
+	if !middleware.GetRole(r).Outranks(role) {
+		writeError(w, http.StatusForbidden, "Cannot invite with a role equal to or above your own")
+		return
+	}
+
+	invite := models.Invite{
+		RoomID:    roomID,
+		Role:      role,
+		CreatedBy: middleware.GetEmail(r),
+		MaxUses:   1,
+	}
+	if body.MaxUses != nil {
+		if *body.MaxUses < 0 {
+			writeError(w, http.StatusBadRequest, "maxUses cannot be negative")
+			return
+		}
+		invite.MaxUses = *body.MaxUses
+	}
+	if body.ExpiresIn < 0 {
+		writeError(w, http.StatusBadRequest, "expiresIn cannot be negative")
+		return
+	}
+	if body.ExpiresIn > 0 {
+		expiresAt := time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+		invite.ExpiresAt = &expiresAt
+	}
+
+	token, err := newInviteToken()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create invite")
+		return
+	}
+	invite.Token = token
+
+	if err := store.Get().Invites.Create(&invite); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create invite")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"success": true,
+		"invite":  invite,
+		"link":    fmt.Sprintf("/invites/%s/accept", invite.Token),
+	})
+}
+
+func ListInvites(w http.ResponseWriter, r *http.Request) {
+	invites, err := store.Get().Invites.ListByRoom(mux.Vars(r)["roomId"])
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to list invites")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"invites": invites,
+	})
+}
+
+func RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	err := store.Get().Invites.Delete(vars["roomId"], vars["token"])
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, "Invite not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to revoke invite")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+func AcceptInvite(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("AcceptInvite Called!")
+	email := middleware.GetEmail(r)
+
+	invite, err := store.Get().Invites.Redeem(mux.Vars(r)["token"])
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusGone, "Invite is invalid, expired or used up")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to accept invite")
+		return
+	}
+	if !checkNotBanned(w, invite.RoomID, email) {
+		return
+	}
+
+	membership, err := grantMembership(r, invite.RoomID, email, invite.Role, "invite")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to accept invite")
+		return
+	}
+
+	notifier.Notify(types.Notification{
+		Type:   "member-added",
+		RoomID: invite.RoomID,
+		Data:   membership,
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"membership": membership,
+	})
+}
+
+func RequestToJoin(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("RequestToJoin Called!")
+	roomID := mux.Vars(r)["roomId"]
+	email := middleware.GetEmail(r)
+
+	var body struct {
+		Message string `json:"message"`
+	}
+	// The message is optional, so an empty body is fine.
+	json.NewDecoder(r.Body).Decode(&body)
+
+	exists, err := store.Get().Rooms.Exists(roomID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to request access")
+		return
+	}
+	if !exists {
+		writeError(w, http.StatusNotFound, "Room not found")
+		return
+	}
+
+	if !checkNotBanned(w, roomID, email) {
+		return
+	}
+
+	if _, err := store.Get().Memberships.Get(roomID, email); err == nil {
+		writeError(w, http.StatusConflict, "Already a member of this room")
+		return
+	}
+
+	request := models.JoinRequest{RoomID: roomID, Email: email, Message: body.Message}
+	err = store.Get().Invites.CreateJoinRequest(&request)
+	if err == store.ErrConflict {
+		writeError(w, http.StatusConflict, "A join request is already pending")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to request access")
+		return
+	}
+
+	notifier.Notify(types.Notification{
+		Type:       "join-requested",
+		RoomID:     roomID,
+		Permission: string(roles.PermManageMembers),
+		Data:       request,
+	})
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"success": true,
+		"request": request,
+	})
+}
+
+func ListJoinRequests(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+
+	requests, err := store.Get().Invites.ListJoinRequests(mux.Vars(r)["roomId"], status)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to list join requests")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"requests": requests,
+	})
+}
+
+func ApproveJoinRequest(w http.ResponseWriter, r *http.Request) {
+	decideJoinRequest(w, r, models.JoinRequestApproved)
+}
+
+func DenyJoinRequest(w http.ResponseWriter, r *http.Request) {
+	decideJoinRequest(w, r, models.JoinRequestDenied)
+}
+
+func decideJoinRequest(w http.ResponseWriter, r *http.Request, status string) {
+	vars := mux.Vars(r)
+	roomID := vars["roomId"]
+
+	id, err := strconv.Atoi(vars["requestId"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request id")
+		return
+	}
+
+	request, err := store.Get().Invites.DecideJoinRequest(roomID, id, status, middleware.GetEmail(r))
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, "No pending join request with this id")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to update join request")
+		return
+	}
+
+	if status == models.JoinRequestApproved {
+		membership, err := grantMembership(r, roomID, request.Email, roles.Member, "join-request")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to add member")
+			return
+		}
+		notifier.Notify(types.Notification{
+			Type:   "member-added",
+			RoomID: roomID,
+			Data:   membership,
+		})
+	}
+
+	notifier.Notify(types.Notification{
+		Type:    "join-request-" + status,
+		UserIDs: []string{request.Email},
+		Data:    request,
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"request": request,
+	})
+}
+
+// grantMembership adds the user to the room with the given role, keeping
+// their current role if it is already higher.
+func grantMembership(r *http.Request, roomID, email string, role roles.Role, via string) (*models.Membership, error) {
+	memberships := store.Get().Memberships
+
+	current, err := memberships.Get(roomID, email)
+	if err != nil && err != store.ErrNotFound {
+		return nil, err
+	}
+	if current != nil && !role.Outranks(current.Role) {
+		return current, nil
+	}
+
+	membership := &models.Membership{RoomID: roomID, Email: email, Role: role}
+	if err := memberships.Save(membership); err != nil {
+		return nil, err
+	}
+
+	previousRole := ""
+	if current != nil {
+		previousRole = string(current.Role)
+	}
+	recordAudit(r, types.AuditEvent{
+		Action:  types.AuditRoleChange,
+		Actor:   middleware.GetEmail(r),
+		Target:  email,
+		RoomID:  roomID,
+		Details: map[string]interface{}{"from": previousRole, "to": role, "via": via},
+	})
+	return membership, nil
+}
+
+// isBanned reports whether the user is banned from the room. Callers must
+// treat an error as a ban: letting people in because the lookup failed
+// would undo the ban.
+func isBanned(roomID, userID string) (bool, error) {
+	_, err := store.Get().Bans.GetActive(roomID, userID)
+	if err == store.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// checkNotBanned answers the request and returns false if the user is
+// banned from the room or the ban could not be looked up.
+func checkNotBanned(w http.ResponseWriter, roomID, email string) bool {
+	banned, err := isBanned(roomID, email)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to check bans")
+		return false
+	}
+	if banned {
+		writeError(w, http.StatusForbidden, "You are banned from this room")
+		return false
+	}
+	return true
+}
+
+// newInviteToken returns the secret that both names an invite and grants
+// it, so it is longer than the IDs from newRandomID.
+func newInviteToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,181 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go-gather/http/middleware"
+	"go-gather/store"
+	"go-gather/types"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// maxAccountFailures wrong passwords lock the account, first for
+	// baseLockout and twice as long for every further failure after that.
+	maxAccountFailures = 5
+	// maxIPFailures is how many wrong passwords one IP may send within
+	// ipFailureWindow, whichever accounts they were for.
+	maxIPFailures   = 20
+	ipFailureWindow = 15 * time.Minute
+	baseLockout     = time.Minute
+	maxLockout      = time.Hour
+)
+
+// lockoutDuration backs off exponentially once failures reach threshold.
+func lockoutDuration(failures, threshold int) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+	shift := failures - threshold
+	if shift > 10 {
+		return maxLockout
+	}
+	return time.Duration(math.Min(float64(baseLockout<<shift), float64(maxLockout)))
+}
+
+// failureCounter keeps failed sign-ins in memory, for keys the user
+// repository cannot hold: client IPs, and emails that have no account.
+// Counts are forgotten after window without failures.
+type failureCounter struct {
+	lock      sync.Mutex
+	entries   map[string]*failureEntry
+	threshold int
+	window    time.Duration
+}
+
+type failureEntry struct {
+	failures    int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+func newFailureCounter(threshold int, window time.Duration) *failureCounter {
+	return &failureCounter{entries: make(map[string]*failureEntry), threshold: threshold, window: window}
+}
+
+func (c *failureCounter) lockedUntil(key string, now time.Time) time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if entry, ok := c.entries[key]; ok {
+		return entry.lockedUntil
+	}
+	return time.Time{}
+}
+
+// fail counts a failure and returns the lock it earned, if any.
+func (c *failureCounter) fail(key string, now time.Time) time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for k, entry := range c.entries {
+		if now.Sub(entry.last) > c.window && now.After(entry.lockedUntil) {
+			delete(c.entries, k)
+		}
+	}
+
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &failureEntry{}
+		c.entries[key] = entry
+	}
+	entry.failures++
+	entry.last = now
+	if lock := lockoutDuration(entry.failures, c.threshold); lock > 0 {
+		entry.lockedUntil = now.Add(lock)
+	}
+	return entry.lockedUntil
+}
+
+var (
+	ipLoginFailures = newFailureCounter(maxIPFailures, ipFailureWindow)
+	// unknownAccountFailures locks emails without an account just like real
+	// ones, so a lockout does not reveal which emails are registered.
+	unknownAccountFailures = newFailureCounter(maxAccountFailures, 24*time.Hour)
+)
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// burnPasswordCheck spends as long as a real bcrypt comparison, so that a
+// sign-in for an unknown email takes as long as one with a wrong password.
+func burnPasswordCheck(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+	})
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
+
+func tooManySignInAttempts(w http.ResponseWriter, until time.Time) {
+	seconds := int(math.Ceil(time.Until(until).Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, "Too many failed sign-in attempts, try again later", http.StatusTooManyRequests)
+}
+
+// recordFailedSignIn counts a wrong password against the IP and the
+// account and locks either once it has failed too often.
+func recordFailedSignIn(r *http.Request, email string, accountExists bool) {
+	now := time.Now()
+	ipLoginFailures.fail(clientIP(r), now)
+
+	if !accountExists {
+		unknownAccountFailures.fail(email, now)
+		return
+	}
+
+	users := store.Get().Users
+	failures, err := users.RecordFailedLogin(email)
+	if err != nil {
+		return
+	}
+	lock := lockoutDuration(failures, maxAccountFailures)
+	if lock == 0 {
+		return
+	}
+	if err := users.LockUntil(email, now.Add(lock)); err != nil {
+		log.Printf("Failed to lock account %s: %v", email, err)
+		return
+	}
+	recordAudit(r, types.AuditEvent{
+		Action:  types.AuditAccountLocked,
+		Actor:   email,
+		Details: map[string]interface{}{"failures": failures, "lockedForSeconds": int(lock.Seconds())},
+	})
+}
+
+// UnlockAccount lets an admin lift a lockout before it runs out.
+func UnlockAccount(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("UnlockAccount Called!")
+	email := mux.Vars(r)["email"]
+
+	err := store.Get().Users.ResetFailedLogins(email)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to unlock account")
+		return
+	}
+
+	recordAudit(r, types.AuditEvent{
+		Action: types.AuditAccountUnlocked,
+		Actor:  middleware.GetEmail(r),
+		Target: email,
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Account unlocked",
+	})
+}
@@ -0,0 +1,292 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"go-gather/store"
+	"go-gather/types"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultMessagePageSize = 50
+	maxMessagePageSize     = 200
+)
+
+// ListMessages pages back through a channel of the room: ?zone= for a
+// zone, or the room itself without it. ?replyTo= lists the replies to a
+// message instead.
+func ListMessages(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("ListMessages Called!")
+
+	channel := types.ChatChannel(types.ChatData{Zone: r.URL.Query().Get("zone")})
+	listMessages(w, r, mux.Vars(r)["roomId"], channel)
+}
+
+// ListChannelMessages serves the ws server, which names the channel
+// directly after checking the client may read it.
+func ListChannelMessages(w http.ResponseWriter, r *http.Request) {
+	listMessages(w, r, mux.Vars(r)["roomId"], r.URL.Query().Get("channel"))
+}
+
+func listMessages(w http.ResponseWriter, r *http.Request, roomID, channel string) {
+	query := r.URL.Query()
+
+	limit := defaultMessagePageSize
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+		limit = n
+	}
+	if limit > maxMessagePageSize {
+		limit = maxMessagePageSize
+	}
+
+	messages, err := store.Get().Messages.List(store.MessageQuery{
+		RoomID:  roomID,
+		Channel: channel,
+		ReplyTo: query.Get("replyTo"),
+		Before:  query.Get("before"),
+		Limit:   limit,
+	})
+	if err == store.ErrBadCursor {
+		writeError(w, http.StatusBadRequest, "Invalid before cursor")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load messages")
+		return
+	}
+
+	// A full page means there may be more; the oldest ID continues from it.
+	nextCursor := ""
+	if len(messages) == limit {
+		nextCursor = messages[0].ID
+	}
+
+	response := map[string]interface{}{
+		"success":    true,
+		"channel":    channel,
+		"messages":   messages,
+		"nextCursor": nextCursor,
+	}
+
+	// Room channels come with everyone's read markers. Conversations keep
+	// their own read positions.
+	if roomID != "" && query.Get("replyTo") == "" {
+		markers, err := store.Get().ReadMarkers.ListByChannel(roomID, channel)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to load read markers")
+			return
+		}
+		response["readMarkers"] = markers
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// RecordMessage stores a chat message for the ws server and hands back its
+// ID and timestamp so they can go out with the broadcast.
+func RecordMessage(w http.ResponseWriter, r *http.Request) {
+	var message types.ChatMessage
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	message.RoomID = mux.Vars(r)["roomId"]
+
+	if message.SenderID == "" || (message.Body == "" && len(message.Attachments) == 0) {
+		writeError(w, http.StatusBadRequest, "senderId and a body or attachments are required")
+		return
+	}
+	if problem := checkAttachments(message.RoomID, message.SenderID, message.Attachments); problem != "" {
+		writeError(w, http.StatusBadRequest, problem)
+		return
+	}
+	if message.ReplyTo != "" {
+		parent, err := store.Get().Messages.Get(message.ReplyTo)
+		if err != nil || parent.RoomID != message.RoomID || parent.Channel != message.Channel {
+			writeError(w, http.StatusBadRequest, "Replied-to message not found in this channel")
+			return
+		}
+	}
+
+	verdict, ok := screenMessage(w, message.RoomID, message.Body)
+	if !ok {
+		return
+	}
+	written := message.Body
+	message.Body = verdict.Body
+
+	if err := store.Get().Messages.Create(&message); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to save message")
+		return
+	}
+	if verdict.Flagged {
+		queueReview(&message, written, verdict)
+	}
+	dispatchMessage(message)
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"success":     true,
+		"chatMessage": message,
+	})
+}
+
+// roomMessage loads the {messageId} message, answering 404 unless it
+// belongs to the {roomId} room.
+func roomMessage(w http.ResponseWriter, r *http.Request) (*types.ChatMessage, bool) {
+	vars := mux.Vars(r)
+	message, err := store.Get().Messages.Get(vars["messageId"])
+	if err == store.ErrNotFound || (err == nil && message.RoomID != vars["roomId"]) {
+		writeError(w, http.StatusNotFound, "Message not found")
+		return nil, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load message")
+		return nil, false
+	}
+	return message, true
+}
+
+// The handlers below serve the ws server, which checks who may edit,
+// delete or react before calling them.
+
+func GetRoomMessage(w http.ResponseWriter, r *http.Request) {
+	message, ok := roomMessage(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":     true,
+		"chatMessage": message,
+	})
+}
+
+func EditRoomMessage(w http.ResponseWriter, r *http.Request) {
+	current, ok := roomMessage(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Body string `json:"body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Body == "" {
+		writeError(w, http.StatusBadRequest, "body is required")
+		return
+	}
+
+	// Edits go through the same filters, or they would be a way around them.
+	verdict, ok := screenMessage(w, current.RoomID, body.Body)
+	if !ok {
+		return
+	}
+
+	message, err := store.Get().Messages.Edit(current.ID, verdict.Body)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, "Message not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to edit message")
+		return
+	}
+	if verdict.Flagged {
+		queueReview(message, body.Body, verdict)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":     true,
+		"chatMessage": message,
+	})
+}
+
+func DeleteRoomMessage(w http.ResponseWriter, r *http.Request) {
+	if _, ok := roomMessage(w, r); !ok {
+		return
+	}
+
+	message, err := store.Get().Messages.Delete(mux.Vars(r)["messageId"])
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, "Message not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to delete message")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":     true,
+		"chatMessage": message,
+	})
+}
+
+func ReactToRoomMessage(w http.ResponseWriter, r *http.Request) {
+	if _, ok := roomMessage(w, r); !ok {
+		return
+	}
+
+	var body struct {
+		UserID string `json:"userId"`
+		Emoji  string `json:"emoji"`
+		Remove bool   `json:"remove"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.UserID == "" || body.Emoji == "" {
+		writeError(w, http.StatusBadRequest, "userId and emoji are required")
+		return
+	}
+
+	reactions, err := store.Get().Messages.React(mux.Vars(r)["messageId"], body.UserID, body.Emoji, body.Remove)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, "Message not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to save reaction")
+		return
+	}
+
+	if reactions == nil {
+		reactions = []types.Reaction{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":   true,
+		"reactions": reactions,
+	})
+}
+
+// SaveReadMarker records how far a user of the ws server has read a room
+// channel.
+func SaveReadMarker(w http.ResponseWriter, r *http.Request) {
+	var marker types.ReadMarker
+	if err := json.NewDecoder(r.Body).Decode(&marker); err != nil || marker.UserID == "" || marker.MessageID == "" {
+		writeError(w, http.StatusBadRequest, "userId and messageId are required")
+		return
+	}
+	marker.RoomID = mux.Vars(r)["roomId"]
+
+	message, err := store.Get().Messages.Get(marker.MessageID)
+	if err != nil || message.RoomID != marker.RoomID || message.Channel != marker.Channel {
+		writeError(w, http.StatusNotFound, "Message not found in this channel")
+		return
+	}
+
+	if err := store.Get().ReadMarkers.Save(&marker); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to save read marker")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"readMarker": marker,
+	})
+}
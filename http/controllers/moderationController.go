@@ -0,0 +1,227 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"go-gather/http/middleware"
+	"go-gather/http/models"
+	"go-gather/http/notifier"
+	"go-gather/moderation"
+	"go-gather/roles"
+	"go-gather/store"
+	"go-gather/types"
+
+	"github.com/gorilla/mux"
+)
+
+// filterChains caches each room's compiled filters. Saving a room's
+// configuration drops its entry.
+var filterChains sync.Map // roomID -> *moderation.Chain
+
+func roomFilters(roomID string) (*moderation.Chain, error) {
+	if chain, ok := filterChains.Load(roomID); ok {
+		return chain.(*moderation.Chain), nil
+	}
+
+	config, err := store.Get().Moderation.GetFilters(roomID)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := config.Build()
+	if err != nil {
+		// Only valid configurations are saved, so this is a stored rule
+		// the current code no longer accepts. Fail open but say so.
+		log.Printf("Filters of room %s do not build: %v", roomID, err)
+		chain = moderation.NewChain()
+	}
+	filterChains.Store(roomID, chain)
+	return chain, nil
+}
+
+// screenMessage runs body through the room's filters. When it returns
+// false the response has been written: the message was rejected or the
+// filters could not be loaded.
+func screenMessage(w http.ResponseWriter, roomID, body string) (moderation.Verdict, bool) {
+	chain, err := roomFilters(roomID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load room filters")
+		return moderation.Verdict{}, false
+	}
+
+	verdict := chain.Check(body)
+	if verdict.Rejected {
+		writeError(w, http.StatusUnprocessableEntity, "Message was blocked by this room's filters")
+		return verdict, false
+	}
+	return verdict, true
+}
+
+// queueReview puts a flagged message in front of the room's moderators.
+// body is what the sender wrote, before masking.
+func queueReview(message *types.ChatMessage, body string, verdict moderation.Verdict) {
+	review := &models.Review{
+		RoomID:    message.RoomID,
+		MessageID: message.ID,
+		SenderID:  message.SenderID,
+		Body:      body,
+		Reasons:   verdict.Reasons(),
+	}
+	if err := store.Get().Moderation.CreateReview(review); err != nil {
+		log.Printf("Message %s was flagged but could not be queued: %v", message.ID, err)
+		return
+	}
+
+	notifier.Notify(types.Notification{
+		Type:       "review-queued",
+		RoomID:     message.RoomID,
+		Permission: string(roles.PermDeleteMessages),
+		Data:       review,
+	})
+}
+
+func GetRoomFilters(w http.ResponseWriter, r *http.Request) {
+	config, err := store.Get().Moderation.GetFilters(mux.Vars(r)["roomId"])
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load room filters")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"filters": config,
+	})
+}
+
+// UpdateRoomFilters replaces the room's whole filter configuration.
+func UpdateRoomFilters(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("UpdateRoomFilters Called!")
+	roomID := mux.Vars(r)["roomId"]
+
+	var config moderation.Config
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := config.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := store.Get().Moderation.SaveFilters(roomID, &config); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to save room filters")
+		return
+	}
+	filterChains.Delete(roomID)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"filters": config,
+	})
+}
+
+// ListReviews shows the room's review queue. Only pending reviews are
+// listed unless ?status= names another status or "all".
+func ListReviews(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = models.ReviewPending
+	case "all":
+		status = ""
+	case models.ReviewPending, models.ReviewApproved, models.ReviewRemoved:
+	default:
+		writeError(w, http.StatusBadRequest, "Unknown status")
+		return
+	}
+
+	reviews, err := store.Get().Moderation.ListReviews(mux.Vars(r)["roomId"], status)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to list reviews")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"reviews": reviews,
+	})
+}
+
+// ResolveReview takes a moderator's decision on a flagged message:
+// "approve" leaves it up, "remove" deletes it for everyone.
+func ResolveReview(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("ResolveReview Called!")
+	vars := mux.Vars(r)
+	moderator := middleware.GetEmail(r)
+
+	var body struct {
+		Decision string `json:"decision"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var status string
+	switch body.Decision {
+	case "approve":
+		status = models.ReviewApproved
+	case "remove":
+		status = models.ReviewRemoved
+	default:
+		writeError(w, http.StatusBadRequest, `decision must be "approve" or "remove"`)
+		return
+	}
+
+	moderationStore := store.Get().Moderation
+	review, err := moderationStore.GetReview(vars["reviewId"])
+	if err == store.ErrNotFound || (err == nil && review.RoomID != vars["roomId"]) {
+		writeError(w, http.StatusNotFound, "Review not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load review")
+		return
+	}
+
+	review, err = moderationStore.ResolveReview(review.ID, status, moderator)
+	if err == store.ErrConflict {
+		writeError(w, http.StatusConflict, "Review was already resolved")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to resolve review")
+		return
+	}
+
+	if status == models.ReviewRemoved {
+		deleted, err := store.Get().Messages.Delete(review.MessageID)
+		// The sender may have deleted it already, which is just as good.
+		if err != nil && err != store.ErrNotFound {
+			writeError(w, http.StatusInternalServerError, "Failed to delete message")
+			return
+		}
+		if err == nil {
+			notifier.Notify(types.Notification{
+				Type:   "message-deleted",
+				RoomID: review.RoomID,
+				Data:   deleted,
+			})
+			recordAudit(r, types.AuditEvent{
+				Action:  types.AuditMessageDeleted,
+				Actor:   moderator,
+				Target:  review.SenderID,
+				RoomID:  review.RoomID,
+				Details: map[string]interface{}{"messageId": review.MessageID, "body": review.Body, "reviewId": review.ID},
+			})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"review":  review,
+	})
+}
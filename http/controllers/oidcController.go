@@ -0,0 +1,211 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"go-gather/http/models"
+	"go-gather/oidc"
+	"go-gather/store"
+	"go-gather/types"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcLoginTTL    = 10 * time.Minute
+)
+
+// pendingLogin is what we need to remember between sending the browser to
+// the provider and it coming back with a code.
+type pendingLogin struct {
+	nonce     string
+	verifier  string
+	expiresAt time.Time
+}
+
+var (
+	pendingLoginsLock sync.Mutex
+	pendingLogins     = make(map[string]pendingLogin) // state -> login
+)
+
+func savePendingLogin(state string, login pendingLogin) {
+	pendingLoginsLock.Lock()
+	defer pendingLoginsLock.Unlock()
+
+	now := time.Now()
+	for key, pending := range pendingLogins {
+		if now.After(pending.expiresAt) {
+			delete(pendingLogins, key)
+		}
+	}
+	pendingLogins[state] = login
+}
+
+// takePendingLogin returns the login started with state, at most once.
+func takePendingLogin(state string) (pendingLogin, bool) {
+	pendingLoginsLock.Lock()
+	defer pendingLoginsLock.Unlock()
+
+	login, ok := pendingLogins[state]
+	delete(pendingLogins, state)
+	if !ok || time.Now().After(login.expiresAt) {
+		return pendingLogin{}, false
+	}
+	return login, true
+}
+
+func identityProvider(w http.ResponseWriter, r *http.Request) (*oidc.Provider, bool) {
+	provider, err := oidc.Get(r.Context())
+	if err == oidc.ErrNotConfigured {
+		writeError(w, http.StatusNotFound, "Single sign-on is not configured")
+		return nil, false
+	}
+	if err != nil {
+		log.Println("Identity provider unavailable:", err)
+		writeError(w, http.StatusBadGateway, "Identity provider is unavailable")
+		return nil, false
+	}
+	return provider, true
+}
+
+// OIDCLogin sends the browser to the identity provider. The state is also
+// kept in a cookie so the callback only completes in the browser that
+// started the login.
+func OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("OIDCLogin Called!")
+
+	provider, ok := identityProvider(w, r)
+	if !ok {
+		return
+	}
+
+	var values [3]string
+	for i := range values {
+		value, err := oidc.RandomString()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to start sign-in")
+			return
+		}
+		values[i] = value
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	savePendingLogin(state, pendingLogin{nonce: nonce, verifier: verifier, expiresAt: time.Now().Add(oidcLoginTTL)})
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/auth/oidc",
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, provider.AuthCodeURL(state, nonce, verifier), http.StatusFound)
+}
+
+// OIDCCallback finishes a login the provider sent back, signing the user
+// in to the account linked to their identity and creating it on first
+// use.
+func OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("OIDCCallback Called!")
+	query := r.URL.Query()
+
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: "", Path: "/auth/oidc", MaxAge: -1})
+
+	if providerError := query.Get("error"); providerError != "" {
+		writeError(w, http.StatusUnauthorized, "Sign-in was not completed: "+providerError)
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || cookie.Value != state {
+		writeError(w, http.StatusBadRequest, "Sign-in state does not match, please start again")
+		return
+	}
+	login, ok := takePendingLogin(state)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Sign-in expired, please start again")
+		return
+	}
+
+	provider, ok := identityProvider(w, r)
+	if !ok {
+		return
+	}
+
+	claims, err := provider.Exchange(r.Context(), query.Get("code"), login.verifier, login.nonce)
+	if err != nil {
+		log.Println("OIDC code exchange failed:", err)
+		writeError(w, http.StatusUnauthorized, "Identity provider did not confirm the sign-in")
+		return
+	}
+
+	user, err := userForIdentity(r, provider.Issuer(), claims)
+	if err != nil {
+		log.Printf("No account for %s at %s: %v", claims.Subject, provider.Issuer(), err)
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	completeSignIn(w, r, user, map[string]interface{}{"provider": provider.Issuer()})
+}
+
+// userForIdentity finds the account linked to the provider's subject. An
+// unlinked subject is linked to the account with its email, or to a new
+// one, but only when the provider vouches for the email; otherwise anyone
+// able to set an email at the provider could take over an account here.
+func userForIdentity(r *http.Request, issuer string, claims *oidc.Claims) (*models.User, error) {
+	users := store.Get().Users
+
+	user, err := users.GetByIdentity(issuer, claims.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if err != store.ErrNotFound {
+		return nil, errors.New("Failed to look up account")
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errors.New("Identity provider did not supply a verified email")
+	}
+
+	user, err = users.GetByEmail(claims.Email)
+	if err == store.ErrNotFound {
+		// SSO accounts have no password; the empty hash never matches.
+		user = &models.User{Email: claims.Email}
+		createErr := users.Create(user)
+		if createErr != nil && createErr != store.ErrConflict {
+			return nil, errors.New("Failed to create account")
+		}
+		// On a conflict a sign-up got there first, and its account is
+		// checked like any other below.
+		if createErr == nil {
+			users.SetEmailVerified(claims.Email)
+			recordAudit(r, types.AuditEvent{
+				Action:  types.AuditSignUp,
+				Actor:   claims.Email,
+				Details: map[string]interface{}{"provider": issuer},
+			})
+		}
+		user, err = users.GetByEmail(claims.Email)
+	}
+	if err != nil {
+		return nil, errors.New("Failed to look up account")
+	}
+	// Anyone can sign up with an address they do not own. Linking such an
+	// account would leave its password with whoever set it, so the owner
+	// has to verify the email here first.
+	if !user.EmailVerified {
+		return nil, errors.New("An unverified account already uses this email; verify it before signing in with your identity provider")
+	}
+
+	if err := users.LinkIdentity(user.Email, issuer, claims.Subject); err != nil && err != store.ErrConflict {
+		return nil, errors.New("Failed to link account")
+	}
+	return user, nil
+}
@@ -0,0 +1,281 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go-gather/http/middleware"
+	"go-gather/http/models"
+	"go-gather/http/notifier"
+	"go-gather/store"
+	"go-gather/types"
+)
+
+const (
+	maxDisplayNameLength = 32
+	maxStatusLength      = 140
+	maxAppearanceEntries = 16
+	maxAppearanceValue   = 64
+	// maxProfileLookup bounds one internal lookup; the ws server asks for
+	// a page of chat history's senders at most.
+	maxProfileLookup = 200
+)
+
+// Sprite names and appearance keys are chosen by the client from its own
+// asset set, so only their shape is checked here.
+var assetKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+var availabilities = map[string]bool{
+	models.AvailabilityAvailable: true,
+	models.AvailabilityAway:      true,
+	models.AvailabilityBusy:      true,
+}
+
+func newPublicID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "user-" + hex.EncodeToString(b), nil
+}
+
+// ensureProfile returns the user's profile, giving them a default one the
+// first time. The default name is derived from the public ID rather than
+// the email, which is exactly what profiles are meant to keep private.
+func ensureProfile(email string) (*models.Profile, error) {
+	profiles := store.Get().Profiles
+	profile, err := profiles.Get(email)
+	if err != store.ErrNotFound {
+		return profile, err
+	}
+	if _, err := store.Get().Users.GetByEmail(email); err != nil {
+		return nil, err
+	}
+
+	id, err := newPublicID()
+	if err != nil {
+		return nil, err
+	}
+	profile = &models.Profile{Email: email}
+	profile.ID = id
+	profile.DisplayName = id[:len("user-")+6]
+	profile.Availability = models.AvailabilityAvailable
+
+	err = profiles.Create(profile)
+	if err == store.ErrConflict {
+		// Someone else created it first.
+		return profiles.Get(email)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// checkProfileText rejects text with control characters, which have no
+// business in a name or status and can break other people's clients.
+func checkProfileText(field, value string, max int) string {
+	if utf8.RuneCountInString(value) > max {
+		return fmt.Sprintf("%s must be at most %d characters", field, max)
+	}
+	for _, r := range value {
+		if unicode.IsControl(r) {
+			return fmt.Sprintf("%s cannot contain control characters", field)
+		}
+	}
+	return ""
+}
+
+// GetMe returns the caller's own profile, along with the account details
+// only they get to see.
+func GetMe(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("GetMe Called!")
+	email := middleware.GetEmail(r)
+
+	user, err := store.Get().Users.GetByEmail(email)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	profile, err := ensureProfile(email)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load profile")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":          true,
+		"email":            email,
+		"emailVerified":    user.EmailVerified,
+		"twoFactorEnabled": user.TwoFactor.Enabled,
+		"profile":          profile.Profile,
+		"updatedAt":        profile.UpdatedAt,
+	})
+}
+
+// UpdateMe changes the fields present in the body and leaves the rest.
+// Connected clients in the caller's room see the change straight away.
+func UpdateMe(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("UpdateMe Called!")
+	email := middleware.GetEmail(r)
+
+	var body struct {
+		DisplayName  *string            `json:"displayName"`
+		Avatar       *string            `json:"avatar"`
+		Appearance   *map[string]string `json:"appearance"`
+		Status       *string            `json:"status"`
+		Availability *string            `json:"availability"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	profile, err := ensureProfile(email)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load profile")
+		return
+	}
+
+	if body.DisplayName != nil {
+		name := strings.TrimSpace(*body.DisplayName)
+		if name == "" {
+			writeError(w, http.StatusBadRequest, "displayName cannot be empty")
+			return
+		}
+		if problem := checkProfileText("displayName", name, maxDisplayNameLength); problem != "" {
+			writeError(w, http.StatusBadRequest, problem)
+			return
+		}
+		profile.DisplayName = name
+	}
+	if body.Avatar != nil {
+		if *body.Avatar != "" && !assetKeyPattern.MatchString(*body.Avatar) {
+			writeError(w, http.StatusBadRequest, "Invalid avatar")
+			return
+		}
+		profile.Avatar = *body.Avatar
+	}
+	if body.Appearance != nil {
+		appearance := *body.Appearance
+		if len(appearance) > maxAppearanceEntries {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("appearance is limited to %d entries", maxAppearanceEntries))
+			return
+		}
+		for key, value := range appearance {
+			if !assetKeyPattern.MatchString(key) {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid appearance key %q", key))
+				return
+			}
+			if problem := checkProfileText("appearance."+key, value, maxAppearanceValue); problem != "" {
+				writeError(w, http.StatusBadRequest, problem)
+				return
+			}
+		}
+		if len(appearance) == 0 {
+			appearance = nil
+		}
+		profile.Appearance = appearance
+	}
+	if body.Status != nil {
+		status := strings.TrimSpace(*body.Status)
+		if problem := checkProfileText("status", status, maxStatusLength); problem != "" {
+			writeError(w, http.StatusBadRequest, problem)
+			return
+		}
+		profile.Status = status
+	}
+	if body.Availability != nil {
+		if !availabilities[*body.Availability] {
+			writeError(w, http.StatusBadRequest, "availability must be available, away or busy")
+			return
+		}
+		profile.Availability = *body.Availability
+	}
+
+	if err := store.Get().Profiles.Update(profile); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to save profile")
+		return
+	}
+
+	// The ws server shows the profile to the rooms the user is in.
+	notifier.Notify(types.Notification{
+		Type:    "profile-updated",
+		UserIDs: []string{email},
+		Data:    profile.Profile,
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":   true,
+		"profile":   profile.Profile,
+		"updatedAt": profile.UpdatedAt,
+	})
+}
+
+// LookupProfiles lets the ws server translate between emails and public
+// profiles. Emails of users without a profile get a default one; unknown
+// emails and IDs are left out.
+func LookupProfiles(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Emails []string `json:"emails"`
+		IDs    []string `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(body.Emails)+len(body.IDs) > maxProfileLookup {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("At most %d profiles per lookup", maxProfileLookup))
+		return
+	}
+
+	profiles := store.Get().Profiles
+	found := []models.Profile{}
+	if len(body.Emails) > 0 {
+		stored, err := profiles.List(body.Emails)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to load profiles")
+			return
+		}
+		found = append(found, stored...)
+
+		known := make(map[string]bool, len(stored))
+		for _, profile := range stored {
+			known[profile.Email] = true
+		}
+		for _, email := range body.Emails {
+			if known[email] {
+				continue
+			}
+			known[email] = true
+			profile, err := ensureProfile(email)
+			if err == store.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				log.Printf("Failed to create profile of %s: %v", email, err)
+				continue
+			}
+			found = append(found, *profile)
+		}
+	}
+	for _, id := range body.IDs {
+		profile, err := profiles.GetByID(id)
+		if err == nil {
+			found = append(found, *profile)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"profiles": found,
+	})
+}
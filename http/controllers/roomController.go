@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"go-gather/http/middleware"
+	"go-gather/http/models"
+	"go-gather/roles"
+	"go-gather/store"
+	"go-gather/types"
+
+	"github.com/gorilla/mux"
+)
+
+func CreateRoom(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("CreateRoom Called!")
+
+	var body struct {
+		RoomID string `json:"roomId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RoomID == "" {
+		writeError(w, http.StatusBadRequest, "roomId is required")
+		return
+	}
+
+	err := store.Get().Rooms.Create(body.RoomID, middleware.GetEmail(r))
+	if err == store.ErrConflict {
+		writeError(w, http.StatusConflict, "Room already exists")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create room")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"success": true,
+		"roomId":  body.RoomID,
+		"role":    roles.Owner,
+	})
+}
+
+func ListRoomMembers(w http.ResponseWriter, r *http.Request) {
+	roomID := mux.Vars(r)["roomId"]
+
+	members, err := store.Get().Memberships.ListByRoom(roomID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to list members")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"members": members,
+	})
+}
+
+// SetMemberRole adds a user to the room or changes their role. Callers can
+// only grant roles below their own and only touch members they outrank.
+func SetMemberRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID, email := vars["roomId"], vars["email"]
+	callerRole := middleware.GetRole(r)
+
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	role, ok := roles.Parse(body.Role)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Unknown role")
+		return
+	}
+
+	if email == middleware.GetEmail(r) {
+		writeError(w, http.StatusForbidden, "Cannot change your own role")
+		return
+	}
+
+	if !callerRole.Outranks(role) {
+		writeError(w, http.StatusForbidden, "Cannot grant a role equal to or above your own")
+		return
+	}
+
+	memberships := store.Get().Memberships
+
+	current, err := memberships.Get(roomID, email)
+	if err != nil && err != store.ErrNotFound {
+		writeError(w, http.StatusInternalServerError, "Failed to load membership")
+		return
+	}
+	if current != nil && !callerRole.Outranks(current.Role) {
+		writeError(w, http.StatusForbidden, "Cannot change the role of this member")
+		return
+	}
+
+	membership := models.Membership{RoomID: roomID, Email: email, Role: role}
+	if err := memberships.Save(&membership); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to save membership")
+		return
+	}
+
+	previousRole := ""
+	if current != nil {
+		previousRole = string(current.Role)
+	}
+	recordAudit(r, types.AuditEvent{
+		Action:  types.AuditRoleChange,
+		Actor:   middleware.GetEmail(r),
+		Target:  email,
+		RoomID:  roomID,
+		Details: map[string]interface{}{"from": previousRole, "to": role},
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"membership": membership,
+	})
+}
+
+func RemoveMember(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID, email := vars["roomId"], vars["email"]
+	callerRole := middleware.GetRole(r)
+
+	memberships := store.Get().Memberships
+
+	current, err := memberships.Get(roomID, email)
+	if err == store.ErrNotFound {
+		writeError(w, http.StatusNotFound, "Member not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load membership")
+		return
+	}
+
+	if !callerRole.Outranks(current.Role) {
+		writeError(w, http.StatusForbidden, "Cannot remove this member")
+		return
+	}
+
+	if err := memberships.Delete(roomID, email); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to remove member")
+		return
+	}
+
+	recordAudit(r, types.AuditEvent{
+		Action:  types.AuditMemberRemoved,
+		Actor:   middleware.GetEmail(r),
+		Target:  email,
+		RoomID:  roomID,
+		Details: map[string]interface{}{"role": current.Role},
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"roomId":  roomID,
+		"email":   email,
+	})
+}
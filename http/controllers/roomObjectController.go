@@ -0,0 +1,321 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+
+	"go-gather/http/middleware"
+	"go-gather/http/notifier"
+	"go-gather/store"
+	"go-gather/types"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxObjectsPerRoom    = 200
+	maxObjectTitleLength = 100
+	maxObjectURLLength   = 2048
+	// maxObjectStateSize bounds what interactions can pile up in one
+	// object, such as the strokes on a whiteboard.
+	maxObjectStateSize = 64 * 1024
+)
+
+// How an object property is checked.
+const (
+	propertyText  = "text"
+	propertyURL   = "url"
+	propertyRoom  = "room"
+	propertyPoint = "point"
+)
+
+// objectProperties lists the properties each kind of object takes.
+var objectProperties = map[string]map[string]string{
+	types.ObjectWhiteboard: {"title": propertyText},
+	types.ObjectLink:       {"title": propertyText, "url": propertyURL},
+	types.ObjectNote:       {"title": propertyText},
+	types.ObjectPortal:     {"title": propertyText, "roomId": propertyRoom, "x": propertyPoint, "y": propertyPoint},
+}
+
+var requiredObjectProperties = map[string][]string{
+	types.ObjectLink:   {"url"},
+	types.ObjectPortal: {"roomId"},
+}
+
+// checkObjectProperties returns what is wrong with the properties of an
+// object of the type in the room, or "".
+func checkObjectProperties(roomID, objectType string, properties map[string]interface{}) string {
+	allowed := objectProperties[objectType]
+	for _, key := range requiredObjectProperties[objectType] {
+		if _, ok := properties[key]; !ok {
+			return fmt.Sprintf("A %s needs the %s property", objectType, key)
+		}
+	}
+
+	for key, value := range properties {
+		kind, ok := allowed[key]
+		if !ok {
+			return fmt.Sprintf("Unknown %s property %q", objectType, key)
+		}
+		switch kind {
+		case propertyText:
+			text, ok := value.(string)
+			if !ok {
+				return fmt.Sprintf("%s must be a string", key)
+			}
+			if problem := checkProfileText(key, text, maxObjectTitleLength); problem != "" {
+				return problem
+			}
+		case propertyURL:
+			text, _ := value.(string)
+			target, err := url.Parse(text)
+			if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" ||
+				len(text) > maxObjectURLLength {
+				return fmt.Sprintf("%s must be an http or https URL", key)
+			}
+		case propertyRoom:
+			target, _ := value.(string)
+			if target == "" || target == roomID {
+				return fmt.Sprintf("%s must name another room", key)
+			}
+			exists, err := store.Get().Rooms.Exists(target)
+			if err != nil || !exists {
+				return fmt.Sprintf("Room %q does not exist", target)
+			}
+		case propertyPoint:
+			number, ok := value.(float64)
+			if !ok || number != math.Trunc(number) {
+				return fmt.Sprintf("%s must be a whole number", key)
+			}
+		}
+	}
+	return ""
+}
+
+func roomObject(w http.ResponseWriter, r *http.Request) (*types.RoomObject, bool) {
+	vars := mux.Vars(r)
+	object, err := store.Get().Objects.Get(vars["objectId"])
+	if err == store.ErrNotFound || (err == nil && object.RoomID != vars["roomId"]) {
+		writeError(w, http.StatusNotFound, "Object not found")
+		return nil, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load object")
+		return nil, false
+	}
+	return object, true
+}
+
+// notifyObject shows connected clients the object as it is now.
+func notifyObject(object *types.RoomObject) {
+	notifier.Notify(types.Notification{
+		Type:   "object-updated",
+		RoomID: object.RoomID,
+		Data:   object,
+	})
+}
+
+// CreateRoomObject places an object on the room's map.
+func CreateRoomObject(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("CreateRoomObject Called!")
+	roomID := mux.Vars(r)["roomId"]
+
+	var body struct {
+		Type       string                 `json:"type"`
+		X          int                    `json:"x"`
+		Y          int                    `json:"y"`
+		Properties map[string]interface{} `json:"properties"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if _, ok := objectProperties[body.Type]; !ok {
+		writeError(w, http.StatusBadRequest, "type must be whiteboard, link, note or portal")
+		return
+	}
+	if problem := checkObjectProperties(roomID, body.Type, body.Properties); problem != "" {
+		writeError(w, http.StatusBadRequest, problem)
+		return
+	}
+
+	objects := store.Get().Objects
+	existing, err := objects.ListByRoom(roomID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load objects")
+		return
+	}
+	if len(existing) >= maxObjectsPerRoom {
+		writeError(w, http.StatusConflict, fmt.Sprintf("At most %d objects per room", maxObjectsPerRoom))
+		return
+	}
+
+	id, err := newRandomID("obj-")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create object")
+		return
+	}
+	object := &types.RoomObject{
+		ID:         id,
+		RoomID:     roomID,
+		Type:       body.Type,
+		X:          body.X,
+		Y:          body.Y,
+		Properties: body.Properties,
+		State:      map[string]interface{}{},
+		CreatedBy:  middleware.GetEmail(r),
+	}
+	if object.Properties == nil {
+		object.Properties = map[string]interface{}{}
+	}
+	if err := objects.Create(object); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create object")
+		return
+	}
+	notifyObject(object)
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"success": true,
+		"object":  object,
+	})
+}
+
+// ListRoomObjects returns everything on the room's map. The ws server
+// uses it too, for the join snapshot.
+func ListRoomObjects(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("ListRoomObjects Called!")
+
+	objects, err := store.Get().Objects.ListByRoom(mux.Vars(r)["roomId"])
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load objects")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"objects": objects,
+	})
+}
+
+func GetRoomObject(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("GetRoomObject Called!")
+
+	object, ok := roomObject(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"object":  object,
+	})
+}
+
+// UpdateRoomObject moves the object or replaces its properties. The type
+// stays; replace the object to change it.
+func UpdateRoomObject(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("UpdateRoomObject Called!")
+
+	var body struct {
+		X          *int                    `json:"x"`
+		Y          *int                    `json:"y"`
+		Properties *map[string]interface{} `json:"properties"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	object, ok := roomObject(w, r)
+	if !ok {
+		return
+	}
+	if body.X != nil {
+		object.X = *body.X
+	}
+	if body.Y != nil {
+		object.Y = *body.Y
+	}
+	if body.Properties != nil {
+		properties := *body.Properties
+		if problem := checkObjectProperties(object.RoomID, object.Type, properties); problem != "" {
+			writeError(w, http.StatusBadRequest, problem)
+			return
+		}
+		if properties == nil {
+			properties = map[string]interface{}{}
+		}
+		object.Properties = properties
+	}
+
+	if err := store.Get().Objects.Update(object); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to save object")
+		return
+	}
+	notifyObject(object)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"object":  object,
+	})
+}
+
+func DeleteRoomObject(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("DeleteRoomObject Called!")
+
+	object, ok := roomObject(w, r)
+	if !ok {
+		return
+	}
+	if err := store.Get().Objects.Delete(object.ID); err != nil && err != store.ErrNotFound {
+		writeError(w, http.StatusInternalServerError, "Failed to delete object")
+		return
+	}
+	notifier.Notify(types.Notification{
+		Type:   "object-removed",
+		RoomID: object.RoomID,
+		Data:   map[string]string{"id": object.ID, "roomId": object.RoomID},
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Object deleted",
+	})
+}
+
+// SaveRoomObjectState stores the state the ws server worked out for an
+// interaction. The ws server tells the room itself.
+func SaveRoomObjectState(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		State map[string]interface{} `json:"state"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if raw, _ := json.Marshal(body.State); len(raw) > maxObjectStateSize {
+		writeError(w, http.StatusUnprocessableEntity, "Object state is too large")
+		return
+	}
+
+	object, ok := roomObject(w, r)
+	if !ok {
+		return
+	}
+	object.State = body.State
+	if object.State == nil {
+		object.State = map[string]interface{}{}
+	}
+	if err := store.Get().Objects.SaveState(object); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to save object state")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"object":  object,
+	})
+}
@@ -0,0 +1,343 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go-gather/auth"
+	"go-gather/http/middleware"
+	"go-gather/http/models"
+	"go-gather/store"
+	"go-gather/totp"
+	"go-gather/types"
+)
+
+const (
+	// twoFactorChallengeTTL is how long a user has to enter their code
+	// after the password was accepted.
+	twoFactorChallengeTTL = 5 * time.Minute
+	recoveryCodeCount     = 10
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpIssuer is the service name authenticator apps show next to the
+// account.
+func totpIssuer() string {
+	if value := os.Getenv("TOTP_ISSUER"); value != "" {
+		return value
+	}
+	return "go-gather"
+}
+
+// newRecoveryCodes returns fresh codes to show the user once, along with
+// the hashes to store.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryEncoding.EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(code)
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode ignores case, dashes and spaces, which people add or
+// drop when they copy a code out.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return hashToken(code)
+}
+
+type secondFactor struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// checkSecondFactor verifies a TOTP code or, failing that, a recovery
+// code, using it up either way. Wrong codes count towards the same
+// lockouts as wrong passwords, since six digits are quick to guess
+// otherwise. On failure the response has been written.
+func checkSecondFactor(w http.ResponseWriter, r *http.Request, user *models.User, factor secondFactor) (string, bool) {
+	now := time.Now()
+	if until := ipLoginFailures.lockedUntil(clientIP(r), now); until.After(now) {
+		tooManySignInAttempts(w, until)
+		return "", false
+	}
+	if user.LockedUntil != nil && user.LockedUntil.After(now) {
+		tooManySignInAttempts(w, *user.LockedUntil)
+		return "", false
+	}
+
+	users := store.Get().Users
+	method := ""
+	if factor.Code != "" {
+		if step, ok := totp.Validate(user.TwoFactor.Secret, factor.Code, now); ok && users.UseTOTPStep(user.Email, step) == nil {
+			method = "totp"
+		}
+	} else if factor.RecoveryCode != "" {
+		if users.UseRecoveryCode(user.Email, hashRecoveryCode(factor.RecoveryCode)) == nil {
+			method = "recovery-code"
+		}
+	} else {
+		writeError(w, http.StatusBadRequest, "code or recoveryCode is required")
+		return "", false
+	}
+
+	if method == "" {
+		recordFailedSignIn(r, user.Email, true)
+		writeError(w, http.StatusUnauthorized, "Invalid code")
+		return "", false
+	}
+	if user.FailedLogins > 0 || user.LockedUntil != nil {
+		users.ResetFailedLogins(user.Email)
+	}
+	return method, true
+}
+
+// startTwoFactorChallenge answers a sign-in that still needs the second
+// factor with a short-lived challenge token instead of an access token.
+func startTwoFactorChallenge(w http.ResponseWriter, user *models.User) {
+	claims := auth.Claims{Email: user.Email, Purpose: auth.PurposeTwoFactor}
+	challenge, err := auth.Sign(claims, twoFactorChallengeTTL)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":           true,
+		"twoFactorRequired": true,
+		"challenge":         challenge,
+		"expiresIn":         int(twoFactorChallengeTTL.Seconds()),
+	})
+}
+
+// VerifyTwoFactor finishes a sign-in by trading the challenge and a code
+// for the access token.
+func VerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("VerifyTwoFactor Called!")
+
+	var body struct {
+		Challenge string `json:"challenge"`
+		secondFactor
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Challenge == "" {
+		writeError(w, http.StatusBadRequest, "challenge is required")
+		return
+	}
+
+	claims, err := auth.ParsePurpose(body.Challenge, auth.PurposeTwoFactor)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "Invalid or expired challenge")
+		return
+	}
+	user, err := store.Get().Users.GetByEmail(claims.Email)
+	if err != nil || !user.TwoFactor.Enabled {
+		writeError(w, http.StatusUnauthorized, "Invalid or expired challenge")
+		return
+	}
+
+	method, ok := checkSecondFactor(w, r, user, body.secondFactor)
+	if !ok {
+		recordAudit(r, types.AuditEvent{
+			Action:  types.AuditSignInFailed,
+			Actor:   user.Email,
+			Details: map[string]interface{}{"secondFactor": true},
+		})
+		return
+	}
+
+	issueAccessToken(w, r, user, map[string]interface{}{"secondFactor": method})
+}
+
+// SetupTwoFactor starts enrollment with a new secret. It only counts once
+// EnableTwoFactor has seen a code from it, so starting over is harmless.
+func SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("SetupTwoFactor Called!")
+	email := middleware.GetEmail(r)
+
+	users := store.Get().Users
+	user, err := users.GetByEmail(email)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if user.TwoFactor.Enabled {
+		writeError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to start setup")
+		return
+	}
+	if err := users.SaveTwoFactor(email, models.TwoFactor{Secret: secret}); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to start setup")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":         true,
+		"secret":          secret,
+		"provisioningUri": totp.ProvisioningURI(secret, totpIssuer(), email),
+	})
+}
+
+// EnableTwoFactor turns 2FA on once the user shows a code from the secret
+// SetupTwoFactor gave them, and hands out the recovery codes.
+func EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("EnableTwoFactor Called!")
+	email := middleware.GetEmail(r)
+
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Code == "" {
+		writeError(w, http.StatusBadRequest, "code is required")
+		return
+	}
+
+	users := store.Get().Users
+	user, err := users.GetByEmail(email)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if user.TwoFactor.Enabled {
+		writeError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	if user.TwoFactor.Secret == "" {
+		writeError(w, http.StatusBadRequest, "Start two-factor setup first")
+		return
+	}
+
+	step, ok := totp.Validate(user.TwoFactor.Secret, body.Code, time.Now())
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Invalid code")
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+		return
+	}
+	err = users.SaveTwoFactor(email, models.TwoFactor{
+		Secret:        user.TwoFactor.Secret,
+		Enabled:       true,
+		LastStep:      step,
+		RecoveryCodes: hashes,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+		return
+	}
+	recordAudit(r, types.AuditEvent{Action: types.AuditTwoFactorEnabled, Actor: email})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":       true,
+		"message":       "Two-factor authentication enabled, store the recovery codes somewhere safe",
+		"recoveryCodes": codes,
+	})
+}
+
+// DisableTwoFactor turns 2FA off. It asks for a code so that a stolen
+// access token alone cannot do it.
+func DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("DisableTwoFactor Called!")
+	email := middleware.GetEmail(r)
+
+	var factor secondFactor
+	if err := json.NewDecoder(r.Body).Decode(&factor); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	users := store.Get().Users
+	user, err := users.GetByEmail(email)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if !user.TwoFactor.Enabled {
+		writeError(w, http.StatusConflict, "Two-factor authentication is not enabled")
+		return
+	}
+	if _, ok := checkSecondFactor(w, r, user, factor); !ok {
+		return
+	}
+
+	if err := users.SaveTwoFactor(email, models.TwoFactor{}); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		return
+	}
+	recordAudit(r, types.AuditEvent{Action: types.AuditTwoFactorDisabled, Actor: email})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+// RegenerateRecoveryCodes replaces every recovery code, used or not.
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("RegenerateRecoveryCodes Called!")
+	email := middleware.GetEmail(r)
+
+	var factor secondFactor
+	if err := json.NewDecoder(r.Body).Decode(&factor); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	users := store.Get().Users
+	user, err := users.GetByEmail(email)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if !user.TwoFactor.Enabled {
+		writeError(w, http.StatusConflict, "Two-factor authentication is not enabled")
+		return
+	}
+	if _, ok := checkSecondFactor(w, r, user, factor); !ok {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to generate recovery codes")
+		return
+	}
+	// Reload so the step checkSecondFactor just used is kept.
+	if current, err := users.GetByEmail(email); err == nil {
+		user = current
+	}
+	twoFactor := user.TwoFactor
+	twoFactor.RecoveryCodes = hashes
+	if err := users.SaveTwoFactor(email, twoFactor); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to generate recovery codes")
+		return
+	}
+	recordAudit(r, types.AuditEvent{Action: types.AuditRecoveryCodesRegenerated, Actor: email})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":       true,
+		"recoveryCodes": codes,
+	})
+}
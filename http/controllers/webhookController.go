@@ -0,0 +1,262 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"go-gather/http/middleware"
+	"go-gather/http/models"
+	"go-gather/http/webhooks"
+	"go-gather/store"
+	"go-gather/types"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxWebhooksPerRoom   = 10
+	maxWebhookURLLength  = 2048
+	minWebhookSecret     = 16
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 200
+)
+
+var webhookEvents = map[string]bool{
+	models.WebhookEventUserJoined:     true,
+	models.WebhookEventUserLeft:       true,
+	models.WebhookEventMessageCreated: true,
+}
+
+// webhookUser describes a user to a webhook the way other users see them,
+// by profile rather than email.
+func webhookUser(userID, guestName string) types.Profile {
+	if !strings.Contains(userID, "@") {
+		return types.Profile{ID: userID, DisplayName: guestName, Guest: true}
+	}
+	profile, err := ensureProfile(userID)
+	if err != nil {
+		return types.Profile{}
+	}
+	return profile.Profile
+}
+
+// dispatchPresence turns the join and leave events the ws server audits
+// into webhook deliveries.
+func dispatchPresence(event *types.AuditEvent) {
+	var webhookEvent string
+	switch event.Action {
+	case types.AuditRoomJoin:
+		webhookEvent = models.WebhookEventUserJoined
+	case types.AuditRoomLeave:
+		webhookEvent = models.WebhookEventUserLeft
+	default:
+		return
+	}
+	if event.RoomID == "" {
+		return
+	}
+	name, _ := event.Details["name"].(string)
+	actor := event.Actor
+	webhooks.Dispatch(event.RoomID, webhookEvent, func() interface{} {
+		return map[string]interface{}{"user": webhookUser(actor, name)}
+	})
+}
+
+// dispatchMessage sends a newly stored chat message to the room's webhooks.
+func dispatchMessage(message types.ChatMessage) {
+	webhooks.Dispatch(message.RoomID, models.WebhookEventMessageCreated, func() interface{} {
+		sender := webhookUser(message.SenderID, message.SenderName)
+		message.SenderID = sender.ID
+		return map[string]interface{}{
+			"message": message,
+			"sender":  sender,
+		}
+	})
+}
+
+func roomWebhook(w http.ResponseWriter, r *http.Request) (*models.Webhook, bool) {
+	vars := mux.Vars(r)
+	hook, err := store.Get().Webhooks.Get(vars["webhookId"])
+	if err == store.ErrNotFound || (err == nil && hook.RoomID != vars["roomId"]) {
+		writeError(w, http.StatusNotFound, "Webhook not found")
+		return nil, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load webhook")
+		return nil, false
+	}
+	return hook, true
+}
+
+// CreateWebhook subscribes a URL to some of the room's events. The secret
+// is generated unless given and is only shown in this response.
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("CreateWebhook Called!")
+	roomID := mux.Vars(r)["roomId"]
+
+	var body struct {
+		URL    string   `json:"url"`
+		Secret string   `json:"secret"`
+		Events []string `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	target, err := url.Parse(body.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" ||
+		len(body.URL) > maxWebhookURLLength {
+		writeError(w, http.StatusBadRequest, "url must be an http or https URL")
+		return
+	}
+	if len(body.Events) == 0 {
+		writeError(w, http.StatusBadRequest, "At least one event is required")
+		return
+	}
+	for _, event := range body.Events {
+		if !webhookEvents[event] {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Unknown event %q", event))
+			return
+		}
+	}
+	if body.Secret != "" && len(body.Secret) < minWebhookSecret {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("secret must be at least %d characters", minWebhookSecret))
+		return
+	}
+
+	hooks := store.Get().Webhooks
+	existing, err := hooks.ListByRoom(roomID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load webhooks")
+		return
+	}
+	if len(existing) >= maxWebhooksPerRoom {
+		writeError(w, http.StatusConflict, fmt.Sprintf("At most %d webhooks per room", maxWebhooksPerRoom))
+		return
+	}
+
+	secret := body.Secret
+	if secret == "" {
+		if secret, err = newRandomID("whsec_"); err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to create webhook")
+			return
+		}
+	}
+	id, err := newRandomID("hook-")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create webhook")
+		return
+	}
+	hook := &models.Webhook{
+		ID:        id,
+		RoomID:    roomID,
+		URL:       body.URL,
+		Secret:    secret,
+		Events:    body.Events,
+		CreatedBy: middleware.GetEmail(r),
+	}
+	if err := hooks.Create(hook); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create webhook")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"success": true,
+		"webhook": hook,
+		"secret":  secret,
+	})
+}
+
+func ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("ListWebhooks Called!")
+
+	hooks, err := store.Get().Webhooks.ListByRoom(mux.Vars(r)["roomId"])
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load webhooks")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"webhooks": hooks,
+	})
+}
+
+// DeleteWebhook removes the webhook and its delivery log. Retries still
+// pending are dropped.
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("DeleteWebhook Called!")
+
+	hook, ok := roomWebhook(w, r)
+	if !ok {
+		return
+	}
+	if err := store.Get().Webhooks.Delete(hook.ID); err != nil && err != store.ErrNotFound {
+		writeError(w, http.StatusInternalServerError, "Failed to delete webhook")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Webhook deleted",
+	})
+}
+
+// ListWebhookDeliveries returns the webhook's latest deliveries. The limit
+// query parameter defaults to 50.
+func ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("ListWebhookDeliveries Called!")
+
+	hook, ok := roomWebhook(w, r)
+	if !ok {
+		return
+	}
+	limit := defaultDeliveryLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxDeliveryLimit {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxDeliveryLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	deliveries, err := store.Get().Webhooks.ListDeliveries(hook.ID, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to load deliveries")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"deliveries": deliveries,
+	})
+}
+
+// PingWebhook sends a ping event, to check the receiver and its signature
+// verification without waiting for real room activity.
+func PingWebhook(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("PingWebhook Called!")
+
+	hook, ok := roomWebhook(w, r)
+	if !ok {
+		return
+	}
+	delivery, err := webhooks.Deliver(*hook, models.WebhookEventPing, map[string]interface{}{
+		"webhookId": hook.ID,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to send ping")
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"success":  true,
+		"delivery": delivery,
+	})
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
 
 	"go-gather/http/routes"
 
@@ -10,10 +11,20 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
 	router := mux.NewRouter()
 
 	// Add auth routes
 	routes.AuthRoutes(router)
+	routes.RoomRoutes(router)
+	routes.AuditRoutes(router)
+	routes.ConversationRoutes(router)
+	routes.ProfileRoutes(router)
+	routes.AccessTokenRoutes(router)
 
 	// Start the HTTP server
 	log.Println("Server running on port 3000")
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http"
+	"os"
+
+	"go-gather/store"
+)
+
+// RequireAdmin only lets through callers whose account has is_admin set.
+// With ADMIN_REQUIRE_2FA=true their account must also have two-factor
+// authentication enabled. It must be chained after AuthMiddleware.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := store.Get().Users.GetByEmail(GetEmail(r))
+		if err != nil || !user.IsAdmin {
+			http.Error(w, "Admin access required", http.StatusForbidden)
+			return
+		}
+		if os.Getenv("ADMIN_REQUIRE_2FA") == "true" && !user.TwoFactor.Enabled {
+			http.Error(w, "Admins must enable two-factor authentication", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"net/http"
+
+	"go-gather/auth"
+)
+
+// InternalOnly restricts a route to the ws server, which calls from the
+// same host with INTERNAL_TOKEN. It guards the endpoints the ws server
+// uses to write back into the HTTP service.
+func InternalOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auth.IsInternal(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
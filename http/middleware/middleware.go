@@ -1,14 +1,27 @@
 package middleware
 
 import (
-	"fmt"
+	"context"
+	"log"
 	"net/http"
 	"strings"
+	"time"
 
-	"github.com/golang-jwt/jwt/v5"
+	"go-gather/auth"
+	"go-gather/http/models"
+	"go-gather/store"
 )
 
-var jwtSecret = []byte("your_jwt_secret_key") // Replace with your actual secret
+type contextKey string
+
+const (
+	emailKey       contextKey = "email"
+	accessTokenKey contextKey = "accessToken"
+)
+
+// touchInterval limits how often a token's last use is written back, so a
+// busy integration doesn't cost a write per request.
+const touchInterval = time.Minute
 
 func AuthMiddleware(next http.Handler) http.Handler {
 
@@ -28,18 +41,80 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		if strings.HasPrefix(tokenString, auth.AccessTokenPrefix) {
+			token, err := LookupAccessToken(tokenString, models.ScopeAPI)
+			if err != nil {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
 			}
-			return jwtSecret, nil
-		})
+			ctx := context.WithValue(r.Context(), emailKey, token.Email)
+			ctx = context.WithValue(ctx, accessTokenKey, token)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
 
-		if err != nil || !token.Valid {
+		claims, err := auth.Parse(tokenString)
+		if err != nil {
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
 		}
 
+		// Guest tokens are only good for the ws server.
+		if claims.Guest || claims.Email == "" {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), emailKey, claims.Email)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// LookupAccessToken finds the personal access token behind the secret and
+// checks that it is still active and has the scope. Its last use is
+// recorded along the way.
+func LookupAccessToken(secret, scope string) (*models.AccessToken, error) {
+	tokens := store.Get().AccessTokens
+	token, err := tokens.GetByHash(models.HashAccessToken(secret))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if !token.Active(now) || !token.HasScope(scope) {
+		return nil, store.ErrNotFound
+	}
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > touchInterval {
+		if err := tokens.Touch(token.ID, now); err != nil {
+			log.Println("Error recording access token use:", err)
+		}
+	}
+	return token, nil
+}
+
+// SessionOnly rejects requests made with a personal access token. It keeps
+// integrations away from account management, such as minting more tokens
+// or turning off two-factor authentication. It must be chained after
+// AuthMiddleware.
+func SessionOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if GetAccessToken(r) != nil {
+			http.Error(w, "Not available to access tokens", http.StatusForbidden)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// GetEmail returns the email of the authenticated caller. It is only set on
+// requests that went through AuthMiddleware.
+func GetEmail(r *http.Request) string {
+	email, _ := r.Context().Value(emailKey).(string)
+	return email
+}
+
+// GetAccessToken returns the personal access token the request was made
+// with, or nil when it was made with a session token.
+func GetAccessToken(r *http.Request) *models.AccessToken {
+	token, _ := r.Context().Value(accessTokenKey).(*models.AccessToken)
+	return token
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"go-gather/roles"
+	"go-gather/store"
+
+	"github.com/gorilla/mux"
+)
+
+const roleKey contextKey = "role"
+
+// RequireRoomPermission loads the caller's role in the room named by the
+// {roomId} route variable and rejects the request unless that role grants
+// the permission. Users banned from the room are turned away whatever their
+// role. It must be chained after AuthMiddleware.
+func RequireRoomPermission(permission roles.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			roomID := mux.Vars(r)["roomId"]
+			email := GetEmail(r)
+
+			if token := GetAccessToken(r); token != nil && !token.AllowsRoom(roomID) {
+				http.Error(w, "Token not valid for this room", http.StatusForbidden)
+				return
+			}
+
+			// A ban outlasts the membership record, and a failed lookup
+			// counts as one.
+			_, err := store.Get().Bans.GetActive(roomID, email)
+			if err == nil {
+				http.Error(w, "You are banned from this room", http.StatusForbidden)
+				return
+			}
+			if err != store.ErrNotFound {
+				http.Error(w, "Failed to check bans", http.StatusInternalServerError)
+				return
+			}
+
+			membership, err := store.Get().Memberships.Get(roomID, email)
+			if err == store.ErrNotFound {
+				http.Error(w, "Not a member of this room", http.StatusForbidden)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Failed to load membership", http.StatusInternalServerError)
+				return
+			}
+
+			if permission != "" && !membership.Role.Can(permission) {
+				http.Error(w, "Permission denied", http.StatusForbidden)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), roleKey, membership.Role)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// GetRole returns the caller's role in the current room. It is only set on
+// requests that went through RequireRoomPermission.
+func GetRole(r *http.Request) roles.Role {
+	role, _ := r.Context().Value(roleKey).(roles.Role)
+	return role
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"go-gather/db"
+)
+
+const migrateUsage = `usage: migrate <command> [steps]
+
+commands:
+  up [n]     apply all pending migrations, or only the next n
+  down [n]   roll back the latest migration, or the latest n
+  status     list migrations and when they were applied`
+
+func runMigrate(args []string) {
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Println(migrateUsage)
+		os.Exit(2)
+	}
+
+	steps := 0
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			fmt.Println(migrateUsage)
+			os.Exit(2)
+		}
+		steps = n
+	}
+
+	conn := db.GetInstance()
+
+	switch args[0] {
+	case "up":
+		applied, err := db.MigrateUp(conn, steps)
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		if len(applied) == 0 {
+			log.Println("Database is up to date")
+		}
+
+	case "down":
+		if steps == 0 {
+			steps = 1
+		}
+		if _, err := db.MigrateDown(conn, steps); err != nil {
+			log.Fatalf("Rollback failed: %v", err)
+		}
+
+	case "status":
+		statuses, err := db.GetMigrationStatus(conn)
+		if err != nil {
+			log.Fatalf("Could not read migration status: %v", err)
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-30s %s\n", status.Version, status.Name, applied)
+		}
+
+	default:
+		fmt.Println(migrateUsage)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	// ScopeAPI lets the token call the REST API as its account.
+	ScopeAPI = "api"
+	// ScopeWS lets the token join rooms over the websocket.
+	ScopeWS = "ws"
+)
+
+// AccessToken is a long-lived token a user creates for an integration,
+// acting either as themselves or as one of their bots. Rooms, when set,
+// limits it to those rooms. Only the SHA-256 hash of the secret is kept.
+type AccessToken struct {
+	ID         string     `json:"id"`
+	Hash       string     `json:"-"`
+	Email      string     `json:"email"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	Rooms      []string   `json:"rooms,omitempty"`
+	CreatedBy  string     `json:"createdBy"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+func HashAccessToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Active reports whether the token is neither revoked nor expired.
+func (t *AccessToken) Active(now time.Time) bool {
+	return t.RevokedAt == nil && (t.ExpiresAt == nil || now.Before(*t.ExpiresAt))
+}
+
+func (t *AccessToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *AccessToken) AllowsRoom(roomID string) bool {
+	if len(t.Rooms) == 0 {
+		return true
+	}
+	for _, room := range t.Rooms {
+		if room == roomID {
+			return true
+		}
+	}
+	return false
+}
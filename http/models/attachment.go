@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Attachment describes an uploaded file. The bytes live in blob storage
+// under BlobKey, and the thumbnail, if any, under ThumbnailKey. Width and
+// Height are only known for images.
+type Attachment struct {
+	ID           string    `json:"id"`
+	RoomID       string    `json:"roomId"`
+	UploaderID   string    `json:"uploaderId"`
+	FileName     string    `json:"fileName"`
+	ContentType  string    `json:"contentType"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width,omitempty"`
+	Height       int       `json:"height,omitempty"`
+	BlobKey      string    `json:"-"`
+	ThumbnailKey string    `json:"-"`
+	HasThumbnail bool      `json:"hasThumbnail"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
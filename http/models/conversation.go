@@ -0,0 +1,41 @@
+package models
+
+import (
+	"sort"
+	"time"
+
+	"go-gather/types"
+)
+
+// Conversation is a direct message thread between users, independent of
+// rooms. One-to-one conversations are Direct and unique per pair; groups
+// may carry a title.
+type Conversation struct {
+	ID           string    `json:"id"`
+	Title        string    `json:"title,omitempty"`
+	Direct       bool      `json:"direct"`
+	Participants []string  `json:"participants"`
+	CreatedBy    string    `json:"createdBy"`
+	CreatedAt    time.Time `json:"createdAt"`
+
+	// Filled in when listing for one participant.
+	Unread      int                `json:"unread"`
+	LastMessage *types.ChatMessage `json:"lastMessage,omitempty"`
+}
+
+func (c *Conversation) HasParticipant(email string) bool {
+	for _, participant := range c.Participants {
+		if participant == email {
+			return true
+		}
+	}
+	return false
+}
+
+// DirectKey identifies the one-to-one conversation between two users
+// regardless of who started it.
+func DirectKey(a, b string) string {
+	pair := []string{a, b}
+	sort.Strings(pair)
+	return pair[0] + "|" + pair[1]
+}
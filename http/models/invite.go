@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"go-gather/roles"
+)
+
+// Invite is a shareable token granting a role in a room. MaxUses of 1 makes
+// it single-use, 0 makes it unlimited. A nil ExpiresAt never expires.
+type Invite struct {
+	Token     string     `json:"token"`
+	RoomID    string     `json:"roomId"`
+	Role      roles.Role `json:"role"`
+	CreatedBy string     `json:"createdBy"`
+	MaxUses   int        `json:"maxUses"`
+	Uses      int        `json:"uses"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
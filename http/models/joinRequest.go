@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	JoinRequestPending  = "pending"
+	JoinRequestApproved = "approved"
+	JoinRequestDenied   = "denied"
+)
+
+type JoinRequest struct {
+	ID        int        `json:"id"`
+	RoomID    string     `json:"roomId"`
+	Email     string     `json:"email"`
+	Message   string     `json:"message"`
+	Status    string     `json:"status"`
+	DecidedBy *string    `json:"decidedBy,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	DecidedAt *time.Time `json:"decidedAt,omitempty"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import (
+	"go-gather/roles"
+)
+
+type Membership struct {
+	RoomID string     `json:"roomId"`
+	Email  string     `json:"email"`
+	Role   roles.Role `json:"role"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go-gather/types"
+)
+
+const (
+	AvailabilityAvailable = "available"
+	AvailabilityAway      = "away"
+	AvailabilityBusy      = "busy"
+)
+
+// Profile is a user's public face. Only the embedded types.Profile leaves
+// the service; the email stays with us and the ws server.
+type Profile struct {
+	Email string `json:"email"`
+	types.Profile
+	UpdatedAt time.Time `json:"updatedAt"`
+}
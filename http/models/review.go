@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRemoved  = "removed"
+)
+
+// Review is a chat message a room filter flagged for moderators. Body is
+// what the sender wrote, before any masking; Reasons lists what matched.
+type Review struct {
+	ID         string     `json:"id"`
+	RoomID     string     `json:"roomId"`
+	MessageID  string     `json:"messageId"`
+	SenderID   string     `json:"senderId"`
+	Body       string     `json:"body"`
+	Reasons    []string   `json:"reasons"`
+	Status     string     `json:"status"`
+	ReviewedBy string     `json:"reviewedBy,omitempty"`
+	ReviewedAt *time.Time `json:"reviewedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
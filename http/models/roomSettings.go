@@ -0,0 +1,6 @@
+package models
+
+type RoomSettings struct {
+	RoomID      string `json:"roomId"`
+	GuestAccess bool   `json:"guestAccess"`
+}
@@ -1,46 +1,66 @@
 package models
 
 import (
-	"database/sql"
 	"log"
+	"time"
 
-	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// BotEmailDomain holds the made-up addresses of bot accounts. The .invalid
+// TLD never resolves, so no mail can go out to them.
+const BotEmailDomain = "bots.invalid"
+
 type User struct {
-	Email    string   `json:"email"`
-	Password string   `json:"password"`
-	Rooms    []string `json:"rooms"`
+	Email        string   `json:"email"`
+	Password     string   `json:"password"`
+	PasswordHash string   `json:"-"`
+	Rooms        []string `json:"rooms"`
+	IsAdmin      bool     `json:"-"`
+
+	// Bots are accounts without a password, driven through access tokens
+	// by the user in BotOwner.
+	IsBot    bool   `json:"-"`
+	BotOwner string `json:"-"`
+
+	EmailVerified bool `json:"-"`
+
+	// FailedLogins counts wrong passwords since the last successful sign-in;
+	// LockedUntil is set while the account refuses sign-ins because of them.
+	FailedLogins int        `json:"-"`
+	LockedUntil  *time.Time `json:"-"`
+
+	TwoFactor TwoFactor `json:"-"`
+}
+
+// TwoFactor is the user's TOTP setup. Secret is stored as soon as
+// enrollment starts but only counts once Enabled is set. LastStep is the
+// last time step a code was accepted for, so a code cannot be used twice,
+// and RecoveryCodes holds the hashes of the recovery codes not used yet.
+type TwoFactor struct {
+	Secret        string
+	Enabled       bool
+	LastStep      int64
+	RecoveryCodes []string
 }
 
-func (u *User) CreateUser(db *sql.DB) bool {
+// HashPassword replaces the plain-text Password with its bcrypt hash so the
+// user is ready to be stored.
+func (u *User) HashPassword() bool {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
 	if err != nil {
 		log.Println("Password hashing failed", err)
 		return false
 	}
 
-	query := `INSERT INTO users (email, password, rooms) VALUES ($1, $2, $3)`
-	_, err = db.Exec(query, u.Email, hashedPassword, pq.Array(u.Rooms))
-	if err != nil {
-		log.Println("User creation failed", err)
-		return false
-	}
-
-	log.Println("User created successfully")
+	u.PasswordHash = string(hashedPassword)
+	u.Password = ""
 	return true
 }
 
-func (u *User) Authenticate(db *sql.DB) bool {
-	var hashedPassword string
-	err := db.QueryRow("SELECT password FROM users WHERE email = $1", u.Email).Scan(&hashedPassword)
-	if err != nil {
-		log.Println("User not found", err)
-		return false
-	}
-
-	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(u.Password))
+// CheckPassword compares a plain-text password against the stored hash.
+func (u *User) CheckPassword(password string) bool {
+	err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password))
 	if err != nil {
 		log.Println("Password does not match", err)
 		return false
@@ -49,31 +69,3 @@ func (u *User) Authenticate(db *sql.DB) bool {
 	log.Println("User authenticated successfully!")
 	return true
 }
-func (u *User) GetRoomsOfUser(db *sql.DB) []string {
-	username := u.Email
-
-	if username == "" {
-		log.Println("Empty email")
-		return []string{}
-	}
-
-	if db == nil {
-		log.Println("Database connection is nil")
-		return []string{}
-	}
-
-	log.Printf("Getting rooms for user %s", username)
-	var rooms []string
-	query := `SELECT rooms FROM users WHERE email=$1`
-	err := db.QueryRow(query, username).Scan(pq.Array(&rooms))
-	if err != nil {
-		if err == sql.ErrConnDone {
-			log.Printf("Database connection is closed for user %s", username)
-		} else {
-			log.Printf("Error querying rooms for user %s: %v", username, err)
-		}
-		return []string{}
-	}
-
-	return rooms
-}
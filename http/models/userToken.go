@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+const (
+	TokenVerifyEmail   = "verify-email"
+	TokenResetPassword = "reset-password"
+)
+
+// UserToken is a single-use secret mailed to a user. Only the SHA-256
+// hash of the secret is stored, so a leaked table cannot be replayed.
+type UserToken struct {
+	Hash      string
+	Email     string
+	Purpose   string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
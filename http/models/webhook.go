@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// Events a webhook can subscribe to. EventPing is only ever sent on
+// request, to check that a receiver is reachable. There are no recording
+// events: the server only relays media and has no recording feature to
+// report on.
+const (
+	WebhookEventUserJoined     = "user-joined"
+	WebhookEventUserLeft       = "user-left"
+	WebhookEventMessageCreated = "message-created"
+	WebhookEventPing           = "ping"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook subscribes a URL to some of a room's events. Secret signs every
+// delivery so the receiver can tell them apart from forgeries.
+type Webhook struct {
+	ID        string    `json:"id"`
+	RoomID    string    `json:"roomId"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`
+	Events    []string  `json:"events"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (h *Webhook) Wants(event string) bool {
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is one event sent to a webhook, retried until it
+// succeeds or runs out of attempts. StatusCode and Error describe the
+// last attempt.
+type WebhookDelivery struct {
+	ID            string     `json:"id"`
+	WebhookID     string     `json:"webhookId"`
+	Event         string     `json:"event"`
+	Payload       string     `json:"payload"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	StatusCode    int        `json:"statusCode,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	LastAttemptAt *time.Time `json:"lastAttemptAt,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+}
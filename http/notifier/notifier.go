@@ -0,0 +1,48 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"go-gather/auth"
+	"go-gather/types"
+)
+
+const notifyURL = "http://localhost:8080/internal/notify"
+
+var client = &http.Client{Timeout: 5 * time.Second}
+
+// Notify forwards the notification to the ws server in the background.
+// Delivery is best effort: clients that are offline will pick up the state
+// through the REST endpoints instead.
+func Notify(notification types.Notification) {
+	go func() {
+		body, err := json.Marshal(notification)
+		if err != nil {
+			log.Println("Error marshalling notification:", err)
+			return
+		}
+
+		req, err := http.NewRequest(http.MethodPost, notifyURL, bytes.NewReader(body))
+		if err != nil {
+			log.Println("Error creating notification request:", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(auth.InternalTokenHeader, auth.InternalToken())
+
+		resp, err := client.Do(req)
+		if err != nil {
+			log.Printf("Error sending %s notification: %v", notification.Type, err)
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			log.Printf("ws server rejected %s notification with status %d", notification.Type, resp.StatusCode)
+		}
+	}()
+}
@@ -0,0 +1,25 @@
+package routes
+
+import (
+	controller "go-gather/http/controllers"
+	"go-gather/http/middleware"
+
+	"github.com/gorilla/mux"
+)
+
+func AccessTokenRoutes(router *mux.Router) {
+	tokens := router.PathPrefix("/tokens").Subrouter()
+	tokens.Use(middleware.AuthMiddleware, middleware.SessionOnly)
+	tokens.HandleFunc("", controller.CreateAccessToken).Methods("POST")
+	tokens.HandleFunc("", controller.ListAccessTokens).Methods("GET")
+	tokens.HandleFunc("/{tokenId}", controller.RevokeAccessToken).Methods("DELETE")
+
+	bots := router.PathPrefix("/bots").Subrouter()
+	bots.Use(middleware.AuthMiddleware, middleware.SessionOnly)
+	bots.HandleFunc("", controller.CreateBot).Methods("POST")
+	bots.HandleFunc("", controller.ListBots).Methods("GET")
+
+	internal := router.PathPrefix("/internal/access-tokens").Subrouter()
+	internal.Use(middleware.InternalOnly)
+	internal.HandleFunc("/verify", controller.VerifyAccessToken).Methods("POST")
+}
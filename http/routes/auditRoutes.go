@@ -0,0 +1,18 @@
+package routes
+
+import (
+	controller "go-gather/http/controllers"
+	"go-gather/http/middleware"
+
+	"github.com/gorilla/mux"
+)
+
+func AuditRoutes(router *mux.Router) {
+	audit := router.PathPrefix("/audit").Subrouter()
+	audit.Use(middleware.AuthMiddleware, middleware.SessionOnly, middleware.RequireAdmin)
+	audit.HandleFunc("", controller.GetAuditLog).Methods("GET")
+
+	internal := router.PathPrefix("/internal/audit").Subrouter()
+	internal.Use(middleware.InternalOnly)
+	internal.HandleFunc("", controller.RecordAuditEvent).Methods("POST")
+}
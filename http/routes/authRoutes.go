@@ -1,7 +1,10 @@
 package routes
 
 import (
+	"net/http"
+
 	controller "go-gather/http/controllers"
+	"go-gather/http/middleware"
 
 	"github.com/gorilla/mux"
 )
@@ -11,6 +14,31 @@ func AuthRoutes(router *mux.Router) {
 	router.HandleFunc("/", controller.HomeHandler)
 	router.HandleFunc("/register", controller.SignUp)
 	router.HandleFunc("/login", controller.SignIn)
-	router.HandleFunc("/authenticate", controller.Authenticate)
+	router.Handle("/refresh", middleware.AuthMiddleware(middleware.SessionOnly(http.HandlerFunc(controller.RefreshToken)))).Methods("POST")
+
+	router.HandleFunc("/verify-email", controller.VerifyEmail).Methods("POST")
+	router.Handle("/verify-email/resend", middleware.AuthMiddleware(http.HandlerFunc(controller.ResendVerification))).Methods("POST")
+	router.HandleFunc("/password-reset", controller.RequestPasswordReset).Methods("POST")
+	router.HandleFunc("/password-reset/confirm", controller.ConfirmPasswordReset).Methods("POST")
+
+	router.HandleFunc("/login/2fa", controller.VerifyTwoFactor).Methods("POST")
+	twoFactor := router.PathPrefix("/2fa").Subrouter()
+	twoFactor.Use(middleware.AuthMiddleware, middleware.SessionOnly)
+	twoFactor.HandleFunc("/setup", controller.SetupTwoFactor).Methods("POST")
+	twoFactor.HandleFunc("/enable", controller.EnableTwoFactor).Methods("POST")
+	twoFactor.HandleFunc("/disable", controller.DisableTwoFactor).Methods("POST")
+	twoFactor.HandleFunc("/recovery-codes", controller.RegenerateRecoveryCodes).Methods("POST")
+
+	router.HandleFunc("/auth/oidc/login", controller.OIDCLogin).Methods("GET")
+	router.HandleFunc("/auth/oidc/callback", controller.OIDCCallback).Methods("GET")
+
+	// Rooms, roles and bans of any user, for the ws server only.
+	internal := router.PathPrefix("/internal/authenticate").Subrouter()
+	internal.Use(middleware.InternalOnly)
+	internal.HandleFunc("", controller.Authenticate).Methods("GET")
+
+	admin := router.PathPrefix("/admin").Subrouter()
+	admin.Use(middleware.AuthMiddleware, middleware.SessionOnly, middleware.RequireAdmin)
+	admin.HandleFunc("/users/{email}/unlock", controller.UnlockAccount).Methods("POST")
 
 }
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	controller "go-gather/http/controllers"
+	"go-gather/http/middleware"
+
+	"github.com/gorilla/mux"
+)
+
+func ConversationRoutes(router *mux.Router) {
+	conversations := router.PathPrefix("/conversations").Subrouter()
+	conversations.Use(middleware.AuthMiddleware)
+	conversations.HandleFunc("", controller.CreateConversation).Methods("POST")
+	conversations.HandleFunc("", controller.ListConversations).Methods("GET")
+	conversations.HandleFunc("/{conversationId}/messages", controller.ListConversationMessages).Methods("GET")
+	conversations.HandleFunc("/{conversationId}/messages", controller.SendConversationMessage).Methods("POST")
+	conversations.HandleFunc("/{conversationId}/read", controller.MarkConversationRead).Methods("POST")
+
+	internal := router.PathPrefix("/internal/direct-messages").Subrouter()
+	internal.Use(middleware.InternalOnly)
+	internal.HandleFunc("", controller.RecordDirectMessage).Methods("POST")
+}
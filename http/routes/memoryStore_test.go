@@ -0,0 +1,207 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-gather/auth"
+	"go-gather/store"
+	"go-gather/types"
+
+	"github.com/gorilla/mux"
+)
+
+// newTestRouter serves the HTTP service from an in-memory store. SQL_URI
+// is cleared so that anything still reaching for Postgres fails the test
+// instead of quietly connecting.
+func newTestRouter(t *testing.T) *mux.Router {
+	t.Helper()
+	t.Setenv("SQL_URI", "")
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("INTERNAL_TOKEN", "test-internal-token")
+	store.Set(store.NewMemory())
+
+	router := mux.NewRouter()
+	AuthRoutes(router)
+	RoomRoutes(router)
+	AuditRoutes(router)
+	return router
+}
+
+// call sends a JSON request and decodes the JSON answer. Paths under
+// /internal are sent from the loopback address with the internal token, as
+// the ws server would.
+func call(t *testing.T, router http.Handler, method, path, token string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&payload).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if len(path) > 9 && path[:9] == "/internal" {
+		req.RemoteAddr = "127.0.0.1:40000"
+		req.Header.Set(auth.InternalTokenHeader, "test-internal-token")
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	result := map[string]interface{}{}
+	json.Unmarshal(recorder.Body.Bytes(), &result)
+	return recorder.Code, result
+}
+
+func signUpAndIn(t *testing.T, router http.Handler, email string) string {
+	t.Helper()
+	credentials := map[string]string{"email": email, "password": "correct horse battery"}
+	if _, result := call(t, router, http.MethodPost, "/register", "", credentials); result["success"] != true {
+		t.Fatalf("sign-up of %s: %v", email, result)
+	}
+	_, result := call(t, router, http.MethodPost, "/login", "", credentials)
+	token, _ := result["token"].(string)
+	if token == "" {
+		t.Fatalf("sign-in of %s: %v", email, result)
+	}
+	return token
+}
+
+func TestInvitesAndBansWithMemoryStore(t *testing.T) {
+	router := newTestRouter(t)
+	owner := signUpAndIn(t, router, "owner@example.com")
+	member := signUpAndIn(t, router, "member@example.com")
+
+	if status, result := call(t, router, http.MethodPost, "/rooms", owner, map[string]string{"roomId": "lobby"}); status != http.StatusCreated {
+		t.Fatalf("creating room: %d %v", status, result)
+	}
+
+	status, result := call(t, router, http.MethodPost, "/rooms/lobby/invites", owner, map[string]interface{}{"maxUses": 1})
+	if status != http.StatusCreated {
+		t.Fatalf("creating invite: %d %v", status, result)
+	}
+	token := result["invite"].(map[string]interface{})["token"].(string)
+
+	if status, result := call(t, router, http.MethodPost, "/invites/"+token+"/accept", member, nil); status != http.StatusOK {
+		t.Fatalf("accepting invite: %d %v", status, result)
+	}
+	if status, _ := call(t, router, http.MethodPost, "/invites/"+token+"/accept", member, nil); status != http.StatusGone {
+		t.Fatalf("accepting a used-up invite: got %d, want %d", status, http.StatusGone)
+	}
+
+	ban := types.Ban{UserID: "member@example.com", BannedBy: "owner@example.com", Reason: "spam"}
+	if status, result := call(t, router, http.MethodPost, "/internal/rooms/lobby/bans", "", ban); status != http.StatusCreated {
+		t.Fatalf("recording ban: %d %v", status, result)
+	}
+	_, result = call(t, router, http.MethodGet, "/internal/authenticate?email=member@example.com&roomId=lobby", "", nil)
+	if result["banned"] != true {
+		t.Fatalf("authenticate after the ban: %v", result)
+	}
+
+	if status, _ := call(t, router, http.MethodGet, "/rooms/lobby/members", member, nil); status != http.StatusForbidden {
+		t.Fatalf("banned member listing members: got %d, want %d", status, http.StatusForbidden)
+	}
+	_, result = call(t, router, http.MethodPost, "/rooms/lobby/invites", owner, map[string]interface{}{"maxUses": 0})
+	token = result["invite"].(map[string]interface{})["token"].(string)
+	if status, _ := call(t, router, http.MethodPost, "/invites/"+token+"/accept", member, nil); status != http.StatusForbidden {
+		t.Fatalf("banned member redeeming an invite: got %d, want %d", status, http.StatusForbidden)
+	}
+
+	guestBan := types.Ban{UserID: "guest-0123456789abcdef", BannedBy: "owner@example.com"}
+	call(t, router, http.MethodPost, "/internal/rooms/lobby/bans", "", guestBan)
+	_, result = call(t, router, http.MethodGet, "/internal/rooms/lobby/guests/guest-0123456789abcdef", "", nil)
+	if result["banned"] != true {
+		t.Fatalf("guest access after the guest's ban: %v", result)
+	}
+
+	events, err := store.Get().Audit.Query(store.AuditFilter{User: "member@example.com", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := map[string]bool{}
+	for _, event := range events {
+		actions[event.Action] = true
+	}
+	if !actions[types.AuditSignUp] || !actions[types.AuditSignIn] || !actions[types.AuditRoleChange] {
+		t.Fatalf("audit events of the member: %+v", events)
+	}
+}
+
+// Rooms, roles and bans are only reported to the ws server.
+func TestAuthenticateIsInternal(t *testing.T) {
+	router := newTestRouter(t)
+	signUpAndIn(t, router, "someone@example.com")
+
+	for path, want := range map[string]int{
+		"/authenticate?email=someone@example.com":          http.StatusNotFound,
+		"/internal/authenticate?email=someone@example.com": http.StatusForbidden,
+	} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if recorder.Code != want {
+			t.Errorf("GET %s from outside: got %d, want %d", path, recorder.Code, want)
+		}
+	}
+}
+
+// A rooms list sent at sign-up used to make the new account a member of
+// each room in it.
+func TestSignUpGrantsNoRooms(t *testing.T) {
+	router := newTestRouter(t)
+	owner := signUpAndIn(t, router, "owner@example.com")
+	if status, result := call(t, router, http.MethodPost, "/rooms", owner, map[string]string{"roomId": "private"}); status != http.StatusCreated {
+		t.Fatalf("creating room: %d %v", status, result)
+	}
+
+	credentials := map[string]interface{}{
+		"email":    "intruder@example.com",
+		"password": "correct horse battery",
+		"rooms":    []string{"private"},
+	}
+	if _, result := call(t, router, http.MethodPost, "/register", "", credentials); result["success"] != true {
+		t.Fatalf("sign-up: %v", result)
+	}
+
+	_, result := call(t, router, http.MethodGet, "/internal/authenticate?email=intruder@example.com&roomId=private", "", nil)
+	if result["role"] != "" || len(result["rooms"].([]interface{})) != 0 {
+		t.Fatalf("sign-up granted access: %v", result)
+	}
+	_, result = call(t, router, http.MethodPost, "/login", "", credentials)
+	intruder, _ := result["token"].(string)
+	if status, _ := call(t, router, http.MethodGet, "/rooms/private/members", intruder, nil); status != http.StatusForbidden {
+		t.Fatalf("listing members of the room: got %d, want %d", status, http.StatusForbidden)
+	}
+}
+
+// A reverse proxy on the same host makes every request look local, so the
+// internal routes also want the shared token.
+func TestInternalRoutesNeedToken(t *testing.T) {
+	router := newTestRouter(t)
+	ban := types.Ban{UserID: "someone@example.com", BannedBy: "mallory@example.com"}
+
+	for name, token := range map[string]string{"no token": "", "wrong token": "guess"} {
+		body, _ := json.Marshal(ban)
+		req := httptest.NewRequest(http.MethodPost, "/internal/rooms/lobby/bans", bytes.NewReader(body))
+		req.RemoteAddr = "127.0.0.1:40000"
+		if token != "" {
+			req.Header.Set(auth.InternalTokenHeader, token)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusForbidden {
+			t.Errorf("%s from loopback: got %d, want %d", name, recorder.Code, http.StatusForbidden)
+		}
+	}
+	if _, err := store.Get().Bans.GetActive("lobby", "someone@example.com"); err != store.ErrNotFound {
+		t.Fatalf("ban was recorded: %v", err)
+	}
+
+	t.Setenv("INTERNAL_TOKEN", "")
+	if status, _ := call(t, router, http.MethodPost, "/internal/rooms/lobby/bans", "", ban); status != http.StatusForbidden {
+		t.Fatalf("internal call while INTERNAL_TOKEN is unset: got %d, want %d", status, http.StatusForbidden)
+	}
+}
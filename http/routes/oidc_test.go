@@ -0,0 +1,304 @@
+package routes
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"go-gather/store"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+)
+
+const testClientID = "go-gather-test"
+
+// mockGrant is what the mock provider remembers about a code it handed
+// out: the PKCE challenge and nonce of the login, and who signed in.
+type mockGrant struct {
+	challenge     string
+	nonce         string
+	subject       string
+	email         string
+	emailVerified bool
+}
+
+// mockProvider is an OpenID provider that signs everyone in without
+// asking. Codes are single-use, as at a real provider.
+type mockProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	lock   sync.Mutex
+	grants map[string]mockGrant
+	issued int
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{key: key, grants: make(map[string]mockGrant)}
+
+	router := http.NewServeMux()
+	router.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	router.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test-key",
+			"use": "sig",
+			"n":   encode(key.N.Bytes()),
+			"e":   encode(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	router.HandleFunc("/token", p.token)
+	p.server = httptest.NewServer(router)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// authorize plays the browser's visit to the provider: it reads the
+// authorization request and returns a code for the grant, which starts out
+// with the request's challenge and nonce.
+func (p *mockProvider) authorize(t *testing.T, authURL string, grant mockGrant) string {
+	t.Helper()
+	target, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := target.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != testClientID {
+		t.Fatalf("unexpected authorization request %s", authURL)
+	}
+	if grant.challenge == "" {
+		grant.challenge = query.Get("code_challenge")
+	}
+	if grant.nonce == "" {
+		grant.nonce = query.Get("nonce")
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.issued++
+	code := "code-" + big.NewInt(int64(p.issued)).String()
+	p.grants[code] = grant
+	return code
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	p.lock.Lock()
+	grant, ok := p.grants[r.Form.Get("code")]
+	delete(p.grants, r.Form.Get("code"))
+	p.lock.Unlock()
+
+	sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            p.server.URL,
+		"aud":            testClientID,
+		"sub":            grant.subject,
+		"email":          grant.email,
+		"email_verified": grant.emailVerified,
+		"nonce":          grant.nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = "test-key"
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+}
+
+// oidcLogin is a sign-in started at /auth/oidc/login: where the browser
+// was sent and the state cookie it was given.
+type oidcLogin struct {
+	authURL string
+	state   string
+}
+
+func startOIDCLogin(t *testing.T, router *mux.Router) oidcLogin {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+	if recorder.Code != http.StatusFound {
+		t.Fatalf("starting sign-in: %d %s", recorder.Code, recorder.Body)
+	}
+	var state string
+	for _, cookie := range recorder.Result().Cookies() {
+		if cookie.Name == "oidc_state" {
+			state = cookie.Value
+		}
+	}
+	return oidcLogin{authURL: recorder.Header().Get("Location"), state: state}
+}
+
+func finishOIDCLogin(router *mux.Router, login oidcLogin, code string) (int, map[string]interface{}) {
+	query := url.Values{"code": {code}, "state": {login.state}}
+	req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?"+query.Encode(), nil)
+	req.AddCookie(&http.Cookie{Name: "oidc_state", Value: login.state})
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	result := map[string]interface{}{}
+	json.Unmarshal(recorder.Body.Bytes(), &result)
+	return recorder.Code, result
+}
+
+// The provider is configured once per test binary, so every case runs
+// against the same mock.
+func TestOIDCSignIn(t *testing.T) {
+	provider := newMockProvider(t)
+	t.Setenv("OIDC_ISSUER", provider.server.URL)
+	t.Setenv("OIDC_CLIENT_ID", testClientID)
+	t.Setenv("OIDC_REDIRECT_URL", "http://localhost:3000/auth/oidc/callback")
+	router := newTestRouter(t)
+	alice := mockGrant{subject: "alice-sub", email: "alice@example.com", emailVerified: true}
+
+	t.Run("provisions an account on first sign-in", func(t *testing.T) {
+		login := startOIDCLogin(t, router)
+		status, result := finishOIDCLogin(router, login, provider.authorize(t, login.authURL, alice))
+		if status != http.StatusOK || result["token"] == nil {
+			t.Fatalf("callback: %d %v", status, result)
+		}
+		user, err := store.Get().Users.GetByIdentity(provider.server.URL, "alice-sub")
+		if err != nil || user.Email != "alice@example.com" || !user.EmailVerified {
+			t.Fatalf("provisioned user %+v, %v", user, err)
+		}
+
+		// The second sign-in finds the account by subject, even once the
+		// provider reports another email.
+		login = startOIDCLogin(t, router)
+		renamed := alice
+		renamed.email = "alice@elsewhere.example"
+		if status, result := finishOIDCLogin(router, login, provider.authorize(t, login.authURL, renamed)); status != http.StatusOK {
+			t.Fatalf("second callback: %d %v", status, result)
+		}
+		if _, err := store.Get().Users.GetByEmail("alice@elsewhere.example"); err != store.ErrNotFound {
+			t.Fatalf("second sign-in created another account: %v", err)
+		}
+	})
+
+	t.Run("refuses unverified emails", func(t *testing.T) {
+		login := startOIDCLogin(t, router)
+		unverified := mockGrant{subject: "mallory-sub", email: "alice@example.com"}
+		if status, _ := finishOIDCLogin(router, login, provider.authorize(t, login.authURL, unverified)); status != http.StatusForbidden {
+			t.Fatalf("unverified email: got %d, want %d", status, http.StatusForbidden)
+		}
+	})
+
+	t.Run("refuses to link an unverified local account", func(t *testing.T) {
+		// Someone signs up first with the victim's address and a password
+		// of their own.
+		credentials := map[string]string{"email": "victim@example.com", "password": "attacker's password"}
+		if _, result := call(t, router, http.MethodPost, "/register", "", credentials); result["success"] != true {
+			t.Fatalf("sign-up: %v", result)
+		}
+
+		login := startOIDCLogin(t, router)
+		victim := mockGrant{subject: "victim-sub", email: "victim@example.com", emailVerified: true}
+		if status, _ := finishOIDCLogin(router, login, provider.authorize(t, login.authURL, victim)); status != http.StatusForbidden {
+			t.Fatalf("linking an unverified account: got %d, want %d", status, http.StatusForbidden)
+		}
+		if _, err := store.Get().Users.GetByIdentity(provider.server.URL, "victim-sub"); err != store.ErrNotFound {
+			t.Fatalf("identity was linked: %v", err)
+		}
+		if user, _ := store.Get().Users.GetByEmail("victim@example.com"); user.EmailVerified {
+			t.Fatal("the attacker's account was marked verified")
+		}
+	})
+
+	t.Run("links a verified local account", func(t *testing.T) {
+		signUpAndIn(t, router, "bob@example.com")
+		store.Get().Users.SetEmailVerified("bob@example.com")
+
+		login := startOIDCLogin(t, router)
+		bob := mockGrant{subject: "bob-sub", email: "bob@example.com", emailVerified: true}
+		if status, result := finishOIDCLogin(router, login, provider.authorize(t, login.authURL, bob)); status != http.StatusOK {
+			t.Fatalf("callback: %d %v", status, result)
+		}
+		if user, err := store.Get().Users.GetByIdentity(provider.server.URL, "bob-sub"); err != nil || user.Email != "bob@example.com" {
+			t.Fatalf("linked user %+v, %v", user, err)
+		}
+	})
+
+	t.Run("rejects a code issued for another login's PKCE challenge", func(t *testing.T) {
+		victim := startOIDCLogin(t, router)
+		attacker := startOIDCLogin(t, router)
+		code := provider.authorize(t, attacker.authURL, alice)
+		if status, _ := finishOIDCLogin(router, victim, code); status != http.StatusUnauthorized {
+			t.Fatalf("mismatched verifier: got %d, want %d", status, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("rejects a replayed state", func(t *testing.T) {
+		login := startOIDCLogin(t, router)
+		if status, _ := finishOIDCLogin(router, login, provider.authorize(t, login.authURL, alice)); status != http.StatusOK {
+			t.Fatalf("first callback: %d", status)
+		}
+		if status, _ := finishOIDCLogin(router, login, provider.authorize(t, login.authURL, alice)); status != http.StatusBadRequest {
+			t.Fatalf("replayed state: got %d, want %d", status, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("rejects a state without its cookie", func(t *testing.T) {
+		login := startOIDCLogin(t, router)
+		other := startOIDCLogin(t, router)
+		login.state, other.state = other.state, login.state
+		query := url.Values{"code": {provider.authorize(t, login.authURL, alice)}, "state": {login.state}}
+		req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?"+query.Encode(), nil)
+		req.AddCookie(&http.Cookie{Name: "oidc_state", Value: other.state})
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusBadRequest {
+			t.Fatalf("state not matching the cookie: got %d, want %d", recorder.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("rejects an ID token with another login's nonce", func(t *testing.T) {
+		earlier := startOIDCLogin(t, router)
+		login := startOIDCLogin(t, router)
+		replayed := alice
+		replayed.nonce = mustQuery(t, earlier.authURL, "nonce")
+		if status, _ := finishOIDCLogin(router, login, provider.authorize(t, login.authURL, replayed)); status != http.StatusUnauthorized {
+			t.Fatalf("replayed nonce: got %d, want %d", status, http.StatusUnauthorized)
+		}
+	})
+}
+
+func mustQuery(t *testing.T, rawURL, name string) string {
+	t.Helper()
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return target.Query().Get(name)
+}
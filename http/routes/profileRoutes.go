@@ -0,0 +1,19 @@
+package routes
+
+import (
+	controller "go-gather/http/controllers"
+	"go-gather/http/middleware"
+
+	"github.com/gorilla/mux"
+)
+
+func ProfileRoutes(router *mux.Router) {
+	me := router.PathPrefix("/me").Subrouter()
+	me.Use(middleware.AuthMiddleware)
+	me.HandleFunc("", controller.GetMe).Methods("GET")
+	me.HandleFunc("", controller.UpdateMe).Methods("PATCH")
+
+	internal := router.PathPrefix("/internal/profiles").Subrouter()
+	internal.Use(middleware.InternalOnly)
+	internal.HandleFunc("", controller.LookupProfiles).Methods("POST")
+}
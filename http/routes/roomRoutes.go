@@ -0,0 +1,100 @@
+package routes
+
+import (
+	"net/http"
+
+	controller "go-gather/http/controllers"
+	"go-gather/http/middleware"
+	"go-gather/roles"
+
+	"github.com/gorilla/mux"
+)
+
+func RoomRoutes(router *mux.Router) {
+	// Write-backs from the ws server, which has already checked permissions.
+	internal := router.PathPrefix("/internal").Subrouter()
+	internal.Use(middleware.InternalOnly)
+	internal.HandleFunc("/rooms/{roomId}/bans", controller.RecordBan).Methods("POST")
+	internal.HandleFunc("/rooms/{roomId}/guests/{guestId}", controller.CheckGuestAccess).Methods("GET")
+	internal.HandleFunc("/rooms/{roomId}/messages", controller.RecordMessage).Methods("POST")
+	internal.HandleFunc("/rooms/{roomId}/messages", controller.ListChannelMessages).Methods("GET")
+	internal.HandleFunc("/rooms/{roomId}/messages/{messageId}", controller.GetRoomMessage).Methods("GET")
+	internal.HandleFunc("/rooms/{roomId}/messages/{messageId}", controller.EditRoomMessage).Methods("PATCH")
+	internal.HandleFunc("/rooms/{roomId}/messages/{messageId}", controller.DeleteRoomMessage).Methods("DELETE")
+	internal.HandleFunc("/rooms/{roomId}/messages/{messageId}/reactions", controller.ReactToRoomMessage).Methods("POST")
+	internal.HandleFunc("/rooms/{roomId}/read-markers", controller.SaveReadMarker).Methods("POST")
+	internal.HandleFunc("/rooms/{roomId}/objects", controller.ListRoomObjects).Methods("GET")
+	internal.HandleFunc("/rooms/{roomId}/objects/{objectId}", controller.GetRoomObject).Methods("GET")
+	internal.HandleFunc("/rooms/{roomId}/objects/{objectId}/state", controller.SaveRoomObjectState).Methods("PUT")
+
+	// Guests have no account, so this one sits outside the auth middleware.
+	router.HandleFunc("/rooms/{roomId}/guest-token", controller.IssueGuestToken).Methods("POST")
+
+	rooms := router.PathPrefix("/rooms").Subrouter()
+	rooms.Use(middleware.AuthMiddleware)
+
+	rooms.HandleFunc("", controller.CreateRoom).Methods("POST")
+
+	members := rooms.PathPrefix("/{roomId}/members").Subrouter()
+	members.Handle("", withPermission("", controller.ListRoomMembers)).Methods("GET")
+	members.Handle("/{email}", withPermission(roles.PermManageMembers, controller.SetMemberRole)).Methods("PUT")
+	members.Handle("/{email}", withPermission(roles.PermKick, controller.RemoveMember)).Methods("DELETE")
+
+	invites := rooms.PathPrefix("/{roomId}/invites").Subrouter()
+	invites.Handle("", withPermission(roles.PermManageMembers, controller.CreateInvite)).Methods("POST")
+	invites.Handle("", withPermission(roles.PermManageMembers, controller.ListInvites)).Methods("GET")
+	invites.Handle("/{token}", withPermission(roles.PermManageMembers, controller.RevokeInvite)).Methods("DELETE")
+
+	// Anyone signed in may ask for access; only managers see and decide.
+	rooms.HandleFunc("/{roomId}/join-requests", controller.RequestToJoin).Methods("POST")
+	requests := rooms.PathPrefix("/{roomId}/join-requests").Subrouter()
+	requests.Handle("", withPermission(roles.PermManageMembers, controller.ListJoinRequests)).Methods("GET")
+	requests.Handle("/{requestId}/approve", withPermission(roles.PermManageMembers, controller.ApproveJoinRequest)).Methods("POST")
+	requests.Handle("/{requestId}/deny", withPermission(roles.PermManageMembers, controller.DenyJoinRequest)).Methods("POST")
+
+	settings := rooms.PathPrefix("/{roomId}/settings").Subrouter()
+	settings.Handle("", withPermission("", controller.GetRoomSettings)).Methods("GET")
+	settings.Handle("", withPermission(roles.PermManageMembers, controller.UpdateRoomSettings)).Methods("PATCH")
+
+	rooms.Handle("/{roomId}/messages", withPermission("", controller.ListMessages)).Methods("GET")
+
+	attachments := rooms.PathPrefix("/{roomId}/attachments").Subrouter()
+	attachments.Handle("", withPermission(roles.PermChat, controller.UploadAttachment)).Methods("POST")
+	attachments.Handle("/{attachmentId}", withPermission("", controller.GetAttachment)).Methods("GET")
+	attachments.Handle("/{attachmentId}/content", withPermission("", controller.DownloadAttachment)).Methods("GET")
+	attachments.Handle("/{attachmentId}/thumbnail", withPermission("", controller.DownloadThumbnail)).Methods("GET")
+
+	filters := rooms.PathPrefix("/{roomId}/filters").Subrouter()
+	filters.Handle("", withPermission(roles.PermManageMembers, controller.GetRoomFilters)).Methods("GET")
+	filters.Handle("", withPermission(roles.PermManageMembers, controller.UpdateRoomFilters)).Methods("PUT")
+
+	reviews := rooms.PathPrefix("/{roomId}/reviews").Subrouter()
+	reviews.Handle("", withPermission(roles.PermDeleteMessages, controller.ListReviews)).Methods("GET")
+	reviews.Handle("/{reviewId}", withPermission(roles.PermDeleteMessages, controller.ResolveReview)).Methods("POST")
+
+	hooks := rooms.PathPrefix("/{roomId}/webhooks").Subrouter()
+	hooks.Handle("", withPermission(roles.PermManageMembers, controller.CreateWebhook)).Methods("POST")
+	hooks.Handle("", withPermission(roles.PermManageMembers, controller.ListWebhooks)).Methods("GET")
+	hooks.Handle("/{webhookId}", withPermission(roles.PermManageMembers, controller.DeleteWebhook)).Methods("DELETE")
+	hooks.Handle("/{webhookId}/deliveries", withPermission(roles.PermManageMembers, controller.ListWebhookDeliveries)).Methods("GET")
+	hooks.Handle("/{webhookId}/ping", withPermission(roles.PermManageMembers, controller.PingWebhook)).Methods("POST")
+
+	objects := rooms.PathPrefix("/{roomId}/objects").Subrouter()
+	objects.Handle("", withPermission("", controller.ListRoomObjects)).Methods("GET")
+	objects.Handle("", withPermission(roles.PermEditMap, controller.CreateRoomObject)).Methods("POST")
+	objects.Handle("/{objectId}", withPermission("", controller.GetRoomObject)).Methods("GET")
+	objects.Handle("/{objectId}", withPermission(roles.PermEditMap, controller.UpdateRoomObject)).Methods("PATCH")
+	objects.Handle("/{objectId}", withPermission(roles.PermEditMap, controller.DeleteRoomObject)).Methods("DELETE")
+
+	bans := rooms.PathPrefix("/{roomId}/bans").Subrouter()
+	bans.Handle("", withPermission(roles.PermKick, controller.ListBans)).Methods("GET")
+	bans.Handle("/{email}", withPermission(roles.PermKick, controller.Unban)).Methods("DELETE")
+
+	inviteLinks := router.PathPrefix("/invites").Subrouter()
+	inviteLinks.Use(middleware.AuthMiddleware)
+	inviteLinks.HandleFunc("/{token}/accept", controller.AcceptInvite).Methods("POST")
+}
+
+func withPermission(permission roles.Permission, handler http.HandlerFunc) http.Handler {
+	return middleware.RequireRoomPermission(permission)(handler)
+}
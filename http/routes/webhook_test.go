@@ -0,0 +1,87 @@
+package routes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-gather/http/models"
+	"go-gather/http/webhooks"
+	"go-gather/store"
+	"go-gather/types"
+)
+
+// Joins the ws server audits reach the room's webhooks, naming the user by
+// profile rather than email.
+func TestAuditedJoinsReachWebhooks(t *testing.T) {
+	router := newTestRouter(t)
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true")
+	signUpAndIn(t, router, "joiner@example.com")
+
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	hook := models.Webhook{
+		ID:     "whk-lobby",
+		RoomID: "lobby",
+		URL:    receiver.URL,
+		Secret: "0123456789abcdef",
+		Events: []string{models.WebhookEventUserJoined},
+	}
+	if err := store.Get().Webhooks.Create(&hook); err != nil {
+		t.Fatal(err)
+	}
+
+	// Leaves are audited too, but this webhook did not ask for them.
+	for _, action := range []string{types.AuditRoomLeave, types.AuditRoomJoin} {
+		event := types.AuditEvent{Action: action, Actor: "joiner@example.com", RoomID: "lobby"}
+		if status, result := call(t, router, http.MethodPost, "/internal/audit", "", event); status != http.StatusCreated {
+			t.Fatalf("recording %s: %d %v", action, status, result)
+		}
+	}
+
+	var req *http.Request
+	var body []byte
+	select {
+	case req = <-received:
+		body = <-bodies
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not called")
+	}
+	if req.Header.Get(webhooks.HeaderEvent) != models.WebhookEventUserJoined {
+		t.Fatalf("got event %q", req.Header.Get(webhooks.HeaderEvent))
+	}
+	if got, want := req.Header.Get(webhooks.HeaderSignature), webhooks.Sign(hook.Secret, req.Header.Get(webhooks.HeaderTimestamp), body); got != want {
+		t.Fatalf("signature %q, want %q", got, want)
+	}
+	if strings.Contains(string(body), "joiner@example.com") {
+		t.Fatalf("payload names the user by email: %s", body)
+	}
+
+	var payload struct {
+		Data struct {
+			User types.Profile `json:"user"`
+		} `json:"data"`
+	}
+	json.Unmarshal(body, &payload)
+	profile, err := store.Get().Profiles.Get("joiner@example.com")
+	if err != nil || payload.Data.User.ID != profile.ID {
+		t.Fatalf("payload user %+v, want profile %+v (%v)", payload.Data.User, profile, err)
+	}
+
+	select {
+	case <-received:
+		t.Fatal("webhook was sent an event it did not subscribe to")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
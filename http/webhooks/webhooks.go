@@ -0,0 +1,255 @@
+// Package webhooks delivers room events to the URLs rooms subscribe. Every
+// delivery is a signed JSON POST, retried with backoff until the receiver
+// answers with a 2xx, and logged through the store so room owners can see
+// what happened.
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"go-gather/http/models"
+	"go-gather/store"
+)
+
+const (
+	HeaderEvent     = "X-Gather-Event"
+	HeaderDelivery  = "X-Gather-Delivery"
+	HeaderTimestamp = "X-Gather-Timestamp"
+	HeaderSignature = "X-Gather-Signature"
+
+	// maxConcurrent bounds the requests in flight at once; deliveries
+	// waiting for a retry do not count.
+	maxConcurrent = 16
+	// maxErrorLength keeps a chatty receiver out of the delivery log.
+	maxErrorLength = 256
+)
+
+// retryDelays are the waits before each retry. A delivery that still fails
+// after the last one is given up on. Retries live in memory, so the ones
+// pending when the service stops are lost.
+var retryDelays = []time.Duration{
+	5 * time.Second,
+	30 * time.Second,
+	2 * time.Minute,
+	10 * time.Minute,
+	30 * time.Minute,
+}
+
+var slots = make(chan struct{}, maxConcurrent)
+
+var client = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: checkDestination,
+		}).DialContext,
+	},
+	// A redirect would send the payload somewhere nobody configured.
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+var errPrivateDestination = errors.New("webhook destination is not a public address")
+
+// checkDestination refuses connections to loopback and private addresses,
+// which would let a room owner reach the internal endpoints that trust
+// them. Set WEBHOOK_ALLOW_PRIVATE_NETWORKS=true to test against a local
+// receiver.
+func checkDestination(network, address string, _ syscall.RawConn) error {
+	if os.Getenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS") == "true" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return errPrivateDestination
+	}
+	return nil
+}
+
+// Sign returns the signature sent in HeaderSignature: the hex HMAC-SHA256,
+// keyed with the webhook's secret, of the timestamp header, a dot and the
+// body. Receivers compute the same and compare, and can reject old
+// timestamps to stop replays.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// envelope is the body of every delivery.
+type envelope struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	RoomID    string      `json:"roomId"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Data      interface{} `json:"data"`
+}
+
+func newDeliveryID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "dlv-" + hex.EncodeToString(b), nil
+}
+
+// Dispatch sends the event to each of the room's webhooks subscribed to
+// it. It returns straight away; delivery happens in the background, and
+// data is only called when some webhook wants the event. Deliveries are
+// independent, so receivers should order events by createdAt rather than
+// by arrival.
+func Dispatch(roomID, event string, data func() interface{}) {
+	go func() {
+		hooks, err := store.Get().Webhooks.ListByRoom(roomID)
+		if err != nil {
+			log.Printf("Error loading webhooks of room %s: %v", roomID, err)
+			return
+		}
+		var payload interface{}
+		for _, hook := range hooks {
+			if !hook.Wants(event) {
+				continue
+			}
+			if payload == nil {
+				payload = data()
+			}
+			if _, err := Deliver(hook, event, payload); err != nil {
+				log.Printf("Error queueing %s for webhook %s: %v", event, hook.ID, err)
+			}
+		}
+	}()
+}
+
+// Deliver logs a new delivery of the event to the webhook and starts
+// sending it, whether or not the webhook subscribed to the event.
+func Deliver(hook models.Webhook, event string, data interface{}) (*models.WebhookDelivery, error) {
+	id, err := newDeliveryID()
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(envelope{
+		ID:        id,
+		Event:     event,
+		RoomID:    hook.RoomID,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	delivery := &models.WebhookDelivery{
+		ID:        id,
+		WebhookID: hook.ID,
+		Event:     event,
+		Payload:   string(body),
+		Status:    models.DeliveryPending,
+	}
+	if err := store.Get().Webhooks.CreateDelivery(delivery); err != nil {
+		return nil, err
+	}
+
+	go run(hook, *delivery, body)
+	return delivery, nil
+}
+
+// run makes the attempts, recording the outcome of each.
+func run(hook models.Webhook, delivery models.WebhookDelivery, body []byte) {
+	for {
+		statusCode, err := attempt(hook, delivery, body)
+
+		now := time.Now()
+		delivery.Attempts++
+		delivery.LastAttemptAt = &now
+		delivery.StatusCode = statusCode
+		delivery.Error = ""
+		if err != nil {
+			delivery.Error = truncate(err.Error())
+		}
+
+		retry := err != nil && delivery.Attempts <= len(retryDelays)
+		switch {
+		case err == nil:
+			delivery.Status = models.DeliverySucceeded
+		case !retry:
+			delivery.Status = models.DeliveryFailed
+		}
+
+		updateErr := store.Get().Webhooks.UpdateDelivery(&delivery)
+		if updateErr == store.ErrNotFound {
+			// The webhook was deleted, and its log with it.
+			return
+		}
+		if updateErr != nil {
+			log.Printf("Error logging delivery %s: %v", delivery.ID, updateErr)
+		}
+		if !retry {
+			if err != nil {
+				log.Printf("Giving up on delivery %s to webhook %s: %v", delivery.ID, hook.ID, err)
+			}
+			return
+		}
+		time.Sleep(retryDelays[delivery.Attempts-1])
+	}
+}
+
+func attempt(hook models.Webhook, delivery models.WebhookDelivery, body []byte) (int, error) {
+	slots <- struct{}{}
+	defer func() { <-slots }()
+
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-gather-webhooks")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, timestamp, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain a little so the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("receiver answered with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func truncate(s string) string {
+	if len(s) > maxErrorLength {
+		return s[:maxErrorLength]
+	}
+	return s
+}
@@ -0,0 +1,160 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go-gather/http/models"
+	"go-gather/store"
+)
+
+// receiver is a webhook endpoint that answers with the given statuses in
+// turn, then with 200, and keeps what it was sent.
+type receiver struct {
+	server   *httptest.Server
+	lock     sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	t.Helper()
+	rec := &receiver{statuses: statuses}
+	rec.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec.lock.Lock()
+		defer rec.lock.Unlock()
+		rec.requests = append(rec.requests, r)
+		rec.bodies = append(rec.bodies, body)
+		status := http.StatusOK
+		if len(rec.statuses) > 0 {
+			status, rec.statuses = rec.statuses[0], rec.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(rec.server.Close)
+	return rec
+}
+
+func (rec *receiver) received() int {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	return len(rec.requests)
+}
+
+// setup points deliveries at a memory store and lets them reach the local
+// receiver without the usual waits between retries.
+func setup(t *testing.T, receiverURL string) models.Webhook {
+	t.Helper()
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true")
+	store.Set(store.NewMemory())
+
+	delays := retryDelays
+	retryDelays = []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond}
+	t.Cleanup(func() { retryDelays = delays })
+
+	hook := models.Webhook{
+		ID:     "whk-test",
+		RoomID: "room-1",
+		URL:    receiverURL,
+		Secret: "0123456789abcdef",
+		Events: []string{models.WebhookEventUserJoined},
+	}
+	if err := store.Get().Webhooks.Create(&hook); err != nil {
+		t.Fatal(err)
+	}
+	return hook
+}
+
+// waitForDelivery polls the delivery log until the delivery is settled.
+func waitForDelivery(t *testing.T, hook models.Webhook) models.WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		deliveries, err := store.Get().Webhooks.ListDeliveries(hook.ID, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) == 1 && deliveries[0].Status != models.DeliveryPending {
+			return deliveries[0]
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("delivery did not settle")
+	return models.WebhookDelivery{}
+}
+
+func TestDeliverSignsAndRetries(t *testing.T) {
+	rec := newReceiver(t, http.StatusInternalServerError, http.StatusServiceUnavailable)
+	hook := setup(t, rec.server.URL)
+
+	if _, err := Deliver(hook, models.WebhookEventUserJoined, map[string]string{"user": "guest-1"}); err != nil {
+		t.Fatal(err)
+	}
+	delivery := waitForDelivery(t, hook)
+	if delivery.Status != models.DeliverySucceeded || delivery.Attempts != 3 || delivery.StatusCode != http.StatusOK {
+		t.Fatalf("delivery %+v, want success on the third attempt", delivery)
+	}
+	if rec.received() != 3 {
+		t.Fatalf("receiver got %d requests, want 3", rec.received())
+	}
+
+	for i, req := range rec.requests {
+		body := rec.bodies[i]
+		if got, want := req.Header.Get(HeaderSignature), Sign(hook.Secret, req.Header.Get(HeaderTimestamp), body); got != want {
+			t.Errorf("attempt %d signed %q, want %q", i+1, got, want)
+		}
+		if req.Header.Get(HeaderDelivery) != delivery.ID || req.Header.Get(HeaderEvent) != models.WebhookEventUserJoined {
+			t.Errorf("attempt %d has headers %v", i+1, req.Header)
+		}
+		if string(body) != delivery.Payload {
+			t.Errorf("attempt %d sent %s, want the logged payload", i+1, body)
+		}
+	}
+
+	var sent envelope
+	if err := json.Unmarshal(rec.bodies[0], &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent.ID != delivery.ID || sent.RoomID != hook.RoomID || sent.Event != models.WebhookEventUserJoined {
+		t.Fatalf("envelope %+v", sent)
+	}
+}
+
+func TestDeliverGivesUp(t *testing.T) {
+	rec := newReceiver(t, http.StatusInternalServerError, http.StatusInternalServerError,
+		http.StatusInternalServerError, http.StatusInternalServerError)
+	hook := setup(t, rec.server.URL)
+
+	if _, err := Deliver(hook, models.WebhookEventUserJoined, nil); err != nil {
+		t.Fatal(err)
+	}
+	delivery := waitForDelivery(t, hook)
+	if delivery.Status != models.DeliveryFailed || delivery.Attempts != len(retryDelays)+1 ||
+		delivery.StatusCode != http.StatusInternalServerError || delivery.Error == "" {
+		t.Fatalf("delivery %+v, want failure after every retry", delivery)
+	}
+}
+
+func TestDeliverRefusesPrivateNetworks(t *testing.T) {
+	rec := newReceiver(t)
+	hook := setup(t, rec.server.URL)
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "")
+	retryDelays = nil
+
+	if _, err := Deliver(hook, models.WebhookEventUserJoined, nil); err != nil {
+		t.Fatal(err)
+	}
+	if delivery := waitForDelivery(t, hook); delivery.Status != models.DeliveryFailed {
+		t.Fatalf("delivery %+v, want it refused", delivery)
+	}
+	if rec.received() != 0 {
+		t.Fatalf("receiver was reached %d times", rec.received())
+	}
+}
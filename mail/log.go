@@ -0,0 +1,45 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Log is a mailer for local development. With a directory it writes every
+// message there as an .eml file; without one it prints them to the log.
+type Log struct {
+	dir string
+}
+
+func NewLog(dir string) (*Log, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return &Log{dir: dir}, nil
+}
+
+func (l *Log) Send(message Message) error {
+	if l.dir == "" {
+		log.Printf("Mail to %s: %s\n%s", message.To, message.Subject, message.Body)
+		return nil
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), safeName(message.To))
+	return os.WriteFile(filepath.Join(l.dir, name), format("dev@localhost", message), 0o644)
+}
+
+// safeName keeps an address usable as part of a file name.
+func safeName(address string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '@' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, address)
+}
@@ -0,0 +1,63 @@
+package mail
+
+import (
+	"log"
+	"os"
+	"sync"
+
+	"go-gather/db"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers account emails such as verification and password reset
+// links.
+type Mailer interface {
+	Send(message Message) error
+}
+
+var (
+	instance Mailer
+	once     sync.Once
+)
+
+// Get returns the mailer selected by MAIL_DRIVER: "log" (the default)
+// writes messages to MAIL_DIR, or to the log when that is unset, and
+// "smtp" sends them through the server configured by the SMTP_* variables.
+func Get() Mailer {
+	once.Do(func() {
+		db.LoadEnv()
+
+		driver := os.Getenv("MAIL_DRIVER")
+		switch driver {
+		case "", "log":
+			dir := os.Getenv("MAIL_DIR")
+			mailer, err := NewLog(dir)
+			if err != nil {
+				log.Fatalf("Unable to open mail directory %s: %v", dir, err)
+			}
+			instance = mailer
+		case "smtp":
+			mailer, err := NewSMTP(SMTPConfigFromEnv())
+			if err != nil {
+				log.Fatalf("Unable to configure SMTP: %v", err)
+			}
+			instance = mailer
+			log.Println("Sending mail through SMTP")
+		default:
+			log.Fatalf("Unknown MAIL_DRIVER %q", driver)
+		}
+	})
+	return instance
+}
+
+// Set replaces the mailer returned by Get, for tests.
+func Set(m Mailer) {
+	once.Do(func() {})
+	instance = m
+}
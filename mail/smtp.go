@@ -0,0 +1,78 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// SMTPConfig names the relay to send through. Username and Password are
+// optional; when set, PLAIN auth is used, which net/smtp only allows over
+// TLS or to localhost.
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func SMTPConfigFromEnv() SMTPConfig {
+	return SMTPConfig{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     os.Getenv("SMTP_PORT"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("MAIL_FROM"),
+	}
+}
+
+// SMTP sends mail with net/smtp, upgrading to TLS when the server offers
+// STARTTLS.
+type SMTP struct {
+	config SMTPConfig
+	auth   smtp.Auth
+}
+
+func NewSMTP(config SMTPConfig) (*SMTP, error) {
+	if config.Host == "" || config.From == "" {
+		return nil, errors.New("SMTP_HOST and MAIL_FROM are required")
+	}
+	if config.Port == "" {
+		config.Port = "587"
+	}
+
+	s := &SMTP{config: config}
+	if config.Username != "" {
+		s.auth = smtp.PlainAuth("", config.Username, config.Password, config.Host)
+	}
+	return s, nil
+}
+
+func (s *SMTP) Send(message Message) error {
+	if strings.ContainsAny(message.To, "\r\n") {
+		return fmt.Errorf("invalid recipient %q", message.To)
+	}
+	addr := net.JoinHostPort(s.config.Host, s.config.Port)
+	return smtp.SendMail(addr, s.auth, s.config.From, []string{message.To}, format(s.config.From, message))
+}
+
+// format renders the message with the headers every server expects.
+func format(from string, message Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", message.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(message.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes()
+}
@@ -7,8 +7,14 @@ import (
 )
 
 func main() {
+	if err := ws.StartPlugins(); err != nil {
+		log.Fatal(err)
+	}
+
 	// Use ws.HandleWebsocket instead of ws.NewWebSocketHandler
 	http.HandleFunc("/ws", ws.HandleWebsocket)
+	http.HandleFunc("/internal/notify", ws.HandleNotify)
+	http.HandleFunc("/internal/metrics/events", ws.HandleEventMetrics)
 
 	log.Println("Server started at :8080")
 	err := http.ListenAndServe(":8080", nil)
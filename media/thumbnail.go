@@ -0,0 +1,111 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+
+	// Decoders for the formats we can thumbnail.
+	_ "image/gif"
+)
+
+const (
+	// ThumbnailSize bounds both sides of a thumbnail.
+	ThumbnailSize = 320
+	// maxPixels refuses images that would take too much memory to decode,
+	// whatever their file size.
+	maxPixels = 40_000_000
+	// samples per axis taken inside each thumbnail pixel's source box.
+	samples = 4
+)
+
+var ErrTooLarge = errors.New("image dimensions too large")
+
+// Thumbnail is a scaled-down copy of an image, re-encoded as PNG when it
+// has transparency and as JPEG otherwise.
+type Thumbnail struct {
+	Data        []byte
+	ContentType string
+	// Width and Height are those of the original image.
+	Width  int
+	Height int
+}
+
+// MakeThumbnail decodes a PNG, JPEG or GIF (first frame) and shrinks it to
+// fit in ThumbnailSize x ThumbnailSize. Smaller images keep their size but
+// are still re-encoded, which also drops any embedded metadata.
+func MakeThumbnail(data []byte) (*Thumbnail, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	dst := scale(src, fit(config.Width, config.Height))
+
+	var out bytes.Buffer
+	contentType := "image/jpeg"
+	if dst.Opaque() {
+		err = jpeg.Encode(&out, dst, &jpeg.Options{Quality: 80})
+	} else {
+		contentType = "image/png"
+		err = png.Encode(&out, dst)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Thumbnail{
+		Data:        out.Bytes(),
+		ContentType: contentType,
+		Width:       config.Width,
+		Height:      config.Height,
+	}, nil
+}
+
+// fit returns the thumbnail bounds for a width x height image.
+func fit(width, height int) image.Rectangle {
+	if width <= ThumbnailSize && height <= ThumbnailSize {
+		return image.Rect(0, 0, width, height)
+	}
+	if width >= height {
+		return image.Rect(0, 0, ThumbnailSize, max(1, height*ThumbnailSize/width))
+	}
+	return image.Rect(0, 0, max(1, width*ThumbnailSize/height), ThumbnailSize)
+}
+
+// scale averages a grid of samples from each destination pixel's box in
+// the source. That is close to a box filter at a bounded cost per pixel.
+func scale(src image.Image, bounds image.Rectangle) *image.RGBA {
+	dst := image.NewRGBA(bounds)
+	sb := src.Bounds()
+	boxW := float64(sb.Dx()) / float64(bounds.Dx())
+	boxH := float64(sb.Dy()) / float64(bounds.Dy())
+
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			var r, g, b, a uint32
+			for sy := 0; sy < samples; sy++ {
+				for sx := 0; sx < samples; sx++ {
+					px := sb.Min.X + int((float64(x)+(float64(sx)+0.5)/samples)*boxW)
+					py := sb.Min.Y + int((float64(y)+(float64(sy)+0.5)/samples)*boxH)
+					cr, cg, cb, ca := src.At(px, py).RGBA()
+					r, g, b, a = r+cr, g+cg, b+cb, a+ca
+				}
+			}
+			n := uint32(samples * samples)
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,90 @@
+package moderation
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	maxWords         = 1000
+	maxRules         = 50
+	maxPatternLength = 512
+	maxDomains       = 200
+)
+
+// Config is a room's filter setup. Empty actions fall back to masking
+// words and rejecting links.
+type Config struct {
+	Words          []string `json:"words"`
+	WordAction     Action   `json:"wordAction,omitempty"`
+	Rules          []Rule   `json:"rules"`
+	AllowedDomains []string `json:"allowedDomains"`
+	BlockedDomains []string `json:"blockedDomains"`
+	LinkAction     Action   `json:"linkAction,omitempty"`
+}
+
+// Rule is a named regular expression in RE2 syntax.
+type Rule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	Action  Action `json:"action"`
+}
+
+// Validate reports the first problem with the configuration in a form fit
+// to show the room owner.
+func (c *Config) Validate() error {
+	if len(c.Words) > maxWords {
+		return fmt.Errorf("at most %d words are allowed", maxWords)
+	}
+	if len(c.Rules) > maxRules {
+		return fmt.Errorf("at most %d rules are allowed", maxRules)
+	}
+	if len(c.AllowedDomains) > maxDomains || len(c.BlockedDomains) > maxDomains {
+		return fmt.Errorf("at most %d domains are allowed per list", maxDomains)
+	}
+	if c.WordAction != "" && !c.WordAction.valid() {
+		return fmt.Errorf("unknown word action %q", c.WordAction)
+	}
+	if c.LinkAction != "" && !c.LinkAction.valid() {
+		return fmt.Errorf("unknown link action %q", c.LinkAction)
+	}
+	for _, rule := range c.Rules {
+		if strings.TrimSpace(rule.Name) == "" {
+			return fmt.Errorf("every rule needs a name")
+		}
+		if !rule.Action.valid() {
+			return fmt.Errorf("rule %s has unknown action %q", rule.Name, rule.Action)
+		}
+		if len(rule.Pattern) > maxPatternLength {
+			return fmt.Errorf("rule %s is longer than %d characters", rule.Name, maxPatternLength)
+		}
+		if _, err := NewRegexRule(rule.Name, rule.Pattern, rule.Action); err != nil {
+			return fmt.Errorf("rule %s: %v", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+// Build turns the configuration into the chain its room runs.
+func (c *Config) Build() (*Chain, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	wordAction := c.WordAction
+	if wordAction == "" {
+		wordAction = Mask
+	}
+	linkAction := c.LinkAction
+	if linkAction == "" {
+		linkAction = Reject
+	}
+
+	filters := []Filter{NewWordList(c.Words, wordAction)}
+	for _, rule := range c.Rules {
+		filter, _ := NewRegexRule(rule.Name, rule.Pattern, rule.Action)
+		filters = append(filters, filter)
+	}
+	filters = append(filters, NewLinkFilter(c.AllowedDomains, c.BlockedDomains, linkAction))
+	return NewChain(filters...), nil
+}
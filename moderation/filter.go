@@ -0,0 +1,133 @@
+// Package moderation screens chat messages before they are stored. A room
+// configures which filters run and what happens on a match; the filters
+// themselves only find text.
+package moderation
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Action is what happens to a message a filter matched.
+type Action string
+
+const (
+	// Flag delivers the message and queues it for a moderator.
+	Flag Action = "flag"
+	// Mask delivers the message with the matched text starred out.
+	Mask Action = "mask"
+	// Reject refuses the message.
+	Reject Action = "reject"
+)
+
+func (a Action) valid() bool {
+	return a == Flag || a == Mask || a == Reject
+}
+
+// Match is one piece of a message a filter objected to. Start and End are
+// byte offsets into the body.
+type Match struct {
+	Filter string `json:"filter"`
+	Text   string `json:"text"`
+	Action Action `json:"action"`
+	Start  int    `json:"-"`
+	End    int    `json:"-"`
+}
+
+// Filter finds text in a message body that a room does not want.
+type Filter interface {
+	Check(body string) []Match
+}
+
+// Verdict is the outcome of running a message through a Chain. Body is the
+// message as it should be stored, with masked text replaced.
+type Verdict struct {
+	Body     string
+	Rejected bool
+	Flagged  bool
+	Matches  []Match
+}
+
+// Reasons describes the matches for the review queue.
+func (v Verdict) Reasons() []string {
+	reasons := make([]string, 0, len(v.Matches))
+	for _, match := range v.Matches {
+		reasons = append(reasons, match.Filter+": "+match.Text)
+	}
+	return reasons
+}
+
+// Chain runs filters in order and combines what they found.
+type Chain struct {
+	filters []Filter
+}
+
+func NewChain(filters ...Filter) *Chain {
+	return &Chain{filters: filters}
+}
+
+var (
+	globalLock    sync.RWMutex
+	globalFilters []Filter
+)
+
+// Register adds a filter that runs in every room ahead of the room's own
+// configuration. Call it during start-up.
+func Register(filter Filter) {
+	globalLock.Lock()
+	defer globalLock.Unlock()
+	globalFilters = append(globalFilters, filter)
+}
+
+// Check runs every filter over body. Masks are applied even when another
+// match flags the message; a rejection makes the rest moot.
+func (c *Chain) Check(body string) Verdict {
+	globalLock.RLock()
+	filters := append(append([]Filter{}, globalFilters...), c.filters...)
+	globalLock.RUnlock()
+
+	verdict := Verdict{Body: body}
+	var masked []Match
+	for _, filter := range filters {
+		for _, match := range filter.Check(body) {
+			verdict.Matches = append(verdict.Matches, match)
+			switch match.Action {
+			case Reject:
+				verdict.Rejected = true
+			case Flag:
+				verdict.Flagged = true
+			case Mask:
+				masked = append(masked, match)
+			}
+		}
+	}
+	if len(masked) > 0 && !verdict.Rejected {
+		verdict.Body = mask(body, masked)
+	}
+	return verdict
+}
+
+// mask stars out every letter and digit inside the matches, keeping spaces
+// and punctuation so the message still reads naturally.
+func mask(body string, matches []Match) string {
+	hidden := make([]bool, len(body))
+	for _, match := range matches {
+		for i := match.Start; i < match.End && i < len(body); i++ {
+			hidden[i] = true
+		}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(body); {
+		r, size := utf8.DecodeRuneInString(body[i:])
+		if hidden[i] && (unicode.IsLetter(r) || unicode.IsNumber(r)) {
+			b.WriteByte('*')
+		} else {
+			b.WriteString(body[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
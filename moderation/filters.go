@@ -0,0 +1,162 @@
+package moderation
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type wordList struct {
+	pattern *regexp.Regexp
+	action  Action
+}
+
+// NewWordList matches any of words, ignoring case, where it stands on its
+// own rather than inside a longer word. Words may contain spaces.
+func NewWordList(words []string, action Action) Filter {
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	if len(quoted) == 0 {
+		return &wordList{action: action}
+	}
+	return &wordList{
+		pattern: regexp.MustCompile(`(?i)` + strings.Join(quoted, "|")),
+		action:  action,
+	}
+}
+
+func (f *wordList) Check(body string) []Match {
+	if f.pattern == nil {
+		return nil
+	}
+
+	var matches []Match
+	for _, loc := range f.pattern.FindAllStringIndex(body, -1) {
+		// RE2's \b only knows ASCII, so word boundaries are checked here.
+		before, _ := utf8.DecodeLastRuneInString(body[:loc[0]])
+		after, _ := utf8.DecodeRuneInString(body[loc[1]:])
+		if isWordRune(before) || isWordRune(after) {
+			continue
+		}
+		matches = append(matches, Match{
+			Filter: "words",
+			Text:   body[loc[0]:loc[1]],
+			Action: f.action,
+			Start:  loc[0],
+			End:    loc[1],
+		})
+	}
+	return matches
+}
+
+func isWordRune(r rune) bool {
+	return r != utf8.RuneError && (unicode.IsLetter(r) || unicode.IsNumber(r) || r == '_')
+}
+
+type regexRule struct {
+	name    string
+	pattern *regexp.Regexp
+	action  Action
+}
+
+// NewRegexRule matches the RE2 pattern. The name shows up in the review
+// queue so moderators can tell rules apart.
+func NewRegexRule(name, pattern string, action Action) (Filter, error) {
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &regexRule{name: name, pattern: compiled, action: action}, nil
+}
+
+func (f *regexRule) Check(body string) []Match {
+	var matches []Match
+	for _, loc := range f.pattern.FindAllStringIndex(body, -1) {
+		if loc[0] == loc[1] {
+			continue
+		}
+		matches = append(matches, Match{
+			Filter: "rule " + f.name,
+			Text:   body[loc[0]:loc[1]],
+			Action: f.action,
+			Start:  loc[0],
+			End:    loc[1],
+		})
+	}
+	return matches
+}
+
+// linkPattern finds the links chat clients turn into anchors: anything
+// with a scheme or starting with www.
+var linkPattern = regexp.MustCompile(`(?i)\b(?:[a-z][a-z0-9+.-]*://|www\.)[^\s<>"']+`)
+
+type linkFilter struct {
+	allowed []string
+	blocked []string
+	action  Action
+}
+
+// NewLinkFilter matches links to blocked domains and, when allowed is not
+// empty, links to any domain not on it. Subdomains count as their parent.
+func NewLinkFilter(allowed, blocked []string, action Action) Filter {
+	return &linkFilter{allowed: normalizeDomains(allowed), blocked: normalizeDomains(blocked), action: action}
+}
+
+func normalizeDomains(domains []string) []string {
+	normalized := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		domain = strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if domain != "" {
+			normalized = append(normalized, domain)
+		}
+	}
+	return normalized
+}
+
+func (f *linkFilter) Check(body string) []Match {
+	if len(f.allowed) == 0 && len(f.blocked) == 0 {
+		return nil
+	}
+
+	var matches []Match
+	for _, loc := range linkPattern.FindAllStringIndex(body, -1) {
+		link := strings.TrimRight(body[loc[0]:loc[1]], ".,;:!?)")
+		host := linkHost(link)
+		if inDomains(host, f.blocked) || (len(f.allowed) > 0 && !inDomains(host, f.allowed)) {
+			matches = append(matches, Match{
+				Filter: "links",
+				Text:   link,
+				Action: f.action,
+				Start:  loc[0],
+				End:    loc[0] + len(link),
+			})
+		}
+	}
+	return matches
+}
+
+func linkHost(link string) string {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+}
+
+func inDomains(host string, domains []string) bool {
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
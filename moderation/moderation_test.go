@@ -0,0 +1,140 @@
+package moderation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChainOutcomes(t *testing.T) {
+	config := Config{
+		Words:          []string{"darn", "heck off"},
+		Rules:          []Rule{{Name: "caps", Pattern: `\b[A-Z]{8,}\b`, Action: Flag}},
+		BlockedDomains: []string{"spam.example"},
+	}
+	chain, err := config.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		want     string
+		rejected bool
+		flagged  bool
+	}{
+		{"clean message is allowed", "hello there", "hello there", false, false},
+		{"words are masked", "well darn it", "well **** it", false, false},
+		{"masking ignores case and keeps spaces", "HECK OFF now", "**** *** now", false, false},
+		{"words inside longer words are left alone", "darned socks", "darned socks", false, false},
+		{"non-ASCII letters count as part of a word", "darnß", "darnß", false, false},
+		{"blocked links are rejected", "see https://spam.example/win", "see https://spam.example/win", true, false},
+		{"subdomains of blocked domains are rejected", "www.promo.spam.example", "www.promo.spam.example", true, false},
+		{"other links are allowed", "docs at https://example.org/spam.example", "docs at https://example.org/spam.example", false, false},
+		{"shouting is queued for review", "WHYYYYYYY is this", "WHYYYYYYY is this", false, true},
+		{"a queued message is still masked", "DARNITALL darn", "DARNITALL ****", false, true},
+		{"a rejection keeps the body unmasked", "darn https://spam.example", "darn https://spam.example", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict := chain.Check(tt.body)
+			if verdict.Body != tt.want || verdict.Rejected != tt.rejected || verdict.Flagged != tt.flagged {
+				t.Fatalf("Check(%q) = body %q, rejected %v, flagged %v; want %q, %v, %v",
+					tt.body, verdict.Body, verdict.Rejected, verdict.Flagged, tt.want, tt.rejected, tt.flagged)
+			}
+		})
+	}
+}
+
+func TestRoomsKeepTheirOwnConfig(t *testing.T) {
+	strict, err := (&Config{
+		Words:          []string{"darn"},
+		WordAction:     Reject,
+		AllowedDomains: []string{"example.org"},
+		LinkAction:     Flag,
+	}).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	relaxed, err := (&Config{}).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := "darn, read www.news.example.com"
+	if verdict := strict.Check(body); !verdict.Rejected || !verdict.Flagged || len(verdict.Matches) != 2 {
+		t.Fatalf("strict room: %+v", verdict)
+	}
+	if verdict := relaxed.Check(body); verdict.Rejected || verdict.Flagged || verdict.Body != body {
+		t.Fatalf("room without filters: %+v", verdict)
+	}
+	if verdict := strict.Check("see https://sub.example.org/page."); verdict.Flagged {
+		t.Fatalf("allowed domain was flagged: %+v", verdict)
+	}
+}
+
+func TestReasonsNameTheFilter(t *testing.T) {
+	chain, err := (&Config{Rules: []Rule{{Name: "invites", Pattern: `discord\.gg/\w+`, Action: Flag}}}).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reasons := chain.Check("join discord.gg/abc").Reasons()
+	if len(reasons) != 1 || reasons[0] != "rule invites: discord.gg/abc" {
+		t.Fatalf("reasons %v", reasons)
+	}
+}
+
+func TestRegisteredFiltersRunInEveryRoom(t *testing.T) {
+	globalLock.Lock()
+	saved := globalFilters
+	globalLock.Unlock()
+	defer func() {
+		globalLock.Lock()
+		globalFilters = saved
+		globalLock.Unlock()
+	}()
+
+	Register(NewWordList([]string{"forbidden"}, Reject))
+	chain, err := (&Config{}).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verdict := chain.Check("a forbidden word"); !verdict.Rejected {
+		t.Fatalf("global filter did not run: %+v", verdict)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		want   string
+	}{
+		{"defaults", Config{}, ""},
+		{"unknown word action", Config{WordAction: "delete"}, "unknown word action"},
+		{"unknown link action", Config{LinkAction: "delete"}, "unknown link action"},
+		{"unnamed rule", Config{Rules: []Rule{{Pattern: "x", Action: Flag}}}, "needs a name"},
+		{"rule without action", Config{Rules: []Rule{{Name: "x", Pattern: "x"}}}, "unknown action"},
+		{"bad pattern", Config{Rules: []Rule{{Name: "x", Pattern: "(", Action: Flag}}}, "rule x:"},
+		{"long pattern", Config{Rules: []Rule{{Name: "x", Pattern: strings.Repeat("a", maxPatternLength+1), Action: Flag}}}, "longer than"},
+		{"too many words", Config{Words: make([]string, maxWords+1)}, "words are allowed"},
+		{"too many domains", Config{BlockedDomains: make([]string, maxDomains+1)}, "domains are allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.want)
+			}
+			if _, buildErr := tt.config.Build(); buildErr == nil {
+				t.Fatal("Build accepted an invalid config")
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+// Package oidc signs users in through an OpenID Connect identity provider
+// using the authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go-gather/db"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrNotConfigured = errors.New("OIDC is not configured")
+
+// Config identifies us to the provider. RedirectURL must match what is
+// registered there and point at our callback endpoint.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// ConfigFromEnv reads OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET,
+// OIDC_REDIRECT_URL and OIDC_SCOPES (space separated, defaulting to
+// "openid email profile").
+func ConfigFromEnv() Config {
+	scopes := strings.Fields(os.Getenv("OIDC_SCOPES"))
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	return Config{
+		Issuer:       strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:       scopes,
+	}
+}
+
+// Claims are the parts of an ID token we use.
+type Claims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Nonce         string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider talks to one identity provider. Its keys are fetched on first
+// use and again whenever a token names a key we have not seen.
+type Provider struct {
+	config   Config
+	endpoint discovery
+	client   *http.Client
+	keys     *keySet
+}
+
+var (
+	instance    *Provider
+	instanceErr error
+	lock        sync.Mutex
+)
+
+// Get returns the provider configured through the environment, running
+// discovery on first use. It returns ErrNotConfigured when OIDC_ISSUER is
+// unset. A failed discovery is retried on the next call.
+func Get(ctx context.Context) (*Provider, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if instance != nil || instanceErr == ErrNotConfigured {
+		return instance, instanceErr
+	}
+
+	db.LoadEnv()
+	config := ConfigFromEnv()
+	if config.Issuer == "" {
+		instanceErr = ErrNotConfigured
+		return nil, instanceErr
+	}
+
+	provider, err := Discover(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	instance = provider
+	return instance, nil
+}
+
+// Discover reads the provider's metadata from its well-known document.
+func Discover(ctx context.Context, config Config) (*Provider, error) {
+	if config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required")
+	}
+
+	p := &Provider{config: config, client: &http.Client{Timeout: 10 * time.Second}}
+	if err := p.getJSON(ctx, config.Issuer+"/.well-known/openid-configuration", &p.endpoint); err != nil {
+		return nil, fmt.Errorf("discovering %s: %w", config.Issuer, err)
+	}
+	if strings.TrimSuffix(p.endpoint.Issuer, "/") != config.Issuer {
+		return nil, fmt.Errorf("provider calls itself %q, expected %q", p.endpoint.Issuer, config.Issuer)
+	}
+	if p.endpoint.AuthorizationEndpoint == "" || p.endpoint.TokenEndpoint == "" || p.endpoint.JWKSURI == "" {
+		return nil, errors.New("provider metadata is missing endpoints")
+	}
+	p.keys = &keySet{uri: p.endpoint.JWKSURI, fetch: p.getJSON}
+	return p, nil
+}
+
+func (p *Provider) Issuer() string {
+	return p.config.Issuer
+}
+
+func (p *Provider) getJSON(ctx context.Context, target string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned status %d", target, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(result)
+}
+
+// RandomString returns a URL-safe random value for state, nonce and PKCE
+// verifiers.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge derives the S256 PKCE challenge sent with the authorization
+// request from the verifier kept for the token request.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL is where the browser goes to sign in at the provider.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(p.endpoint.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.endpoint.AuthorizationEndpoint + separator + query.Encode()
+}
+
+// Exchange trades the code from the callback for an ID token and returns
+// its verified claims. nonce must be the one sent with the authorization
+// request.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decoding token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || result.Error != "" {
+		return nil, fmt.Errorf("token request failed: %s %s", result.Error, result.ErrorDescription)
+	}
+	if result.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	return p.Verify(ctx, result.IDToken, nonce)
+}
+
+// Verify checks an ID token's signature, issuer, audience, expiry and
+// nonce.
+func (p *Provider) Verify(ctx context.Context, idToken, nonce string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(idToken, claims,
+		func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			return p.keys.get(ctx, kid)
+		},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(p.config.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("invalid ID token: nonce does not match")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("invalid ID token: no subject")
+	}
+	return claims, nil
+}
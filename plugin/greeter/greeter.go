@@ -0,0 +1,61 @@
+// Package greeter is an example plugin that welcomes people to rooms. To
+// enable it, import it from the ws server's main package:
+//
+//	import _ "go-gather/plugin/greeter"
+//
+// GREETER_ROOMS limits it to a comma-separated list of rooms and
+// GREETER_MESSAGE replaces the greeting, in which {name} stands for the
+// newcomer's display name.
+package greeter
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"go-gather/plugin"
+)
+
+const defaultMessage = "Welcome, {name}!"
+
+type greeter struct {
+	api     plugin.API
+	rooms   map[string]bool
+	message string
+}
+
+func init() {
+	plugin.Register(&greeter{})
+}
+
+func (g *greeter) Name() string {
+	return "greeter"
+}
+
+func (g *greeter) Start(api plugin.API) error {
+	g.api = api
+	g.message = defaultMessage
+	if message := os.Getenv("GREETER_MESSAGE"); message != "" {
+		g.message = message
+	}
+	if rooms := os.Getenv("GREETER_ROOMS"); rooms != "" {
+		g.rooms = make(map[string]bool)
+		for _, room := range strings.Split(rooms, ",") {
+			g.rooms[strings.TrimSpace(room)] = true
+		}
+	}
+	return nil
+}
+
+func (g *greeter) HandleEvent(event plugin.Event) {
+	if event.Type != plugin.EventJoin || event.User.Bot {
+		return
+	}
+	if g.rooms != nil && !g.rooms[event.RoomID] {
+		return
+	}
+	body := strings.ReplaceAll(g.message, "{name}", event.User.DisplayName)
+	if err := g.api.SendChat(event.RoomID, "", body); err != nil {
+		log.Printf("greeter: could not greet in room %s: %v", event.RoomID, err)
+	}
+}
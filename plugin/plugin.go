@@ -0,0 +1,144 @@
+// Package plugin lets Go code script rooms from inside the ws server:
+// greeters, games, doors that open when someone walks up to them. A plugin
+// registers itself at startup, is told what happens in rooms through
+// HandleEvent and acts through the API it is handed, which is all it can
+// touch. Plugins are compiled in; import one for its Register call from
+// the server's main package to enable it.
+package plugin
+
+import (
+	"fmt"
+	"sync"
+
+	"go-gather/types"
+)
+
+// Event types delivered to HandleEvent.
+const (
+	EventJoin  = "join"
+	EventLeave = "leave"
+	EventMove  = "move"
+	EventChat  = "chat"
+	// EventZoneEntered and EventZoneLeft only go to the plugin that
+	// defined the zone.
+	EventZoneEntered = "zone-entered"
+	EventZoneLeft    = "zone-left"
+)
+
+// Event is something that happened in a room. User is the public profile
+// of whoever caused it; X and Y are their position afterwards. Zone is set
+// for zone events and Message for chat.
+type Event struct {
+	Type    string
+	RoomID  string
+	User    types.Profile
+	X       int
+	Y       int
+	Zone    string
+	Message *types.ChatMessage
+}
+
+// Plugin is implemented by room scripts. Start is called once, before any
+// event, with the API the plugin acts through. HandleEvent is called for
+// every event in every room, one at a time per plugin, so a plugin's own
+// state needs no locking against it; slow handlers only delay their own
+// plugin, and events beyond its backlog are dropped.
+type Plugin interface {
+	Name() string
+	Start(api API) error
+	HandleEvent(event Event)
+}
+
+// NPC is an avatar a plugin puts on the map. IDs are the plugin's own and
+// only need to be unique among its NPCs in the room.
+type NPC struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Avatar string `json:"avatar,omitempty"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+}
+
+// Zone is a named rectangle of tiles. Users walking into or out of it
+// produce zone events for the plugin that defined it.
+type Zone struct {
+	Name   string `json:"name"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+func (z Zone) Contains(x, y int) bool {
+	return x >= z.X && x < z.X+z.Width && y >= z.Y && y < z.Y+z.Height
+}
+
+// API is what a plugin may do. Users are named by their public profile
+// ID, as in events. Whatever the plugin sends, places or stores is tagged
+// with its name, so plugins cannot pass themselves off as each other or
+// as the server. NPCs, zones and state live in memory and are gone after
+// a restart; plugins set them up again from Start or on the first join.
+type API interface {
+	// Users lists everyone who has joined the room.
+	Users(roomID string) []types.Profile
+	// Position returns where a user in the room is standing.
+	Position(roomID, userID string) (x, y int, ok bool)
+
+	// SendChat posts a chat message as the plugin, to a zone's channel or
+	// to the whole room when zone is empty. It goes through the room's
+	// filters like any other message.
+	SendChat(roomID, zone, body string) error
+	// SendTo sends a plugin-event to one user in the room.
+	SendTo(roomID, userID, eventType string, data interface{}) error
+	// Broadcast sends a plugin-event to everyone in the room.
+	Broadcast(roomID, eventType string, data interface{})
+
+	// PlaceNPC adds the NPC to the room, or updates it if the plugin
+	// already placed one with that ID.
+	PlaceNPC(roomID string, npc NPC) error
+	MoveNPC(roomID, npcID string, x, y int) error
+	RemoveNPC(roomID, npcID string) error
+
+	// SetZones replaces the plugin's zones in the room. Users already
+	// standing in a new zone are not told they entered it until they
+	// move.
+	SetZones(roomID string, zones []Zone) error
+
+	// SetState stores a value clients see under the plugin's name, such
+	// as a scoreboard or whether a door is open. A nil value removes it.
+	// The value is stored as JSON, so changing it afterwards has no
+	// effect, and State returns it decoded the way encoding/json decodes
+	// into an interface{}.
+	SetState(roomID, key string, value interface{}) error
+	State(roomID, key string) (interface{}, bool)
+}
+
+var (
+	registryLock sync.Mutex
+	registry     []Plugin
+	names        = make(map[string]bool)
+)
+
+// Register makes the plugin start with the server. It is meant to be
+// called from an init function and panics on a duplicate or empty name.
+func Register(p Plugin) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	name := p.Name()
+	if name == "" {
+		panic("plugin: Register called with an empty name")
+	}
+	if names[name] {
+		panic(fmt.Sprintf("plugin: Register called twice for %q", name))
+	}
+	names[name] = true
+	registry = append(registry, p)
+}
+
+// Registered returns the registered plugins in registration order.
+func Registered() []Plugin {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	return append([]Plugin(nil), registry...)
+}
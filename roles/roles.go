@@ -0,0 +1,64 @@
+package roles
+
+type Role string
+
+const (
+	Owner     Role = "owner"
+	Moderator Role = "moderator"
+	Member    Role = "member"
+	Guest     Role = "guest"
+)
+
+type Permission string
+
+const (
+	PermChat          Permission = "chat"
+	PermPublishMedia  Permission = "publish-media"
+	PermKick          Permission = "kick"
+	PermMute          Permission = "mute"
+	PermEditMap       Permission = "edit-map"
+	PermRecord        Permission = "record"
+	PermManageMembers Permission = "manage-members"
+	// PermDeleteMessages covers other people's messages; everyone may
+	// delete their own.
+	PermDeleteMessages Permission = "delete-messages"
+)
+
+// rank orders roles from least to most privileged. A role can only act on
+// members whose rank is strictly lower than its own.
+var rank = map[Role]int{
+	Guest:     1,
+	Member:    2,
+	Moderator: 3,
+	Owner:     4,
+}
+
+var rolePermissions = map[Role][]Permission{
+	Owner:     {PermChat, PermPublishMedia, PermKick, PermMute, PermEditMap, PermRecord, PermManageMembers, PermDeleteMessages},
+	Moderator: {PermChat, PermPublishMedia, PermKick, PermMute, PermEditMap, PermRecord, PermDeleteMessages},
+	Member:    {PermChat, PermPublishMedia},
+	Guest:     {PermChat},
+}
+
+func Parse(s string) (Role, bool) {
+	role := Role(s)
+	_, ok := rank[role]
+	return role, ok
+}
+
+func (r Role) Can(permission Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Role) Outranks(other Role) bool {
+	return rank[r] > rank[other]
+}
+
+func (r Role) Permissions() []Permission {
+	return rolePermissions[r]
+}
@@ -0,0 +1,1425 @@
+package store
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go-gather/http/models"
+	"go-gather/moderation"
+	"go-gather/roles"
+	"go-gather/types"
+)
+
+// memoryData is shared by the in-memory repositories so that, as in the
+// database, users and rooms see the same memberships.
+type memoryData struct {
+	lock         sync.RWMutex
+	users        map[string]models.User
+	tokens       map[string]models.UserToken
+	profiles     map[string]models.Profile
+	accessTokens map[string]models.AccessToken
+	identities   map[identityKey]string           // -> email
+	memberships  map[string]map[string]roles.Role // roomID -> email -> role
+	settings     map[string]models.RoomSettings
+	messages     map[string][]types.ChatMessage
+	nextID       int64
+
+	conversations      map[string]*memoryConversation
+	nextConversationID int64
+
+	readMarkers map[string]map[string]types.ReadMarker // roomID + channel -> userID -> marker
+	attachments map[string]models.Attachment
+
+	filters      map[string]moderation.Config
+	reviews      []models.Review
+	nextReviewID int64
+
+	webhooks   map[string]models.Webhook
+	deliveries []models.WebhookDelivery
+
+	objects map[string]types.RoomObject
+
+	invites           map[string]models.Invite
+	joinRequests      []models.JoinRequest
+	nextJoinRequestID int
+
+	bans map[string]map[string]types.Ban // roomID -> userID -> ban
+
+	audit       []types.AuditEvent
+	nextAuditID int64
+}
+
+type identityKey struct {
+	issuer  string
+	subject string
+}
+
+type memoryConversation struct {
+	models.Conversation
+	lastRead map[string]int64 // email -> ID of the last message read
+}
+
+// NewMemory returns a store that keeps everything in process memory. Data
+// is lost on restart; it exists for tests and local experiments.
+func NewMemory() *Store {
+	data := &memoryData{
+		users:        make(map[string]models.User),
+		tokens:       make(map[string]models.UserToken),
+		profiles:     make(map[string]models.Profile),
+		accessTokens: make(map[string]models.AccessToken),
+		identities:   make(map[identityKey]string),
+		memberships:  make(map[string]map[string]roles.Role),
+		settings:     make(map[string]models.RoomSettings),
+		messages:     make(map[string][]types.ChatMessage),
+
+		conversations: make(map[string]*memoryConversation),
+		readMarkers:   make(map[string]map[string]types.ReadMarker),
+		attachments:   make(map[string]models.Attachment),
+		filters:       make(map[string]moderation.Config),
+		webhooks:      make(map[string]models.Webhook),
+		objects:       make(map[string]types.RoomObject),
+		invites:       make(map[string]models.Invite),
+		bans:          make(map[string]map[string]types.Ban),
+	}
+	return &Store{
+		Users:         &memoryUsers{data},
+		Tokens:        &memoryTokens{data},
+		Profiles:      &memoryProfiles{data},
+		AccessTokens:  &memoryAccessTokens{data},
+		Rooms:         &memoryRooms{data},
+		Memberships:   &memoryMemberships{data},
+		Messages:      &memoryMessages{data},
+		ReadMarkers:   &memoryReadMarkers{data},
+		Attachments:   &memoryAttachments{data},
+		Conversations: &memoryConversations{data},
+		Moderation:    &memoryModeration{data},
+		Webhooks:      &memoryWebhooks{data},
+		Objects:       &memoryRoomObjects{data},
+		Invites:       &memoryInvites{data},
+		Bans:          &memoryBans{data},
+		Audit:         &memoryAudit{data},
+	}
+}
+
+type memoryUsers struct {
+	*memoryData
+}
+
+func (r *memoryUsers) Create(user *models.User) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, exists := r.users[user.Email]; exists {
+		return ErrConflict
+	}
+	stored := *user
+	stored.Password = ""
+	stored.Rooms = nil
+	r.users[user.Email] = stored
+	return nil
+}
+
+func (r *memoryUsers) GetByEmail(email string) (*models.User, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	user, ok := r.users[email]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	user.Rooms = r.roomsOf(email)
+	return &user, nil
+}
+
+func (r *memoryUsers) RecordFailedLogin(email string) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	user, ok := r.users[email]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	user.FailedLogins++
+	r.users[email] = user
+	return user.FailedLogins, nil
+}
+
+func (r *memoryUsers) LockUntil(email string, until time.Time) error {
+	return r.update(email, func(user *models.User) { user.LockedUntil = &until })
+}
+
+func (r *memoryUsers) ResetFailedLogins(email string) error {
+	return r.update(email, func(user *models.User) {
+		user.FailedLogins = 0
+		user.LockedUntil = nil
+	})
+}
+
+func (r *memoryUsers) SetEmailVerified(email string) error {
+	return r.update(email, func(user *models.User) { user.EmailVerified = true })
+}
+
+func (r *memoryUsers) SetPasswordHash(email, hash string) error {
+	return r.update(email, func(user *models.User) { user.PasswordHash = hash })
+}
+
+func (r *memoryUsers) GetByIdentity(issuer, subject string) (*models.User, error) {
+	r.lock.RLock()
+	email, ok := r.identities[identityKey{issuer, subject}]
+	r.lock.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return r.GetByEmail(email)
+}
+
+func (r *memoryUsers) LinkIdentity(email, issuer, subject string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.users[email]; !ok {
+		return ErrNotFound
+	}
+	key := identityKey{issuer, subject}
+	if _, linked := r.identities[key]; linked {
+		return ErrConflict
+	}
+	r.identities[key] = email
+	return nil
+}
+
+func (r *memoryUsers) ListBots(owner string) ([]models.User, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	bots := []models.User{}
+	for email, user := range r.users {
+		if user.IsBot && user.BotOwner == owner {
+			user.Rooms = r.roomsOf(email)
+			bots = append(bots, user)
+		}
+	}
+	sort.Slice(bots, func(i, j int) bool { return bots[i].Email < bots[j].Email })
+	return bots, nil
+}
+
+func (r *memoryUsers) SaveTwoFactor(email string, twoFactor models.TwoFactor) error {
+	twoFactor.RecoveryCodes = append([]string(nil), twoFactor.RecoveryCodes...)
+	return r.update(email, func(user *models.User) { user.TwoFactor = twoFactor })
+}
+
+func (r *memoryUsers) UseTOTPStep(email string, step int64) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	user, ok := r.users[email]
+	if !ok || step <= user.TwoFactor.LastStep {
+		return ErrNotFound
+	}
+	user.TwoFactor.LastStep = step
+	r.users[email] = user
+	return nil
+}
+
+func (r *memoryUsers) UseRecoveryCode(email, hash string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	user, ok := r.users[email]
+	if !ok {
+		return ErrNotFound
+	}
+	codes := user.TwoFactor.RecoveryCodes
+	for i, code := range codes {
+		if code == hash {
+			// Copy rather than splice so users handed out earlier keep
+			// their own slice.
+			remaining := append(append([]string(nil), codes[:i]...), codes[i+1:]...)
+			user.TwoFactor.RecoveryCodes = remaining
+			r.users[email] = user
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *memoryUsers) update(email string, change func(user *models.User)) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	user, ok := r.users[email]
+	if !ok {
+		return ErrNotFound
+	}
+	change(&user)
+	r.users[email] = user
+	return nil
+}
+
+func (r *memoryUsers) GetRooms(email string) ([]string, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.roomsOf(email), nil
+}
+
+// roomsOf lists the rooms the user belongs to. Callers must hold the lock.
+func (d *memoryData) roomsOf(email string) []string {
+	rooms := []string{}
+	for roomID, members := range d.memberships {
+		if _, ok := members[email]; ok {
+			rooms = append(rooms, roomID)
+		}
+	}
+	sort.Strings(rooms)
+	return rooms
+}
+
+type memoryRooms struct {
+	*memoryData
+}
+
+func (r *memoryRooms) Create(roomID, ownerEmail string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.existsLocked(roomID) {
+		return ErrConflict
+	}
+	r.memberships[roomID] = map[string]roles.Role{ownerEmail: roles.Owner}
+	return nil
+}
+
+func (r *memoryRooms) Exists(roomID string) (bool, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.existsLocked(roomID), nil
+}
+
+func (r *memoryRooms) existsLocked(roomID string) bool {
+	_, exists := r.memberships[roomID]
+	return exists
+}
+
+func (r *memoryRooms) GetSettings(roomID string) (*models.RoomSettings, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	settings, ok := r.settings[roomID]
+	if !ok {
+		settings = models.RoomSettings{RoomID: roomID}
+	}
+	return &settings, nil
+}
+
+func (r *memoryRooms) SaveSettings(settings *models.RoomSettings) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.settings[settings.RoomID] = *settings
+	return nil
+}
+
+type memoryMemberships struct {
+	*memoryData
+}
+
+func (r *memoryMemberships) Get(roomID, email string) (*models.Membership, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if role, ok := r.memberships[roomID][email]; ok {
+		return &models.Membership{RoomID: roomID, Email: email, Role: role}, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryMemberships) Save(membership *models.Membership) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.memberships[membership.RoomID] == nil {
+		r.memberships[membership.RoomID] = make(map[string]roles.Role)
+	}
+	r.memberships[membership.RoomID][membership.Email] = membership.Role
+	return nil
+}
+
+func (r *memoryMemberships) Delete(roomID, email string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, found := r.memberships[roomID][email]; !found {
+		return ErrNotFound
+	}
+	delete(r.memberships[roomID], email)
+	return nil
+}
+
+func (r *memoryMemberships) ListByRoom(roomID string) ([]models.Membership, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	members := []models.Membership{}
+	for email, role := range r.memberships[roomID] {
+		members = append(members, models.Membership{RoomID: roomID, Email: email, Role: role})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Email < members[j].Email })
+	return members, nil
+}
+
+type memoryMessages struct {
+	*memoryData
+}
+
+func (r *memoryMessages) Create(message *types.ChatMessage) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.nextID++
+	message.ID = strconv.FormatInt(r.nextID, 10)
+	message.CreatedAt = time.Now()
+	r.messages[message.RoomID] = append(r.messages[message.RoomID], copyMessage(*message))
+	return nil
+}
+
+func (r *memoryMessages) Get(id string) (*types.ChatMessage, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	message := r.findMessageLocked(id)
+	if message == nil {
+		return nil, ErrNotFound
+	}
+	copied := copyMessage(*message)
+	return &copied, nil
+}
+
+// findMessageLocked returns the stored message itself, so changes through
+// the pointer are kept. Callers must hold the lock.
+func (d *memoryData) findMessageLocked(id string) *types.ChatMessage {
+	for _, messages := range d.messages {
+		for i := range messages {
+			if messages[i].ID == id {
+				return &messages[i]
+			}
+		}
+	}
+	return nil
+}
+
+// copyMessage detaches the slices so callers cannot change the store.
+func copyMessage(message types.ChatMessage) types.ChatMessage {
+	if message.Attachments != nil {
+		message.Attachments = append([]string{}, message.Attachments...)
+	}
+	reactions := message.Reactions
+	message.Reactions = nil
+	for _, reaction := range reactions {
+		reaction.Users = append([]string{}, reaction.Users...)
+		message.Reactions = append(message.Reactions, reaction)
+	}
+	return message
+}
+
+func (r *memoryMessages) List(q MessageQuery) ([]types.ChatMessage, error) {
+	before := int64(math.MaxInt64)
+	if q.Before != "" {
+		var err error
+		if before, err = strconv.ParseInt(q.Before, 10, 64); err != nil {
+			return nil, ErrBadCursor
+		}
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	matches := func(m types.ChatMessage) bool {
+		if q.ReplyTo != "" {
+			return m.ReplyTo == q.ReplyTo
+		}
+		return m.Channel == q.Channel
+	}
+
+	// Walk back from the newest message until the page is full.
+	all := r.messages[q.RoomID]
+	page := []types.ChatMessage{}
+	for i := len(all) - 1; i >= 0 && len(page) < q.Limit; i-- {
+		id, _ := strconv.ParseInt(all[i].ID, 10, 64)
+		if matches(all[i]) && id < before {
+			page = append(page, copyMessage(all[i]))
+		}
+	}
+	for i, j := 0, len(page)-1; i < j; i, j = i+1, j-1 {
+		page[i], page[j] = page[j], page[i]
+	}
+	return page, nil
+}
+
+func (r *memoryMessages) Edit(id, body string) (*types.ChatMessage, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	message := r.findMessageLocked(id)
+	if message == nil || message.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+	now := time.Now()
+	message.Body = body
+	message.EditedAt = &now
+
+	edited := copyMessage(*message)
+	return &edited, nil
+}
+
+func (r *memoryMessages) Delete(id string) (*types.ChatMessage, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	message := r.findMessageLocked(id)
+	if message == nil || message.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+	now := time.Now()
+	message.Body = ""
+	message.Attachments = nil
+	message.Reactions = nil
+	message.DeletedAt = &now
+
+	deleted := *message
+	return &deleted, nil
+}
+
+func (r *memoryMessages) React(id, userID, emoji string, remove bool) ([]types.Reaction, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	message := r.findMessageLocked(id)
+	if message == nil || message.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+	if remove {
+		message.Reactions = types.RemoveReaction(message.Reactions, emoji, userID)
+	} else {
+		message.Reactions = types.AddReaction(message.Reactions, emoji, userID)
+	}
+	return copyMessage(*message).Reactions, nil
+}
+
+type memoryReadMarkers struct {
+	*memoryData
+}
+
+func readMarkerKey(roomID, channel string) string {
+	return roomID + "\x00" + channel
+}
+
+func (r *memoryReadMarkers) Save(marker *types.ReadMarker) error {
+	messageID, err := strconv.ParseInt(marker.MessageID, 10, 64)
+	if err != nil {
+		return ErrBadCursor
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key := readMarkerKey(marker.RoomID, marker.Channel)
+	if r.readMarkers[key] == nil {
+		r.readMarkers[key] = make(map[string]types.ReadMarker)
+	}
+
+	if stored, ok := r.readMarkers[key][marker.UserID]; ok {
+		storedID, _ := strconv.ParseInt(stored.MessageID, 10, 64)
+		if storedID >= messageID {
+			*marker = stored
+			return nil
+		}
+	}
+	marker.ReadAt = time.Now()
+	r.readMarkers[key][marker.UserID] = *marker
+	return nil
+}
+
+func (r *memoryReadMarkers) ListByChannel(roomID, channel string) ([]types.ReadMarker, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	markers := []types.ReadMarker{}
+	for _, marker := range r.readMarkers[readMarkerKey(roomID, channel)] {
+		markers = append(markers, marker)
+	}
+	sort.Slice(markers, func(i, j int) bool { return markers[i].UserID < markers[j].UserID })
+	return markers, nil
+}
+
+type memoryAttachments struct {
+	*memoryData
+}
+
+func (r *memoryAttachments) Create(attachment *models.Attachment) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, exists := r.attachments[attachment.ID]; exists {
+		return ErrConflict
+	}
+	attachment.CreatedAt = time.Now()
+	r.attachments[attachment.ID] = *attachment
+	return nil
+}
+
+func (r *memoryAttachments) Get(id string) (*models.Attachment, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	attachment, ok := r.attachments[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &attachment, nil
+}
+
+type memoryConversations struct {
+	*memoryData
+}
+
+func (r *memoryConversations) Create(conversation *models.Conversation) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if conversation.Direct {
+		if _, err := r.findDirectLocked(conversation.Participants[0], conversation.Participants[1]); err == nil {
+			return ErrConflict
+		}
+	}
+
+	r.nextConversationID++
+	conversation.ID = strconv.FormatInt(r.nextConversationID, 10)
+	conversation.CreatedAt = time.Now()
+
+	stored := *conversation
+	stored.Participants = append([]string{}, conversation.Participants...)
+	sort.Strings(stored.Participants)
+	r.conversations[stored.ID] = &memoryConversation{Conversation: stored, lastRead: make(map[string]int64)}
+	return nil
+}
+
+func (r *memoryConversations) Get(id string) (*models.Conversation, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	stored, ok := r.conversations[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	conversation := stored.Conversation
+	conversation.Participants = append([]string{}, stored.Participants...)
+	return &conversation, nil
+}
+
+func (r *memoryConversations) FindDirect(a, b string) (*models.Conversation, error) {
+	r.lock.RLock()
+	id, err := r.findDirectLocked(a, b)
+	r.lock.RUnlock()
+
+	if err != nil {
+		return nil, err
+	}
+	return r.Get(id)
+}
+
+func (r *memoryConversations) findDirectLocked(a, b string) (string, error) {
+	key := models.DirectKey(a, b)
+	for id, stored := range r.conversations {
+		if stored.Direct && models.DirectKey(stored.Participants[0], stored.Participants[1]) == key {
+			return id, nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+func (r *memoryConversations) ListByUser(email string) ([]models.Conversation, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	conversations := []models.Conversation{}
+	for _, stored := range r.conversations {
+		if !stored.HasParticipant(email) {
+			continue
+		}
+		conversation := stored.Conversation
+		conversation.Participants = append([]string{}, stored.Participants...)
+		conversation.Unread = r.unreadLocked(stored, email)
+		if messages := r.conversationMessages(stored.ID); len(messages) > 0 {
+			last := messages[len(messages)-1]
+			conversation.LastMessage = &last
+		}
+		conversations = append(conversations, conversation)
+	}
+
+	lastActive := func(c models.Conversation) time.Time {
+		if c.LastMessage != nil {
+			return c.LastMessage.CreatedAt
+		}
+		return c.CreatedAt
+	}
+	sort.Slice(conversations, func(i, j int) bool {
+		return lastActive(conversations[i]).After(lastActive(conversations[j]))
+	})
+	return conversations, nil
+}
+
+func (r *memoryConversations) UnreadCount(id, email string) (int, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	stored, ok := r.conversations[id]
+	if !ok || !stored.HasParticipant(email) {
+		return 0, nil
+	}
+	return r.unreadLocked(stored, email), nil
+}
+
+func (r *memoryConversations) MarkRead(id, email, messageID string) error {
+	readID, err := strconv.ParseInt(messageID, 10, 64)
+	if err != nil {
+		return ErrBadCursor
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	stored, ok := r.conversations[id]
+	if !ok || !stored.HasParticipant(email) {
+		return ErrNotFound
+	}
+	if readID > stored.lastRead[email] {
+		stored.lastRead[email] = readID
+	}
+	return nil
+}
+
+// conversationMessages lists a conversation's messages, oldest first.
+// Callers must hold the lock.
+func (d *memoryData) conversationMessages(id string) []types.ChatMessage {
+	channel := types.ConversationChannel(id)
+	messages := []types.ChatMessage{}
+	for _, message := range d.messages[""] {
+		if message.Channel == channel {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+func (d *memoryData) unreadLocked(stored *memoryConversation, email string) int {
+	unread := 0
+	for _, message := range d.conversationMessages(stored.ID) {
+		id, _ := strconv.ParseInt(message.ID, 10, 64)
+		if message.SenderID != email && id > stored.lastRead[email] {
+			unread++
+		}
+	}
+	return unread
+}
+
+type memoryModeration struct {
+	*memoryData
+}
+
+func (r *memoryModeration) GetFilters(roomID string) (*moderation.Config, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	config := copyFilters(r.filters[roomID])
+	return &config, nil
+}
+
+func (r *memoryModeration) SaveFilters(roomID string, config *moderation.Config) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.filters[roomID] = copyFilters(*config)
+	return nil
+}
+
+func copyFilters(config moderation.Config) moderation.Config {
+	config.Words = append([]string(nil), config.Words...)
+	config.Rules = append([]moderation.Rule(nil), config.Rules...)
+	config.AllowedDomains = append([]string(nil), config.AllowedDomains...)
+	config.BlockedDomains = append([]string(nil), config.BlockedDomains...)
+	return config
+}
+
+func (r *memoryModeration) CreateReview(review *models.Review) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.nextReviewID++
+	review.ID = strconv.FormatInt(r.nextReviewID, 10)
+	review.Status = models.ReviewPending
+	review.CreatedAt = time.Now()
+	stored := *review
+	stored.Reasons = append([]string{}, review.Reasons...)
+	r.reviews = append(r.reviews, stored)
+	return nil
+}
+
+func (r *memoryModeration) GetReview(id string) (*models.Review, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for _, review := range r.reviews {
+		if review.ID == id {
+			return &review, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryModeration) ListReviews(roomID, status string) ([]models.Review, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	reviews := []models.Review{}
+	for _, review := range r.reviews {
+		if review.RoomID == roomID && (status == "" || review.Status == status) {
+			reviews = append(reviews, review)
+		}
+	}
+	return reviews, nil
+}
+
+func (r *memoryModeration) ResolveReview(id, status, reviewer string) (*models.Review, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for i := range r.reviews {
+		review := &r.reviews[i]
+		if review.ID != id {
+			continue
+		}
+		if review.Status != models.ReviewPending {
+			return nil, ErrConflict
+		}
+		now := time.Now()
+		review.Status = status
+		review.ReviewedBy = reviewer
+		review.ReviewedAt = &now
+
+		resolved := *review
+		return &resolved, nil
+	}
+	return nil, ErrNotFound
+}
+
+type memoryTokens struct {
+	*memoryData
+}
+
+func (r *memoryTokens) Create(token *models.UserToken) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, exists := r.tokens[token.Hash]; exists {
+		return ErrConflict
+	}
+	token.CreatedAt = time.Now()
+	r.tokens[token.Hash] = *token
+	return nil
+}
+
+func (r *memoryTokens) Consume(hash, purpose string) (*models.UserToken, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	token, ok := r.tokens[hash]
+	now := time.Now()
+	if !ok || token.Purpose != purpose || token.UsedAt != nil || !now.Before(token.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	token.UsedAt = &now
+	r.tokens[hash] = token
+	return &token, nil
+}
+
+func (r *memoryTokens) DeleteByEmail(email, purpose string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for hash, token := range r.tokens {
+		if token.Email == email && token.Purpose == purpose {
+			delete(r.tokens, hash)
+		}
+	}
+	return nil
+}
+
+type memoryProfiles struct {
+	*memoryData
+}
+
+func (r *memoryProfiles) Get(email string) (*models.Profile, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	profile, ok := r.profiles[email]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &profile, nil
+}
+
+func (r *memoryProfiles) GetByID(id string) (*models.Profile, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for _, profile := range r.profiles {
+		if profile.ID == id {
+			return &profile, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryProfiles) List(emails []string) ([]models.Profile, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	profiles := []models.Profile{}
+	for _, email := range emails {
+		if profile, ok := r.profiles[email]; ok {
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles, nil
+}
+
+func (r *memoryProfiles) Create(profile *models.Profile) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, exists := r.profiles[profile.Email]; exists {
+		return ErrConflict
+	}
+	for _, other := range r.profiles {
+		if other.ID == profile.ID {
+			return ErrConflict
+		}
+	}
+	profile.UpdatedAt = time.Now()
+	r.profiles[profile.Email] = *profile
+	return nil
+}
+
+func (r *memoryProfiles) Update(profile *models.Profile) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	stored, ok := r.profiles[profile.Email]
+	if !ok {
+		return ErrNotFound
+	}
+	profile.ID = stored.ID
+	profile.UpdatedAt = time.Now()
+	r.profiles[profile.Email] = *profile
+	return nil
+}
+
+type memoryAccessTokens struct {
+	*memoryData
+}
+
+func (r *memoryAccessTokens) Create(token *models.AccessToken) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, other := range r.accessTokens {
+		if other.ID == token.ID || other.Hash == token.Hash {
+			return ErrConflict
+		}
+	}
+	token.CreatedAt = time.Now()
+	r.accessTokens[token.ID] = *token
+	return nil
+}
+
+func (r *memoryAccessTokens) Get(id string) (*models.AccessToken, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	token, ok := r.accessTokens[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &token, nil
+}
+
+func (r *memoryAccessTokens) GetByHash(hash string) (*models.AccessToken, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for _, token := range r.accessTokens {
+		if token.Hash == hash {
+			return &token, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryAccessTokens) ListByEmails(emails []string) ([]models.AccessToken, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	wanted := make(map[string]bool, len(emails))
+	for _, email := range emails {
+		wanted[email] = true
+	}
+	tokens := []models.AccessToken{}
+	for _, token := range r.accessTokens {
+		if wanted[token.Email] {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.After(tokens[j].CreatedAt) })
+	return tokens, nil
+}
+
+func (r *memoryAccessTokens) Revoke(id string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	token, ok := r.accessTokens[id]
+	if !ok || token.RevokedAt != nil {
+		return ErrNotFound
+	}
+	now := time.Now()
+	token.RevokedAt = &now
+	r.accessTokens[id] = token
+	return nil
+}
+
+func (r *memoryAccessTokens) Touch(id string, at time.Time) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	token, ok := r.accessTokens[id]
+	if !ok {
+		return ErrNotFound
+	}
+	token.LastUsedAt = &at
+	r.accessTokens[id] = token
+	return nil
+}
+
+type memoryWebhooks struct {
+	*memoryData
+}
+
+func (r *memoryWebhooks) Create(hook *models.Webhook) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, exists := r.webhooks[hook.ID]; exists {
+		return ErrConflict
+	}
+	hook.CreatedAt = time.Now()
+	stored := *hook
+	stored.Events = append([]string(nil), hook.Events...)
+	r.webhooks[hook.ID] = stored
+	return nil
+}
+
+func (r *memoryWebhooks) Get(id string) (*models.Webhook, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	hook, ok := r.webhooks[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &hook, nil
+}
+
+func (r *memoryWebhooks) ListByRoom(roomID string) ([]models.Webhook, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	hooks := []models.Webhook{}
+	for _, hook := range r.webhooks {
+		if hook.RoomID == roomID {
+			hooks = append(hooks, hook)
+		}
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].CreatedAt.Before(hooks[j].CreatedAt) })
+	return hooks, nil
+}
+
+func (r *memoryWebhooks) Delete(id string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.webhooks[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.webhooks, id)
+	kept := r.deliveries[:0]
+	for _, delivery := range r.deliveries {
+		if delivery.WebhookID != id {
+			kept = append(kept, delivery)
+		}
+	}
+	r.deliveries = kept
+	return nil
+}
+
+func (r *memoryWebhooks) CreateDelivery(delivery *models.WebhookDelivery) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.webhooks[delivery.WebhookID]; !ok {
+		return ErrNotFound
+	}
+	delivery.CreatedAt = time.Now()
+	r.deliveries = append(r.deliveries, *delivery)
+	return nil
+}
+
+func (r *memoryWebhooks) UpdateDelivery(delivery *models.WebhookDelivery) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for i := range r.deliveries {
+		if r.deliveries[i].ID == delivery.ID {
+			stored := &r.deliveries[i]
+			stored.Status = delivery.Status
+			stored.Attempts = delivery.Attempts
+			stored.StatusCode = delivery.StatusCode
+			stored.Error = delivery.Error
+			stored.LastAttemptAt = delivery.LastAttemptAt
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *memoryWebhooks) ListDeliveries(webhookID string, limit int) ([]models.WebhookDelivery, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	deliveries := []models.WebhookDelivery{}
+	for i := len(r.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if r.deliveries[i].WebhookID == webhookID {
+			deliveries = append(deliveries, r.deliveries[i])
+		}
+	}
+	return deliveries, nil
+}
+
+type memoryRoomObjects struct {
+	*memoryData
+}
+
+// copyObjectData copies through JSON, which is what the other stores do
+// anyway, so callers never share nested values with the store.
+func copyObjectData(data map[string]interface{}) map[string]interface{} {
+	copied := map[string]interface{}{}
+	raw, err := json.Marshal(data)
+	if err == nil {
+		json.Unmarshal(raw, &copied)
+	}
+	return copied
+}
+
+func copyRoomObject(object types.RoomObject) types.RoomObject {
+	object.Properties = copyObjectData(object.Properties)
+	object.State = copyObjectData(object.State)
+	return object
+}
+
+func (r *memoryRoomObjects) Create(object *types.RoomObject) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, exists := r.objects[object.ID]; exists {
+		return ErrConflict
+	}
+	object.CreatedAt = time.Now()
+	object.UpdatedAt = object.CreatedAt
+	r.objects[object.ID] = copyRoomObject(*object)
+	return nil
+}
+
+func (r *memoryRoomObjects) Get(id string) (*types.RoomObject, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	object, ok := r.objects[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	object = copyRoomObject(object)
+	return &object, nil
+}
+
+func (r *memoryRoomObjects) ListByRoom(roomID string) ([]types.RoomObject, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	objects := []types.RoomObject{}
+	for _, object := range r.objects {
+		if object.RoomID == roomID {
+			objects = append(objects, copyRoomObject(object))
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].CreatedAt.Before(objects[j].CreatedAt) })
+	return objects, nil
+}
+
+func (r *memoryRoomObjects) Update(object *types.RoomObject) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	stored, ok := r.objects[object.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.X = object.X
+	stored.Y = object.Y
+	stored.Properties = copyObjectData(object.Properties)
+	stored.UpdatedAt = time.Now()
+	object.UpdatedAt = stored.UpdatedAt
+	r.objects[object.ID] = stored
+	return nil
+}
+
+func (r *memoryRoomObjects) SaveState(object *types.RoomObject) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	stored, ok := r.objects[object.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.State = copyObjectData(object.State)
+	stored.UpdatedAt = time.Now()
+	object.UpdatedAt = stored.UpdatedAt
+	r.objects[object.ID] = stored
+	return nil
+}
+
+func (r *memoryRoomObjects) Delete(id string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.objects[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.objects, id)
+	return nil
+}
+
+type memoryInvites struct {
+	*memoryData
+}
+
+func (r *memoryInvites) Create(invite *models.Invite) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, exists := r.invites[invite.Token]; exists {
+		return ErrConflict
+	}
+	invite.CreatedAt = time.Now()
+	r.invites[invite.Token] = *invite
+	return nil
+}
+
+func (r *memoryInvites) ListByRoom(roomID string) ([]models.Invite, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	invites := []models.Invite{}
+	for _, invite := range r.invites {
+		if invite.RoomID == roomID {
+			invites = append(invites, invite)
+		}
+	}
+	sort.Slice(invites, func(i, j int) bool { return invites[i].CreatedAt.After(invites[j].CreatedAt) })
+	return invites, nil
+}
+
+func (r *memoryInvites) Delete(roomID, token string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	invite, ok := r.invites[token]
+	if !ok || invite.RoomID != roomID {
+		return ErrNotFound
+	}
+	delete(r.invites, token)
+	return nil
+}
+
+func (r *memoryInvites) Redeem(token string) (*models.Invite, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	invite, ok := r.invites[token]
+	if !ok || (invite.MaxUses > 0 && invite.Uses >= invite.MaxUses) ||
+		(invite.ExpiresAt != nil && !invite.ExpiresAt.After(time.Now())) {
+		return nil, ErrNotFound
+	}
+	invite.Uses++
+	r.invites[token] = invite
+	return &invite, nil
+}
+
+func (r *memoryInvites) CreateJoinRequest(request *models.JoinRequest) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, existing := range r.joinRequests {
+		if existing.RoomID == request.RoomID && existing.Email == request.Email && existing.Status == models.JoinRequestPending {
+			return ErrConflict
+		}
+	}
+	r.nextJoinRequestID++
+	request.ID = r.nextJoinRequestID
+	request.Status = models.JoinRequestPending
+	request.CreatedAt = time.Now()
+	r.joinRequests = append(r.joinRequests, *request)
+	return nil
+}
+
+func (r *memoryInvites) ListJoinRequests(roomID, status string) ([]models.JoinRequest, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	requests := []models.JoinRequest{}
+	for _, request := range r.joinRequests {
+		if request.RoomID == roomID && (status == "" || request.Status == status) {
+			requests = append(requests, request)
+		}
+	}
+	return requests, nil
+}
+
+func (r *memoryInvites) DecideJoinRequest(roomID string, id int, status, decidedBy string) (*models.JoinRequest, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for i := range r.joinRequests {
+		request := &r.joinRequests[i]
+		if request.ID != id || request.RoomID != roomID || request.Status != models.JoinRequestPending {
+			continue
+		}
+		now := time.Now()
+		request.Status = status
+		request.DecidedBy = &decidedBy
+		request.DecidedAt = &now
+		decided := *request
+		return &decided, nil
+	}
+	return nil, ErrNotFound
+}
+
+type memoryBans struct {
+	*memoryData
+}
+
+func (r *memoryBans) Save(ban *types.Ban) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.bans[ban.RoomID] == nil {
+		r.bans[ban.RoomID] = make(map[string]types.Ban)
+	}
+	ban.CreatedAt = time.Now()
+	r.bans[ban.RoomID][ban.UserID] = *ban
+	return nil
+}
+
+func banActive(ban types.Ban, now time.Time) bool {
+	return ban.ExpiresAt == nil || ban.ExpiresAt.After(now)
+}
+
+func (r *memoryBans) GetActive(roomID, userID string) (*types.Ban, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	ban, ok := r.bans[roomID][userID]
+	if !ok || !banActive(ban, time.Now()) {
+		return nil, ErrNotFound
+	}
+	return &ban, nil
+}
+
+func (r *memoryBans) ListActive(roomID string) ([]types.Ban, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	now := time.Now()
+	bans := []types.Ban{}
+	for _, ban := range r.bans[roomID] {
+		if banActive(ban, now) {
+			bans = append(bans, ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].CreatedAt.After(bans[j].CreatedAt) })
+	return bans, nil
+}
+
+func (r *memoryBans) Delete(roomID, userID string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.bans[roomID][userID]; !ok {
+		return ErrNotFound
+	}
+	delete(r.bans[roomID], userID)
+	return nil
+}
+
+type memoryAudit struct {
+	*memoryData
+}
+
+func (r *memoryAudit) Record(event *types.AuditEvent) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.nextAuditID++
+	event.ID = r.nextAuditID
+	event.CreatedAt = time.Now()
+	stored := *event
+	if event.Details != nil {
+		stored.Details = copyObjectData(event.Details)
+	}
+	r.audit = append(r.audit, stored)
+	return nil
+}
+
+func (r *memoryAudit) Query(filter AuditFilter) ([]types.AuditEvent, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	events := []types.AuditEvent{}
+	for i := len(r.audit) - 1; i >= 0 && len(events) < filter.Limit; i-- {
+		event := r.audit[i]
+		switch {
+		case filter.User != "" && event.Actor != filter.User && event.Target != filter.User,
+			filter.RoomID != "" && event.RoomID != filter.RoomID,
+			filter.Action != "" && event.Action != filter.Action,
+			filter.From != nil && event.CreatedAt.Before(*filter.From),
+			filter.To != nil && !event.CreatedAt.Before(*filter.To):
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
@@ -0,0 +1,171 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"go-gather/http/models"
+	"go-gather/roles"
+	"go-gather/types"
+)
+
+func TestMemoryInviteRedeem(t *testing.T) {
+	invites := NewMemory().Invites
+
+	invite := &models.Invite{Token: "single", RoomID: "r1", Role: roles.Member, CreatedBy: "owner@x", MaxUses: 1}
+	if err := invites.Create(invite); err != nil {
+		t.Fatal(err)
+	}
+	if err := invites.Create(invite); err != ErrConflict {
+		t.Fatalf("creating the same token twice: got %v, want ErrConflict", err)
+	}
+
+	redeemed, err := invites.Redeem("single")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redeemed.RoomID != "r1" || redeemed.Uses != 1 {
+		t.Fatalf("redeemed %+v", redeemed)
+	}
+	if _, err := invites.Redeem("single"); err != ErrNotFound {
+		t.Fatalf("redeeming a used-up invite: got %v, want ErrNotFound", err)
+	}
+
+	expired := time.Now().Add(-time.Minute)
+	invites.Create(&models.Invite{Token: "old", RoomID: "r1", Role: roles.Member, ExpiresAt: &expired})
+	if _, err := invites.Redeem("old"); err != ErrNotFound {
+		t.Fatalf("redeeming an expired invite: got %v, want ErrNotFound", err)
+	}
+
+	if err := invites.Delete("r2", "old"); err != ErrNotFound {
+		t.Fatalf("deleting another room's invite: got %v, want ErrNotFound", err)
+	}
+	if err := invites.Delete("r1", "old"); err != nil {
+		t.Fatal(err)
+	}
+	listed, _ := invites.ListByRoom("r1")
+	if len(listed) != 1 || listed[0].Token != "single" {
+		t.Fatalf("listed %+v", listed)
+	}
+}
+
+func TestMemoryJoinRequests(t *testing.T) {
+	invites := NewMemory().Invites
+
+	request := &models.JoinRequest{RoomID: "r1", Email: "a@x", Message: "hi"}
+	if err := invites.CreateJoinRequest(request); err != nil {
+		t.Fatal(err)
+	}
+	if request.ID == 0 || request.Status != models.JoinRequestPending {
+		t.Fatalf("created %+v", request)
+	}
+	if err := invites.CreateJoinRequest(&models.JoinRequest{RoomID: "r1", Email: "a@x"}); err != ErrConflict {
+		t.Fatalf("second pending request: got %v, want ErrConflict", err)
+	}
+
+	decided, err := invites.DecideJoinRequest("r1", request.ID, models.JoinRequestApproved, "owner@x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decided.Status != models.JoinRequestApproved || decided.DecidedBy == nil || *decided.DecidedBy != "owner@x" {
+		t.Fatalf("decided %+v", decided)
+	}
+	if _, err := invites.DecideJoinRequest("r1", request.ID, models.JoinRequestDenied, "owner@x"); err != ErrNotFound {
+		t.Fatalf("deciding twice: got %v, want ErrNotFound", err)
+	}
+
+	pending, _ := invites.ListJoinRequests("r1", models.JoinRequestPending)
+	all, _ := invites.ListJoinRequests("r1", "")
+	if len(pending) != 0 || len(all) != 1 {
+		t.Fatalf("pending %d, all %d", len(pending), len(all))
+	}
+}
+
+func TestMemoryBans(t *testing.T) {
+	bans := NewMemory().Bans
+
+	if _, err := bans.GetActive("r1", "a@x"); err != ErrNotFound {
+		t.Fatalf("never banned: got %v, want ErrNotFound", err)
+	}
+
+	expired := time.Now().Add(-time.Minute)
+	bans.Save(&types.Ban{RoomID: "r1", UserID: "a@x", BannedBy: "owner@x", ExpiresAt: &expired})
+	if _, err := bans.GetActive("r1", "a@x"); err != ErrNotFound {
+		t.Fatalf("expired ban: got %v, want ErrNotFound", err)
+	}
+
+	if err := bans.Save(&types.Ban{RoomID: "r1", UserID: "a@x", BannedBy: "owner@x", Reason: "spam"}); err != nil {
+		t.Fatal(err)
+	}
+	ban, err := bans.GetActive("r1", "a@x")
+	if err != nil || ban.Reason != "spam" {
+		t.Fatalf("replaced ban: %+v, %v", ban, err)
+	}
+	if active, _ := bans.ListActive("r1"); len(active) != 1 {
+		t.Fatalf("listed %d bans, want 1", len(active))
+	}
+
+	if err := bans.Delete("r1", "a@x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bans.Delete("r1", "a@x"); err != ErrNotFound {
+		t.Fatalf("deleting twice: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryAuditQuery(t *testing.T) {
+	audit := NewMemory().Audit
+
+	for _, event := range []types.AuditEvent{
+		{Action: types.AuditSignIn, Actor: "a@x"},
+		{Action: types.AuditRoomJoin, Actor: "a@x", RoomID: "r1"},
+		{Action: types.AuditRoleChange, Actor: "owner@x", Target: "a@x", RoomID: "r1"},
+		{Action: types.AuditSignIn, Actor: "b@x"},
+	} {
+		if err := audit.Record(&event); err != nil {
+			t.Fatal(err)
+		}
+		if event.ID == 0 || event.CreatedAt.IsZero() {
+			t.Fatalf("recorded %+v", event)
+		}
+	}
+
+	events, _ := audit.Query(AuditFilter{User: "a@x", Limit: 10})
+	if len(events) != 3 || events[0].Action != types.AuditRoleChange {
+		t.Fatalf("events of a@x: %+v", events)
+	}
+	events, _ = audit.Query(AuditFilter{RoomID: "r1", Action: types.AuditRoomJoin, Limit: 10})
+	if len(events) != 1 {
+		t.Fatalf("joins of r1: %+v", events)
+	}
+	events, _ = audit.Query(AuditFilter{Limit: 2})
+	if len(events) != 2 || events[0].Actor != "b@x" {
+		t.Fatalf("latest two: %+v", events)
+	}
+	future := time.Now().Add(time.Hour)
+	if events, _ = audit.Query(AuditFilter{From: &future, Limit: 10}); len(events) != 0 {
+		t.Fatalf("events from the future: %+v", events)
+	}
+}
+
+func TestMemoryTOTPStepsAreSingleUse(t *testing.T) {
+	users := NewMemory().Users
+	if err := users.Create(&models.User{Email: "a@x"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := users.UseTOTPStep("a@x", 100); err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []int64{100, 99} {
+		if err := users.UseTOTPStep("a@x", step); err != ErrNotFound {
+			t.Errorf("reusing step %d: got %v, want ErrNotFound", step, err)
+		}
+	}
+	if err := users.UseTOTPStep("a@x", 101); err != nil {
+		t.Fatalf("next step: %v", err)
+	}
+	if err := users.UseTOTPStep("nobody@x", 200); err != ErrNotFound {
+		t.Fatalf("unknown user: got %v, want ErrNotFound", err)
+	}
+}
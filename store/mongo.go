@@ -0,0 +1,2458 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"go-gather/db"
+	"go-gather/http/models"
+	"go-gather/moderation"
+	"go-gather/roles"
+	"go-gather/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const mongoTimeout = 10 * time.Second
+
+type mongoUser struct {
+	Email         string          `bson:"email"`
+	PasswordHash  string          `bson:"passwordHash"`
+	Rooms         []string        `bson:"rooms"`
+	IsAdmin       bool            `bson:"isAdmin"`
+	EmailVerified bool            `bson:"emailVerified,omitempty"`
+	FailedLogins  int             `bson:"failedLogins,omitempty"`
+	LockedUntil   *time.Time      `bson:"lockedUntil,omitempty"`
+	TwoFactor     *mongoTwoFactor `bson:"twoFactor,omitempty"`
+	IsBot         bool            `bson:"isBot,omitempty"`
+	BotOwner      string          `bson:"botOwner,omitempty"`
+}
+
+type mongoTwoFactor struct {
+	Secret        string   `bson:"secret"`
+	Enabled       bool     `bson:"enabled"`
+	LastStep      int64    `bson:"lastStep"`
+	RecoveryCodes []string `bson:"recoveryCodes"`
+}
+
+type mongoMembership struct {
+	RoomID    string    `bson:"roomId"`
+	Email     string    `bson:"email"`
+	Role      string    `bson:"role"`
+	CreatedAt time.Time `bson:"createdAt"`
+}
+
+type mongoRoomSettings struct {
+	RoomID      string `bson:"roomId"`
+	GuestAccess bool   `bson:"guestAccess"`
+}
+
+type mongoConversation struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	Title        string             `bson:"title"`
+	DirectKey    string             `bson:"directKey,omitempty"`
+	Participants []string           `bson:"participants"`
+	Reads        []mongoRead        `bson:"reads"`
+	CreatedBy    string             `bson:"createdBy"`
+	CreatedAt    time.Time          `bson:"createdAt"`
+}
+
+// mongoRead is kept in an array rather than a map keyed by email because
+// emails contain dots, which Mongo reads as paths in updates.
+type mongoRead struct {
+	Email    string             `bson:"email"`
+	LastRead primitive.ObjectID `bson:"lastRead"`
+}
+
+func (doc mongoConversation) model() *models.Conversation {
+	return &models.Conversation{
+		ID:           doc.ID.Hex(),
+		Title:        doc.Title,
+		Direct:       doc.DirectKey != "",
+		Participants: doc.Participants,
+		CreatedBy:    doc.CreatedBy,
+		CreatedAt:    doc.CreatedAt,
+	}
+}
+
+type mongoReadMarker struct {
+	RoomID    string             `bson:"roomId"`
+	Channel   string             `bson:"channel"`
+	UserID    string             `bson:"userId"`
+	MessageID primitive.ObjectID `bson:"messageId"`
+	ReadAt    time.Time          `bson:"readAt"`
+}
+
+type mongoMessage struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	RoomID      string             `bson:"roomId"`
+	Channel     string             `bson:"channel"`
+	SenderID    string             `bson:"senderId"`
+	SenderName  string             `bson:"senderName,omitempty"`
+	Body        string             `bson:"body"`
+	ReplyTo     string             `bson:"replyTo,omitempty"`
+	Attachments []string           `bson:"attachments,omitempty"`
+	Reactions   []mongoReaction    `bson:"reactions,omitempty"`
+	EditedAt    *time.Time         `bson:"editedAt,omitempty"`
+	DeletedAt   *time.Time         `bson:"deletedAt,omitempty"`
+	CreatedAt   time.Time          `bson:"createdAt"`
+}
+
+type mongoReaction struct {
+	Emoji  string `bson:"emoji"`
+	UserID string `bson:"userId"`
+}
+
+func (doc mongoMessage) model() types.ChatMessage {
+	message := types.ChatMessage{
+		ID:          doc.ID.Hex(),
+		RoomID:      doc.RoomID,
+		Channel:     doc.Channel,
+		SenderID:    doc.SenderID,
+		SenderName:  doc.SenderName,
+		Body:        doc.Body,
+		ReplyTo:     doc.ReplyTo,
+		Attachments: doc.Attachments,
+		EditedAt:    doc.EditedAt,
+		DeletedAt:   doc.DeletedAt,
+		CreatedAt:   doc.CreatedAt,
+	}
+	for _, reaction := range doc.Reactions {
+		message.Reactions = types.AddReaction(message.Reactions, reaction.Emoji, reaction.UserID)
+	}
+	return message
+}
+
+// NewMongo opens the collections backing the store and makes sure the
+// unique indexes the repositories rely on exist.
+func NewMongo() (*Store, error) {
+	collections := make(map[string]*mongo.Collection)
+	for _, name := range []string{"users", "room_members", "room_settings", "messages", "conversations", "read_markers", "attachments", "room_filters", "message_reviews", "user_tokens", "user_identities", "user_profiles", "access_tokens", "room_webhooks", "webhook_deliveries", "room_objects", "room_invites", "join_requests", "room_bans", "audit_log", "counters"} {
+		collection, err := db.GetCollection(name)
+		if err != nil {
+			return nil, err
+		}
+		collections[name] = collection
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	indexes := map[string]mongo.IndexModel{
+		"users": {
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		"room_members": {
+			Keys:    bson.D{{Key: "roomId", Value: 1}, {Key: "email", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		"room_settings": {
+			Keys:    bson.D{{Key: "roomId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		"messages": {
+			Keys: bson.D{{Key: "roomId", Value: 1}, {Key: "channel", Value: 1}, {Key: "_id", Value: -1}},
+		},
+		"read_markers": {
+			Keys:    bson.D{{Key: "roomId", Value: 1}, {Key: "channel", Value: 1}, {Key: "userId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		"user_identities": {
+			Keys:    bson.D{{Key: "issuer", Value: 1}, {Key: "subject", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		// Mongo drops tokens on its own once they expire.
+		"user_tokens": {
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+		"user_profiles": {
+			Keys:    bson.D{{Key: "publicId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		"access_tokens": {
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		"message_reviews": {
+			Keys: bson.D{{Key: "roomId", Value: 1}, {Key: "status", Value: 1}, {Key: "_id", Value: 1}},
+		},
+		"room_webhooks": {
+			Keys: bson.D{{Key: "roomId", Value: 1}},
+		},
+		"webhook_deliveries": {
+			Keys: bson.D{{Key: "webhookId", Value: 1}, {Key: "createdAt", Value: -1}},
+		},
+		"room_objects": {
+			Keys: bson.D{{Key: "roomId", Value: 1}, {Key: "createdAt", Value: 1}},
+		},
+		"room_invites": {
+			Keys: bson.D{{Key: "roomId", Value: 1}, {Key: "createdAt", Value: -1}},
+		},
+		"join_requests": {
+			Keys: bson.D{{Key: "roomId", Value: 1}, {Key: "email", Value: 1}, {Key: "status", Value: 1}},
+		},
+		"room_bans": {
+			Keys:    bson.D{{Key: "roomId", Value: 1}, {Key: "userId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		"audit_log": {
+			Keys: bson.D{{Key: "createdAt", Value: -1}},
+		},
+		"conversations": {
+			Keys: bson.D{{Key: "directKey", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"directKey": bson.M{"$exists": true}}),
+		},
+	}
+	for name, index := range indexes {
+		if _, err := collections[name].Indexes().CreateOne(ctx, index); err != nil {
+			return nil, err
+		}
+	}
+
+	users := collections["users"]
+	members := collections["room_members"]
+	return &Store{
+		Users:        &mongoUsers{users: users, members: members, identities: collections["user_identities"]},
+		Tokens:       &mongoTokens{tokens: collections["user_tokens"]},
+		Profiles:     &mongoProfiles{profiles: collections["user_profiles"]},
+		AccessTokens: &mongoAccessTokens{tokens: collections["access_tokens"]},
+		Rooms:        &mongoRooms{users: users, members: members, settings: collections["room_settings"]},
+		Memberships:  &mongoMemberships{users: users, members: members},
+		Messages:     &mongoMessages{messages: collections["messages"]},
+		ReadMarkers:  &mongoReadMarkers{markers: collections["read_markers"]},
+		Attachments:  &mongoAttachments{attachments: collections["attachments"]},
+		Conversations: &mongoConversations{
+			conversations: collections["conversations"],
+			messages:      collections["messages"],
+		},
+		Moderation: &mongoModeration{filters: collections["room_filters"], reviews: collections["message_reviews"]},
+		Webhooks:   &mongoWebhooks{webhooks: collections["room_webhooks"], deliveries: collections["webhook_deliveries"]},
+		Objects:    &mongoRoomObjects{objects: collections["room_objects"]},
+		Invites: &mongoInvites{
+			invites:  collections["room_invites"],
+			requests: collections["join_requests"],
+			counters: collections["counters"],
+		},
+		Bans:  &mongoBans{bans: collections["room_bans"]},
+		Audit: &mongoAudit{events: collections["audit_log"], counters: collections["counters"]},
+	}, nil
+}
+
+func mongoContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), mongoTimeout)
+}
+
+type mongoUsers struct {
+	users      *mongo.Collection
+	members    *mongo.Collection
+	identities *mongo.Collection
+}
+
+// Create stores the user with an empty rooms array. Mongo has no
+// migrations, so users created before memberships existed keep their rooms
+// array and the other repositories still read it.
+func (r *mongoUsers) Create(user *models.User) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	_, err := r.users.InsertOne(ctx, mongoUser{
+		Email:        user.Email,
+		PasswordHash: user.PasswordHash,
+		Rooms:        []string{},
+		IsBot:        user.IsBot,
+		BotOwner:     user.BotOwner,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		log.Println("User creation failed", err)
+		return err
+	}
+	return nil
+}
+
+func (r *mongoUsers) GetByEmail(email string) (*models.User, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	var doc mongoUser
+	err := r.users.FindOne(ctx, bson.M{"email": email}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Printf("Error querying user %s: %v", email, err)
+		return nil, err
+	}
+	return doc.toModel(), nil
+}
+
+func (doc mongoUser) toModel() *models.User {
+	user := &models.User{
+		Email:         doc.Email,
+		PasswordHash:  doc.PasswordHash,
+		Rooms:         doc.Rooms,
+		IsAdmin:       doc.IsAdmin,
+		IsBot:         doc.IsBot,
+		BotOwner:      doc.BotOwner,
+		EmailVerified: doc.EmailVerified,
+		FailedLogins:  doc.FailedLogins,
+		LockedUntil:   doc.LockedUntil,
+	}
+	if tf := doc.TwoFactor; tf != nil {
+		user.TwoFactor = models.TwoFactor{
+			Secret:        tf.Secret,
+			Enabled:       tf.Enabled,
+			LastStep:      tf.LastStep,
+			RecoveryCodes: tf.RecoveryCodes,
+		}
+	}
+	return user
+}
+
+func (r *mongoUsers) RecordFailedLogin(email string) (int, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	var doc mongoUser
+	err := r.users.FindOneAndUpdate(ctx,
+		bson.M{"email": email},
+		bson.M{"$inc": bson.M{"failedLogins": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		log.Printf("Error counting failed login of %s: %v", email, err)
+		return 0, err
+	}
+	return doc.FailedLogins, nil
+}
+
+func (r *mongoUsers) LockUntil(email string, until time.Time) error {
+	return r.updateOne(email, bson.M{"$set": bson.M{"lockedUntil": until}})
+}
+
+func (r *mongoUsers) ResetFailedLogins(email string) error {
+	return r.updateOne(email, bson.M{"$unset": bson.M{"failedLogins": "", "lockedUntil": ""}})
+}
+
+func (r *mongoUsers) SetEmailVerified(email string) error {
+	return r.updateOne(email, bson.M{"$set": bson.M{"emailVerified": true}})
+}
+
+func (r *mongoUsers) SetPasswordHash(email, hash string) error {
+	return r.updateOne(email, bson.M{"$set": bson.M{"passwordHash": hash}})
+}
+
+type mongoIdentity struct {
+	Issuer    string    `bson:"issuer"`
+	Subject   string    `bson:"subject"`
+	Email     string    `bson:"email"`
+	CreatedAt time.Time `bson:"createdAt"`
+}
+
+func (r *mongoUsers) GetByIdentity(issuer, subject string) (*models.User, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	var doc mongoIdentity
+	err := r.identities.FindOne(ctx, bson.M{"issuer": issuer, "subject": subject}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Printf("Error querying identity %s at %s: %v", subject, issuer, err)
+		return nil, err
+	}
+	return r.GetByEmail(doc.Email)
+}
+
+func (r *mongoUsers) LinkIdentity(email, issuer, subject string) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	_, err := r.identities.InsertOne(ctx, mongoIdentity{Issuer: issuer, Subject: subject, Email: email, CreatedAt: time.Now()})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		log.Println("Linking identity failed", err)
+	}
+	return err
+}
+
+func (r *mongoUsers) ListBots(owner string) ([]models.User, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	cursor, err := r.users.Find(ctx, bson.M{"botOwner": owner}, options.Find().SetSort(bson.D{{Key: "email", Value: 1}}))
+	if err != nil {
+		log.Printf("Error listing bots of %s: %v", owner, err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	bots := []models.User{}
+	for cursor.Next(ctx) {
+		var doc mongoUser
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		bots = append(bots, *doc.toModel())
+	}
+	return bots, cursor.Err()
+}
+
+func (r *mongoUsers) SaveTwoFactor(email string, twoFactor models.TwoFactor) error {
+	codes := twoFactor.RecoveryCodes
+	if codes == nil {
+		codes = []string{}
+	}
+	return r.updateOne(email, bson.M{"$set": bson.M{"twoFactor": mongoTwoFactor{
+		Secret:        twoFactor.Secret,
+		Enabled:       twoFactor.Enabled,
+		LastStep:      twoFactor.LastStep,
+		RecoveryCodes: codes,
+	}}})
+}
+
+func (r *mongoUsers) UseTOTPStep(email string, step int64) error {
+	return r.updateWhere(bson.M{"email": email, "twoFactor.lastStep": bson.M{"$lt": step}},
+		bson.M{"$set": bson.M{"twoFactor.lastStep": step}})
+}
+
+func (r *mongoUsers) UseRecoveryCode(email, hash string) error {
+	return r.updateWhere(bson.M{"email": email, "twoFactor.recoveryCodes": hash},
+		bson.M{"$pull": bson.M{"twoFactor.recoveryCodes": hash}})
+}
+
+// updateOne applies change to the user, reporting ErrNotFound when there
+// is no such user.
+func (r *mongoUsers) updateOne(email string, change bson.M) error {
+	return r.updateWhere(bson.M{"email": email}, change)
+}
+
+// updateWhere is updateOne for a filter narrower than the email, which
+// makes the update conditional.
+func (r *mongoUsers) updateWhere(filter, change bson.M) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	result, err := r.users.UpdateOne(ctx, filter, change)
+	if err != nil {
+		log.Printf("Error updating user %v: %v", filter["email"], err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *mongoUsers) GetRooms(email string) ([]string, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	roomIDs, err := r.members.Distinct(ctx, "roomId", bson.M{"email": email})
+	if err != nil {
+		log.Printf("Error querying rooms for user %s: %v", email, err)
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	rooms := []string{}
+	for _, id := range roomIDs {
+		if room, ok := id.(string); ok && !seen[room] {
+			seen[room] = true
+			rooms = append(rooms, room)
+		}
+	}
+
+	user, err := r.GetByEmail(email)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	if user != nil {
+		for _, room := range user.Rooms {
+			if !seen[room] {
+				seen[room] = true
+				rooms = append(rooms, room)
+			}
+		}
+	}
+	return rooms, nil
+}
+
+type mongoRooms struct {
+	users    *mongo.Collection
+	members  *mongo.Collection
+	settings *mongo.Collection
+}
+
+func (r *mongoRooms) Create(roomID, ownerEmail string) error {
+	exists, err := r.Exists(roomID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrConflict
+	}
+
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	_, err = r.members.InsertOne(ctx, mongoMembership{
+		RoomID:    roomID,
+		Email:     ownerEmail,
+		Role:      string(roles.Owner),
+		CreatedAt: time.Now(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrConflict
+	}
+	return err
+}
+
+func (r *mongoRooms) Exists(roomID string) (bool, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	count, err := r.members.CountDocuments(ctx, bson.M{"roomId": roomID}, options.Count().SetLimit(1))
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+	count, err = r.users.CountDocuments(ctx, bson.M{"rooms": roomID}, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+func (r *mongoRooms) GetSettings(roomID string) (*models.RoomSettings, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	var doc mongoRoomSettings
+	err := r.settings.FindOne(ctx, bson.M{"roomId": roomID}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &models.RoomSettings{RoomID: roomID}, nil
+	}
+	if err != nil {
+		log.Printf("Error loading settings of room %s: %v", roomID, err)
+		return nil, err
+	}
+	return &models.RoomSettings{RoomID: roomID, GuestAccess: doc.GuestAccess}, nil
+}
+
+func (r *mongoRooms) SaveSettings(settings *models.RoomSettings) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	_, err := r.settings.ReplaceOne(ctx,
+		bson.M{"roomId": settings.RoomID},
+		mongoRoomSettings{RoomID: settings.RoomID, GuestAccess: settings.GuestAccess},
+		options.Replace().SetUpsert(true))
+	return err
+}
+
+type mongoMemberships struct {
+	users   *mongo.Collection
+	members *mongo.Collection
+}
+
+func (r *mongoMemberships) Get(roomID, email string) (*models.Membership, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	var doc mongoMembership
+	err := r.members.FindOne(ctx, bson.M{"roomId": roomID, "email": email}).Decode(&doc)
+	if err == nil {
+		return &models.Membership{RoomID: roomID, Email: email, Role: roles.Role(doc.Role)}, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Printf("Error querying membership of %s in room %s: %v", email, roomID, err)
+		return nil, err
+	}
+
+	count, err := r.users.CountDocuments(ctx, bson.M{"email": email, "rooms": roomID})
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return &models.Membership{RoomID: roomID, Email: email, Role: roles.Member}, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (r *mongoMemberships) Save(membership *models.Membership) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	_, err := r.members.UpdateOne(ctx,
+		bson.M{"roomId": membership.RoomID, "email": membership.Email},
+		bson.M{
+			"$set":         bson.M{"role": string(membership.Role)},
+			"$setOnInsert": bson.M{"createdAt": time.Now()},
+		},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		log.Println("Saving membership failed", err)
+	}
+	return err
+}
+
+func (r *mongoMemberships) Delete(roomID, email string) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	removed, err := r.members.DeleteOne(ctx, bson.M{"roomId": roomID, "email": email})
+	if err != nil {
+		return err
+	}
+	revoked, err := r.users.UpdateOne(ctx, bson.M{"email": email, "rooms": roomID}, bson.M{"$pull": bson.M{"rooms": roomID}})
+	if err != nil {
+		return err
+	}
+	if removed.DeletedCount == 0 && revoked.ModifiedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *mongoMemberships) ListByRoom(roomID string) ([]models.Membership, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	cursor, err := r.members.Find(ctx, bson.M{"roomId": roomID}, options.Find().SetSort(bson.D{{Key: "email", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var docs []mongoMembership
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	members := []models.Membership{}
+	seen := make(map[string]bool)
+	for _, doc := range docs {
+		seen[doc.Email] = true
+		members = append(members, models.Membership{RoomID: roomID, Email: doc.Email, Role: roles.Role(doc.Role)})
+	}
+
+	emails, err := r.users.Distinct(ctx, "email", bson.M{"rooms": roomID})
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range emails {
+		if email, ok := e.(string); ok && !seen[email] {
+			members = append(members, models.Membership{RoomID: roomID, Email: email, Role: roles.Member})
+		}
+	}
+	return members, nil
+}
+
+type mongoMessages struct {
+	messages *mongo.Collection
+}
+
+func (r *mongoMessages) Create(message *types.ChatMessage) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	doc := mongoMessage{
+		ID:          primitive.NewObjectID(),
+		RoomID:      message.RoomID,
+		Channel:     message.Channel,
+		SenderID:    message.SenderID,
+		SenderName:  message.SenderName,
+		Body:        message.Body,
+		ReplyTo:     message.ReplyTo,
+		Attachments: message.Attachments,
+		CreatedAt:   time.Now(),
+	}
+	if _, err := r.messages.InsertOne(ctx, doc); err != nil {
+		log.Println("Saving message failed", err)
+		return err
+	}
+	message.ID = doc.ID.Hex()
+	message.CreatedAt = doc.CreatedAt
+	return nil
+}
+
+func (r *mongoMessages) Get(id string) (*types.ChatMessage, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	var doc mongoMessage
+	err = r.messages.FindOne(ctx, bson.M{"_id": objectID}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Printf("Error loading message %s: %v", id, err)
+		return nil, err
+	}
+	message := doc.model()
+	return &message, nil
+}
+
+func (r *mongoMessages) List(q MessageQuery) ([]types.ChatMessage, error) {
+	filter := bson.M{"roomId": q.RoomID, "channel": q.Channel}
+	if q.ReplyTo != "" {
+		filter = bson.M{"roomId": q.RoomID, "replyTo": q.ReplyTo}
+	} else if q.Channel == types.RoomChannel {
+		// Messages stored before channels existed have no channel field.
+		filter["channel"] = bson.M{"$in": bson.A{q.Channel, nil}}
+	}
+	if q.Before != "" {
+		before, err := primitive.ObjectIDFromHex(q.Before)
+		if err != nil {
+			return nil, ErrBadCursor
+		}
+		filter["_id"] = bson.M{"$lt": before}
+	}
+
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(q.Limit))
+	cursor, err := r.messages.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	var docs []mongoMessage
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	messages := make([]types.ChatMessage, len(docs))
+	for i, doc := range docs {
+		// Newest came first from the query; hand them back oldest first.
+		messages[len(docs)-1-i] = doc.model()
+	}
+	return messages, nil
+}
+
+// update applies change to a message that is not deleted and returns the
+// message as it is afterwards.
+func (r *mongoMessages) update(id string, change bson.M) (*types.ChatMessage, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	var doc mongoMessage
+	err = r.messages.FindOneAndUpdate(ctx,
+		bson.M{"_id": objectID, "deletedAt": bson.M{"$exists": false}},
+		change,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Printf("Updating message %s failed: %v", id, err)
+		return nil, err
+	}
+	message := doc.model()
+	return &message, nil
+}
+
+func (r *mongoMessages) Edit(id, body string) (*types.ChatMessage, error) {
+	return r.update(id, bson.M{"$set": bson.M{"body": body, "editedAt": time.Now()}})
+}
+
+func (r *mongoMessages) Delete(id string) (*types.ChatMessage, error) {
+	return r.update(id, bson.M{
+		"$set":   bson.M{"body": "", "deletedAt": time.Now()},
+		"$unset": bson.M{"reactions": "", "attachments": ""},
+	})
+}
+
+func (r *mongoMessages) React(id, userID, emoji string, remove bool) ([]types.Reaction, error) {
+	reaction := mongoReaction{Emoji: emoji, UserID: userID}
+	change := bson.M{"$addToSet": bson.M{"reactions": reaction}}
+	if remove {
+		change = bson.M{"$pull": bson.M{"reactions": reaction}}
+	}
+
+	message, err := r.update(id, change)
+	if err != nil {
+		return nil, err
+	}
+	return message.Reactions, nil
+}
+
+type mongoAttachment struct {
+	ID           string    `bson:"_id"`
+	RoomID       string    `bson:"roomId"`
+	UploaderID   string    `bson:"uploaderId"`
+	FileName     string    `bson:"fileName"`
+	ContentType  string    `bson:"contentType"`
+	Size         int64     `bson:"size"`
+	Width        int       `bson:"width,omitempty"`
+	Height       int       `bson:"height,omitempty"`
+	BlobKey      string    `bson:"blobKey"`
+	ThumbnailKey string    `bson:"thumbnailKey,omitempty"`
+	CreatedAt    time.Time `bson:"createdAt"`
+}
+
+type mongoAttachments struct {
+	attachments *mongo.Collection
+}
+
+func (r *mongoAttachments) Create(a *models.Attachment) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	a.CreatedAt = time.Now()
+	_, err := r.attachments.InsertOne(ctx, mongoAttachment{
+		ID:           a.ID,
+		RoomID:       a.RoomID,
+		UploaderID:   a.UploaderID,
+		FileName:     a.FileName,
+		ContentType:  a.ContentType,
+		Size:         a.Size,
+		Width:        a.Width,
+		Height:       a.Height,
+		BlobKey:      a.BlobKey,
+		ThumbnailKey: a.ThumbnailKey,
+		CreatedAt:    a.CreatedAt,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		log.Println("Saving attachment failed", err)
+	}
+	return err
+}
+
+func (r *mongoAttachments) Get(id string) (*models.Attachment, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	var doc mongoAttachment
+	err := r.attachments.FindOne(ctx, bson.M{"_id": id}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Printf("Error loading attachment %s: %v", id, err)
+		return nil, err
+	}
+	return &models.Attachment{
+		ID:           doc.ID,
+		RoomID:       doc.RoomID,
+		UploaderID:   doc.UploaderID,
+		FileName:     doc.FileName,
+		ContentType:  doc.ContentType,
+		Size:         doc.Size,
+		Width:        doc.Width,
+		Height:       doc.Height,
+		BlobKey:      doc.BlobKey,
+		ThumbnailKey: doc.ThumbnailKey,
+		HasThumbnail: doc.ThumbnailKey != "",
+		CreatedAt:    doc.CreatedAt,
+	}, nil
+}
+
+type mongoReadMarkers struct {
+	markers *mongo.Collection
+}
+
+func (r *mongoReadMarkers) Save(marker *types.ReadMarker) error {
+	messageID, err := primitive.ObjectIDFromHex(marker.MessageID)
+	if err != nil {
+		return ErrBadCursor
+	}
+
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	key := bson.M{"roomId": marker.RoomID, "channel": marker.Channel, "userId": marker.UserID}
+	filter := bson.M{"roomId": marker.RoomID, "channel": marker.Channel, "userId": marker.UserID, "messageId": bson.M{"$lt": messageID}}
+
+	// When a later marker is stored the filter misses, the upsert collides
+	// with the unique index, and the stored marker is what we report.
+	var doc mongoReadMarker
+	err = r.markers.FindOneAndUpdate(ctx, filter,
+		bson.M{"$set": bson.M{"messageId": messageID, "readAt": time.Now()}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&doc)
+	if mongo.IsDuplicateKeyError(err) {
+		err = r.markers.FindOne(ctx, key).Decode(&doc)
+	}
+	if err != nil {
+		log.Println("Saving read marker failed", err)
+		return err
+	}
+
+	marker.MessageID = doc.MessageID.Hex()
+	marker.ReadAt = doc.ReadAt
+	return nil
+}
+
+func (r *mongoReadMarkers) ListByChannel(roomID, channel string) ([]types.ReadMarker, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	cursor, err := r.markers.Find(ctx, bson.M{"roomId": roomID, "channel": channel},
+		options.Find().SetSort(bson.D{{Key: "userId", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var docs []mongoReadMarker
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	markers := []types.ReadMarker{}
+	for _, doc := range docs {
+		markers = append(markers, types.ReadMarker{
+			RoomID:    doc.RoomID,
+			Channel:   doc.Channel,
+			UserID:    doc.UserID,
+			MessageID: doc.MessageID.Hex(),
+			ReadAt:    doc.ReadAt,
+		})
+	}
+	return markers, nil
+}
+
+type mongoConversations struct {
+	conversations *mongo.Collection
+	messages      *mongo.Collection
+}
+
+func (r *mongoConversations) Create(conversation *models.Conversation) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	doc := mongoConversation{
+		ID:           primitive.NewObjectID(),
+		Title:        conversation.Title,
+		Participants: conversation.Participants,
+		CreatedBy:    conversation.CreatedBy,
+		CreatedAt:    time.Now(),
+	}
+	if conversation.Direct {
+		doc.DirectKey = models.DirectKey(conversation.Participants[0], conversation.Participants[1])
+	}
+	for _, email := range conversation.Participants {
+		doc.Reads = append(doc.Reads, mongoRead{Email: email})
+	}
+
+	_, err := r.conversations.InsertOne(ctx, doc)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		log.Println("Conversation creation failed", err)
+		return err
+	}
+	conversation.ID = doc.ID.Hex()
+	conversation.CreatedAt = doc.CreatedAt
+	return nil
+}
+
+func (r *mongoConversations) findOne(filter bson.M) (*mongoConversation, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	var doc mongoConversation
+	err := r.conversations.FindOne(ctx, filter).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func (r *mongoConversations) Get(id string) (*models.Conversation, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	doc, err := r.findOne(bson.M{"_id": objectID})
+	if err != nil {
+		return nil, err
+	}
+	return doc.model(), nil
+}
+
+func (r *mongoConversations) FindDirect(a, b string) (*models.Conversation, error) {
+	doc, err := r.findOne(bson.M{"directKey": models.DirectKey(a, b)})
+	if err != nil {
+		return nil, err
+	}
+	return doc.model(), nil
+}
+
+func (r *mongoConversations) ListByUser(email string) ([]models.Conversation, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	cursor, err := r.conversations.Find(ctx, bson.M{"participants": email})
+	if err != nil {
+		log.Printf("Error listing conversations of %s: %v", email, err)
+		return nil, err
+	}
+	var docs []mongoConversation
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	conversations := []models.Conversation{}
+	for _, doc := range docs {
+		conversation := doc.model()
+
+		if conversation.Unread, err = r.unread(ctx, &doc, email); err != nil {
+			return nil, err
+		}
+
+		var last mongoMessage
+		err := r.messages.FindOne(ctx,
+			bson.M{"roomId": "", "channel": types.ConversationChannel(conversation.ID)},
+			options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}})).Decode(&last)
+		if err == nil {
+			message := last.model()
+			conversation.LastMessage = &message
+		} else if !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, err
+		}
+
+		conversations = append(conversations, *conversation)
+	}
+
+	lastActive := func(c models.Conversation) time.Time {
+		if c.LastMessage != nil {
+			return c.LastMessage.CreatedAt
+		}
+		return c.CreatedAt
+	}
+	sort.Slice(conversations, func(i, j int) bool {
+		return lastActive(conversations[i]).After(lastActive(conversations[j]))
+	})
+	return conversations, nil
+}
+
+func (r *mongoConversations) unread(ctx context.Context, doc *mongoConversation, email string) (int, error) {
+	var lastRead primitive.ObjectID
+	for _, read := range doc.Reads {
+		if read.Email == email {
+			lastRead = read.LastRead
+		}
+	}
+
+	count, err := r.messages.CountDocuments(ctx, bson.M{
+		"roomId":   "",
+		"channel":  types.ConversationChannel(doc.ID.Hex()),
+		"senderId": bson.M{"$ne": email},
+		"_id":      bson.M{"$gt": lastRead},
+	})
+	return int(count), err
+}
+
+func (r *mongoConversations) UnreadCount(id, email string) (int, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return 0, ErrNotFound
+	}
+	doc, err := r.findOne(bson.M{"_id": objectID, "participants": email})
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := mongoContext()
+	defer cancel()
+	return r.unread(ctx, doc, email)
+}
+
+func (r *mongoConversations) MarkRead(id, email, messageID string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrNotFound
+	}
+	readID, err := primitive.ObjectIDFromHex(messageID)
+	if err != nil {
+		return ErrBadCursor
+	}
+
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	result, err := r.conversations.UpdateOne(ctx,
+		bson.M{"_id": objectID, "reads.email": email},
+		bson.M{"$max": bson.M{"reads.$.lastRead": readID}})
+	if err != nil {
+		log.Println("Marking conversation read failed", err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type mongoFilters struct {
+	RoomID string            `bson:"_id"`
+	Config moderation.Config `bson:"config"`
+}
+
+type mongoReview struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	RoomID     string             `bson:"roomId"`
+	MessageID  string             `bson:"messageId"`
+	SenderID   string             `bson:"senderId"`
+	Body       string             `bson:"body"`
+	Reasons    []string           `bson:"reasons"`
+	Status     string             `bson:"status"`
+	ReviewedBy string             `bson:"reviewedBy,omitempty"`
+	ReviewedAt *time.Time         `bson:"reviewedAt,omitempty"`
+	CreatedAt  time.Time          `bson:"createdAt"`
+}
+
+func (doc mongoReview) model() models.Review {
+	return models.Review{
+		ID:         doc.ID.Hex(),
+		RoomID:     doc.RoomID,
+		MessageID:  doc.MessageID,
+		SenderID:   doc.SenderID,
+		Body:       doc.Body,
+		Reasons:    doc.Reasons,
+		Status:     doc.Status,
+		ReviewedBy: doc.ReviewedBy,
+		ReviewedAt: doc.ReviewedAt,
+		CreatedAt:  doc.CreatedAt,
+	}
+}
+
+type mongoModeration struct {
+	filters *mongo.Collection
+	reviews *mongo.Collection
+}
+
+func (r *mongoModeration) GetFilters(roomID string) (*moderation.Config, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	var doc mongoFilters
+	err := r.filters.FindOne(ctx, bson.M{"_id": roomID}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &moderation.Config{}, nil
+	}
+	if err != nil {
+		log.Printf("Error loading filters of room %s: %v", roomID, err)
+		return nil, err
+	}
+	return &doc.Config, nil
+}
+
+func (r *mongoModeration) SaveFilters(roomID string, config *moderation.Config) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	_, err := r.filters.ReplaceOne(ctx, bson.M{"_id": roomID}, mongoFilters{RoomID: roomID, Config: *config},
+		options.Replace().SetUpsert(true))
+	if err != nil {
+		log.Println("Saving room filters failed", err)
+	}
+	return err
+}
+
+func (r *mongoModeration) CreateReview(review *models.Review) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	review.Status = models.ReviewPending
+	review.CreatedAt = time.Now()
+	result, err := r.reviews.InsertOne(ctx, mongoReview{
+		RoomID:    review.RoomID,
+		MessageID: review.MessageID,
+		SenderID:  review.SenderID,
+		Body:      review.Body,
+		Reasons:   review.Reasons,
+		Status:    review.Status,
+		CreatedAt: review.CreatedAt,
+	})
+	if err != nil {
+		log.Println("Saving review failed", err)
+		return err
+	}
+	review.ID = result.InsertedID.(primitive.ObjectID).Hex()
+	return nil
+}
+
+func (r *mongoModeration) GetReview(id string) (*models.Review, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	var doc mongoReview
+	err = r.reviews.FindOne(ctx, bson.M{"_id": objectID}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Printf("Error loading review %s: %v", id, err)
+		return nil, err
+	}
+	review := doc.model()
+	return &review, nil
+}
+
+func (r *mongoModeration) ListReviews(roomID, status string) ([]models.Review, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	filter := bson.M{"roomId": roomID}
+	if status != "" {
+		filter["status"] = status
+	}
+	cursor, err := r.reviews.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		log.Printf("Error listing reviews of room %s: %v", roomID, err)
+		return nil, err
+	}
+
+	var docs []mongoReview
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	reviews := make([]models.Review, len(docs))
+	for i, doc := range docs {
+		reviews[i] = doc.model()
+	}
+	return reviews, nil
+}
+
+func (r *mongoModeration) ResolveReview(id, status, reviewer string) (*models.Review, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	var doc mongoReview
+	err = r.reviews.FindOneAndUpdate(ctx,
+		bson.M{"_id": objectID, "status": models.ReviewPending},
+		bson.M{"$set": bson.M{"status": status, "reviewedBy": reviewer, "reviewedAt": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Tell a missing review apart from one somebody else resolved.
+		if _, err := r.GetReview(id); err != nil {
+			return nil, err
+		}
+		return nil, ErrConflict
+	}
+	if err != nil {
+		log.Printf("Error resolving review %s: %v", id, err)
+		return nil, err
+	}
+	review := doc.model()
+	return &review, nil
+}
+
+type mongoToken struct {
+	Hash      string     `bson:"_id"`
+	Email     string     `bson:"email"`
+	Purpose   string     `bson:"purpose"`
+	ExpiresAt time.Time  `bson:"expiresAt"`
+	UsedAt    *time.Time `bson:"usedAt,omitempty"`
+	CreatedAt time.Time  `bson:"createdAt"`
+}
+
+type mongoTokens struct {
+	tokens *mongo.Collection
+}
+
+func (r *mongoTokens) Create(token *models.UserToken) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	token.CreatedAt = time.Now()
+	_, err := r.tokens.InsertOne(ctx, mongoToken{
+		Hash:      token.Hash,
+		Email:     token.Email,
+		Purpose:   token.Purpose,
+		ExpiresAt: token.ExpiresAt,
+		CreatedAt: token.CreatedAt,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		log.Println("Saving token failed", err)
+	}
+	return err
+}
+
+func (r *mongoTokens) Consume(hash, purpose string) (*models.UserToken, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	now := time.Now()
+	var doc mongoToken
+	err := r.tokens.FindOneAndUpdate(ctx,
+		bson.M{"_id": hash, "purpose": purpose, "usedAt": bson.M{"$exists": false}, "expiresAt": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"usedAt": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Println("Consuming token failed", err)
+		return nil, err
+	}
+	return &models.UserToken{
+		Hash:      doc.Hash,
+		Email:     doc.Email,
+		Purpose:   doc.Purpose,
+		ExpiresAt: doc.ExpiresAt,
+		UsedAt:    doc.UsedAt,
+		CreatedAt: doc.CreatedAt,
+	}, nil
+}
+
+func (r *mongoTokens) DeleteByEmail(email, purpose string) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	_, err := r.tokens.DeleteMany(ctx, bson.M{"email": email, "purpose": purpose})
+	if err != nil {
+		log.Printf("Error deleting tokens of %s: %v", email, err)
+	}
+	return err
+}
+
+type mongoProfile struct {
+	Email        string            `bson:"_id"`
+	ID           string            `bson:"publicId"`
+	DisplayName  string            `bson:"displayName"`
+	Avatar       string            `bson:"avatar,omitempty"`
+	Appearance   map[string]string `bson:"appearance,omitempty"`
+	Status       string            `bson:"status,omitempty"`
+	Availability string            `bson:"availability"`
+	Bot          bool              `bson:"bot,omitempty"`
+	UpdatedAt    time.Time         `bson:"updatedAt"`
+}
+
+func (doc mongoProfile) toModel() models.Profile {
+	profile := models.Profile{Email: doc.Email, UpdatedAt: doc.UpdatedAt}
+	profile.ID = doc.ID
+	profile.DisplayName = doc.DisplayName
+	profile.Avatar = doc.Avatar
+	profile.Appearance = doc.Appearance
+	profile.Status = doc.Status
+	profile.Availability = doc.Availability
+	profile.Bot = doc.Bot
+	return profile
+}
+
+type mongoProfiles struct {
+	profiles *mongo.Collection
+}
+
+func (r *mongoProfiles) get(filter bson.M) (*models.Profile, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	var doc mongoProfile
+	err := r.profiles.FindOne(ctx, filter).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Printf("Error querying profile %v: %v", filter, err)
+		return nil, err
+	}
+	profile := doc.toModel()
+	return &profile, nil
+}
+
+func (r *mongoProfiles) Get(email string) (*models.Profile, error) {
+	return r.get(bson.M{"_id": email})
+}
+
+func (r *mongoProfiles) GetByID(id string) (*models.Profile, error) {
+	return r.get(bson.M{"publicId": id})
+}
+
+func (r *mongoProfiles) List(emails []string) ([]models.Profile, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	cursor, err := r.profiles.Find(ctx, bson.M{"_id": bson.M{"$in": emails}})
+	if err != nil {
+		log.Println("Error listing profiles:", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	profiles := []models.Profile{}
+	for cursor.Next(ctx) {
+		var doc mongoProfile
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, doc.toModel())
+	}
+	return profiles, cursor.Err()
+}
+
+func (r *mongoProfiles) Create(profile *models.Profile) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	profile.UpdatedAt = time.Now()
+	_, err := r.profiles.InsertOne(ctx, mongoProfile{
+		Email:        profile.Email,
+		ID:           profile.ID,
+		DisplayName:  profile.DisplayName,
+		Avatar:       profile.Avatar,
+		Appearance:   profile.Appearance,
+		Status:       profile.Status,
+		Availability: profile.Availability,
+		Bot:          profile.Bot,
+		UpdatedAt:    profile.UpdatedAt,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		log.Println("Saving profile failed", err)
+	}
+	return err
+}
+
+func (r *mongoProfiles) Update(profile *models.Profile) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	profile.UpdatedAt = time.Now()
+	var doc mongoProfile
+	err := r.profiles.FindOneAndUpdate(ctx,
+		bson.M{"_id": profile.Email},
+		bson.M{"$set": bson.M{
+			"displayName":  profile.DisplayName,
+			"avatar":       profile.Avatar,
+			"appearance":   profile.Appearance,
+			"status":       profile.Status,
+			"availability": profile.Availability,
+			"updatedAt":    profile.UpdatedAt,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrNotFound
+	}
+	if err != nil {
+		log.Println("Saving profile failed", err)
+		return err
+	}
+	profile.ID = doc.ID
+	return nil
+}
+
+type mongoAccessToken struct {
+	ID         string     `bson:"_id"`
+	Hash       string     `bson:"hash"`
+	Email      string     `bson:"email"`
+	Name       string     `bson:"name"`
+	Scopes     []string   `bson:"scopes"`
+	Rooms      []string   `bson:"rooms,omitempty"`
+	CreatedBy  string     `bson:"createdBy"`
+	ExpiresAt  *time.Time `bson:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `bson:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `bson:"revokedAt,omitempty"`
+	CreatedAt  time.Time  `bson:"createdAt"`
+}
+
+func (doc mongoAccessToken) toModel() models.AccessToken {
+	return models.AccessToken{
+		ID:         doc.ID,
+		Hash:       doc.Hash,
+		Email:      doc.Email,
+		Name:       doc.Name,
+		Scopes:     doc.Scopes,
+		Rooms:      doc.Rooms,
+		CreatedBy:  doc.CreatedBy,
+		ExpiresAt:  doc.ExpiresAt,
+		LastUsedAt: doc.LastUsedAt,
+		RevokedAt:  doc.RevokedAt,
+		CreatedAt:  doc.CreatedAt,
+	}
+}
+
+type mongoAccessTokens struct {
+	tokens *mongo.Collection
+}
+
+func (r *mongoAccessTokens) Create(token *models.AccessToken) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	token.CreatedAt = time.Now()
+	_, err := r.tokens.InsertOne(ctx, mongoAccessToken{
+		ID:        token.ID,
+		Hash:      token.Hash,
+		Email:     token.Email,
+		Name:      token.Name,
+		Scopes:    token.Scopes,
+		Rooms:     token.Rooms,
+		CreatedBy: token.CreatedBy,
+		ExpiresAt: token.ExpiresAt,
+		CreatedAt: token.CreatedAt,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		log.Println("Saving access token failed", err)
+	}
+	return err
+}
+
+func (r *mongoAccessTokens) get(filter bson.M) (*models.AccessToken, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	var doc mongoAccessToken
+	err := r.tokens.FindOne(ctx, filter).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Println("Error querying access token:", err)
+		return nil, err
+	}
+	token := doc.toModel()
+	return &token, nil
+}
+
+func (r *mongoAccessTokens) Get(id string) (*models.AccessToken, error) {
+	return r.get(bson.M{"_id": id})
+}
+
+func (r *mongoAccessTokens) GetByHash(hash string) (*models.AccessToken, error) {
+	return r.get(bson.M{"hash": hash})
+}
+
+func (r *mongoAccessTokens) ListByEmails(emails []string) ([]models.AccessToken, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	cursor, err := r.tokens.Find(ctx, bson.M{"email": bson.M{"$in": emails}},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		log.Println("Error listing access tokens:", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	tokens := []models.AccessToken{}
+	for cursor.Next(ctx) {
+		var doc mongoAccessToken
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, doc.toModel())
+	}
+	return tokens, cursor.Err()
+}
+
+func (r *mongoAccessTokens) Revoke(id string) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	result, err := r.tokens.UpdateOne(ctx,
+		bson.M{"_id": id, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}})
+	if err != nil {
+		log.Println("Revoking access token failed", err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *mongoAccessTokens) Touch(id string, at time.Time) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	_, err := r.tokens.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"lastUsedAt": at}})
+	return err
+}
+
+type mongoWebhook struct {
+	ID        string    `bson:"_id"`
+	RoomID    string    `bson:"roomId"`
+	URL       string    `bson:"url"`
+	Secret    string    `bson:"secret"`
+	Events    []string  `bson:"events"`
+	CreatedBy string    `bson:"createdBy"`
+	CreatedAt time.Time `bson:"createdAt"`
+}
+
+func (doc mongoWebhook) toModel() models.Webhook {
+	return models.Webhook{
+		ID:        doc.ID,
+		RoomID:    doc.RoomID,
+		URL:       doc.URL,
+		Secret:    doc.Secret,
+		Events:    doc.Events,
+		CreatedBy: doc.CreatedBy,
+		CreatedAt: doc.CreatedAt,
+	}
+}
+
+type mongoWebhookDelivery struct {
+	ID            string     `bson:"_id"`
+	WebhookID     string     `bson:"webhookId"`
+	Event         string     `bson:"event"`
+	Payload       string     `bson:"payload"`
+	Status        string     `bson:"status"`
+	Attempts      int        `bson:"attempts"`
+	StatusCode    int        `bson:"statusCode"`
+	Error         string     `bson:"error"`
+	LastAttemptAt *time.Time `bson:"lastAttemptAt,omitempty"`
+	CreatedAt     time.Time  `bson:"createdAt"`
+}
+
+type mongoWebhooks struct {
+	webhooks   *mongo.Collection
+	deliveries *mongo.Collection
+}
+
+func (r *mongoWebhooks) Create(hook *models.Webhook) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	hook.CreatedAt = time.Now()
+	_, err := r.webhooks.InsertOne(ctx, mongoWebhook{
+		ID:        hook.ID,
+		RoomID:    hook.RoomID,
+		URL:       hook.URL,
+		Secret:    hook.Secret,
+		Events:    hook.Events,
+		CreatedBy: hook.CreatedBy,
+		CreatedAt: hook.CreatedAt,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		log.Println("Saving webhook failed", err)
+	}
+	return err
+}
+
+func (r *mongoWebhooks) Get(id string) (*models.Webhook, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	var doc mongoWebhook
+	err := r.webhooks.FindOne(ctx, bson.M{"_id": id}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Println("Error querying webhook:", err)
+		return nil, err
+	}
+	hook := doc.toModel()
+	return &hook, nil
+}
+
+func (r *mongoWebhooks) ListByRoom(roomID string) ([]models.Webhook, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	cursor, err := r.webhooks.Find(ctx, bson.M{"roomId": roomID},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		log.Println("Error listing webhooks:", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	hooks := []models.Webhook{}
+	for cursor.Next(ctx) {
+		var doc mongoWebhook
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, doc.toModel())
+	}
+	return hooks, cursor.Err()
+}
+
+func (r *mongoWebhooks) Delete(id string) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	result, err := r.webhooks.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		log.Println("Deleting webhook failed", err)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	if _, err := r.deliveries.DeleteMany(ctx, bson.M{"webhookId": id}); err != nil {
+		log.Println("Deleting webhook deliveries failed", err)
+		return err
+	}
+	return nil
+}
+
+func (r *mongoWebhooks) CreateDelivery(delivery *models.WebhookDelivery) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	delivery.CreatedAt = time.Now()
+	_, err := r.deliveries.InsertOne(ctx, mongoWebhookDelivery{
+		ID:        delivery.ID,
+		WebhookID: delivery.WebhookID,
+		Event:     delivery.Event,
+		Payload:   delivery.Payload,
+		Status:    delivery.Status,
+		CreatedAt: delivery.CreatedAt,
+	})
+	if err != nil {
+		log.Println("Saving webhook delivery failed", err)
+	}
+	return err
+}
+
+func (r *mongoWebhooks) UpdateDelivery(delivery *models.WebhookDelivery) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	result, err := r.deliveries.UpdateOne(ctx, bson.M{"_id": delivery.ID}, bson.M{"$set": bson.M{
+		"status":        delivery.Status,
+		"attempts":      delivery.Attempts,
+		"statusCode":    delivery.StatusCode,
+		"error":         delivery.Error,
+		"lastAttemptAt": delivery.LastAttemptAt,
+	}})
+	if err != nil {
+		log.Println("Updating webhook delivery failed", err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *mongoWebhooks) ListDeliveries(webhookID string, limit int) ([]models.WebhookDelivery, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := r.deliveries.Find(ctx, bson.M{"webhookId": webhookID}, opts)
+	if err != nil {
+		log.Println("Error listing webhook deliveries:", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	deliveries := []models.WebhookDelivery{}
+	for cursor.Next(ctx) {
+		var doc mongoWebhookDelivery
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, models.WebhookDelivery{
+			ID:            doc.ID,
+			WebhookID:     doc.WebhookID,
+			Event:         doc.Event,
+			Payload:       doc.Payload,
+			Status:        doc.Status,
+			Attempts:      doc.Attempts,
+			StatusCode:    doc.StatusCode,
+			Error:         doc.Error,
+			LastAttemptAt: doc.LastAttemptAt,
+			CreatedAt:     doc.CreatedAt,
+		})
+	}
+	return deliveries, cursor.Err()
+}
+
+// mongoRoomObject keeps properties and state as JSON text: documents
+// decoded into interface{} come back as BSON types that would not
+// marshal to the JSON clients sent in the first place.
+type mongoRoomObject struct {
+	ID         string    `bson:"_id"`
+	RoomID     string    `bson:"roomId"`
+	Type       string    `bson:"type"`
+	X          int       `bson:"x"`
+	Y          int       `bson:"y"`
+	Properties string    `bson:"properties"`
+	State      string    `bson:"state"`
+	CreatedBy  string    `bson:"createdBy"`
+	CreatedAt  time.Time `bson:"createdAt"`
+	UpdatedAt  time.Time `bson:"updatedAt"`
+}
+
+func (doc mongoRoomObject) toModel() (types.RoomObject, error) {
+	object := types.RoomObject{
+		ID:        doc.ID,
+		RoomID:    doc.RoomID,
+		Type:      doc.Type,
+		X:         doc.X,
+		Y:         doc.Y,
+		CreatedBy: doc.CreatedBy,
+		CreatedAt: doc.CreatedAt,
+		UpdatedAt: doc.UpdatedAt,
+	}
+	if err := json.Unmarshal([]byte(doc.Properties), &object.Properties); err != nil {
+		return object, err
+	}
+	err := json.Unmarshal([]byte(doc.State), &object.State)
+	return object, err
+}
+
+func objectText(data map[string]interface{}) (string, error) {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	raw, err := json.Marshal(data)
+	return string(raw), err
+}
+
+type mongoRoomObjects struct {
+	objects *mongo.Collection
+}
+
+func (r *mongoRoomObjects) Create(object *types.RoomObject) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	properties, err := objectText(object.Properties)
+	if err != nil {
+		return err
+	}
+	state, err := objectText(object.State)
+	if err != nil {
+		return err
+	}
+	object.CreatedAt = time.Now()
+	object.UpdatedAt = object.CreatedAt
+	_, err = r.objects.InsertOne(ctx, mongoRoomObject{
+		ID:         object.ID,
+		RoomID:     object.RoomID,
+		Type:       object.Type,
+		X:          object.X,
+		Y:          object.Y,
+		Properties: properties,
+		State:      state,
+		CreatedBy:  object.CreatedBy,
+		CreatedAt:  object.CreatedAt,
+		UpdatedAt:  object.UpdatedAt,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		log.Println("Saving room object failed", err)
+	}
+	return err
+}
+
+func (r *mongoRoomObjects) Get(id string) (*types.RoomObject, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	var doc mongoRoomObject
+	err := r.objects.FindOne(ctx, bson.M{"_id": id}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Println("Error querying room object:", err)
+		return nil, err
+	}
+	object, err := doc.toModel()
+	if err != nil {
+		return nil, err
+	}
+	return &object, nil
+}
+
+func (r *mongoRoomObjects) ListByRoom(roomID string) ([]types.RoomObject, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	cursor, err := r.objects.Find(ctx, bson.M{"roomId": roomID},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		log.Println("Error listing room objects:", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	objects := []types.RoomObject{}
+	for cursor.Next(ctx) {
+		var doc mongoRoomObject
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		object, err := doc.toModel()
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+	return objects, cursor.Err()
+}
+
+func (r *mongoRoomObjects) update(id string, fields bson.M) (time.Time, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	now := time.Now()
+	fields["updatedAt"] = now
+	result, err := r.objects.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": fields})
+	if err != nil {
+		return now, err
+	}
+	if result.MatchedCount == 0 {
+		return now, ErrNotFound
+	}
+	return now, nil
+}
+
+func (r *mongoRoomObjects) Update(object *types.RoomObject) error {
+	properties, err := objectText(object.Properties)
+	if err != nil {
+		return err
+	}
+	updatedAt, err := r.update(object.ID, bson.M{"x": object.X, "y": object.Y, "properties": properties})
+	if err == ErrNotFound {
+		return err
+	}
+	if err != nil {
+		log.Println("Updating room object failed", err)
+		return err
+	}
+	object.UpdatedAt = updatedAt
+	return nil
+}
+
+func (r *mongoRoomObjects) SaveState(object *types.RoomObject) error {
+	state, err := objectText(object.State)
+	if err != nil {
+		return err
+	}
+	updatedAt, err := r.update(object.ID, bson.M{"state": state})
+	if err == ErrNotFound {
+		return err
+	}
+	if err != nil {
+		log.Println("Saving room object state failed", err)
+		return err
+	}
+	object.UpdatedAt = updatedAt
+	return nil
+}
+
+func (r *mongoRoomObjects) Delete(id string) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	result, err := r.objects.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		log.Println("Deleting room object failed", err)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// nextSequence hands out increasing numbers for the records that have
+// integer IDs in Postgres, so that the two backends answer alike.
+func nextSequence(ctx context.Context, counters *mongo.Collection, name string) (int64, error) {
+	var doc struct {
+		Value int64 `bson:"value"`
+	}
+	err := counters.FindOneAndUpdate(ctx, bson.M{"_id": name}, bson.M{"$inc": bson.M{"value": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&doc)
+	return doc.Value, err
+}
+
+type mongoInvite struct {
+	Token     string     `bson:"_id"`
+	RoomID    string     `bson:"roomId"`
+	Role      string     `bson:"role"`
+	CreatedBy string     `bson:"createdBy"`
+	MaxUses   int        `bson:"maxUses"`
+	Uses      int        `bson:"uses"`
+	ExpiresAt *time.Time `bson:"expiresAt,omitempty"`
+	CreatedAt time.Time  `bson:"createdAt"`
+}
+
+func (doc mongoInvite) toModel() models.Invite {
+	return models.Invite{
+		Token:     doc.Token,
+		RoomID:    doc.RoomID,
+		Role:      roles.Role(doc.Role),
+		CreatedBy: doc.CreatedBy,
+		MaxUses:   doc.MaxUses,
+		Uses:      doc.Uses,
+		ExpiresAt: doc.ExpiresAt,
+		CreatedAt: doc.CreatedAt,
+	}
+}
+
+type mongoJoinRequest struct {
+	ID        int        `bson:"_id"`
+	RoomID    string     `bson:"roomId"`
+	Email     string     `bson:"email"`
+	Message   string     `bson:"message"`
+	Status    string     `bson:"status"`
+	DecidedBy *string    `bson:"decidedBy,omitempty"`
+	CreatedAt time.Time  `bson:"createdAt"`
+	DecidedAt *time.Time `bson:"decidedAt,omitempty"`
+}
+
+func (doc mongoJoinRequest) toModel() models.JoinRequest {
+	return models.JoinRequest{
+		ID:        doc.ID,
+		RoomID:    doc.RoomID,
+		Email:     doc.Email,
+		Message:   doc.Message,
+		Status:    doc.Status,
+		DecidedBy: doc.DecidedBy,
+		CreatedAt: doc.CreatedAt,
+		DecidedAt: doc.DecidedAt,
+	}
+}
+
+type mongoInvites struct {
+	invites  *mongo.Collection
+	requests *mongo.Collection
+	counters *mongo.Collection
+}
+
+func (r *mongoInvites) Create(invite *models.Invite) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	invite.CreatedAt = time.Now()
+	_, err := r.invites.InsertOne(ctx, mongoInvite{
+		Token:     invite.Token,
+		RoomID:    invite.RoomID,
+		Role:      string(invite.Role),
+		CreatedBy: invite.CreatedBy,
+		MaxUses:   invite.MaxUses,
+		ExpiresAt: invite.ExpiresAt,
+		CreatedAt: invite.CreatedAt,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		log.Println("Invite creation failed", err)
+	}
+	return err
+}
+
+func (r *mongoInvites) ListByRoom(roomID string) ([]models.Invite, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	cursor, err := r.invites.Find(ctx, bson.M{"roomId": roomID},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		log.Printf("Error listing invites of room %s: %v", roomID, err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	invites := []models.Invite{}
+	for cursor.Next(ctx) {
+		var doc mongoInvite
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		invites = append(invites, doc.toModel())
+	}
+	return invites, cursor.Err()
+}
+
+func (r *mongoInvites) Delete(roomID, token string) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	result, err := r.invites.DeleteOne(ctx, bson.M{"_id": token, "roomId": roomID})
+	if err != nil {
+		log.Println("Deleting invite failed", err)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *mongoInvites) Redeem(token string) (*models.Invite, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	filter := bson.M{
+		"_id": token,
+		"$and": bson.A{
+			bson.M{"$or": bson.A{bson.M{"maxUses": 0}, bson.M{"$expr": bson.M{"$lt": bson.A{"$uses", "$maxUses"}}}}},
+			bson.M{"$or": bson.A{bson.M{"expiresAt": bson.M{"$exists": false}}, bson.M{"expiresAt": bson.M{"$gt": time.Now()}}}},
+		},
+	}
+	var doc mongoInvite
+	err := r.invites.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"uses": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Println("Redeeming invite failed", err)
+		return nil, err
+	}
+	invite := doc.toModel()
+	return &invite, nil
+}
+
+func (r *mongoInvites) CreateJoinRequest(request *models.JoinRequest) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	pending, err := r.requests.CountDocuments(ctx, bson.M{
+		"roomId": request.RoomID,
+		"email":  request.Email,
+		"status": models.JoinRequestPending,
+	})
+	if err != nil {
+		log.Println("Checking pending join requests failed", err)
+		return err
+	}
+	if pending > 0 {
+		return ErrConflict
+	}
+
+	id, err := nextSequence(ctx, r.counters, "join_requests")
+	if err != nil {
+		log.Println("Join request creation failed", err)
+		return err
+	}
+	request.ID = int(id)
+	request.Status = models.JoinRequestPending
+	request.CreatedAt = time.Now()
+	_, err = r.requests.InsertOne(ctx, mongoJoinRequest{
+		ID:        request.ID,
+		RoomID:    request.RoomID,
+		Email:     request.Email,
+		Message:   request.Message,
+		Status:    request.Status,
+		CreatedAt: request.CreatedAt,
+	})
+	if err != nil {
+		log.Println("Join request creation failed", err)
+	}
+	return err
+}
+
+func (r *mongoInvites) ListJoinRequests(roomID, status string) ([]models.JoinRequest, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	filter := bson.M{"roomId": roomID}
+	if status != "" {
+		filter["status"] = status
+	}
+	cursor, err := r.requests.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		log.Printf("Error listing join requests of room %s: %v", roomID, err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	requests := []models.JoinRequest{}
+	for cursor.Next(ctx) {
+		var doc mongoJoinRequest
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		requests = append(requests, doc.toModel())
+	}
+	return requests, cursor.Err()
+}
+
+func (r *mongoInvites) DecideJoinRequest(roomID string, id int, status, decidedBy string) (*models.JoinRequest, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	var doc mongoJoinRequest
+	err := r.requests.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "roomId": roomID, "status": models.JoinRequestPending},
+		bson.M{"$set": bson.M{"status": status, "decidedBy": decidedBy, "decidedAt": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Println("Deciding join request failed", err)
+		return nil, err
+	}
+	request := doc.toModel()
+	return &request, nil
+}
+
+type mongoBan struct {
+	RoomID    string     `bson:"roomId"`
+	UserID    string     `bson:"userId"`
+	BannedBy  string     `bson:"bannedBy"`
+	Reason    string     `bson:"reason"`
+	ExpiresAt *time.Time `bson:"expiresAt,omitempty"`
+	CreatedAt time.Time  `bson:"createdAt"`
+}
+
+func (doc mongoBan) toModel() types.Ban {
+	return types.Ban{
+		RoomID:    doc.RoomID,
+		UserID:    doc.UserID,
+		BannedBy:  doc.BannedBy,
+		Reason:    doc.Reason,
+		ExpiresAt: doc.ExpiresAt,
+		CreatedAt: doc.CreatedAt,
+	}
+}
+
+type mongoBans struct {
+	bans *mongo.Collection
+}
+
+func activeBanFilter(roomID string) bson.M {
+	return bson.M{
+		"roomId": roomID,
+		"$or":    bson.A{bson.M{"expiresAt": bson.M{"$exists": false}}, bson.M{"expiresAt": bson.M{"$gt": time.Now()}}},
+	}
+}
+
+func (r *mongoBans) Save(ban *types.Ban) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	ban.CreatedAt = time.Now()
+	_, err := r.bans.ReplaceOne(ctx, bson.M{"roomId": ban.RoomID, "userId": ban.UserID}, mongoBan{
+		RoomID:    ban.RoomID,
+		UserID:    ban.UserID,
+		BannedBy:  ban.BannedBy,
+		Reason:    ban.Reason,
+		ExpiresAt: ban.ExpiresAt,
+		CreatedAt: ban.CreatedAt,
+	}, options.Replace().SetUpsert(true))
+	if err != nil {
+		log.Println("Saving ban failed", err)
+		return err
+	}
+
+	log.Printf("User %s banned from room %s by %s", ban.UserID, ban.RoomID, ban.BannedBy)
+	return nil
+}
+
+func (r *mongoBans) GetActive(roomID, userID string) (*types.Ban, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	filter := activeBanFilter(roomID)
+	filter["userId"] = userID
+	var doc mongoBan
+	err := r.bans.FindOne(ctx, filter).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Printf("Error querying ban of %s in room %s: %v", userID, roomID, err)
+		return nil, err
+	}
+	ban := doc.toModel()
+	return &ban, nil
+}
+
+func (r *mongoBans) ListActive(roomID string) ([]types.Ban, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	cursor, err := r.bans.Find(ctx, activeBanFilter(roomID),
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		log.Printf("Error listing bans of room %s: %v", roomID, err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	bans := []types.Ban{}
+	for cursor.Next(ctx) {
+		var doc mongoBan
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		bans = append(bans, doc.toModel())
+	}
+	return bans, cursor.Err()
+}
+
+func (r *mongoBans) Delete(roomID, userID string) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	result, err := r.bans.DeleteOne(ctx, bson.M{"roomId": roomID, "userId": userID})
+	if err != nil {
+		log.Println("Deleting ban failed", err)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// mongoAuditEvent keeps the details as JSON text, like the state of room
+// objects, since their keys are whatever the caller chose.
+type mongoAuditEvent struct {
+	ID        int64     `bson:"_id"`
+	Action    string    `bson:"action"`
+	Actor     string    `bson:"actor,omitempty"`
+	Target    string    `bson:"target,omitempty"`
+	RoomID    string    `bson:"roomId,omitempty"`
+	IP        string    `bson:"ip,omitempty"`
+	Details   string    `bson:"details,omitempty"`
+	CreatedAt time.Time `bson:"createdAt"`
+}
+
+type mongoAudit struct {
+	events   *mongo.Collection
+	counters *mongo.Collection
+}
+
+func (r *mongoAudit) Record(event *types.AuditEvent) error {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	doc := mongoAuditEvent{
+		Action: event.Action,
+		Actor:  event.Actor,
+		Target: event.Target,
+		RoomID: event.RoomID,
+		IP:     event.IP,
+	}
+	if len(event.Details) > 0 {
+		details, err := json.Marshal(event.Details)
+		if err != nil {
+			return err
+		}
+		doc.Details = string(details)
+	}
+
+	id, err := nextSequence(ctx, r.counters, "audit_log")
+	if err != nil {
+		log.Println("Recording audit event failed", err)
+		return err
+	}
+	doc.ID = id
+	doc.CreatedAt = time.Now()
+	if _, err := r.events.InsertOne(ctx, doc); err != nil {
+		log.Println("Recording audit event failed", err)
+		return err
+	}
+	event.ID = doc.ID
+	event.CreatedAt = doc.CreatedAt
+	return nil
+}
+
+func (r *mongoAudit) Query(filter AuditFilter) ([]types.AuditEvent, error) {
+	ctx, cancel := mongoContext()
+	defer cancel()
+
+	query := bson.M{}
+	if filter.User != "" {
+		query["$or"] = bson.A{bson.M{"actor": filter.User}, bson.M{"target": filter.User}}
+	}
+	if filter.RoomID != "" {
+		query["roomId"] = filter.RoomID
+	}
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+	createdAt := bson.M{}
+	if filter.From != nil {
+		createdAt["$gte"] = *filter.From
+	}
+	if filter.To != nil {
+		createdAt["$lt"] = *filter.To
+	}
+	if len(createdAt) > 0 {
+		query["createdAt"] = createdAt
+	}
+
+	cursor, err := r.events.Find(ctx, query, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(filter.Limit)))
+	if err != nil {
+		log.Println("Error querying audit log", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []types.AuditEvent{}
+	for cursor.Next(ctx) {
+		var doc mongoAuditEvent
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		event := types.AuditEvent{
+			ID:        doc.ID,
+			Action:    doc.Action,
+			Actor:     doc.Actor,
+			Target:    doc.Target,
+			RoomID:    doc.RoomID,
+			IP:        doc.IP,
+			CreatedAt: doc.CreatedAt,
+		}
+		if doc.Details != "" {
+			json.Unmarshal([]byte(doc.Details), &event.Details)
+		}
+		events = append(events, event)
+	}
+	return events, cursor.Err()
+}
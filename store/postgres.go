@@ -0,0 +1,1890 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-gather/http/models"
+	"go-gather/moderation"
+	"go-gather/roles"
+	"go-gather/types"
+
+	"github.com/lib/pq"
+)
+
+func NewPostgres(db *sql.DB) *Store {
+	return &Store{
+		Users:         &pgUsers{db: db},
+		Tokens:        &pgTokens{db: db},
+		Profiles:      &pgProfiles{db: db},
+		AccessTokens:  &pgAccessTokens{db: db},
+		Rooms:         &pgRooms{db: db},
+		Memberships:   &pgMemberships{db: db},
+		Messages:      &pgMessages{db: db},
+		ReadMarkers:   &pgReadMarkers{db: db},
+		Attachments:   &pgAttachments{db: db},
+		Conversations: &pgConversations{db: db},
+		Moderation:    &pgModeration{db: db},
+		Webhooks:      &pgWebhooks{db: db},
+		Objects:       &pgRoomObjects{db: db},
+		Invites:       &pgInvites{db: db},
+		Bans:          &pgBans{db: db},
+		Audit:         &pgAudit{db: db},
+	}
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+type pgUsers struct {
+	db *sql.DB
+}
+
+func (r *pgUsers) Create(user *models.User) error {
+	var botOwner sql.NullString
+	if user.BotOwner != "" {
+		botOwner = sql.NullString{String: user.BotOwner, Valid: true}
+	}
+	_, err := r.db.Exec(`INSERT INTO users (email, password, is_bot, bot_owner) VALUES ($1, $2, $3, $4)`,
+		user.Email, user.PasswordHash, user.IsBot, botOwner)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		log.Println("User creation failed", err)
+		return err
+	}
+
+	log.Println("User created successfully")
+	return nil
+}
+
+func (r *pgUsers) GetByEmail(email string) (*models.User, error) {
+	user := &models.User{Email: email}
+	var lockedUntil sql.NullTime
+	var recoveryCodes pq.StringArray
+	query := `SELECT password, is_admin, email_verified, failed_logins, locked_until,
+		totp_secret, totp_enabled, totp_last_step, recovery_codes, is_bot, COALESCE(bot_owner, '')
+		FROM users WHERE email = $1`
+	err := r.db.QueryRow(query, email).Scan(&user.PasswordHash, &user.IsAdmin, &user.EmailVerified,
+		&user.FailedLogins, &lockedUntil, &user.TwoFactor.Secret, &user.TwoFactor.Enabled,
+		&user.TwoFactor.LastStep, &recoveryCodes, &user.IsBot, &user.BotOwner)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Printf("Error querying user %s: %v", email, err)
+		return nil, err
+	}
+
+	if lockedUntil.Valid {
+		user.LockedUntil = &lockedUntil.Time
+	}
+	user.TwoFactor.RecoveryCodes = recoveryCodes
+
+	user.Rooms, err = r.GetRooms(email)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (r *pgUsers) RecordFailedLogin(email string) (int, error) {
+	var failures int
+	query := `UPDATE users SET failed_logins = failed_logins + 1 WHERE email = $1 RETURNING failed_logins`
+	err := r.db.QueryRow(query, email).Scan(&failures)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		log.Printf("Error counting failed login of %s: %v", email, err)
+		return 0, err
+	}
+	return failures, nil
+}
+
+func (r *pgUsers) LockUntil(email string, until time.Time) error {
+	return r.updateOne(`UPDATE users SET locked_until = $2 WHERE email = $1`, email, until)
+}
+
+func (r *pgUsers) ResetFailedLogins(email string) error {
+	return r.updateOne(`UPDATE users SET failed_logins = 0, locked_until = NULL WHERE email = $1`, email)
+}
+
+func (r *pgUsers) SetEmailVerified(email string) error {
+	return r.updateOne(`UPDATE users SET email_verified = TRUE WHERE email = $1`, email)
+}
+
+func (r *pgUsers) SetPasswordHash(email, hash string) error {
+	return r.updateOne(`UPDATE users SET password = $2 WHERE email = $1`, email, hash)
+}
+
+func (r *pgUsers) GetByIdentity(issuer, subject string) (*models.User, error) {
+	var email string
+	query := `SELECT email FROM user_identities WHERE issuer = $1 AND subject = $2`
+	err := r.db.QueryRow(query, issuer, subject).Scan(&email)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Printf("Error querying identity %s at %s: %v", subject, issuer, err)
+		return nil, err
+	}
+	return r.GetByEmail(email)
+}
+
+func (r *pgUsers) LinkIdentity(email, issuer, subject string) error {
+	query := `INSERT INTO user_identities (issuer, subject, email) VALUES ($1, $2, $3)`
+	_, err := r.db.Exec(query, issuer, subject, email)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		log.Println("Linking identity failed", err)
+	}
+	return err
+}
+
+func (r *pgUsers) ListBots(owner string) ([]models.User, error) {
+	rows, err := r.db.Query(`SELECT email FROM users WHERE bot_owner = $1 ORDER BY email`, owner)
+	if err != nil {
+		log.Printf("Error listing bots of %s: %v", owner, err)
+		return nil, err
+	}
+	var emails []string
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		emails = append(emails, email)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	bots := []models.User{}
+	for _, email := range emails {
+		bot, err := r.GetByEmail(email)
+		if err != nil {
+			return nil, err
+		}
+		bots = append(bots, *bot)
+	}
+	return bots, nil
+}
+
+func (r *pgUsers) SaveTwoFactor(email string, twoFactor models.TwoFactor) error {
+	query := `UPDATE users SET totp_secret = $2, totp_enabled = $3, totp_last_step = $4, recovery_codes = $5
+		WHERE email = $1`
+	return r.updateOne(query, email, twoFactor.Secret, twoFactor.Enabled, twoFactor.LastStep,
+		pq.Array(twoFactor.RecoveryCodes))
+}
+
+func (r *pgUsers) UseTOTPStep(email string, step int64) error {
+	return r.updateOne(`UPDATE users SET totp_last_step = $2 WHERE email = $1 AND totp_last_step < $2`, email, step)
+}
+
+func (r *pgUsers) UseRecoveryCode(email, hash string) error {
+	query := `UPDATE users SET recovery_codes = array_remove(recovery_codes, $2)
+		WHERE email = $1 AND $2 = ANY(recovery_codes)`
+	return r.updateOne(query, email, hash)
+}
+
+// updateOne runs an update of the user's row, reporting ErrNotFound when
+// there is no such user.
+func (r *pgUsers) updateOne(query, email string, args ...interface{}) error {
+	result, err := r.db.Exec(query, append([]interface{}{email}, args...)...)
+	if err != nil {
+		log.Printf("Error updating user %s: %v", email, err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *pgUsers) GetRooms(email string) ([]string, error) {
+	rows, err := r.db.Query(`SELECT room_id FROM room_members WHERE email = $1 ORDER BY room_id`, email)
+	if err != nil {
+		log.Printf("Error querying rooms for user %s: %v", email, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	rooms := []string{}
+	for rows.Next() {
+		var room string
+		if err := rows.Scan(&room); err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms, rows.Err()
+}
+
+type pgRooms struct {
+	db *sql.DB
+}
+
+func (r *pgRooms) Create(roomID, ownerEmail string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO rooms (id) VALUES ($1)`, roomID)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		log.Println("Room creation failed", err)
+		return err
+	}
+
+	query := `INSERT INTO room_members (room_id, email, role) VALUES ($1, $2, $3)`
+	if _, err := tx.Exec(query, roomID, ownerEmail, string(roles.Owner)); err != nil {
+		log.Println("Room creation failed", err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *pgRooms) Exists(roomID string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM rooms WHERE id = $1)`, roomID).Scan(&exists)
+	return exists, err
+}
+
+func (r *pgRooms) GetSettings(roomID string) (*models.RoomSettings, error) {
+	settings := &models.RoomSettings{RoomID: roomID}
+	err := r.db.QueryRow(`SELECT guest_access FROM room_settings WHERE room_id = $1`, roomID).Scan(&settings.GuestAccess)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error loading settings of room %s: %v", roomID, err)
+		return nil, err
+	}
+	return settings, nil
+}
+
+func (r *pgRooms) SaveSettings(settings *models.RoomSettings) error {
+	query := `
+	INSERT INTO room_settings (room_id, guest_access) VALUES ($1, $2)
+	ON CONFLICT (room_id) DO UPDATE SET guest_access = EXCLUDED.guest_access, updated_at = NOW()`
+	_, err := r.db.Exec(query, settings.RoomID, settings.GuestAccess)
+	if err != nil {
+		log.Println("Saving room settings failed", err)
+	}
+	return err
+}
+
+type pgMemberships struct {
+	db *sql.DB
+}
+
+func (r *pgMemberships) Get(roomID, email string) (*models.Membership, error) {
+	var role string
+	err := r.db.QueryRow(`SELECT role FROM room_members WHERE room_id = $1 AND email = $2`, roomID, email).Scan(&role)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Printf("Error querying membership of %s in room %s: %v", email, roomID, err)
+		return nil, err
+	}
+	return &models.Membership{RoomID: roomID, Email: email, Role: roles.Role(role)}, nil
+}
+
+func (r *pgMemberships) Save(membership *models.Membership) error {
+	query := `
+	INSERT INTO room_members (room_id, email, role) VALUES ($1, $2, $3)
+	ON CONFLICT (room_id, email) DO UPDATE SET role = EXCLUDED.role`
+	_, err := r.db.Exec(query, membership.RoomID, membership.Email, string(membership.Role))
+	if err != nil {
+		log.Println("Saving membership failed", err)
+		return err
+	}
+
+	log.Printf("User %s is now %s of room %s", membership.Email, membership.Role, membership.RoomID)
+	return nil
+}
+
+func (r *pgMemberships) Delete(roomID, email string) error {
+	result, err := r.db.Exec(`DELETE FROM room_members WHERE room_id = $1 AND email = $2`, roomID, email)
+	if err != nil {
+		log.Println("Deleting membership failed", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *pgMemberships) ListByRoom(roomID string) ([]models.Membership, error) {
+	rows, err := r.db.Query(`SELECT email, role FROM room_members WHERE room_id = $1 ORDER BY email`, roomID)
+	if err != nil {
+		log.Printf("Error listing members of room %s: %v", roomID, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []models.Membership{}
+	for rows.Next() {
+		m := models.Membership{RoomID: roomID}
+		var role string
+		if err := rows.Scan(&m.Email, &role); err != nil {
+			return nil, err
+		}
+		m.Role = roles.Role(role)
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+type pgMessages struct {
+	db *sql.DB
+}
+
+func (r *pgMessages) Create(message *types.ChatMessage) error {
+	var replyTo sql.NullInt64
+	if message.ReplyTo != "" {
+		id, err := strconv.ParseInt(message.ReplyTo, 10, 64)
+		if err != nil {
+			return ErrNotFound
+		}
+		replyTo = sql.NullInt64{Int64: id, Valid: true}
+	}
+
+	attachments := message.Attachments
+	if attachments == nil {
+		attachments = []string{}
+	}
+
+	query := `
+	INSERT INTO messages (room_id, channel, sender_id, sender_name, body, reply_to, attachment_ids)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id, created_at`
+
+	var id int64
+	err := r.db.QueryRow(query, message.RoomID, message.Channel, message.SenderID, message.SenderName, message.Body, replyTo,
+		pq.Array(attachments)).Scan(&id, &message.CreatedAt)
+	if err != nil {
+		log.Println("Saving message failed", err)
+		return err
+	}
+	message.ID = strconv.FormatInt(id, 10)
+	return nil
+}
+
+const messageColumns = `id, room_id, channel, sender_id, sender_name, body, reply_to, attachment_ids, edited_at, deleted_at, created_at`
+
+func scanMessage(row interface{ Scan(...interface{}) error }) (types.ChatMessage, error) {
+	var m types.ChatMessage
+	var id int64
+	var replyTo sql.NullInt64
+	var editedAt, deletedAt sql.NullTime
+	var attachments pq.StringArray
+	err := row.Scan(&id, &m.RoomID, &m.Channel, &m.SenderID, &m.SenderName, &m.Body, &replyTo, &attachments,
+		&editedAt, &deletedAt, &m.CreatedAt)
+	if err != nil {
+		return m, err
+	}
+	if len(attachments) > 0 {
+		m.Attachments = attachments
+	}
+
+	m.ID = strconv.FormatInt(id, 10)
+	if replyTo.Valid {
+		m.ReplyTo = strconv.FormatInt(replyTo.Int64, 10)
+	}
+	if editedAt.Valid {
+		m.EditedAt = &editedAt.Time
+	}
+	if deletedAt.Valid {
+		m.DeletedAt = &deletedAt.Time
+	}
+	return m, nil
+}
+
+func (r *pgMessages) Get(id string) (*types.ChatMessage, error) {
+	messageID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	message, err := scanMessage(r.db.QueryRow(`SELECT `+messageColumns+` FROM messages WHERE id = $1`, messageID))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Printf("Error loading message %s: %v", id, err)
+		return nil, err
+	}
+
+	messages := []types.ChatMessage{message}
+	if err := r.loadReactions(messages); err != nil {
+		return nil, err
+	}
+	return &messages[0], nil
+}
+
+func (r *pgMessages) List(q MessageQuery) ([]types.ChatMessage, error) {
+	before := int64(math.MaxInt64)
+	if q.Before != "" {
+		var err error
+		if before, err = strconv.ParseInt(q.Before, 10, 64); err != nil {
+			return nil, ErrBadCursor
+		}
+	}
+
+	filter, args := `channel = $2`, []interface{}{q.RoomID, q.Channel}
+	if q.ReplyTo != "" {
+		replyTo, err := strconv.ParseInt(q.ReplyTo, 10, 64)
+		if err != nil {
+			return []types.ChatMessage{}, nil
+		}
+		filter, args = `reply_to = $2`, []interface{}{q.RoomID, replyTo}
+	}
+
+	query := `
+	SELECT * FROM (
+		SELECT ` + messageColumns + ` FROM messages
+		WHERE room_id = $1 AND ` + filter + ` AND id < $3 ORDER BY id DESC LIMIT $4
+	) page ORDER BY id`
+	rows, err := r.db.Query(query, append(args, before, q.Limit)...)
+	if err != nil {
+		log.Printf("Error listing messages of room %s: %v", q.RoomID, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []types.ChatMessage{}
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := r.loadReactions(messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// loadReactions fills in the reactions of the given messages in one query.
+func (r *pgMessages) loadReactions(messages []types.ChatMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(messages))
+	byID := make(map[int64]*types.ChatMessage, len(messages))
+	for i := range messages {
+		ids[i], _ = strconv.ParseInt(messages[i].ID, 10, 64)
+		byID[ids[i]] = &messages[i]
+	}
+
+	query := `
+	SELECT message_id, emoji, user_id FROM message_reactions
+	WHERE message_id = ANY($1) ORDER BY created_at, user_id`
+	rows, err := r.db.Query(query, pq.Array(ids))
+	if err != nil {
+		log.Println("Error loading reactions", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var emoji, userID string
+		if err := rows.Scan(&id, &emoji, &userID); err != nil {
+			return err
+		}
+		message := byID[id]
+		message.Reactions = types.AddReaction(message.Reactions, emoji, userID)
+	}
+	return rows.Err()
+}
+
+func (r *pgMessages) Edit(id, body string) (*types.ChatMessage, error) {
+	messageID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	query := `
+	UPDATE messages SET body = $2, edited_at = NOW()
+	WHERE id = $1 AND deleted_at IS NULL
+	RETURNING ` + messageColumns
+	message, err := scanMessage(r.db.QueryRow(query, messageID, body))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Println("Editing message failed", err)
+		return nil, err
+	}
+
+	messages := []types.ChatMessage{message}
+	if err := r.loadReactions(messages); err != nil {
+		return nil, err
+	}
+	return &messages[0], nil
+}
+
+func (r *pgMessages) Delete(id string) (*types.ChatMessage, error) {
+	messageID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE messages SET body = '', attachment_ids = '{}', deleted_at = NOW()
+	WHERE id = $1 AND deleted_at IS NULL
+	RETURNING ` + messageColumns
+	message, err := scanMessage(tx.QueryRow(query, messageID))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Println("Deleting message failed", err)
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM message_reactions WHERE message_id = $1`, messageID); err != nil {
+		return nil, err
+	}
+	return &message, tx.Commit()
+}
+
+func (r *pgMessages) React(id, userID, emoji string, remove bool) ([]types.Reaction, error) {
+	message, err := r.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if message.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+
+	query := `INSERT INTO message_reactions (message_id, user_id, emoji) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`
+	if remove {
+		query = `DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3`
+	}
+	if _, err := r.db.Exec(query, message.ID, userID, emoji); err != nil {
+		log.Println("Saving reaction failed", err)
+		return nil, err
+	}
+
+	message, err = r.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return message.Reactions, nil
+}
+
+type pgConversations struct {
+	db *sql.DB
+}
+
+func parseConversationID(id string) (int64, bool) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	return n, err == nil
+}
+
+func (r *pgConversations) Create(conversation *models.Conversation) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var directKey sql.NullString
+	if conversation.Direct {
+		directKey = sql.NullString{String: models.DirectKey(conversation.Participants[0], conversation.Participants[1]), Valid: true}
+	}
+
+	var id int64
+	query := `
+	INSERT INTO conversations (title, direct_key, created_by) VALUES ($1, $2, $3)
+	RETURNING id, created_at`
+	err = tx.QueryRow(query, conversation.Title, directKey, conversation.CreatedBy).Scan(&id, &conversation.CreatedAt)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		log.Println("Conversation creation failed", err)
+		return err
+	}
+
+	for _, email := range conversation.Participants {
+		_, err := tx.Exec(`INSERT INTO conversation_members (conversation_id, email) VALUES ($1, $2)`, id, email)
+		if err != nil {
+			log.Println("Conversation creation failed", err)
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	conversation.ID = strconv.FormatInt(id, 10)
+	return nil
+}
+
+func (r *pgConversations) Get(id string) (*models.Conversation, error) {
+	conversationID, ok := parseConversationID(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	conversation := &models.Conversation{ID: id}
+	query := `SELECT title, direct_key IS NOT NULL, created_by, created_at FROM conversations WHERE id = $1`
+	err := r.db.QueryRow(query, conversationID).
+		Scan(&conversation.Title, &conversation.Direct, &conversation.CreatedBy, &conversation.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Printf("Error loading conversation %s: %v", id, err)
+		return nil, err
+	}
+
+	participants, err := r.participants([]int64{conversationID})
+	if err != nil {
+		return nil, err
+	}
+	conversation.Participants = participants[conversationID]
+	return conversation, nil
+}
+
+func (r *pgConversations) participants(ids []int64) (map[int64][]string, error) {
+	query := `SELECT conversation_id, email FROM conversation_members WHERE conversation_id = ANY($1) ORDER BY email`
+	rows, err := r.db.Query(query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	participants := make(map[int64][]string)
+	for rows.Next() {
+		var id int64
+		var email string
+		if err := rows.Scan(&id, &email); err != nil {
+			return nil, err
+		}
+		participants[id] = append(participants[id], email)
+	}
+	return participants, rows.Err()
+}
+
+func (r *pgConversations) FindDirect(a, b string) (*models.Conversation, error) {
+	var id int64
+	err := r.db.QueryRow(`SELECT id FROM conversations WHERE direct_key = $1`, models.DirectKey(a, b)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.Get(strconv.FormatInt(id, 10))
+}
+
+func (r *pgConversations) ListByUser(email string) ([]models.Conversation, error) {
+	query := `
+	SELECT c.id, c.title, c.direct_key IS NOT NULL, c.created_by, c.created_at,
+		(SELECT COUNT(*) FROM messages m
+		 WHERE m.room_id = '' AND m.channel = 'dm:' || c.id
+		 AND m.id > cm.last_read_id AND m.sender_id <> cm.email),
+		last.id, last.sender_id, last.body, last.created_at
+	FROM conversation_members cm
+	JOIN conversations c ON c.id = cm.conversation_id
+	LEFT JOIN LATERAL (
+		SELECT id, sender_id, body, created_at FROM messages
+		WHERE room_id = '' AND channel = 'dm:' || c.id
+		ORDER BY id DESC LIMIT 1
+	) last ON TRUE
+	WHERE cm.email = $1
+	ORDER BY COALESCE(last.created_at, c.created_at) DESC`
+	rows, err := r.db.Query(query, email)
+	if err != nil {
+		log.Printf("Error listing conversations of %s: %v", email, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	conversations := []models.Conversation{}
+	ids := []int64{}
+	for rows.Next() {
+		var c models.Conversation
+		var id int64
+		var lastID sql.NullInt64
+		var lastSender, lastBody sql.NullString
+		var lastAt sql.NullTime
+		err := rows.Scan(&id, &c.Title, &c.Direct, &c.CreatedBy, &c.CreatedAt, &c.Unread,
+			&lastID, &lastSender, &lastBody, &lastAt)
+		if err != nil {
+			return nil, err
+		}
+		c.ID = strconv.FormatInt(id, 10)
+		if lastID.Valid {
+			c.LastMessage = &types.ChatMessage{
+				ID:        strconv.FormatInt(lastID.Int64, 10),
+				Channel:   types.ConversationChannel(c.ID),
+				SenderID:  lastSender.String,
+				Body:      lastBody.String,
+				CreatedAt: lastAt.Time,
+			}
+		}
+		conversations = append(conversations, c)
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	participants, err := r.participants(ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range conversations {
+		conversations[i].Participants = participants[ids[i]]
+	}
+	return conversations, nil
+}
+
+func (r *pgConversations) UnreadCount(id, email string) (int, error) {
+	conversationID, ok := parseConversationID(id)
+	if !ok {
+		return 0, ErrNotFound
+	}
+
+	query := `
+	SELECT COUNT(*) FROM messages m
+	JOIN conversation_members cm ON cm.conversation_id = $1 AND cm.email = $2
+	WHERE m.room_id = '' AND m.channel = $3 AND m.id > cm.last_read_id AND m.sender_id <> cm.email`
+	var count int
+	err := r.db.QueryRow(query, conversationID, email, types.ConversationChannel(id)).Scan(&count)
+	return count, err
+}
+
+func (r *pgConversations) MarkRead(id, email, messageID string) error {
+	conversationID, ok := parseConversationID(id)
+	if !ok {
+		return ErrNotFound
+	}
+	readID, err := strconv.ParseInt(messageID, 10, 64)
+	if err != nil {
+		return ErrBadCursor
+	}
+
+	query := `
+	UPDATE conversation_members SET last_read_id = GREATEST(last_read_id, $3)
+	WHERE conversation_id = $1 AND email = $2`
+	result, err := r.db.Exec(query, conversationID, email, readID)
+	if err != nil {
+		log.Println("Marking conversation read failed", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type pgReadMarkers struct {
+	db *sql.DB
+}
+
+func (r *pgReadMarkers) Save(marker *types.ReadMarker) error {
+	messageID, err := strconv.ParseInt(marker.MessageID, 10, 64)
+	if err != nil {
+		return ErrBadCursor
+	}
+
+	// The WHERE keeps markers from moving backwards; when it filters the
+	// row out nothing is returned and the stored marker is read instead.
+	query := `
+	INSERT INTO read_markers (room_id, channel, user_id, message_id) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (room_id, channel, user_id) DO UPDATE
+	SET message_id = EXCLUDED.message_id, read_at = NOW()
+	WHERE read_markers.message_id < EXCLUDED.message_id
+	RETURNING message_id, read_at`
+	err = r.db.QueryRow(query, marker.RoomID, marker.Channel, marker.UserID, messageID).Scan(&messageID, &marker.ReadAt)
+	if err == sql.ErrNoRows {
+		query = `SELECT message_id, read_at FROM read_markers WHERE room_id = $1 AND channel = $2 AND user_id = $3`
+		err = r.db.QueryRow(query, marker.RoomID, marker.Channel, marker.UserID).Scan(&messageID, &marker.ReadAt)
+	}
+	if err != nil {
+		log.Println("Saving read marker failed", err)
+		return err
+	}
+	marker.MessageID = strconv.FormatInt(messageID, 10)
+	return nil
+}
+
+func (r *pgReadMarkers) ListByChannel(roomID, channel string) ([]types.ReadMarker, error) {
+	query := `
+	SELECT user_id, message_id, read_at FROM read_markers
+	WHERE room_id = $1 AND channel = $2 ORDER BY user_id`
+	rows, err := r.db.Query(query, roomID, channel)
+	if err != nil {
+		log.Printf("Error listing read markers of room %s: %v", roomID, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	markers := []types.ReadMarker{}
+	for rows.Next() {
+		marker := types.ReadMarker{RoomID: roomID, Channel: channel}
+		var messageID int64
+		if err := rows.Scan(&marker.UserID, &messageID, &marker.ReadAt); err != nil {
+			return nil, err
+		}
+		marker.MessageID = strconv.FormatInt(messageID, 10)
+		markers = append(markers, marker)
+	}
+	return markers, rows.Err()
+}
+
+type pgAttachments struct {
+	db *sql.DB
+}
+
+func (r *pgAttachments) Create(a *models.Attachment) error {
+	query := `
+	INSERT INTO attachments (id, room_id, uploader_id, file_name, content_type, size, width, height, blob_key, thumbnail_key)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	RETURNING created_at`
+	err := r.db.QueryRow(query, a.ID, a.RoomID, a.UploaderID, a.FileName, a.ContentType, a.Size, a.Width, a.Height,
+		a.BlobKey, a.ThumbnailKey).Scan(&a.CreatedAt)
+	if err != nil {
+		log.Println("Saving attachment failed", err)
+	}
+	return err
+}
+
+func (r *pgAttachments) Get(id string) (*models.Attachment, error) {
+	a := &models.Attachment{ID: id}
+	query := `
+	SELECT room_id, uploader_id, file_name, content_type, size, width, height, blob_key, thumbnail_key, created_at
+	FROM attachments WHERE id = $1`
+	err := r.db.QueryRow(query, id).Scan(&a.RoomID, &a.UploaderID, &a.FileName, &a.ContentType, &a.Size,
+		&a.Width, &a.Height, &a.BlobKey, &a.ThumbnailKey, &a.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Printf("Error loading attachment %s: %v", id, err)
+		return nil, err
+	}
+	a.HasThumbnail = a.ThumbnailKey != ""
+	return a, nil
+}
+
+type pgModeration struct {
+	db *sql.DB
+}
+
+func (r *pgModeration) GetFilters(roomID string) (*moderation.Config, error) {
+	config := &moderation.Config{}
+	var raw []byte
+	err := r.db.QueryRow(`SELECT config FROM room_filters WHERE room_id = $1`, roomID).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return config, nil
+	}
+	if err != nil {
+		log.Printf("Error loading filters of room %s: %v", roomID, err)
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (r *pgModeration) SaveFilters(roomID string, config *moderation.Config) error {
+	raw, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO room_filters (room_id, config) VALUES ($1, $2)
+	ON CONFLICT (room_id) DO UPDATE SET config = EXCLUDED.config, updated_at = NOW()`
+	if _, err := r.db.Exec(query, roomID, raw); err != nil {
+		log.Println("Saving room filters failed", err)
+		return err
+	}
+	return nil
+}
+
+func (r *pgModeration) CreateReview(review *models.Review) error {
+	messageID, err := strconv.ParseInt(review.MessageID, 10, 64)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	var id int64
+	query := `
+	INSERT INTO message_reviews (room_id, message_id, sender_id, body, reasons)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, status, created_at`
+	err = r.db.QueryRow(query, review.RoomID, messageID, review.SenderID, review.Body, pq.Array(review.Reasons)).
+		Scan(&id, &review.Status, &review.CreatedAt)
+	if err != nil {
+		log.Println("Saving review failed", err)
+		return err
+	}
+	review.ID = strconv.FormatInt(id, 10)
+	return nil
+}
+
+const reviewColumns = `id, room_id, message_id, sender_id, body, reasons, status, reviewed_by, reviewed_at, created_at`
+
+func scanReview(row interface{ Scan(...interface{}) error }) (*models.Review, error) {
+	var (
+		id, messageID int64
+		reasons       pq.StringArray
+		reviewedBy    sql.NullString
+		reviewedAt    sql.NullTime
+		review        models.Review
+	)
+	err := row.Scan(&id, &review.RoomID, &messageID, &review.SenderID, &review.Body, &reasons, &review.Status,
+		&reviewedBy, &reviewedAt, &review.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	review.ID = strconv.FormatInt(id, 10)
+	review.MessageID = strconv.FormatInt(messageID, 10)
+	review.Reasons = []string(reasons)
+	review.ReviewedBy = reviewedBy.String
+	if reviewedAt.Valid {
+		review.ReviewedAt = &reviewedAt.Time
+	}
+	return &review, nil
+}
+
+func (r *pgModeration) GetReview(id string) (*models.Review, error) {
+	reviewID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	review, err := scanReview(r.db.QueryRow(`SELECT `+reviewColumns+` FROM message_reviews WHERE id = $1`, reviewID))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Printf("Error loading review %s: %v", id, err)
+		return nil, err
+	}
+	return review, nil
+}
+
+func (r *pgModeration) ListReviews(roomID, status string) ([]models.Review, error) {
+	rows, err := r.db.Query(`
+	SELECT `+reviewColumns+` FROM message_reviews
+	WHERE room_id = $1 AND ($2 = '' OR status = $2)
+	ORDER BY id`, roomID, status)
+	if err != nil {
+		log.Printf("Error listing reviews of room %s: %v", roomID, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []models.Review{}
+	for rows.Next() {
+		review, err := scanReview(rows)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, *review)
+	}
+	return reviews, rows.Err()
+}
+
+func (r *pgModeration) ResolveReview(id, status, reviewer string) (*models.Review, error) {
+	reviewID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	review, err := scanReview(r.db.QueryRow(`
+	UPDATE message_reviews SET status = $2, reviewed_by = $3, reviewed_at = NOW()
+	WHERE id = $1 AND status = 'pending'
+	RETURNING `+reviewColumns, reviewID, status, reviewer))
+	if err == sql.ErrNoRows {
+		// Tell a missing review apart from one somebody else resolved.
+		if _, err := r.GetReview(id); err != nil {
+			return nil, err
+		}
+		return nil, ErrConflict
+	}
+	if err != nil {
+		log.Printf("Error resolving review %s: %v", id, err)
+		return nil, err
+	}
+	return review, nil
+}
+
+type pgTokens struct {
+	db *sql.DB
+}
+
+func (r *pgTokens) Create(token *models.UserToken) error {
+	query := `
+	INSERT INTO user_tokens (token_hash, email, purpose, expires_at) VALUES ($1, $2, $3, $4)
+	RETURNING created_at`
+	err := r.db.QueryRow(query, token.Hash, token.Email, token.Purpose, token.ExpiresAt).Scan(&token.CreatedAt)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		log.Println("Saving token failed", err)
+	}
+	return err
+}
+
+func (r *pgTokens) Consume(hash, purpose string) (*models.UserToken, error) {
+	token := &models.UserToken{Hash: hash, Purpose: purpose}
+	var usedAt time.Time
+	query := `
+	UPDATE user_tokens SET used_at = NOW()
+	WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+	RETURNING email, expires_at, used_at, created_at`
+	err := r.db.QueryRow(query, hash, purpose).Scan(&token.Email, &token.ExpiresAt, &usedAt, &token.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Println("Consuming token failed", err)
+		return nil, err
+	}
+	token.UsedAt = &usedAt
+	return token, nil
+}
+
+func (r *pgTokens) DeleteByEmail(email, purpose string) error {
+	_, err := r.db.Exec(`DELETE FROM user_tokens WHERE email = $1 AND purpose = $2`, email, purpose)
+	if err != nil {
+		log.Printf("Error deleting tokens of %s: %v", email, err)
+	}
+	return err
+}
+
+type pgProfiles struct {
+	db *sql.DB
+}
+
+const profileColumns = `email, public_id, display_name, avatar, appearance, status, availability, bot, updated_at`
+
+func scanProfile(row interface{ Scan(...interface{}) error }) (*models.Profile, error) {
+	profile := &models.Profile{}
+	var appearance []byte
+	err := row.Scan(&profile.Email, &profile.ID, &profile.DisplayName, &profile.Avatar, &appearance,
+		&profile.Status, &profile.Availability, &profile.Bot, &profile.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(appearance, &profile.Appearance); err != nil {
+		return nil, err
+	}
+	if len(profile.Appearance) == 0 {
+		profile.Appearance = nil
+	}
+	return profile, nil
+}
+
+func (r *pgProfiles) get(column, value string) (*models.Profile, error) {
+	row := r.db.QueryRow(`SELECT `+profileColumns+` FROM user_profiles WHERE `+column+` = $1`, value)
+	profile, err := scanProfile(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Printf("Error querying profile %s: %v", value, err)
+		return nil, err
+	}
+	return profile, nil
+}
+
+func (r *pgProfiles) Get(email string) (*models.Profile, error) {
+	return r.get("email", email)
+}
+
+func (r *pgProfiles) GetByID(id string) (*models.Profile, error) {
+	return r.get("public_id", id)
+}
+
+func (r *pgProfiles) List(emails []string) ([]models.Profile, error) {
+	rows, err := r.db.Query(`SELECT `+profileColumns+` FROM user_profiles WHERE email = ANY($1)`, pq.Array(emails))
+	if err != nil {
+		log.Println("Error listing profiles:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	profiles := []models.Profile{}
+	for rows.Next() {
+		profile, err := scanProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, *profile)
+	}
+	return profiles, rows.Err()
+}
+
+func (r *pgProfiles) Create(profile *models.Profile) error {
+	appearance, err := appearanceJSON(profile.Appearance)
+	if err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO user_profiles (email, public_id, display_name, avatar, appearance, status, availability, bot)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING updated_at`
+	err = r.db.QueryRow(query, profile.Email, profile.ID, profile.DisplayName, profile.Avatar, appearance,
+		profile.Status, profile.Availability, profile.Bot).Scan(&profile.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		log.Println("Saving profile failed", err)
+	}
+	return err
+}
+
+func (r *pgProfiles) Update(profile *models.Profile) error {
+	appearance, err := appearanceJSON(profile.Appearance)
+	if err != nil {
+		return err
+	}
+	query := `
+	UPDATE user_profiles SET display_name = $2, avatar = $3, appearance = $4,
+		status = $5, availability = $6, updated_at = NOW()
+	WHERE email = $1
+	RETURNING public_id, updated_at`
+	err = r.db.QueryRow(query, profile.Email, profile.DisplayName, profile.Avatar, appearance,
+		profile.Status, profile.Availability).Scan(&profile.ID, &profile.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		log.Println("Saving profile failed", err)
+	}
+	return err
+}
+
+func appearanceJSON(appearance map[string]string) ([]byte, error) {
+	if appearance == nil {
+		appearance = map[string]string{}
+	}
+	return json.Marshal(appearance)
+}
+
+type pgAccessTokens struct {
+	db *sql.DB
+}
+
+const accessTokenColumns = `id, token_hash, email, name, scopes, rooms, created_by, expires_at, last_used_at, revoked_at, created_at`
+
+func scanAccessToken(row interface{ Scan(...interface{}) error }) (*models.AccessToken, error) {
+	token := &models.AccessToken{}
+	var scopes, rooms pq.StringArray
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(&token.ID, &token.Hash, &token.Email, &token.Name, &scopes, &rooms, &token.CreatedBy,
+		&expiresAt, &lastUsedAt, &revokedAt, &token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	token.Scopes = scopes
+	if len(rooms) > 0 {
+		token.Rooms = rooms
+	}
+	if expiresAt.Valid {
+		token.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+	return token, nil
+}
+
+func (r *pgAccessTokens) Create(token *models.AccessToken) error {
+	query := `
+	INSERT INTO access_tokens (id, token_hash, email, name, scopes, rooms, created_by, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING created_at`
+	rooms := token.Rooms
+	if rooms == nil {
+		rooms = []string{}
+	}
+	err := r.db.QueryRow(query, token.ID, token.Hash, token.Email, token.Name, pq.Array(token.Scopes),
+		pq.Array(rooms), token.CreatedBy, token.ExpiresAt).Scan(&token.CreatedAt)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		log.Println("Saving access token failed", err)
+	}
+	return err
+}
+
+func (r *pgAccessTokens) get(column, value string) (*models.AccessToken, error) {
+	row := r.db.QueryRow(`SELECT `+accessTokenColumns+` FROM access_tokens WHERE `+column+` = $1`, value)
+	token, err := scanAccessToken(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Println("Error querying access token:", err)
+		return nil, err
+	}
+	return token, nil
+}
+
+func (r *pgAccessTokens) Get(id string) (*models.AccessToken, error) {
+	return r.get("id", id)
+}
+
+func (r *pgAccessTokens) GetByHash(hash string) (*models.AccessToken, error) {
+	return r.get("token_hash", hash)
+}
+
+func (r *pgAccessTokens) ListByEmails(emails []string) ([]models.AccessToken, error) {
+	query := `SELECT ` + accessTokenColumns + ` FROM access_tokens WHERE email = ANY($1) ORDER BY created_at DESC`
+	rows, err := r.db.Query(query, pq.Array(emails))
+	if err != nil {
+		log.Println("Error listing access tokens:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []models.AccessToken{}
+	for rows.Next() {
+		token, err := scanAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+func (r *pgAccessTokens) Revoke(id string) error {
+	result, err := r.db.Exec(`UPDATE access_tokens SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		log.Println("Revoking access token failed", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *pgAccessTokens) Touch(id string, at time.Time) error {
+	_, err := r.db.Exec(`UPDATE access_tokens SET last_used_at = $2 WHERE id = $1`, id, at)
+	return err
+}
+
+type pgWebhooks struct {
+	db *sql.DB
+}
+
+const webhookColumns = `id, room_id, url, secret, events, created_by, created_at`
+
+func scanWebhook(row interface{ Scan(...interface{}) error }) (*models.Webhook, error) {
+	hook := &models.Webhook{}
+	var events pq.StringArray
+	err := row.Scan(&hook.ID, &hook.RoomID, &hook.URL, &hook.Secret, &events, &hook.CreatedBy, &hook.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	hook.Events = events
+	return hook, nil
+}
+
+func (r *pgWebhooks) Create(hook *models.Webhook) error {
+	query := `
+	INSERT INTO room_webhooks (id, room_id, url, secret, events, created_by)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING created_at`
+	err := r.db.QueryRow(query, hook.ID, hook.RoomID, hook.URL, hook.Secret, pq.Array(hook.Events), hook.CreatedBy).
+		Scan(&hook.CreatedAt)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		log.Println("Saving webhook failed", err)
+	}
+	return err
+}
+
+func (r *pgWebhooks) Get(id string) (*models.Webhook, error) {
+	row := r.db.QueryRow(`SELECT `+webhookColumns+` FROM room_webhooks WHERE id = $1`, id)
+	hook, err := scanWebhook(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Println("Error querying webhook:", err)
+		return nil, err
+	}
+	return hook, nil
+}
+
+func (r *pgWebhooks) ListByRoom(roomID string) ([]models.Webhook, error) {
+	rows, err := r.db.Query(`SELECT `+webhookColumns+` FROM room_webhooks WHERE room_id = $1 ORDER BY created_at, id`, roomID)
+	if err != nil {
+		log.Println("Error listing webhooks:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := []models.Webhook{}
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, *hook)
+	}
+	return hooks, rows.Err()
+}
+
+func (r *pgWebhooks) Delete(id string) error {
+	result, err := r.db.Exec(`DELETE FROM room_webhooks WHERE id = $1`, id)
+	if err != nil {
+		log.Println("Deleting webhook failed", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *pgWebhooks) CreateDelivery(delivery *models.WebhookDelivery) error {
+	query := `
+	INSERT INTO webhook_deliveries (id, webhook_id, event, payload, status)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING created_at`
+	err := r.db.QueryRow(query, delivery.ID, delivery.WebhookID, delivery.Event, delivery.Payload, delivery.Status).
+		Scan(&delivery.CreatedAt)
+	if err != nil {
+		log.Println("Saving webhook delivery failed", err)
+	}
+	return err
+}
+
+func (r *pgWebhooks) UpdateDelivery(delivery *models.WebhookDelivery) error {
+	query := `
+	UPDATE webhook_deliveries
+	SET status = $2, attempts = $3, status_code = $4, error = $5, last_attempt_at = $6
+	WHERE id = $1`
+	result, err := r.db.Exec(query, delivery.ID, delivery.Status, delivery.Attempts, delivery.StatusCode,
+		delivery.Error, delivery.LastAttemptAt)
+	if err != nil {
+		log.Println("Updating webhook delivery failed", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *pgWebhooks) ListDeliveries(webhookID string, limit int) ([]models.WebhookDelivery, error) {
+	query := `
+	SELECT id, webhook_id, event, payload, status, attempts, status_code, error, last_attempt_at, created_at
+	FROM webhook_deliveries
+	WHERE webhook_id = $1
+	ORDER BY created_at DESC, id
+	LIMIT $2`
+	rows, err := r.db.Query(query, webhookID, limit)
+	if err != nil {
+		log.Println("Error listing webhook deliveries:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		var lastAttemptAt sql.NullTime
+		err := rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &delivery.Payload, &delivery.Status,
+			&delivery.Attempts, &delivery.StatusCode, &delivery.Error, &lastAttemptAt, &delivery.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if lastAttemptAt.Valid {
+			delivery.LastAttemptAt = &lastAttemptAt.Time
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+type pgRoomObjects struct {
+	db *sql.DB
+}
+
+const roomObjectColumns = `id, room_id, type, x, y, properties, state, created_by, created_at, updated_at`
+
+func scanRoomObject(row interface{ Scan(...interface{}) error }) (*types.RoomObject, error) {
+	object := &types.RoomObject{}
+	var properties, state []byte
+	err := row.Scan(&object.ID, &object.RoomID, &object.Type, &object.X, &object.Y, &properties, &state,
+		&object.CreatedBy, &object.CreatedAt, &object.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(properties, &object.Properties); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(state, &object.State); err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// objectJSON stores nil maps as {} so that they read back as empty maps.
+func objectJSON(data map[string]interface{}) ([]byte, error) {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	return json.Marshal(data)
+}
+
+func (r *pgRoomObjects) Create(object *types.RoomObject) error {
+	properties, err := objectJSON(object.Properties)
+	if err != nil {
+		return err
+	}
+	state, err := objectJSON(object.State)
+	if err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO room_objects (id, room_id, type, x, y, properties, state, created_by)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING created_at, updated_at`
+	err = r.db.QueryRow(query, object.ID, object.RoomID, object.Type, object.X, object.Y, properties, state,
+		object.CreatedBy).Scan(&object.CreatedAt, &object.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		log.Println("Saving room object failed", err)
+	}
+	return err
+}
+
+func (r *pgRoomObjects) Get(id string) (*types.RoomObject, error) {
+	row := r.db.QueryRow(`SELECT `+roomObjectColumns+` FROM room_objects WHERE id = $1`, id)
+	object, err := scanRoomObject(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Println("Error querying room object:", err)
+		return nil, err
+	}
+	return object, nil
+}
+
+func (r *pgRoomObjects) ListByRoom(roomID string) ([]types.RoomObject, error) {
+	rows, err := r.db.Query(`SELECT `+roomObjectColumns+` FROM room_objects WHERE room_id = $1 ORDER BY created_at, id`, roomID)
+	if err != nil {
+		log.Println("Error listing room objects:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	objects := []types.RoomObject{}
+	for rows.Next() {
+		object, err := scanRoomObject(rows)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, *object)
+	}
+	return objects, rows.Err()
+}
+
+func (r *pgRoomObjects) Update(object *types.RoomObject) error {
+	properties, err := objectJSON(object.Properties)
+	if err != nil {
+		return err
+	}
+	query := `
+	UPDATE room_objects SET x = $2, y = $3, properties = $4, updated_at = NOW()
+	WHERE id = $1
+	RETURNING updated_at`
+	err = r.db.QueryRow(query, object.ID, object.X, object.Y, properties).Scan(&object.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		log.Println("Updating room object failed", err)
+	}
+	return err
+}
+
+func (r *pgRoomObjects) SaveState(object *types.RoomObject) error {
+	state, err := objectJSON(object.State)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE room_objects SET state = $2, updated_at = NOW() WHERE id = $1 RETURNING updated_at`
+	err = r.db.QueryRow(query, object.ID, state).Scan(&object.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		log.Println("Saving room object state failed", err)
+	}
+	return err
+}
+
+func (r *pgRoomObjects) Delete(id string) error {
+	result, err := r.db.Exec(`DELETE FROM room_objects WHERE id = $1`, id)
+	if err != nil {
+		log.Println("Deleting room object failed", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type pgInvites struct {
+	db *sql.DB
+}
+
+func (r *pgInvites) Create(invite *models.Invite) error {
+	query := `
+	INSERT INTO room_invites (token, room_id, role, created_by, max_uses, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING created_at`
+	err := r.db.QueryRow(query, invite.Token, invite.RoomID, string(invite.Role), invite.CreatedBy, invite.MaxUses, invite.ExpiresAt).
+		Scan(&invite.CreatedAt)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		log.Println("Invite creation failed", err)
+	}
+	return err
+}
+
+func (r *pgInvites) ListByRoom(roomID string) ([]models.Invite, error) {
+	query := `
+	SELECT token, role, created_by, max_uses, uses, expires_at, created_at
+	FROM room_invites WHERE room_id = $1 ORDER BY created_at DESC`
+	rows, err := r.db.Query(query, roomID)
+	if err != nil {
+		log.Printf("Error listing invites of room %s: %v", roomID, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []models.Invite{}
+	for rows.Next() {
+		invite := models.Invite{RoomID: roomID}
+		var role string
+		if err := rows.Scan(&invite.Token, &role, &invite.CreatedBy, &invite.MaxUses, &invite.Uses, &invite.ExpiresAt, &invite.CreatedAt); err != nil {
+			return nil, err
+		}
+		invite.Role = roles.Role(role)
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+func (r *pgInvites) Delete(roomID, token string) error {
+	result, err := r.db.Exec(`DELETE FROM room_invites WHERE room_id = $1 AND token = $2`, roomID, token)
+	if err != nil {
+		log.Println("Deleting invite failed", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *pgInvites) Redeem(token string) (*models.Invite, error) {
+	query := `
+	UPDATE room_invites SET uses = uses + 1
+	WHERE token = $1
+	AND (max_uses = 0 OR uses < max_uses)
+	AND (expires_at IS NULL OR expires_at > NOW())
+	RETURNING room_id, role, created_by, max_uses, uses, expires_at, created_at`
+
+	invite := &models.Invite{Token: token}
+	var role string
+	err := r.db.QueryRow(query, token).
+		Scan(&invite.RoomID, &role, &invite.CreatedBy, &invite.MaxUses, &invite.Uses, &invite.ExpiresAt, &invite.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Println("Redeeming invite failed", err)
+		return nil, err
+	}
+	invite.Role = roles.Role(role)
+	return invite, nil
+}
+
+func (r *pgInvites) CreateJoinRequest(request *models.JoinRequest) error {
+	var pending bool
+	err := r.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM join_requests WHERE room_id = $1 AND email = $2 AND status = $3)`,
+		request.RoomID, request.Email, models.JoinRequestPending).Scan(&pending)
+	if err != nil {
+		log.Println("Checking pending join requests failed", err)
+		return err
+	}
+	if pending {
+		return ErrConflict
+	}
+
+	query := `
+	INSERT INTO join_requests (room_id, email, message, status)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, status, created_at`
+	err = r.db.QueryRow(query, request.RoomID, request.Email, request.Message, models.JoinRequestPending).
+		Scan(&request.ID, &request.Status, &request.CreatedAt)
+	if err != nil {
+		log.Println("Join request creation failed", err)
+	}
+	return err
+}
+
+func (r *pgInvites) ListJoinRequests(roomID, status string) ([]models.JoinRequest, error) {
+	query := `
+	SELECT id, email, message, status, decided_by, created_at, decided_at
+	FROM join_requests WHERE room_id = $1 AND ($2 = '' OR status = $2)
+	ORDER BY created_at`
+	rows, err := r.db.Query(query, roomID, status)
+	if err != nil {
+		log.Printf("Error listing join requests of room %s: %v", roomID, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []models.JoinRequest{}
+	for rows.Next() {
+		request := models.JoinRequest{RoomID: roomID}
+		if err := rows.Scan(&request.ID, &request.Email, &request.Message, &request.Status, &request.DecidedBy, &request.CreatedAt, &request.DecidedAt); err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+	return requests, rows.Err()
+}
+
+func (r *pgInvites) DecideJoinRequest(roomID string, id int, status, decidedBy string) (*models.JoinRequest, error) {
+	query := `
+	UPDATE join_requests SET status = $1, decided_by = $2, decided_at = NOW()
+	WHERE id = $3 AND room_id = $4 AND status = $5
+	RETURNING email, message, status, decided_by, created_at, decided_at`
+
+	request := &models.JoinRequest{ID: id, RoomID: roomID}
+	err := r.db.QueryRow(query, status, decidedBy, id, roomID, models.JoinRequestPending).
+		Scan(&request.Email, &request.Message, &request.Status, &request.DecidedBy, &request.CreatedAt, &request.DecidedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Println("Deciding join request failed", err)
+		return nil, err
+	}
+	return request, nil
+}
+
+type pgBans struct {
+	db *sql.DB
+}
+
+func (r *pgBans) Save(ban *types.Ban) error {
+	query := `
+	INSERT INTO room_bans (room_id, email, banned_by, reason, expires_at) VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (room_id, email) DO UPDATE SET
+		banned_by = EXCLUDED.banned_by,
+		reason = EXCLUDED.reason,
+		expires_at = EXCLUDED.expires_at,
+		created_at = NOW()
+	RETURNING created_at`
+	err := r.db.QueryRow(query, ban.RoomID, ban.UserID, ban.BannedBy, ban.Reason, ban.ExpiresAt).Scan(&ban.CreatedAt)
+	if err != nil {
+		log.Println("Saving ban failed", err)
+		return err
+	}
+
+	log.Printf("User %s banned from room %s by %s", ban.UserID, ban.RoomID, ban.BannedBy)
+	return nil
+}
+
+func (r *pgBans) GetActive(roomID, userID string) (*types.Ban, error) {
+	query := `
+	SELECT banned_by, reason, expires_at, created_at FROM room_bans
+	WHERE room_id = $1 AND email = $2 AND (expires_at IS NULL OR expires_at > NOW())`
+
+	ban := &types.Ban{RoomID: roomID, UserID: userID}
+	err := r.db.QueryRow(query, roomID, userID).Scan(&ban.BannedBy, &ban.Reason, &ban.ExpiresAt, &ban.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Printf("Error querying ban of %s in room %s: %v", userID, roomID, err)
+		return nil, err
+	}
+	return ban, nil
+}
+
+func (r *pgBans) ListActive(roomID string) ([]types.Ban, error) {
+	query := `
+	SELECT email, banned_by, reason, expires_at, created_at FROM room_bans
+	WHERE room_id = $1 AND (expires_at IS NULL OR expires_at > NOW())
+	ORDER BY created_at DESC`
+	rows, err := r.db.Query(query, roomID)
+	if err != nil {
+		log.Printf("Error listing bans of room %s: %v", roomID, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	bans := []types.Ban{}
+	for rows.Next() {
+		ban := types.Ban{RoomID: roomID}
+		if err := rows.Scan(&ban.UserID, &ban.BannedBy, &ban.Reason, &ban.ExpiresAt, &ban.CreatedAt); err != nil {
+			return nil, err
+		}
+		bans = append(bans, ban)
+	}
+	return bans, rows.Err()
+}
+
+func (r *pgBans) Delete(roomID, userID string) error {
+	result, err := r.db.Exec(`DELETE FROM room_bans WHERE room_id = $1 AND email = $2`, roomID, userID)
+	if err != nil {
+		log.Println("Deleting ban failed", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type pgAudit struct {
+	db *sql.DB
+}
+
+func (r *pgAudit) Record(event *types.AuditEvent) error {
+	var details []byte
+	if len(event.Details) > 0 {
+		var err error
+		details, err = json.Marshal(event.Details)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `
+	INSERT INTO audit_log (action, actor, target, room_id, ip, details)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at`
+	err := r.db.QueryRow(query, event.Action, event.Actor, event.Target, event.RoomID, event.IP, details).
+		Scan(&event.ID, &event.CreatedAt)
+	if err != nil {
+		log.Println("Recording audit event failed", err)
+	}
+	return err
+}
+
+func (r *pgAudit) Query(filter AuditFilter) ([]types.AuditEvent, error) {
+	var conditions []string
+	var args []interface{}
+
+	addCondition := func(format string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(format, len(args)))
+	}
+
+	if filter.User != "" {
+		args = append(args, filter.User)
+		conditions = append(conditions, fmt.Sprintf("(actor = $%d OR target = $%d)", len(args), len(args)))
+	}
+	if filter.RoomID != "" {
+		addCondition("room_id = $%d", filter.RoomID)
+	}
+	if filter.Action != "" {
+		addCondition("action = $%d", filter.Action)
+	}
+	if filter.From != nil {
+		addCondition("created_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		addCondition("created_at < $%d", *filter.To)
+	}
+
+	query := `SELECT id, action, actor, target, room_id, ip, details, created_at FROM audit_log`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args))
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		log.Println("Error querying audit log", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []types.AuditEvent{}
+	for rows.Next() {
+		var event types.AuditEvent
+		var details []byte
+		if err := rows.Scan(&event.ID, &event.Action, &event.Actor, &event.Target, &event.RoomID, &event.IP, &details, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if len(details) > 0 {
+			json.Unmarshal(details, &event.Details)
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
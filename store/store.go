@@ -0,0 +1,339 @@
+package store
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"go-gather/db"
+	"go-gather/http/models"
+	"go-gather/moderation"
+	"go-gather/types"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("already exists")
+	// ErrBadCursor is returned for a message ID, such as
+	// MessageQuery.Before, that is not valid for the backend in use.
+	ErrBadCursor = errors.New("invalid cursor")
+)
+
+type UserRepository interface {
+	// Create stores a user whose PasswordHash is already set. user.Rooms
+	// is ignored; memberships only come from Memberships.
+	Create(user *models.User) error
+	GetByEmail(email string) (*models.User, error)
+	// GetRooms lists every room the user belongs to.
+	GetRooms(email string) ([]string, error)
+	// RecordFailedLogin counts a wrong password against the account and
+	// returns how many there have been since the last reset.
+	RecordFailedLogin(email string) (int, error)
+	// LockUntil refuses sign-ins to the account until the given time.
+	LockUntil(email string, until time.Time) error
+	// ResetFailedLogins clears the failure count and any lock.
+	ResetFailedLogins(email string) error
+	SetEmailVerified(email string) error
+	SetPasswordHash(email, hash string) error
+	// GetByIdentity finds the user an identity provider's subject is
+	// linked to.
+	GetByIdentity(issuer, subject string) (*models.User, error)
+	// LinkIdentity ties the provider's subject to the user. A subject
+	// already linked to anyone is an ErrConflict.
+	LinkIdentity(email, issuer, subject string) error
+	// ListBots returns the bot accounts the user owns.
+	ListBots(owner string) ([]models.User, error)
+	// SaveTwoFactor replaces the user's whole TOTP setup.
+	SaveTwoFactor(email string, twoFactor models.TwoFactor) error
+	// UseTOTPStep records that a code for step was accepted. A step at or
+	// before the last one used is ErrNotFound.
+	UseTOTPStep(email string, step int64) error
+	// UseRecoveryCode removes the recovery code with this hash. A hash
+	// that is not among the unused codes is ErrNotFound.
+	UseRecoveryCode(email, hash string) error
+}
+
+type TokenRepository interface {
+	// Create stores a token and assigns its CreatedAt.
+	Create(token *models.UserToken) error
+	// Consume marks the token with this hash and purpose used and returns
+	// it. Tokens that are unknown, used or expired are ErrNotFound.
+	Consume(hash, purpose string) (*models.UserToken, error)
+	// DeleteByEmail drops the user's outstanding tokens for the purpose.
+	DeleteByEmail(email, purpose string) error
+}
+
+type AccessTokenRepository interface {
+	// Create stores the token and sets its CreatedAt.
+	Create(token *models.AccessToken) error
+	Get(id string) (*models.AccessToken, error)
+	GetByHash(hash string) (*models.AccessToken, error)
+	// ListByEmails returns the tokens acting as any of the accounts,
+	// revoked ones included, newest first.
+	ListByEmails(emails []string) ([]models.AccessToken, error)
+	// Revoke marks the token revoked. Unknown or already revoked tokens
+	// are ErrNotFound.
+	Revoke(id string) error
+	// Touch records when the token was last used.
+	Touch(id string, at time.Time) error
+}
+
+type ProfileRepository interface {
+	// Get returns the user's profile. Users who never had one are
+	// ErrNotFound.
+	Get(email string) (*models.Profile, error)
+	GetByID(id string) (*models.Profile, error)
+	// List returns the profiles of those emails that have one.
+	List(emails []string) ([]models.Profile, error)
+	// Create stores a new profile and sets UpdatedAt. A second profile for
+	// the same email or ID is an ErrConflict.
+	Create(profile *models.Profile) error
+	// Update saves everything but the ID and sets UpdatedAt.
+	Update(profile *models.Profile) error
+}
+
+type RoomRepository interface {
+	// Create registers a new room with ownerEmail as its owner.
+	Create(roomID, ownerEmail string) error
+	Exists(roomID string) (bool, error)
+	// GetSettings returns the defaults for rooms never configured.
+	GetSettings(roomID string) (*models.RoomSettings, error)
+	SaveSettings(settings *models.RoomSettings) error
+}
+
+type MembershipRepository interface {
+	Get(roomID, email string) (*models.Membership, error)
+	// Save adds the membership or updates the role of an existing one.
+	Save(membership *models.Membership) error
+	Delete(roomID, email string) error
+	ListByRoom(roomID string) ([]models.Membership, error)
+}
+
+// MessageQuery selects one page of a channel's history. Before is the ID
+// of the oldest message the caller already has, or empty for the latest
+// page. ReplyTo, when set, selects the replies to that message instead of
+// the channel.
+type MessageQuery struct {
+	RoomID  string
+	Channel string
+	ReplyTo string
+	Before  string
+	Limit   int
+}
+
+type MessageRepository interface {
+	// Create assigns the message its ID and CreatedAt.
+	Create(message *types.ChatMessage) error
+	Get(id string) (*types.ChatMessage, error)
+	// List returns up to Limit messages older than Before, oldest first.
+	List(query MessageQuery) ([]types.ChatMessage, error)
+	// Edit replaces the body of a message that is not deleted and sets
+	// EditedAt.
+	Edit(id, body string) (*types.ChatMessage, error)
+	// Delete turns the message into a tombstone, see types.ChatMessage.
+	Delete(id string) (*types.ChatMessage, error)
+	// React adds the user's reaction, or takes it back when remove is set,
+	// and returns the message's reactions afterwards.
+	React(id, userID, emoji string, remove bool) ([]types.Reaction, error)
+}
+
+type AttachmentRepository interface {
+	// Create assigns the attachment its CreatedAt; the ID is chosen by the
+	// caller since it also names the blobs.
+	Create(attachment *models.Attachment) error
+	Get(id string) (*models.Attachment, error)
+}
+
+type ReadMarkerRepository interface {
+	// Save moves the user's marker forward to marker.MessageID and sets
+	// ReadAt. A marker that would move backwards is left alone; either way
+	// marker ends up holding what is stored.
+	Save(marker *types.ReadMarker) error
+	ListByChannel(roomID, channel string) ([]types.ReadMarker, error)
+}
+
+type ModerationRepository interface {
+	// GetFilters returns an empty configuration for rooms never configured.
+	GetFilters(roomID string) (*moderation.Config, error)
+	SaveFilters(roomID string, config *moderation.Config) error
+	// CreateReview assigns the review its ID and CreatedAt and leaves it
+	// pending.
+	CreateReview(review *models.Review) error
+	GetReview(id string) (*models.Review, error)
+	// ListReviews returns the room's reviews with the given status, or all
+	// of them for an empty status, oldest first.
+	ListReviews(roomID, status string) ([]models.Review, error)
+	// ResolveReview records a moderator's decision. Reviews that are no
+	// longer pending are an ErrConflict.
+	ResolveReview(id, status, reviewer string) (*models.Review, error)
+}
+
+type ConversationRepository interface {
+	// Create assigns the conversation its ID and CreatedAt. A second
+	// direct conversation between the same pair is an ErrConflict.
+	Create(conversation *models.Conversation) error
+	Get(id string) (*models.Conversation, error)
+	FindDirect(a, b string) (*models.Conversation, error)
+	// ListByUser returns the user's conversations, most recently active
+	// first, with Unread and LastMessage filled in for that user.
+	ListByUser(email string) ([]models.Conversation, error)
+	// UnreadCount counts messages the user neither sent nor has read.
+	UnreadCount(id, email string) (int, error)
+	// MarkRead records that the user has read up to messageID. It never
+	// moves the read position backwards.
+	MarkRead(id, email, messageID string) error
+}
+
+type WebhookRepository interface {
+	// Create stores the webhook and sets its CreatedAt; the ID is chosen
+	// by the caller.
+	Create(hook *models.Webhook) error
+	Get(id string) (*models.Webhook, error)
+	// ListByRoom returns the room's webhooks, oldest first.
+	ListByRoom(roomID string) ([]models.Webhook, error)
+	// Delete removes the webhook along with its delivery log.
+	Delete(id string) error
+	// CreateDelivery stores a delivery and sets its CreatedAt.
+	CreateDelivery(delivery *models.WebhookDelivery) error
+	// UpdateDelivery saves the outcome of the delivery's latest attempt.
+	UpdateDelivery(delivery *models.WebhookDelivery) error
+	// ListDeliveries returns the webhook's latest deliveries, newest
+	// first.
+	ListDeliveries(webhookID string, limit int) ([]models.WebhookDelivery, error)
+}
+
+type InviteRepository interface {
+	// Create stores the invite and sets its CreatedAt; the token is chosen
+	// by the caller.
+	Create(invite *models.Invite) error
+	// ListByRoom returns the room's invites, newest first.
+	ListByRoom(roomID string) ([]models.Invite, error)
+	Delete(roomID, token string) error
+	// Redeem uses up one use of the invite and returns it. Checking and
+	// counting happen at once, so a single-use invite cannot be redeemed
+	// twice. Invites that are unknown, expired or used up are ErrNotFound.
+	Redeem(token string) (*models.Invite, error)
+	// CreateJoinRequest assigns the request its ID and CreatedAt and
+	// leaves it pending. A second pending request from the same user for
+	// the room is an ErrConflict.
+	CreateJoinRequest(request *models.JoinRequest) error
+	// ListJoinRequests returns the room's requests with the given status,
+	// or all of them for an empty status, oldest first.
+	ListJoinRequests(roomID, status string) ([]models.JoinRequest, error)
+	// DecideJoinRequest moves a pending request to approved or denied.
+	// Requests that were already decided are ErrNotFound.
+	DecideJoinRequest(roomID string, id int, status, decidedBy string) (*models.JoinRequest, error)
+}
+
+type BanRepository interface {
+	// Save records the ban, replacing any earlier one of the same user
+	// from the room, and sets its CreatedAt.
+	Save(ban *types.Ban) error
+	// GetActive returns the user's ban from the room. Users who were never
+	// banned, or whose ban has run out, are ErrNotFound.
+	GetActive(roomID, userID string) (*types.Ban, error)
+	// ListActive returns the room's bans that have not run out, newest
+	// first.
+	ListActive(roomID string) ([]types.Ban, error)
+	Delete(roomID, userID string) error
+}
+
+// AuditFilter selects audit events. User matches either the actor or the
+// target; From and To bound CreatedAt, the latter exclusively.
+type AuditFilter struct {
+	User   string
+	RoomID string
+	Action string
+	From   *time.Time
+	To     *time.Time
+	Limit  int
+}
+
+type AuditRepository interface {
+	// Record assigns the event its ID and CreatedAt.
+	Record(event *types.AuditEvent) error
+	// Query returns up to Limit matching events, newest first.
+	Query(filter AuditFilter) ([]types.AuditEvent, error)
+}
+
+type Store struct {
+	Users         UserRepository
+	Tokens        TokenRepository
+	Profiles      ProfileRepository
+	AccessTokens  AccessTokenRepository
+	Rooms         RoomRepository
+	Memberships   MembershipRepository
+	Messages      MessageRepository
+	ReadMarkers   ReadMarkerRepository
+	Attachments   AttachmentRepository
+	Conversations ConversationRepository
+	Moderation    ModerationRepository
+	Webhooks      WebhookRepository
+	Objects       RoomObjectRepository
+	Invites       InviteRepository
+	Bans          BanRepository
+	Audit         AuditRepository
+}
+
+type RoomObjectRepository interface {
+	// Create stores the object and sets its CreatedAt and UpdatedAt; the
+	// ID is chosen by the caller.
+	Create(object *types.RoomObject) error
+	Get(id string) (*types.RoomObject, error)
+	// ListByRoom returns the room's objects, oldest first.
+	ListByRoom(roomID string) ([]types.RoomObject, error)
+	// Update saves the object's position and properties and sets its
+	// UpdatedAt. The state is left alone.
+	Update(object *types.RoomObject) error
+	// SaveState replaces the object's state and sets its UpdatedAt.
+	SaveState(object *types.RoomObject) error
+	Delete(id string) error
+}
+
+var (
+	instance *Store
+	once     sync.Once
+)
+
+// Get returns the store selected by the STORE_DRIVER environment variable:
+// "postgres" (the default), "mongo" or "memory".
+func Get() *Store {
+	once.Do(func() {
+		db.LoadEnv()
+
+		driver := os.Getenv("STORE_DRIVER")
+		switch driver {
+		case "", "postgres":
+			instance = NewPostgres(db.GetInstance())
+		case "mongo":
+			s, err := NewMongo()
+			if err != nil {
+				log.Fatalf("Unable to open Mongo store: %v", err)
+			}
+			instance = s
+		case "memory":
+			instance = NewMemory()
+		default:
+			log.Fatalf("Unknown STORE_DRIVER %q", driver)
+		}
+
+		log.Printf("Using %s store", driverName(driver))
+	})
+	return instance
+}
+
+// Set replaces the store returned by Get. It is meant for tests that want
+// an in-memory store without touching the environment.
+func Set(s *Store) {
+	once.Do(func() {})
+	instance = s
+}
+
+func driverName(driver string) string {
+	if driver == "" {
+		return "postgres"
+	}
+	return driver
+}
@@ -0,0 +1,106 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of RFC 6238 appendix B, "12345678901234567890",
+// in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// The RFC lists 8-digit codes; 6-digit codes are their last six digits.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestCodeMatchesRFC6238(t *testing.T) {
+	for _, vector := range rfcVectors {
+		code, err := Code(rfcSecret, Step(time.Unix(vector.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != vector.code {
+			t.Errorf("code at %d = %s, want %s", vector.unix, code, vector.code)
+		}
+	}
+}
+
+func TestCodeAcceptsSecretsAsTyped(t *testing.T) {
+	code, err := Code(" "+strings.ToLower(rfcSecret)+" ", Step(time.Unix(59, 0)))
+	if err != nil || code != "287082" {
+		t.Fatalf("lower-case secret: %s, %v", code, err)
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Fatal("invalid secret was accepted")
+	}
+}
+
+func TestValidateSkewWindow(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name   string
+		offset int64
+		ok     bool
+	}{
+		{"current step", 0, true},
+		{"one step behind", -1, true},
+		{"one step ahead", 1, true},
+		{"two steps behind", -2, false},
+		{"two steps ahead", 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _ := Code(rfcSecret, current+tt.offset)
+			step, ok := Validate(rfcSecret, code, now)
+			if ok != tt.ok {
+				t.Fatalf("Validate = %v, want %v", ok, tt.ok)
+			}
+			if ok && step != current+tt.offset {
+				t.Fatalf("matched step %d, want %d", step, current+tt.offset)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	for _, code := range []string{"", "00592", "0059245", "abcdef", "005 925"} {
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("accepted %q", code)
+		}
+	}
+	if _, ok := Validate(rfcSecret, " 005 924 ", now); !ok {
+		t.Error("refused a correct code typed with spaces")
+	}
+	if _, ok := Validate("not base32!", "005924", now); ok {
+		t.Error("accepted a code for an invalid secret")
+	}
+}
+
+// Validate reports the step a code belongs to however late in the window
+// it is typed, which is what lets callers refuse it a second time.
+func TestReusedStepIsRecognised(t *testing.T) {
+	issued := time.Unix(1234567890, 0)
+	code, _ := Code(rfcSecret, Step(issued))
+
+	lastUsed, ok := Validate(rfcSecret, code, issued)
+	if !ok {
+		t.Fatal("fresh code refused")
+	}
+	replayed, ok := Validate(rfcSecret, code, issued.Add(Period))
+	if !ok || replayed > lastUsed {
+		t.Fatalf("replay matched step %d after %d was used; callers would accept it", replayed, lastUsed)
+	}
+}
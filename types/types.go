@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 type Message struct {
 	Type string      `json:"type"`
 	Data interface{} `json:"data"`
@@ -36,3 +38,264 @@ type ICECandidate struct {
 	SDPMLineIndex    *uint16 `json:"sdpMLineIndex,omitempty"`
 	UsernameFragment *string `json:"usernameFragment,omitempty"`
 }
+
+// Notification is pushed from the HTTP service to the ws server so that
+// REST-side changes reach connected clients. It targets either explicit
+// users (wherever they are connected) or everyone in a room, optionally
+// narrowed to clients whose role grants Permission.
+type Notification struct {
+	Type       string      `json:"type"`
+	RoomID     string      `json:"roomId,omitempty"`
+	UserIDs    []string    `json:"userIds,omitempty"`
+	Permission string      `json:"permission,omitempty"`
+	Data       interface{} `json:"data"`
+}
+
+// Profile is what other users get to see of someone. ID is an opaque
+// handle that stands in for the email wherever the ws server names users
+// to each other; guests use their guest ID.
+type Profile struct {
+	ID           string            `json:"id"`
+	DisplayName  string            `json:"displayName"`
+	Avatar       string            `json:"avatar,omitempty"`
+	Appearance   map[string]string `json:"appearance,omitempty"`
+	Status       string            `json:"status,omitempty"`
+	Availability string            `json:"availability,omitempty"`
+	Guest        bool              `json:"guest,omitempty"`
+	Bot          bool              `json:"bot,omitempty"`
+}
+
+// Ban is sent by the ws server to the HTTP service when a moderator bans
+// someone. A nil ExpiresAt bans permanently.
+type Ban struct {
+	RoomID    string     `json:"roomId"`
+	UserID    string     `json:"userId"`
+	BannedBy  string     `json:"bannedBy"`
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// ModerationData is the payload of the kick, ban and mute-chat commands.
+// Duration is in seconds; for bans 0 means permanent.
+type ModerationData struct {
+	UserID   string `json:"userId"`
+	Reason   string `json:"reason"`
+	Duration int    `json:"duration"`
+}
+
+// AuditEvent is one entry of the audit log. Actor is who did it, Target who
+// it was done to; either may be empty when it does not apply.
+type AuditEvent struct {
+	ID        int64                  `json:"id"`
+	Action    string                 `json:"action"`
+	Actor     string                 `json:"actor,omitempty"`
+	Target    string                 `json:"target,omitempty"`
+	RoomID    string                 `json:"roomId,omitempty"`
+	IP        string                 `json:"ip,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	CreatedAt time.Time              `json:"createdAt"`
+}
+
+const (
+	AuditSignUp        = "sign-up"
+	AuditSignIn        = "sign-in"
+	AuditSignInFailed  = "sign-in-failed"
+	AuditTokenRefresh  = "token-refresh"
+	AuditRoomJoin      = "room-join"
+	AuditRoomLeave     = "room-leave"
+	AuditKick          = "kick"
+	AuditBan           = "ban"
+	AuditUnban         = "unban"
+	AuditMuteChat      = "mute-chat"
+	AuditRoleChange    = "role-change"
+	AuditMemberRemoved = "member-removed"
+	// AuditMessageDeleted is only recorded for other people's messages.
+	AuditMessageDeleted = "message-deleted"
+	// AuditFloodDisconnect is a client dropped for ignoring rate limits.
+	AuditFloodDisconnect = "flood-disconnect"
+	AuditAccountLocked   = "account-locked"
+	AuditAccountUnlocked = "account-unlocked"
+	AuditEmailVerified   = "email-verified"
+	AuditPasswordReset   = "password-reset"
+
+	AuditTwoFactorEnabled         = "two-factor-enabled"
+	AuditTwoFactorDisabled        = "two-factor-disabled"
+	AuditRecoveryCodesRegenerated = "recovery-codes-regenerated"
+
+	AuditAccessTokenCreated = "access-token-created"
+	AuditAccessTokenRevoked = "access-token-revoked"
+	AuditBotCreated         = "bot-created"
+)
+
+// ChatMessage is one persisted chat message. Room messages carry their
+// RoomID and a channel from RoomChannel or ZoneChannel; direct messages
+// have no room and use ConversationChannel. SenderName is only set for
+// guests, who have no account to look up.
+//
+// Attachments holds the IDs of files uploaded to the room beforehand.
+// Deleted messages stay in history as tombstones: DeletedAt is set and
+// Body, Attachments and Reactions are empty.
+type ChatMessage struct {
+	ID          string     `json:"id"`
+	RoomID      string     `json:"roomId"`
+	Channel     string     `json:"channel"`
+	SenderID    string     `json:"senderId"`
+	SenderName  string     `json:"senderName,omitempty"`
+	Body        string     `json:"body"`
+	ReplyTo     string     `json:"replyTo,omitempty"`
+	Attachments []string   `json:"attachments,omitempty"`
+	Reactions   []Reaction `json:"reactions,omitempty"`
+	EditedAt    *time.Time `json:"editedAt,omitempty"`
+	DeletedAt   *time.Time `json:"deletedAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+// Reaction groups everyone who reacted to a message with the same emoji.
+type Reaction struct {
+	Emoji string   `json:"emoji"`
+	Count int      `json:"count"`
+	Users []string `json:"users"`
+}
+
+// ChatData is the object form of the send-message and chat-history
+// payloads. Zone picks a zone's channel; leaving it empty means the whole
+// room. ReplyTo makes the message a reply when sending and, for
+// chat-history, lists the replies to that message instead of the channel.
+// Attachments only apply to send-message, Before and Limit only to
+// chat-history.
+type ChatData struct {
+	Body        string   `json:"body"`
+	Attachments []string `json:"attachments"`
+	Zone        string   `json:"zone"`
+	ReplyTo     string   `json:"replyTo"`
+	Before      string   `json:"before"`
+	Limit       int      `json:"limit"`
+}
+
+// MessageActionData is the payload of edit-message, delete-message,
+// react, unreact and mark-read. Body is only used by edit-message, Emoji
+// by the reactions and Zone by mark-read.
+type MessageActionData struct {
+	MessageID string `json:"messageId"`
+	Body      string `json:"body"`
+	Emoji     string `json:"emoji"`
+	Zone      string `json:"zone"`
+}
+
+// ReadMarker is how far a user has read a room channel.
+type ReadMarker struct {
+	RoomID    string    `json:"roomId"`
+	Channel   string    `json:"channel"`
+	UserID    string    `json:"userId"`
+	MessageID string    `json:"messageId"`
+	ReadAt    time.Time `json:"readAt"`
+}
+
+// AddReaction records userID's reaction in the list, keeping the order in
+// which emojis first appeared. It is a no-op if the user already reacted
+// with that emoji.
+func AddReaction(reactions []Reaction, emoji, userID string) []Reaction {
+	for i := range reactions {
+		if reactions[i].Emoji != emoji {
+			continue
+		}
+		for _, user := range reactions[i].Users {
+			if user == userID {
+				return reactions
+			}
+		}
+		reactions[i].Users = append(reactions[i].Users, userID)
+		reactions[i].Count++
+		return reactions
+	}
+	return append(reactions, Reaction{Emoji: emoji, Count: 1, Users: []string{userID}})
+}
+
+// RemoveReaction undoes AddReaction, dropping emojis nobody uses anymore.
+func RemoveReaction(reactions []Reaction, emoji, userID string) []Reaction {
+	for i := range reactions {
+		if reactions[i].Emoji != emoji {
+			continue
+		}
+		users := []string{}
+		for _, user := range reactions[i].Users {
+			if user != userID {
+				users = append(users, user)
+			}
+		}
+		if len(users) == 0 {
+			return append(reactions[:i], reactions[i+1:]...)
+		}
+		reactions[i].Users = users
+		reactions[i].Count = len(users)
+		return reactions
+	}
+	return reactions
+}
+
+// DirectMessageData is the payload of send-dm. It names either an
+// existing conversation or a single recipient, in which case their
+// one-to-one conversation is used or started.
+type DirectMessageData struct {
+	ConversationID string `json:"conversationId"`
+	To             string `json:"to"`
+	Body           string `json:"body"`
+}
+
+// RoomChannel is the channel of messages addressed to everyone in the room.
+const RoomChannel = ""
+
+func ZoneChannel(zone string) string {
+	return "zone:" + zone
+}
+
+// ChatChannel picks the room channel a ChatData payload refers to.
+func ChatChannel(data ChatData) string {
+	if data.Zone != "" {
+		return ZoneChannel(data.Zone)
+	}
+	return RoomChannel
+}
+
+func ConversationChannel(conversationID string) string {
+	return "dm:" + conversationID
+}
+
+// Kinds of objects that can be placed on a room's map.
+const (
+	ObjectWhiteboard = "whiteboard"
+	ObjectLink       = "link"
+	ObjectNote       = "note"
+	ObjectPortal     = "portal"
+)
+
+// RoomObject is something placed on a room's map. Properties are set by
+// whoever edits the map, such as a link's URL or where a portal leads;
+// State is what people change by interacting with it, such as a note's
+// text. CreatedBy stays private since objects are shown to everyone.
+type RoomObject struct {
+	ID         string                 `json:"id"`
+	RoomID     string                 `json:"roomId"`
+	Type       string                 `json:"type"`
+	X          int                    `json:"x"`
+	Y          int                    `json:"y"`
+	Properties map[string]interface{} `json:"properties"`
+	State      map[string]interface{} `json:"state"`
+	CreatedBy  string                 `json:"-"`
+	CreatedAt  time.Time              `json:"createdAt"`
+	UpdatedAt  time.Time              `json:"updatedAt"`
+}
+
+// SwitchRoomData is the payload of switch-room.
+type SwitchRoomData struct {
+	RoomID string `json:"roomId"`
+}
+
+// ObjectInteraction is the payload of object-interact. What Action may be
+// and what Data holds depend on the kind of object.
+type ObjectInteraction struct {
+	ObjectID string                 `json:"objectId"`
+	Action   string                 `json:"action"`
+	Data     map[string]interface{} `json:"data"`
+}
@@ -196,6 +196,15 @@ func (wm *WebRTCManager) HandleICECandidate(clientID string, message types.WebRT
 	return nil
 }
 
+// HasPeerConnection reports whether the client has media set up.
+func (wm *WebRTCManager) HasPeerConnection(clientID string) bool {
+	wm.lock.RLock()
+	defer wm.lock.RUnlock()
+
+	_, exists := wm.PeerConnections[clientID]
+	return exists
+}
+
 func (wm *WebRTCManager) ClosePeerConnection(clientID string) {
 	wm.lock.Lock()
 	defer wm.lock.Unlock()
@@ -3,24 +3,54 @@ package ws
 import (
 	"encoding/json"
 	"fmt"
+	"go-gather/roles"
 	"go-gather/types"
 	"log"
+	"math"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 type Client struct {
-	ID     string
-	roomID string
-	Conn   *websocket.Conn
-	X      int
-	Y      int
+	ID   string
+	Conn *websocket.Conn
+
+	// stateLock guards the room, role and position. The read loop changes
+	// them while notifications, moderation and plugins read them from
+	// other goroutines.
+	stateLock sync.RWMutex
+	roomID    string
+	role      roles.Role // Empty until the client has joined the room
+	x, y      int
+
+	// Guests connect with a room-scoped token instead of an account.
+	Guest       bool
+	DisplayName string
+
+	// Bots connect with a personal access token and may only use the
+	// events that allow them.
+	Bot bool
+	// accessToken is the personal access token the client connected
+	// with, if any. It may be limited to some rooms, so switching rooms
+	// asks about it again.
+	accessToken string
+
+	// IP keys the per-IP rate limits. X-Forwarded-For only counts when it
+	// comes from one of the TRUSTED_PROXIES, see clientip.
+	IP string
+
+	// writeLock serialises writes: gorilla/websocket allows only one
+	// concurrent writer per connection.
+	writeLock sync.Mutex
 }
 
 type Room struct {
 	ID      string
 	clients map[string]*Client
+	muted   map[string]time.Time // userID -> end of chat mute
+	typing  map[typingKey]*time.Timer
 }
 
 type WebSocketManager struct {
@@ -50,10 +80,12 @@ func (ws *WebSocketManager) AddUser(client *Client, roomID string) {
 		ws.rooms[roomID] = &Room{
 			ID:      roomID,
 			clients: make(map[string]*Client),
+			muted:   make(map[string]time.Time),
+			typing:  make(map[typingKey]*time.Timer),
 		}
 	}
 
-	client.roomID = roomID
+	client.setRoom(roomID)
 
 	ws.rooms[roomID].clients[client.ID] = client
 
@@ -61,6 +93,13 @@ func (ws *WebSocketManager) AddUser(client *Client, roomID string) {
 }
 
 func (ws *WebSocketManager) RemoveUser(clientID, roomID string) {
+	// Whatever the user was typing will never be sent now.
+	for _, channel := range ws.removeUser(clientID, roomID) {
+		ws.SendToRoom(roomID, "typing", typingEvent(clientID, channel, false))
+	}
+}
+
+func (ws *WebSocketManager) removeUser(clientID, roomID string) []string {
 	ws.lock.Lock()
 	defer ws.lock.Unlock()
 
@@ -69,7 +108,7 @@ func (ws *WebSocketManager) RemoveUser(clientID, roomID string) {
 
 	if !exists {
 		log.Println("Room", roomID, "not found")
-		return
+		return nil
 	}
 
 	if client, ok := room.clients[clientID]; ok {
@@ -78,22 +117,42 @@ func (ws *WebSocketManager) RemoveUser(clientID, roomID string) {
 		delete(room.clients, clientID)
 		log.Println("User", clientID, "removed from room", roomID)
 	}
+
+	var stopped []string
+	for key, timer := range room.typing {
+		if key.userID == clientID {
+			timer.Stop()
+			delete(room.typing, key)
+			stopped = append(stopped, key.channel)
+		}
+	}
+	return stopped
 }
 
+// BroadcastToRoom writes to a copy of the room's joined clients, so a
+// slow connection does not hold up those waiting for the manager's lock.
 func (ws *WebSocketManager) BroadcastToRoom(roomID, message string) {
 	log.Println("Broadcasting message", message, "to room", roomID)
 	ws.lock.RLock()
-	defer ws.lock.RUnlock()
-
 	room, exists := ws.rooms[roomID]
+	var clients []*Client
+	if exists {
+		clients = make([]*Client, 0, len(room.clients))
+		for _, client := range room.clients {
+			if client.Role() != "" {
+				clients = append(clients, client)
+			}
+		}
+	}
+	ws.lock.RUnlock()
 
 	if !exists {
 		log.Println("Room:", roomID, "not found")
 		return
 	}
 
-	for _, client := range room.clients {
-		err := client.Conn.WriteMessage(websocket.TextMessage, []byte(message))
+	for _, client := range clients {
+		err := client.writeMessage([]byte(message))
 
 		if err != nil {
 			log.Println("Error writing message to client", client.ID, ":", err)
@@ -101,19 +160,71 @@ func (ws *WebSocketManager) BroadcastToRoom(roomID, message string) {
 	}
 }
 
+// SendToRoom sends an event to every client that has joined the room.
+func (ws *WebSocketManager) SendToRoom(roomID, eventType string, payload interface{}) {
+	for _, client := range ws.GetClientsInRoom(roomID) {
+		if client.Role() != "" {
+			client.SendMessage(eventType, payload)
+		}
+	}
+}
+
 func (ws *WebSocketManager) BroadcastMove(client *Client, roomID string) {
-	ws.lock.RLock()
-	defer ws.lock.RUnlock()
+	// Looked up first since it may have to ask the HTTP service.
+	publicID := client.publicID()
+	x, y := client.position()
 
-	_, exists := ws.rooms[roomID]
+	message := fmt.Sprintf("%s moved to (%d, %d)", publicID, x, y)
+	ws.BroadcastToRoom(roomID, message)
+}
 
-	if !exists {
-		log.Println("Room:", roomID, "not found")
-		return
-	}
+// Role is the client's role in its room, empty until it has joined.
+func (c *Client) Role() roles.Role {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	return c.role
+}
 
-	message := fmt.Sprintf("%s moved to (%d, %d)", client.ID, client.X, client.Y)
-	ws.BroadcastToRoom(roomID, message)
+func (c *Client) setRole(role roles.Role) {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	c.role = role
+}
+
+// room is the room the client is in, or was last in.
+func (c *Client) room() string {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	return c.roomID
+}
+
+func (c *Client) setRoom(roomID string) {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	c.roomID = roomID
+}
+
+func (c *Client) position() (int, int) {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	return c.x, c.y
+}
+
+func (c *Client) setPosition(x, y int) {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	c.x, c.y = x, y
+}
+
+// step moves the client to x,y if that is at most one tile away.
+func (c *Client) step(x, y int) bool {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	if math.Abs(float64(c.x-x))+math.Abs(float64(c.y-y)) > 1 {
+		return false
+	}
+	c.x, c.y = x, y
+	return true
 }
 
 func (c *Client) SendMessage(eventType string, payload interface{}) {
@@ -129,12 +240,18 @@ func (c *Client) SendMessage(eventType string, payload interface{}) {
 		return
 	}
 
-	err = c.Conn.WriteMessage(websocket.TextMessage, messageBytes)
+	err = c.writeMessage(messageBytes)
 	if err != nil {
 		log.Println("Error writing message to client", c.ID, ":", err)
 	}
 }
 
+func (c *Client) writeMessage(message []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return c.Conn.WriteMessage(websocket.TextMessage, message)
+}
+
 func (ws *WebSocketManager) GetClientByID(clientID string) *Client {
 	ws.lock.RLock()
 	defer ws.lock.RUnlock()
@@ -147,6 +264,21 @@ func (ws *WebSocketManager) GetClientByID(clientID string) *Client {
 	return nil
 }
 
+// GetClientsByID returns the user's connections, one per room they are
+// in.
+func (ws *WebSocketManager) GetClientsByID(clientID string) []*Client {
+	ws.lock.RLock()
+	defer ws.lock.RUnlock()
+
+	var clients []*Client
+	for _, room := range ws.rooms {
+		if client, exists := room.clients[clientID]; exists {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
 func (ws *WebSocketManager) GetUsersInRoom(roomID string) []string {
 	ws.lock.RLock()
 	defer ws.lock.RUnlock()
@@ -162,3 +294,81 @@ func (ws *WebSocketManager) GetUsersInRoom(roomID string) []string {
 	}
 	return users
 }
+
+func (ws *WebSocketManager) GetClientsInRoom(roomID string) []*Client {
+	ws.lock.RLock()
+	defer ws.lock.RUnlock()
+
+	room, exists := ws.rooms[roomID]
+	if !exists {
+		return []*Client{}
+	}
+
+	clients := make([]*Client, 0, len(room.clients))
+	for _, client := range room.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// DisconnectGuests closes the connection of every guest in the room. The
+// read loops notice the closed connections and clean up as usual.
+func (ws *WebSocketManager) DisconnectGuests(roomID string) {
+	for _, client := range ws.GetClientsInRoom(roomID) {
+		if client.Guest {
+			log.Println("Disconnecting guest", client.ID, "from room", roomID)
+			client.Conn.Close()
+		}
+	}
+}
+
+func (ws *WebSocketManager) GetClientInRoom(roomID, clientID string) *Client {
+	ws.lock.RLock()
+	defer ws.lock.RUnlock()
+
+	room, exists := ws.rooms[roomID]
+	if !exists {
+		return nil
+	}
+	return room.clients[clientID]
+}
+
+// Disconnect removes the client from its room and closes its connection.
+// The read loop then exits and its own RemoveUser call is a no-op.
+func (ws *WebSocketManager) Disconnect(client *Client) {
+	ws.RemoveUser(client.ID, client.room())
+	client.Conn.Close()
+}
+
+// MuteUser blocks the user from chatting in the room until the given time.
+// Mutes outlive reconnects but not a server restart.
+func (ws *WebSocketManager) MuteUser(roomID, userID string, until time.Time) {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+
+	room, exists := ws.rooms[roomID]
+	if !exists {
+		return
+	}
+	room.muted[userID] = until
+}
+
+func (ws *WebSocketManager) IsMuted(roomID, userID string) bool {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+
+	room, exists := ws.rooms[roomID]
+	if !exists {
+		return false
+	}
+
+	until, muted := room.muted[userID]
+	if !muted {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(room.muted, userID)
+		return false
+	}
+	return true
+}
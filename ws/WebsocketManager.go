@@ -3,12 +3,17 @@ package ws
 import (
 	"encoding/json"
 	"fmt"
+	"go-gather/auth"
+	"go-gather/clientip"
+	"go-gather/plugin"
+	"go-gather/roles"
 	"go-gather/types"
 	"go-gather/webrtc"
 	"log"
-	"math"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -43,149 +48,217 @@ func HandleWebsocket(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
-	userID := r.URL.Query().Get("userId")
 	roomID := r.URL.Query().Get("roomId")
 
-	if userID == "" || roomID == "" {
-		log.Println("userId or roomId is missing")
-		conn.WriteMessage(websocket.TextMessage, []byte("userId and roomId are required"))
-		return
-	}
-
 	client := &Client{
-		ID:     userID,
 		roomID: roomID,
 		Conn:   conn,
-		X:      0,
-		Y:      0,
+		IP:     clientip.FromRequest(r),
 	}
 
-	wsManager.AddUser(client, roomID)
-
-	for {
-		_, messageBytes, err := conn.ReadMessage()
+	// The token decides who the client is: a session token for members, a
+	// guest token scoped to the room, or a personal access token for bots
+	// and integrations. A userId on its own proves nothing and is ignored.
+	tokenString := r.URL.Query().Get("token")
+	if tokenString == "" || roomID == "" {
+		log.Println("token or roomId is missing")
+		conn.WriteMessage(websocket.TextMessage, []byte("token and roomId are required"))
+		return
+	}
+	if strings.HasPrefix(tokenString, auth.AccessTokenPrefix) {
+		access, err := verifyAccessToken(tokenString, roomID)
 		if err != nil {
-			log.Printf("Error reading Message from client %s: %v\n", userID, err)
-			wsManager.RemoveUser(userID, roomID)
-			break
+			log.Println("Invalid access token:", err)
+			conn.WriteMessage(websocket.TextMessage, []byte("invalid token"))
+			return
+		}
+		client.ID = access.Email
+		client.Bot = access.Bot
+		client.accessToken = tokenString
+	} else {
+		claims, err := auth.Parse(tokenString)
+		if err != nil {
+			log.Println("Invalid token:", err)
+			conn.WriteMessage(websocket.TextMessage, []byte("invalid token"))
+			return
 		}
 
-		var message types.Message
-		if err := json.Unmarshal(messageBytes, &message); err != nil {
-			log.Println("Invalid message format:", err)
-			continue
+		if claims.Guest {
+			if claims.RoomID != roomID {
+				conn.WriteMessage(websocket.TextMessage, []byte("guest token is not valid for this room"))
+				return
+			}
+			client.ID = claims.Subject
+			client.Guest = true
+			client.DisplayName = claims.Name
+
+			// Guests are dropped as soon as their token runs out.
+			expiry := time.AfterFunc(time.Until(claims.ExpiresAt.Time), func() {
+				log.Printf("Guest token of %s expired, closing connection\n", client.ID)
+				conn.Close()
+			})
+			defer expiry.Stop()
+		} else {
+			client.ID = claims.Email
 		}
+	}
+	userID := client.ID
 
-		handleEvents(wsManager, client, roomID, message)
+	if userID == "" {
+		log.Println("Token does not name a user")
+		conn.WriteMessage(websocket.TextMessage, []byte("invalid token"))
+		return
 	}
-}
 
-func handleEvents(wsManager *WebSocketManager, client *Client, roomID string, message types.Message) {
-	var response types.Response
+	// Everyone else only ever sees the profile, so there has to be one.
+	if client.publicID() == "" {
+		log.Println("No profile for", userID)
+		conn.WriteMessage(websocket.TextMessage, []byte("profile could not be loaded"))
+		return
+	}
 
-	log.Printf("Received message type: %s from user: %s\n", message.Type, client.ID)
+	// The client is only added to the room once a join is let through;
+	// until then client.room() is just the room it asked for.
+	limiter := newClientLimiter(client.IP)
 
-	switch message.Type {
-	case "join":
-		success := handleJoinRoom(wsManager, client, roomID)
-
-		if success {
-			response = types.Response{
-				Type:    "user-joined",
-				Success: success,
-				Data: map[string]string{
-					"userId": client.ID,
-					"roomId": roomID,
-					"X":      strconv.Itoa(client.X),
-					"Y":      strconv.Itoa(client.Y),
-				},
-			}
-		} else {
-			response = types.Response{
-				Type:    "user-joining-failed",
-				Success: false,
-				Error:   "User does not have access to this room",
+	// The client may switch rooms from here on, so the room it is in is
+	// always taken from client.room().
+	for {
+		_, messageBytes, err := conn.ReadMessage()
+		roomID := client.room()
+		if err != nil {
+			log.Printf("Error reading Message from client %s: %v\n", userID, err)
+			if client.Role() != "" {
+				recordAudit(types.AuditEvent{Action: types.AuditRoomLeave, Actor: client.ID, RoomID: roomID, IP: client.IP})
+				roomEvent(client, roomID, plugin.EventLeave, nil)
 			}
+			wsManager.RemoveUser(userID, roomID)
+			break
 		}
 
-	case "leave-room":
-		success := handleLeaveRoom(wsManager, client, roomID)
-		response = types.Response{
-			Type:    "user-left",
-			Success: success,
-			Data: map[string]string{
-				"userId": client.ID,
-				"roomId": roomID,
-			},
-		}
-
-	case "send-message":
-		messageStr, ok := message.Data.(string)
-		if !ok {
-			response = types.Response{
-				Type:    "error",
-				Success: false,
-				Error:   "Invalid message format",
+		// Malformed messages still cost a token, from the "*" bucket.
+		var message types.Message
+		parseErr := json.Unmarshal(messageBytes, &message)
+
+		if allowed, wait := limiter.allow(rateClass(message.Type)); !allowed {
+			if limiter.offending() {
+				log.Printf("Disconnecting %s (%s) for flooding\n", client.ID, client.IP)
+				recordAudit(types.AuditEvent{
+					Action:  types.AuditFloodDisconnect,
+					Actor:   client.ID,
+					RoomID:  roomID,
+					IP:      client.IP,
+					Details: map[string]interface{}{"type": message.Type},
+				})
+				client.SendMessage("error", types.Response{
+					Type:    "error",
+					Success: false,
+					Error:   "Disconnected for sending too many messages",
+				})
+				// The next read fails and runs the usual clean-up.
+				conn.Close()
+				continue
 			}
-		} else {
-			wsManager.BroadcastToRoom(roomID, messageStr)
-			response = types.Response{
-				Type:    "message-sent",
-				Success: true,
-				Data: map[string]string{
-					"message": messageStr,
+			client.SendMessage("rate-limited", types.Response{
+				Type:    "rate-limited",
+				Success: false,
+				Error:   "Too many messages, slow down",
+				Data: map[string]interface{}{
+					"type":         message.Type,
+					"retryAfterMs": wait.Milliseconds() + 1,
 				},
-			}
+			})
+			continue
 		}
 
-	case "move":
-		var moveData types.MoveData
-		dataBytes, _ := json.Marshal(message.Data)
-		if err := json.Unmarshal(dataBytes, &moveData); err != nil {
-			response = types.Response{
-				Type:    "error",
-				Success: false,
-				Error:   "Invalid move data",
-			}
-		} else {
-			success := handleMove(wsManager, client, roomID, moveData)
-			response = types.Response{
-				Type:    "move-completed",
-				Success: success,
-				Data: map[string]int{
-					"x": client.X,
-					"y": client.Y,
-				},
-			}
+		if parseErr != nil {
+			log.Println("Invalid message format:", parseErr)
+			continue
 		}
 
-	case "webrtc-offer", "webrtc-answer", "webrtc-candidate":
-		handleWebRTCSignaling(wsManager, client, message)
-		// No response needed for signaling messages
-		return
+		handleEvents(wsManager, client, roomID, message)
+	}
+}
 
-	default:
-		response = types.Response{
-			Type:    "error",
+// handleJoinEvent answers with the snapshot of the room: who is in it,
+// the recent chat, the objects on the map and what plugins have placed.
+func handleJoinEvent(req *eventRequest) types.Response {
+	wsManager, client, roomID := req.wsManager, req.client, req.roomID
+	if !handleJoinRoom(wsManager, client, roomID) {
+		return types.Response{
+			Type:    "user-joining-failed",
 			Success: false,
-			Error:   "Unknown event type",
+			Error:   "User does not have access to this room",
 		}
 	}
 
-	// Send response if it's set
-	if response.Type != "" {
-		client.SendMessage(response.Type, response)
+	history := publicChatPage(recentRoomMessages(roomID))
+	profile := client.publicProfile()
+	npcs, pluginState := pluginSnapshot(roomID)
+	x, y := client.position()
+	response := types.Response{
+		Type:    "user-joined",
+		Success: true,
+		Data: map[string]interface{}{
+			"userId":      profile.ID,
+			"roomId":      roomID,
+			"role":        string(client.Role()),
+			"name":        profile.DisplayName,
+			"guest":       strconv.FormatBool(client.Guest),
+			"bot":         strconv.FormatBool(client.Bot),
+			"X":           strconv.Itoa(x),
+			"Y":           strconv.Itoa(y),
+			"profile":     profile,
+			"members":     roomProfiles(wsManager, roomID),
+			"history":     history.Messages,
+			"readMarkers": history.ReadMarkers,
+			"profiles":    history.Profiles,
+			"npcs":        npcs,
+			"roomState":   pluginState,
+			"objects":     roomObjects(roomID),
+		},
 	}
+	roomEvent(client, roomID, plugin.EventJoin, nil)
+	return response
 }
 
-func handleWebRTCSignaling(wsManager *WebSocketManager, client *Client, message types.Message) {
-	var webrtcMessage types.WebRTCMessage
-	dataBytes, _ := json.Marshal(message.Data)
-	if err := json.Unmarshal(dataBytes, &webrtcMessage); err != nil {
-		log.Println("Invalid WebRTC message format:", err)
-		return
+func handleLeaveEvent(req *eventRequest) types.Response {
+	success := handleLeaveRoom(req.wsManager, req.client, req.roomID)
+	return types.Response{
+		Type:    "user-left",
+		Success: success,
+		Data: map[string]string{
+			"userId": req.client.publicID(),
+			"roomId": req.roomID,
+		},
+	}
+}
+
+func handleMoveEvent(req *eventRequest, moveData types.MoveData) types.Response {
+	success := handleMove(req.wsManager, req.client, req.roomID, moveData)
+	x, y := req.client.position()
+	return types.Response{
+		Type:    "move-completed",
+		Success: success,
+		Data: map[string]int{
+			"x": x,
+			"y": y,
+		},
 	}
+}
+
+func permissionDenied(permission roles.Permission) types.Response {
+	return types.Response{
+		Type:    "permission-denied",
+		Success: false,
+		Error:   fmt.Sprintf("Missing permission: %s", permission),
+	}
+}
 
+func handleWebRTCSignaling(req *eventRequest, webrtcMessage types.WebRTCMessage) types.Response {
+	client, message := req.client, req.message
+	// Answers go back to the sender, so it has to be who signed in.
+	webrtcMessage.SenderID = client.ID
 	switch message.Type {
 	case "webrtc-offer":
 		err := webrtcManager.HandleOffer(client.ID, webrtcMessage)
@@ -205,78 +278,101 @@ func handleWebRTCSignaling(wsManager *WebSocketManager, client *Client, message
 	default:
 		log.Println("Unknown WebRTC message type:", message.Type)
 	}
+	// No response needed for signaling messages
+	return types.Response{}
 }
 
 func handleJoinRoom(wsManager *WebSocketManager, client *Client, roomID string) bool {
 	log.Println("handleJoinRoom called")
-	url := fmt.Sprintf("http://localhost:3000/authenticate?email=%s", client.ID)
 
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Printf("Error calling authenticate endpoint: %v", err)
-		return false
-	}
-	defer resp.Body.Close()
-
-	var result struct {
-		EmailAddress string   `json:"emailAddress"`
-		Rooms        []string `json:"rooms"`
-		Success      bool     `json:"success"`
+	// Guest tokens are already scoped to this room by HandleWebsocket, but
+	// they outlive the setting they were issued under, so it is checked
+	// again on every join.
+	if client.Guest {
+		access, err := fetchGuestAccess(roomID, client.ID)
+		if err != nil {
+			log.Printf("Error checking guest access: %v", err)
+			return false
+		}
+		if !access.GuestAccess {
+			log.Printf("Guest access to room %s is disabled, refusing %s\n", roomID, client.ID)
+			return false
+		}
+		if access.Banned {
+			log.Printf("Guest %s is banned from room %s\n", client.ID, roomID)
+			return false
+		}
+		client.setRole(roles.Guest)
+		wsManager.AddUser(client, roomID)
+		recordAudit(types.AuditEvent{
+			Action:  types.AuditRoomJoin,
+			Actor:   client.ID,
+			RoomID:  roomID,
+			IP:      client.IP,
+			Details: map[string]interface{}{"guest": true, "name": client.DisplayName},
+		})
+		log.Printf("Guest %s (%s) joined room %s\n", client.ID, client.DisplayName, roomID)
+		x, y := client.position()
+		wsManager.BroadcastToRoom(roomID, fmt.Sprintf("%s (guest) joined the room at %d,%d", client.DisplayName, x, y))
+		wsManager.SendToRoom(roomID, "user-profile", client.publicProfile())
+		return true
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		log.Printf("Error decoding response: %v", err)
+	access, err := fetchRoomAccess(client.ID, roomID)
+	if err != nil {
+		log.Printf("Error checking room access: %v", err)
 		return false
 	}
 
-	if !result.Success {
-		log.Printf("Error: API returned unsuccessful response")
+	log.Println("These are the rooms:", access.Rooms)
+
+	if access.Banned {
+		log.Printf("User %s is banned from room %s\n", client.ID, roomID)
 		return false
 	}
 
-	log.Println("These are the rooms:", result.Rooms)
-
-	rooms := result.Rooms
-
-	isAuthorized := false
-
-	for _, room := range rooms {
-		if room == roomID {
-			isAuthorized = true
-			break
-		}
-	}
+	role, isAuthorized := roles.Parse(access.Role)
 
 	if !isAuthorized {
 		return false
 	}
 
+	client.setRole(role)
 	wsManager.AddUser(client, roomID)
+	recordAudit(types.AuditEvent{Action: types.AuditRoomJoin, Actor: client.ID, RoomID: roomID, IP: client.IP})
 	log.Printf("User %s joined room %s\n", client.ID, roomID)
-	wsManager.BroadcastToRoom(roomID, fmt.Sprintf("%s joined the room at %d,%d", client.ID, client.X, client.Y))
+	profile := client.publicProfile()
+	name := profile.DisplayName
+	if client.Bot {
+		name += " (bot)"
+	}
+	x, y := client.position()
+	wsManager.BroadcastToRoom(roomID, fmt.Sprintf("%s joined the room at %d,%d", name, x, y))
+	wsManager.SendToRoom(roomID, "user-profile", profile)
 	return true
 }
 
 func handleLeaveRoom(wsManager *WebSocketManager, client *Client, roomID string) bool {
 	log.Printf("User %s left room %s\n", client.ID, roomID)
+	if client.Role() != "" {
+		recordAudit(types.AuditEvent{Action: types.AuditRoomLeave, Actor: client.ID, RoomID: roomID, IP: client.IP})
+		roomEvent(client, roomID, plugin.EventLeave, nil)
+		client.setRole("")
+	}
 	wsManager.RemoveUser(client.ID, roomID)
-	wsManager.BroadcastToRoom(roomID, fmt.Sprintf("%s left the room", client.ID))
+	wsManager.BroadcastToRoom(roomID, fmt.Sprintf("%s left the room", client.publicProfile().DisplayName))
 	return true
 }
 
 func handleMove(wsManager *WebSocketManager, client *Client, roomID string, moveData types.MoveData) bool {
 	log.Printf("User %s moved in room %s\n", client.ID, roomID)
 
-	xDisplacement := math.Abs(float64(client.X - moveData.X))
-	yDisplacement := math.Abs(float64(client.Y - moveData.Y))
-
-	if xDisplacement+yDisplacement > 1 {
+	if !client.step(moveData.X, moveData.Y) {
 		log.Println("Invalid move")
 		return false
 	}
 
-	client.X = moveData.X
-	client.Y = moveData.Y
 	wsManager.BroadcastMove(client, roomID)
+	roomEvent(client, roomID, plugin.EventMove, nil)
 	return true
 }
@@ -0,0 +1,295 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"go-gather/auth"
+	"go-gather/types"
+)
+
+// The ws server keeps no user data of its own; it asks the HTTP service.
+const authServiceURL = "http://localhost:3000"
+
+var authHTTPClient = &http.Client{Timeout: 5 * time.Second}
+
+type roomAccess struct {
+	EmailAddress string   `json:"emailAddress"`
+	Rooms        []string `json:"rooms"`
+	Role         string   `json:"role"`
+	Banned       bool     `json:"banned"`
+	Success      bool     `json:"success"`
+}
+
+func fetchRoomAccess(email, roomID string) (*roomAccess, error) {
+	path := fmt.Sprintf("/authenticate?email=%s&roomId=%s", url.QueryEscape(email), url.QueryEscape(roomID))
+
+	var result roomAccess
+	if err := callInternal(http.MethodGet, path, nil, &result); err != nil {
+		return nil, fmt.Errorf("calling authenticate endpoint: %w", err)
+	}
+
+	if !result.Success {
+		return nil, fmt.Errorf("authenticate endpoint returned unsuccessful response")
+	}
+
+	return &result, nil
+}
+
+type guestAccess struct {
+	GuestAccess bool `json:"guestAccess"`
+	Banned      bool `json:"banned"`
+}
+
+// fetchGuestAccess asks whether the room still lets guests in, and whether
+// this one was banned.
+func fetchGuestAccess(roomID, guestID string) (*guestAccess, error) {
+	var result guestAccess
+	path := fmt.Sprintf("/rooms/%s/guests/%s", url.PathEscape(roomID), url.PathEscape(guestID))
+	if err := callInternal(http.MethodGet, path, nil, &result); err != nil {
+		return nil, fmt.Errorf("checking guest access: %w", err)
+	}
+	return &result, nil
+}
+
+type accessTokenIdentity struct {
+	Email string `json:"email"`
+	Bot   bool   `json:"bot"`
+}
+
+// verifyAccessToken asks the HTTP service who a personal access token
+// belongs to, and whether it may join the room.
+func verifyAccessToken(token, roomID string) (*accessTokenIdentity, error) {
+	var result accessTokenIdentity
+	payload := map[string]string{"token": token, "roomId": roomID}
+	if err := postInternal("/access-tokens/verify", payload, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// postInternal sends a write-back to one of the HTTP service's internal
+// endpoints. When result is not nil the response body is decoded into it.
+func postInternal(path string, payload, result interface{}) error {
+	return callInternal(http.MethodPost, path, payload, result)
+}
+
+// callInternal is postInternal for any method; a nil payload sends no body.
+func callInternal(method, path string, payload, result interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequest(method, authServiceURL+"/internal"+path, body)
+	if err != nil {
+		return err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set(auth.InternalTokenHeader, auth.InternalToken())
+
+	resp, err := authHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		failure := &internalError{Method: method, Path: path, Status: resp.StatusCode}
+		var body struct {
+			Message string `json:"message"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&body) == nil {
+			failure.Message = body.Message
+		}
+		return failure
+	}
+	if result != nil {
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+	return nil
+}
+
+// internalError is a non-2xx answer from an internal endpoint. Message is
+// the service's explanation, when it gave one.
+type internalError struct {
+	Method  string
+	Path    string
+	Status  int
+	Message string
+}
+
+func (e *internalError) Error() string {
+	return fmt.Sprintf("%s %s returned status %d", e.Method, e.Path, e.Status)
+}
+
+// rejection returns the reason the HTTP service refused content, such as a
+// message caught by the room's filters, so it can be shown to the sender.
+func rejection(err error) (string, bool) {
+	var failure *internalError
+	if errors.As(err, &failure) && failure.Status == http.StatusUnprocessableEntity && failure.Message != "" {
+		return failure.Message, true
+	}
+	return "", false
+}
+
+func recordBan(ban types.Ban) error {
+	err := postInternal(fmt.Sprintf("/rooms/%s/bans", url.PathEscape(ban.RoomID)), ban, nil)
+	if err != nil {
+		log.Println("Error recording ban:", err)
+	}
+	return err
+}
+
+// recordAudit ships the event to the audit log in the background so a slow
+// auth service never stalls the read loop.
+func recordAudit(event types.AuditEvent) {
+	go func() {
+		if err := postInternal("/audit", event, nil); err != nil {
+			log.Printf("Error recording %s audit event: %v", event.Action, err)
+		}
+	}()
+}
+
+// saveChatMessage persists the message and returns it with the ID and
+// timestamp the store assigned.
+func saveChatMessage(message types.ChatMessage) (*types.ChatMessage, error) {
+	var result struct {
+		ChatMessage types.ChatMessage `json:"chatMessage"`
+	}
+	err := postInternal(fmt.Sprintf("/rooms/%s/messages", url.PathEscape(message.RoomID)), message, &result)
+	if err != nil {
+		return nil, fmt.Errorf("saving chat message: %w", err)
+	}
+	return &result.ChatMessage, nil
+}
+
+type chatPage struct {
+	Channel     string              `json:"channel"`
+	Messages    []types.ChatMessage `json:"messages"`
+	NextCursor  string              `json:"nextCursor"`
+	ReadMarkers []types.ReadMarker  `json:"readMarkers,omitempty"`
+	// Profiles is filled in by the ws server, keyed by public ID.
+	Profiles map[string]types.Profile `json:"profiles,omitempty"`
+}
+
+func fetchChatHistory(roomID, channel, replyTo, before string, limit int) (*chatPage, error) {
+	query := url.Values{"channel": {channel}, "replyTo": {replyTo}, "before": {before}}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	var page chatPage
+	path := fmt.Sprintf("/rooms/%s/messages?%s", url.PathEscape(roomID), query.Encode())
+	if err := callInternal(http.MethodGet, path, nil, &page); err != nil {
+		return nil, fmt.Errorf("fetching chat history: %w", err)
+	}
+	return &page, nil
+}
+
+func messagePath(roomID, messageID string) string {
+	return fmt.Sprintf("/rooms/%s/messages/%s", url.PathEscape(roomID), url.PathEscape(messageID))
+}
+
+// changeChatMessage runs one of the message endpoints that answer with the
+// message. GET fetches it; PATCH and DELETE edit and delete it.
+func changeChatMessage(method, roomID, messageID string, payload interface{}) (*types.ChatMessage, error) {
+	var result struct {
+		ChatMessage types.ChatMessage `json:"chatMessage"`
+	}
+	if err := callInternal(method, messagePath(roomID, messageID), payload, &result); err != nil {
+		return nil, err
+	}
+	return &result.ChatMessage, nil
+}
+
+func reactToChatMessage(roomID, messageID, userID, emoji string, remove bool) ([]types.Reaction, error) {
+	payload := map[string]interface{}{"userId": userID, "emoji": emoji, "remove": remove}
+
+	var result struct {
+		Reactions []types.Reaction `json:"reactions"`
+	}
+	if err := postInternal(messagePath(roomID, messageID)+"/reactions", payload, &result); err != nil {
+		return nil, err
+	}
+	return result.Reactions, nil
+}
+
+func saveReadMarker(marker types.ReadMarker) (*types.ReadMarker, error) {
+	var result struct {
+		ReadMarker types.ReadMarker `json:"readMarker"`
+	}
+	err := postInternal(fmt.Sprintf("/rooms/%s/read-markers", url.PathEscape(marker.RoomID)), marker, &result)
+	if err != nil {
+		return nil, fmt.Errorf("saving read marker: %w", err)
+	}
+	return &result.ReadMarker, nil
+}
+
+type sentDirectMessage struct {
+	ConversationID string            `json:"conversationId"`
+	ChatMessage    types.ChatMessage `json:"chatMessage"`
+}
+
+func sendDirectMessage(senderID string, data types.DirectMessageData) (*sentDirectMessage, error) {
+	payload := struct {
+		SenderID string `json:"senderId"`
+		types.DirectMessageData
+	}{senderID, data}
+
+	var result sentDirectMessage
+	if err := postInternal("/direct-messages", payload, &result); err != nil {
+		return nil, fmt.Errorf("sending direct message: %w", err)
+	}
+	return &result, nil
+}
+
+func objectPath(roomID, objectID string) string {
+	return fmt.Sprintf("/rooms/%s/objects/%s", url.PathEscape(roomID), url.PathEscape(objectID))
+}
+
+func fetchRoomObjects(roomID string) ([]types.RoomObject, error) {
+	var result struct {
+		Objects []types.RoomObject `json:"objects"`
+	}
+	path := fmt.Sprintf("/rooms/%s/objects", url.PathEscape(roomID))
+	if err := callInternal(http.MethodGet, path, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Objects, nil
+}
+
+func fetchRoomObject(roomID, objectID string) (*types.RoomObject, error) {
+	var result struct {
+		Object types.RoomObject `json:"object"`
+	}
+	if err := callInternal(http.MethodGet, objectPath(roomID, objectID), nil, &result); err != nil {
+		return nil, err
+	}
+	return &result.Object, nil
+}
+
+func saveObjectState(object *types.RoomObject) (*types.RoomObject, error) {
+	var result struct {
+		Object types.RoomObject `json:"object"`
+	}
+	payload := map[string]interface{}{"state": object.State}
+	if err := callInternal(http.MethodPut, objectPath(object.RoomID, object.ID)+"/state", payload, &result); err != nil {
+		return nil, err
+	}
+	return &result.Object, nil
+}
@@ -0,0 +1,298 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"go-gather/plugin"
+	"go-gather/roles"
+	"go-gather/types"
+)
+
+const (
+	maxChatMessageLength = 2000
+	// joinHistorySize is how many room messages come with user-joined.
+	joinHistorySize = 50
+	// maxEmojiLength is in bytes; enough for flags and skin tone variants.
+	maxEmojiLength = 32
+	// maxChatAttachments matches the limit the HTTP service enforces.
+	maxChatAttachments = 10
+)
+
+// parseChatData accepts both the plain string payload older clients send
+// and the ChatData object.
+func parseChatData(payload interface{}) (types.ChatData, bool) {
+	if body, ok := payload.(string); ok {
+		return types.ChatData{Body: body}, true
+	}
+
+	var data types.ChatData
+	dataBytes, _ := json.Marshal(payload)
+	if err := json.Unmarshal(dataBytes, &data); err != nil {
+		return data, false
+	}
+	return data, true
+}
+
+func handleSendMessage(req *eventRequest, data types.ChatData) types.Response {
+	wsManager, client, roomID := req.wsManager, req.client, req.roomID
+	if wsManager.IsMuted(roomID, client.ID) {
+		return chatError("You are muted in this room")
+	}
+
+	data.Body = strings.TrimSpace(data.Body)
+	if len(data.Attachments) > maxChatAttachments {
+		return chatError(fmt.Sprintf("Messages are limited to %d attachments", maxChatAttachments))
+	}
+	// A message that only shares files needs no text.
+	if data.Body != "" || len(data.Attachments) == 0 {
+		if problem := checkChatBody(data.Body); problem != "" {
+			return chatError(problem)
+		}
+	}
+
+	chatMessage := types.ChatMessage{
+		RoomID:      roomID,
+		Channel:     types.ChatChannel(data),
+		SenderID:    client.ID,
+		Body:        data.Body,
+		ReplyTo:     data.ReplyTo,
+		Attachments: data.Attachments,
+	}
+	if data.ReplyTo != "" {
+		parent, err := changeChatMessage(http.MethodGet, roomID, data.ReplyTo, nil)
+		if err != nil || parent.Channel != chatMessage.Channel || parent.DeletedAt != nil {
+			return chatError("The message you replied to no longer exists")
+		}
+	}
+	if client.Guest {
+		chatMessage.SenderName = client.DisplayName
+	}
+
+	saved, err := saveChatMessage(chatMessage)
+	if reason, rejected := rejection(err); rejected {
+		return chatError(reason)
+	}
+	if err != nil {
+		log.Println("Error saving chat message:", err)
+		return chatError("Message could not be sent")
+	}
+
+	// The server does not track zones, so zone messages reach the whole
+	// room and clients filter on the channel.
+	wsManager.StopTyping(roomID, client.ID, saved.Channel)
+	public := publicChatMessage(saved)
+	wsManager.SendToRoom(roomID, "chat-message", public)
+	roomEvent(client, roomID, plugin.EventChat, public)
+
+	return types.Response{
+		Type:    "message-sent",
+		Success: true,
+		Data:    public,
+	}
+}
+
+func handleChatHistory(req *eventRequest, data types.ChatData) types.Response {
+	page, err := fetchChatHistory(req.roomID, types.ChatChannel(data), data.ReplyTo, data.Before, data.Limit)
+	if err != nil {
+		log.Println("Error loading chat history:", err)
+		return chatError("Could not load chat history")
+	}
+
+	return types.Response{
+		Type:    "chat-history",
+		Success: true,
+		Data:    publicChatPage(page),
+	}
+}
+
+// handleMessageAction edits, deletes or reacts to a room message and tells
+// the whole room. Only the sender may edit; deleting someone else's
+// message needs PermDeleteMessages and leaves a tombstone either way.
+func handleMessageAction(req *eventRequest, data types.MessageActionData) types.Response {
+	wsManager, client, roomID, message := req.wsManager, req.client, req.roomID, req.message
+	if data.MessageID == "" {
+		return chatError("messageId is required")
+	}
+
+	target, err := changeChatMessage(http.MethodGet, roomID, data.MessageID, nil)
+	if err != nil || target.DeletedAt != nil {
+		return chatError("Message not found")
+	}
+
+	// Deleting your own message is always allowed, even when muted.
+	if message.Type != "delete-message" {
+		if !client.Role().Can(roles.PermChat) {
+			return permissionDenied(roles.PermChat)
+		}
+		if wsManager.IsMuted(roomID, client.ID) {
+			return chatError("You are muted in this room")
+		}
+	}
+
+	switch message.Type {
+	case "edit-message":
+		if target.SenderID != client.ID {
+			return chatError("You can only edit your own messages")
+		}
+		body := strings.TrimSpace(data.Body)
+		if problem := checkChatBody(body); problem != "" {
+			return chatError(problem)
+		}
+
+		edited, err := changeChatMessage(http.MethodPatch, roomID, target.ID, map[string]string{"body": body})
+		if reason, rejected := rejection(err); rejected {
+			return chatError(reason)
+		}
+		if err != nil {
+			log.Println("Error editing message:", err)
+			return chatError("Message could not be edited")
+		}
+		wsManager.SendToRoom(roomID, "message-edited", publicChatMessage(edited))
+
+	case "delete-message":
+		ownMessage := target.SenderID == client.ID
+		if !ownMessage && !client.Role().Can(roles.PermDeleteMessages) {
+			return permissionDenied(roles.PermDeleteMessages)
+		}
+
+		deleted, err := changeChatMessage(http.MethodDelete, roomID, target.ID, nil)
+		if err != nil {
+			log.Println("Error deleting message:", err)
+			return chatError("Message could not be deleted")
+		}
+		if !ownMessage {
+			recordAudit(types.AuditEvent{
+				Action:  types.AuditMessageDeleted,
+				Actor:   client.ID,
+				Target:  target.SenderID,
+				RoomID:  roomID,
+				IP:      client.IP,
+				Details: map[string]interface{}{"messageId": target.ID, "body": target.Body},
+			})
+		}
+		wsManager.SendToRoom(roomID, "message-deleted", publicChatMessage(deleted))
+
+	case "react", "unreact":
+		if data.Emoji == "" || len(data.Emoji) > maxEmojiLength || strings.ContainsAny(data.Emoji, " \t\n") {
+			return chatError("Invalid emoji")
+		}
+
+		reactions, err := reactToChatMessage(roomID, target.ID, client.ID, data.Emoji, message.Type == "unreact")
+		if err != nil {
+			log.Println("Error saving reaction:", err)
+			return chatError("Reaction could not be saved")
+		}
+		var users []string
+		for _, reaction := range reactions {
+			users = append(users, reaction.Users...)
+		}
+		wsManager.SendToRoom(roomID, "reaction-updated", map[string]interface{}{
+			"messageId": target.ID,
+			"reactions": publicReactions(reactions, publicIDs(users)),
+		})
+	}
+
+	// The room event doubles as the answer to the client.
+	return types.Response{}
+}
+
+// handleSendDirectMessage hands the message to the HTTP service, which
+// stores it and notifies the recipients with dm-received wherever they
+// are connected. Direct messages belong to accounts, so guests cannot
+// send them.
+func handleSendDirectMessage(req *eventRequest, data types.DirectMessageData) types.Response {
+	client := req.client
+	if client.Guest {
+		return chatError("Guests cannot send direct messages")
+	}
+
+	if data.ConversationID == "" && data.To == "" {
+		return chatError("conversationId or to is required")
+	}
+	// People met in a room are only known by their profile ID.
+	if data.To != "" && !isAccountID(data.To) {
+		email, ok := profiles.emailOf(data.To)
+		if !ok {
+			return chatError("User not found")
+		}
+		data.To = email
+	}
+	if data.To == client.ID {
+		return chatError("You cannot message yourself")
+	}
+	data.Body = strings.TrimSpace(data.Body)
+	if problem := checkChatBody(data.Body); problem != "" {
+		return chatError(problem)
+	}
+
+	sent, err := sendDirectMessage(client.ID, data)
+	if err != nil {
+		log.Println("Error sending direct message:", err)
+		return chatError("Message could not be sent")
+	}
+
+	return types.Response{
+		Type:    "dm-sent",
+		Success: true,
+		Data:    sent,
+	}
+}
+
+// handleMarkRead moves the client's read marker in a room channel and
+// shows the room the receipt. Markers never move backwards, so the
+// receipt carries whatever ends up stored.
+func handleMarkRead(req *eventRequest, data types.MessageActionData) types.Response {
+	wsManager, client, roomID := req.wsManager, req.client, req.roomID
+	if data.MessageID == "" {
+		return chatError("messageId is required")
+	}
+
+	marker, err := saveReadMarker(types.ReadMarker{
+		RoomID:    roomID,
+		Channel:   types.ChatChannel(types.ChatData{Zone: data.Zone}),
+		UserID:    client.ID,
+		MessageID: data.MessageID,
+	})
+	if err != nil {
+		log.Println("Error saving read marker:", err)
+		return chatError("Could not mark messages read")
+	}
+
+	wsManager.SendToRoom(roomID, "read-receipt", publicReadMarker(marker))
+	return types.Response{}
+}
+
+// recentRoomMessages is the chat part of the join snapshot: the latest
+// room messages and everyone's read markers. A failure only costs the
+// client its backlog, so it is logged rather than returned.
+func recentRoomMessages(roomID string) *chatPage {
+	page, err := fetchChatHistory(roomID, types.RoomChannel, "", "", joinHistorySize)
+	if err != nil {
+		log.Println("Error loading chat history for join:", err)
+		return &chatPage{Messages: []types.ChatMessage{}, ReadMarkers: []types.ReadMarker{}}
+	}
+	return page
+}
+
+func checkChatBody(body string) string {
+	if body == "" {
+		return "Message cannot be empty"
+	}
+	if utf8.RuneCountInString(body) > maxChatMessageLength {
+		return "Message is too long"
+	}
+	return ""
+}
+
+func chatError(message string) types.Response {
+	return types.Response{
+		Type:    "error",
+		Success: false,
+		Error:   message,
+	}
+}
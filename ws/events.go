@@ -0,0 +1,325 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"go-gather/auth"
+	"go-gather/roles"
+	"go-gather/types"
+)
+
+// eventRequest is one message from a client on its way to its handler.
+type eventRequest struct {
+	wsManager *WebSocketManager
+	client    *Client
+	roomID    string
+	message   types.Message
+}
+
+// eventHandler answers a message. A response without a Type sends nothing
+// back, for events whose answer goes to the room instead.
+type eventHandler func(req *eventRequest) types.Response
+
+// eventSpec describes a message type clients may send. The checks it
+// asks for are made by the middlewares before the handler runs.
+type eventSpec struct {
+	Type string
+	// Permission is what the client's role needs, if anything.
+	Permission roles.Permission
+	// Joined events are refused until the client has joined the room.
+	Joined bool
+	// RateClass names the bucket in defaultRateLimits the event draws
+	// from; events without one share "*".
+	RateClass string
+	// Bots may only send events that allow them: enough to chat and walk
+	// around. Everything sent to the room still reaches them.
+	Bots bool
+	// Handle usually comes from withPayload, which decodes the data first.
+	Handle eventHandler
+}
+
+// eventMiddleware wraps the handler of every event; see eventMiddlewares.
+type eventMiddleware func(spec eventSpec, next eventHandler) eventHandler
+
+// eventMiddlewares run in order around every handler, so the first one
+// sees everything and the last one only what got past the others.
+var eventMiddlewares = []eventMiddleware{logEvents, measureEvents, authorizeEvents}
+
+type registeredEvent struct {
+	spec   eventSpec
+	handle eventHandler
+}
+
+var eventHandlers = make(map[string]*registeredEvent)
+
+// registerEvent adds a message type. Like plugin.Register it is meant for
+// init functions and panics on mistakes.
+func registerEvent(spec eventSpec) {
+	if spec.Type == "" || spec.Handle == nil {
+		panic("ws: event needs a type and a handler")
+	}
+	if _, exists := eventHandlers[spec.Type]; exists {
+		panic(fmt.Sprintf("ws: event %q registered twice", spec.Type))
+	}
+
+	handle := spec.Handle
+	for i := len(eventMiddlewares) - 1; i >= 0; i-- {
+		handle = eventMiddlewares[i](spec, handle)
+	}
+	eventHandlers[spec.Type] = &registeredEvent{spec: spec, handle: handle}
+}
+
+// rateClass names the bucket a message of the type draws from. Unknown
+// types share "*" so that made-up ones cannot grow the limiter.
+func rateClass(messageType string) string {
+	if event, ok := eventHandlers[messageType]; ok && event.spec.RateClass != "" {
+		return event.spec.RateClass
+	}
+	return "*"
+}
+
+func handleEvents(wsManager *WebSocketManager, client *Client, roomID string, message types.Message) {
+	event, ok := eventHandlers[message.Type]
+	if !ok {
+		log.Printf("Unknown message type %q from user: %s\n", message.Type, client.ID)
+		client.SendMessage("error", types.Response{
+			Type:    "error",
+			Success: false,
+			Error:   "Unknown event type",
+		})
+		return
+	}
+
+	req := &eventRequest{wsManager: wsManager, client: client, roomID: roomID, message: message}
+	if response := event.handle(req); response.Type != "" {
+		client.SendMessage(response.Type, response)
+	}
+}
+
+// withPayload decodes the message data into T before calling handle. Data
+// that does not fit is answered with invalid, or not at all when invalid
+// has no Type.
+func withPayload[T any](invalid types.Response, handle func(req *eventRequest, data T) types.Response) eventHandler {
+	return decodedBy(func(payload interface{}) (T, error) {
+		var data T
+		dataBytes, _ := json.Marshal(payload)
+		err := json.Unmarshal(dataBytes, &data)
+		return data, err
+	}, invalid, handle)
+}
+
+// withChatPayload is withPayload for the chat events, which also take the
+// plain string older clients send.
+func withChatPayload(invalid types.Response, handle func(req *eventRequest, data types.ChatData) types.Response) eventHandler {
+	return decodedBy(func(payload interface{}) (types.ChatData, error) {
+		data, ok := parseChatData(payload)
+		if !ok {
+			return data, fmt.Errorf("not chat data")
+		}
+		return data, nil
+	}, invalid, handle)
+}
+
+func decodedBy[T any](decode func(interface{}) (T, error), invalid types.Response, handle func(req *eventRequest, data T) types.Response) eventHandler {
+	return func(req *eventRequest) types.Response {
+		data, err := decode(req.message.Data)
+		if err != nil {
+			log.Printf("Invalid %s data from %s: %v\n", req.message.Type, req.client.ID, err)
+			return invalid
+		}
+		return handle(req, data)
+	}
+}
+
+func logEvents(spec eventSpec, next eventHandler) eventHandler {
+	return func(req *eventRequest) types.Response {
+		log.Printf("Received message type: %s from user: %s\n", spec.Type, req.client.ID)
+		return next(req)
+	}
+}
+
+// authorizeEvents makes the checks the spec asks for.
+func authorizeEvents(spec eventSpec, next eventHandler) eventHandler {
+	return func(req *eventRequest) types.Response {
+		client := req.client
+		if client.Bot && !spec.Bots {
+			return types.Response{
+				Type:    "error",
+				Success: false,
+				Error:   fmt.Sprintf("Bots cannot send %s events", spec.Type),
+			}
+		}
+		if spec.Joined && client.Role() == "" {
+			return chatError("Join the room first")
+		}
+		if spec.Permission != "" && !client.Role().Can(spec.Permission) {
+			return permissionDenied(spec.Permission)
+		}
+		return next(req)
+	}
+}
+
+// eventStats counts what happened to one message type since the server
+// started. Failed covers every answer with Success false, refusals by the
+// middlewares included.
+type eventStats struct {
+	Handled int64   `json:"handled"`
+	Failed  int64   `json:"failed"`
+	TotalMs float64 `json:"totalMs"`
+	MaxMs   float64 `json:"maxMs"`
+}
+
+var (
+	eventMetricsLock sync.Mutex
+	eventMetrics     = make(map[string]*eventStats)
+)
+
+func measureEvents(spec eventSpec, next eventHandler) eventHandler {
+	return func(req *eventRequest) types.Response {
+		start := time.Now()
+		response := next(req)
+		elapsed := float64(time.Since(start).Microseconds()) / 1000
+
+		eventMetricsLock.Lock()
+		defer eventMetricsLock.Unlock()
+		stats, ok := eventMetrics[spec.Type]
+		if !ok {
+			stats = &eventStats{}
+			eventMetrics[spec.Type] = stats
+		}
+		stats.Handled++
+		if response.Type != "" && !response.Success {
+			stats.Failed++
+		}
+		stats.TotalMs += elapsed
+		if elapsed > stats.MaxMs {
+			stats.MaxMs = elapsed
+		}
+		return response
+	}
+}
+
+// HandleEventMetrics reports the eventStats of every message type. Like
+// HandleNotify it only answers internal callers.
+func HandleEventMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !auth.IsInternal(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	eventMetricsLock.Lock()
+	snapshot := make(map[string]eventStats, len(eventMetrics))
+	for messageType, stats := range eventMetrics {
+		snapshot[messageType] = *stats
+	}
+	eventMetricsLock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"events": snapshot})
+}
+
+func init() {
+	registerEvent(eventSpec{Type: "join", RateClass: "join", Bots: true, Handle: handleJoinEvent})
+	registerEvent(eventSpec{Type: "leave-room", Joined: true, Bots: true, Handle: handleLeaveEvent})
+	// Switching rooms is a join as far as the limits are concerned.
+	registerEvent(eventSpec{
+		Type:      "switch-room",
+		Joined:    true,
+		RateClass: "join",
+		Bots:      true,
+		Handle:    withPayload(switchError("Invalid switch-room data"), handleSwitchRoom),
+	})
+	registerEvent(eventSpec{
+		Type:      "move",
+		Joined:    true,
+		RateClass: "move",
+		Bots:      true,
+		Handle:    withPayload(chatError("Invalid move data"), handleMoveEvent),
+	})
+
+	registerEvent(eventSpec{
+		Type:       "send-message",
+		Permission: roles.PermChat,
+		RateClass:  "send-message",
+		Bots:       true,
+		Handle:     withChatPayload(chatError("Invalid message format"), handleSendMessage),
+	})
+	registerEvent(eventSpec{
+		Type:      "chat-history",
+		Joined:    true,
+		RateClass: "chat-history",
+		Bots:      true,
+		Handle:    withChatPayload(chatError("Invalid history request"), handleChatHistory),
+	})
+	for _, messageType := range []string{"typing-start", "typing-stop"} {
+		registerEvent(eventSpec{
+			Type:       messageType,
+			Permission: roles.PermChat,
+			RateClass:  messageType,
+			Handle:     withChatPayload(chatError("Invalid typing data"), handleTyping),
+		})
+	}
+	registerEvent(eventSpec{
+		Type:   "mark-read",
+		Joined: true,
+		Handle: withPayload(chatError("messageId is required"), handleMarkRead),
+	})
+	for _, messageType := range []string{"edit-message", "delete-message", "react", "unreact"} {
+		registerEvent(eventSpec{
+			Type:      messageType,
+			Joined:    true,
+			RateClass: messageType,
+			Bots:      true,
+			Handle:    withPayload(chatError("messageId is required"), handleMessageAction),
+		})
+	}
+	// Direct messages are sent as the verified account, and only once the
+	// client has been let into its room, so a ban there also stops them.
+	registerEvent(eventSpec{
+		Type:      "send-dm",
+		Joined:    true,
+		RateClass: "send-dm",
+		Handle:    withPayload(chatError("Invalid direct message"), handleSendDirectMessage),
+	})
+
+	for messageType, permission := range map[string]roles.Permission{
+		"kick":      roles.PermKick,
+		"ban":       roles.PermKick,
+		"mute-chat": roles.PermMute,
+	} {
+		registerEvent(eventSpec{
+			Type:       messageType,
+			Permission: permission,
+			Handle:     withPayload(moderationError("Invalid moderation data"), handleModeration),
+		})
+	}
+
+	registerEvent(eventSpec{
+		Type:      "object-interact",
+		Joined:    true,
+		RateClass: "object-interact",
+		Handle:    withPayload(objectError("Invalid interaction"), handleObjectInteract),
+	})
+
+	// Signaling gets no answer, not even to bad data.
+	for _, messageType := range []string{"webrtc-offer", "webrtc-answer", "webrtc-candidate"} {
+		spec := eventSpec{
+			Type:       messageType,
+			Permission: roles.PermPublishMedia,
+			Handle:     withPayload(types.Response{}, handleWebRTCSignaling),
+		}
+		if messageType == "webrtc-candidate" {
+			spec.RateClass = "webrtc-candidate"
+		}
+		registerEvent(spec)
+	}
+}
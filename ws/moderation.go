@@ -0,0 +1,157 @@
+package ws
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"go-gather/roles"
+	"go-gather/types"
+)
+
+const defaultMuteDuration = 5 * time.Minute
+
+func handleModeration(req *eventRequest, data types.ModerationData) types.Response {
+	wsManager, client, roomID, message := req.wsManager, req.client, req.roomID, req.message
+	if data.UserID == "" {
+		return moderationError("Invalid moderation data")
+	}
+	// Clients name the target by profile ID; the rest of this works on
+	// the account behind it.
+	data.UserID = resolveUserID(data.UserID)
+	if data.Duration < 0 {
+		return moderationError("Duration cannot be negative")
+	}
+	if data.UserID == client.ID {
+		return moderationError("You cannot moderate yourself")
+	}
+
+	target := wsManager.GetClientInRoom(roomID, data.UserID)
+	if target != nil && target.Role() == "" {
+		// Connected but never joined; treat as absent.
+		target = nil
+	}
+
+	// Bans also apply to users who are not connected right now, so look up
+	// their role with the auth service to keep the rank check honest.
+	var targetRole roles.Role
+	if target != nil {
+		targetRole = target.Role()
+	} else if message.Type == "ban" {
+		access, err := fetchRoomAccess(data.UserID, roomID)
+		if err != nil {
+			log.Printf("Error checking role of %s: %v", data.UserID, err)
+			return moderationError("Could not look up the target user")
+		}
+		targetRole = roles.Role(access.Role)
+	} else {
+		return moderationError("User is not in this room")
+	}
+
+	if !client.Role().Outranks(targetRole) {
+		return moderationError("You cannot moderate this user")
+	}
+
+	switch message.Type {
+	case "kick":
+		kick(wsManager, target, "kicked", client, data.Reason)
+		recordModerationAudit(types.AuditKick, client, data, roomID, nil)
+		return moderationResult("user-kicked", roomID, data, nil)
+
+	case "ban":
+		// Guests are banned by the subject of their token, which stays
+		// the same until it expires, so reconnecting with it fails too.
+		var expiresAt *time.Time
+		if data.Duration > 0 {
+			until := time.Now().Add(time.Duration(data.Duration) * time.Second)
+			expiresAt = &until
+		}
+		ban := types.Ban{
+			RoomID:    roomID,
+			UserID:    data.UserID,
+			BannedBy:  client.ID,
+			Reason:    data.Reason,
+			ExpiresAt: expiresAt,
+		}
+		if err := recordBan(ban); err != nil {
+			return moderationError("Failed to save ban")
+		}
+		if target != nil {
+			kick(wsManager, target, "banned", client, data.Reason)
+		}
+		recordModerationAudit(types.AuditBan, client, data, roomID, expiresAt)
+		return moderationResult("user-banned", roomID, data, expiresAt)
+
+	case "mute-chat":
+		duration := defaultMuteDuration
+		if data.Duration > 0 {
+			duration = time.Duration(data.Duration) * time.Second
+		}
+		until := time.Now().Add(duration)
+		wsManager.MuteUser(roomID, data.UserID, until)
+		target.SendMessage("muted", map[string]interface{}{
+			"roomId": roomID,
+			"by":     client.publicID(),
+			"reason": data.Reason,
+			"until":  until,
+		})
+		recordModerationAudit(types.AuditMuteChat, client, data, roomID, &until)
+		return moderationResult("user-muted", roomID, data, &until)
+	}
+
+	return moderationError("Unknown moderation action")
+}
+
+// kick tells the target why they are being removed, then drops their
+// connection and peer connection and lets the room know.
+func kick(wsManager *WebSocketManager, target *Client, eventType string, by *Client, reason string) {
+	moderator := by.publicProfile()
+	roomID := target.room()
+	target.SendMessage(eventType, map[string]string{
+		"roomId": roomID,
+		"by":     moderator.ID,
+		"reason": reason,
+	})
+	name := target.publicProfile().DisplayName
+	wsManager.Disconnect(target)
+	wsManager.BroadcastToRoom(roomID, fmt.Sprintf("%s was %s by %s", name, eventType, moderator.DisplayName))
+}
+
+func recordModerationAudit(action string, actor *Client, data types.ModerationData, roomID string, until *time.Time) {
+	details := map[string]interface{}{"reason": data.Reason}
+	if until != nil {
+		details["until"] = until
+	}
+	recordAudit(types.AuditEvent{
+		Action:  action,
+		Actor:   actor.ID,
+		Target:  data.UserID,
+		RoomID:  roomID,
+		IP:      actor.IP,
+		Details: details,
+	})
+}
+
+func moderationResult(eventType, roomID string, data types.ModerationData, until *time.Time) types.Response {
+	result := map[string]interface{}{
+		"userId": publicID(data.UserID),
+		"roomId": roomID,
+		"reason": data.Reason,
+	}
+	if until != nil {
+		result["until"] = until
+	}
+	return types.Response{
+		Type:    eventType,
+		Success: true,
+		Data:    result,
+	}
+}
+
+func moderationError(message string) types.Response {
+	return types.Response{
+		Type:    "moderation-failed",
+		Success: false,
+		Error:   message,
+	}
+}
@@ -0,0 +1,126 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"go-gather/auth"
+	"go-gather/roles"
+	"go-gather/types"
+)
+
+// HandleNotify accepts notifications from the HTTP service and relays them
+// to connected clients. It is an internal endpoint and only answers
+// requests carrying INTERNAL_TOKEN from the same host.
+func HandleNotify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !auth.IsInternal(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var notification types.Notification
+	if err := json.NewDecoder(r.Body).Decode(&notification); err != nil || notification.Type == "" {
+		http.Error(w, "Invalid notification", http.StatusBadRequest)
+		return
+	}
+
+	switch notification.Type {
+	case "profile-updated":
+		// Goes to the user's rooms rather than the user.
+		applyProfileUpdate(wsManager, notification)
+		w.WriteHeader(http.StatusOK)
+		return
+	case "message-deleted":
+		var message types.ChatMessage
+		dataBytes, _ := json.Marshal(notification.Data)
+		if err := json.Unmarshal(dataBytes, &message); err == nil {
+			notification.Data = publicChatMessage(&message)
+		}
+	case "member-added", "dm-received", "read-receipt", "review-queued":
+		notification.Data = publicNotificationData(notification.Type, notification.Data)
+	}
+
+	delivered := deliverNotification(wsManager, notification)
+	log.Printf("Delivered %s notification to %d clients\n", notification.Type, delivered)
+
+	if notification.Type == "guest-access-revoked" {
+		wsManager.DisconnectGuests(notification.RoomID)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// publicNotificationData swaps the emails the HTTP service names users by
+// for public IDs. Data that does not have the expected shape is dropped
+// rather than relayed with an email in it.
+func publicNotificationData(notificationType string, data interface{}) interface{} {
+	var fields map[string]interface{}
+	dataBytes, _ := json.Marshal(data)
+	if err := json.Unmarshal(dataBytes, &fields); err != nil {
+		return nil
+	}
+
+	switch notificationType {
+	case "member-added":
+		email, _ := fields["email"].(string)
+		delete(fields, "email")
+		profile := profiles.lookup([]string{email})[email]
+		fields["userId"] = profile.ID
+		fields["profile"] = profile
+	case "dm-received":
+		var message types.ChatMessage
+		messageBytes, _ := json.Marshal(fields["message"])
+		if err := json.Unmarshal(messageBytes, &message); err != nil {
+			return nil
+		}
+		fields["message"] = publicChatMessage(&message)
+	case "read-receipt":
+		userID, _ := fields["userId"].(string)
+		fields["userId"] = publicID(userID)
+	case "review-queued":
+		senderID, _ := fields["senderId"].(string)
+		fields["senderId"] = publicID(senderID)
+		if reviewedBy, ok := fields["reviewedBy"].(string); ok {
+			fields["reviewedBy"] = publicID(reviewedBy)
+		}
+	}
+	return fields
+}
+
+func deliverNotification(wsManager *WebSocketManager, notification types.Notification) int {
+	var recipients []*Client
+
+	if len(notification.UserIDs) > 0 {
+		// Users hear about themselves in every room they are connected to.
+		for _, userID := range notification.UserIDs {
+			for _, client := range wsManager.GetClientsByID(userID) {
+				if client.Role() != "" {
+					recipients = append(recipients, client)
+				}
+			}
+		}
+	} else if notification.RoomID != "" {
+		// Only clients that completed the join handshake hear room events.
+		for _, client := range wsManager.GetClientsInRoom(notification.RoomID) {
+			if client.Role() != "" {
+				recipients = append(recipients, client)
+			}
+		}
+	}
+
+	delivered := 0
+	for _, client := range recipients {
+		if notification.Permission != "" && !client.Role().Can(roles.Permission(notification.Permission)) {
+			continue
+		}
+		client.SendMessage(notification.Type, notification.Data)
+		delivered++
+	}
+	return delivered
+}
@@ -0,0 +1,275 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"sync"
+	"unicode/utf8"
+
+	"go-gather/roles"
+	"go-gather/types"
+)
+
+const (
+	// objectReach is how many tiles away, across or along, a client may
+	// stand from an object and still use it.
+	objectReach = 2
+
+	maxNoteLength        = 2000
+	maxWhiteboardStrokes = 200
+	maxStrokePoints      = 500
+	maxStrokeColorLength = 32
+	maxStrokeWidth       = 50
+)
+
+// objectAction applies an interaction to an object. It changes the
+// object's State in place and reports whether it did; the result, if
+// any, only goes back to the client, such as where a link leads. Errors
+// are shown to the client.
+type objectAction func(client *Client, object *types.RoomObject, data map[string]interface{}) (result interface{}, changed bool, err error)
+
+type objectActionSpec struct {
+	Do objectAction
+	// Permission is needed on top of standing within reach.
+	Permission roles.Permission
+	// Announce picks what the room hears about a change. Without it the
+	// room gets the whole saved object with object-updated.
+	Announce func(saved *types.RoomObject) (eventType string, payload interface{})
+}
+
+func (a objectActionSpec) allows(client *Client) bool {
+	return a.Permission == "" || client.Role().Can(a.Permission)
+}
+
+// objectActions lists what can be done with each kind of object.
+var objectActions = map[string]map[string]objectActionSpec{
+	types.ObjectLink: {"open": {Do: openLink}},
+	types.ObjectNote: {"edit": {Do: editNote}},
+	types.ObjectWhiteboard: {
+		"draw": {Do: drawOnWhiteboard, Announce: announceStroke},
+		// Wiping everyone's drawing is for those who may edit the map.
+		"clear": {Do: clearWhiteboard, Permission: roles.PermEditMap},
+	},
+	types.ObjectPortal: {"use": {Do: usePortal}},
+}
+
+// objectLocks make interactions with the same object take turns, so that
+// two people drawing at once do not lose each other's strokes. Objects
+// share a fixed set of locks by hash.
+var objectLocks [64]sync.Mutex
+
+func lockObject(objectID string) *sync.Mutex {
+	hash := fnv.New32a()
+	hash.Write([]byte(objectID))
+	lock := &objectLocks[hash.Sum32()%uint32(len(objectLocks))]
+	lock.Lock()
+	return lock
+}
+
+func withinReach(client *Client, object *types.RoomObject) bool {
+	x, y := client.position()
+	dx := x - object.X
+	dy := y - object.Y
+	return dx >= -objectReach && dx <= objectReach && dy >= -objectReach && dy <= objectReach
+}
+
+// roomObjects is the objects part of the join snapshot. Like the chat
+// backlog, a failure is logged rather than failing the join.
+func roomObjects(roomID string) []types.RoomObject {
+	objects, err := fetchRoomObjects(roomID)
+	if err != nil {
+		log.Println("Error loading room objects for join:", err)
+		return []types.RoomObject{}
+	}
+	return objects
+}
+
+// handleObjectInteract uses an object the client is standing next to.
+// Changes are saved and shown to the whole room, with object-updated or,
+// for new strokes, whiteboard-stroke; portals move the client to the room
+// they lead to.
+func handleObjectInteract(req *eventRequest, data types.ObjectInteraction) types.Response {
+	if data.ObjectID == "" {
+		return objectError("objectId is required")
+	}
+
+	result, failure := interactWithObject(req, data)
+	if failure != nil {
+		return *failure
+	}
+	if destination, ok := result.(portalDestination); ok {
+		return switchRoom(req, destination.RoomID, destination.X, destination.Y)
+	}
+
+	return types.Response{
+		Type:    "object-interacted",
+		Success: true,
+		Data: map[string]interface{}{
+			"objectId": data.ObjectID,
+			"action":   data.Action,
+			"result":   result,
+		},
+	}
+}
+
+// interactWithObject runs the action while holding the object's lock.
+func interactWithObject(req *eventRequest, data types.ObjectInteraction) (interface{}, *types.Response) {
+	wsManager, client, roomID := req.wsManager, req.client, req.roomID
+	fail := func(message string) (interface{}, *types.Response) {
+		response := objectError(message)
+		return nil, &response
+	}
+
+	lock := lockObject(data.ObjectID)
+	defer lock.Unlock()
+
+	object, err := fetchRoomObject(roomID, data.ObjectID)
+	var failure *internalError
+	if errors.As(err, &failure) && failure.Status == http.StatusNotFound {
+		return fail("Object not found")
+	}
+	if err != nil {
+		log.Println("Error loading room object:", err)
+		return fail("Object could not be loaded")
+	}
+	if !withinReach(client, object) {
+		return fail("You are too far away from this object")
+	}
+
+	action, ok := objectActions[object.Type][data.Action]
+	if !ok {
+		return fail(fmt.Sprintf("Unknown action %q for a %s", data.Action, object.Type))
+	}
+	if !action.allows(client) {
+		return fail(fmt.Sprintf("Missing permission: %s", action.Permission))
+	}
+	if object.State == nil {
+		object.State = map[string]interface{}{}
+	}
+	result, changed, err := action.Do(client, object, data.Data)
+	if err != nil {
+		return fail(err.Error())
+	}
+
+	if changed {
+		saved, err := saveObjectState(object)
+		if reason, rejected := rejection(err); rejected {
+			return fail(reason)
+		}
+		if err != nil {
+			log.Println("Error saving room object state:", err)
+			return fail("Object could not be saved")
+		}
+		if action.Announce != nil {
+			eventType, payload := action.Announce(saved)
+			wsManager.SendToRoom(roomID, eventType, payload)
+		} else {
+			wsManager.SendToRoom(roomID, "object-updated", saved)
+		}
+	}
+	return result, nil
+}
+
+func objectError(message string) types.Response {
+	return types.Response{
+		Type:    "object-interaction-failed",
+		Success: false,
+		Error:   message,
+	}
+}
+
+func openLink(client *Client, object *types.RoomObject, data map[string]interface{}) (interface{}, bool, error) {
+	return map[string]interface{}{"url": object.Properties["url"]}, false, nil
+}
+
+func editNote(client *Client, object *types.RoomObject, data map[string]interface{}) (interface{}, bool, error) {
+	text, ok := data["text"].(string)
+	if !ok {
+		return nil, false, errors.New("text is required")
+	}
+	if utf8.RuneCountInString(text) > maxNoteLength {
+		return nil, false, fmt.Errorf("Notes are limited to %d characters", maxNoteLength)
+	}
+	object.State["text"] = text
+	object.State["editedBy"] = client.publicID()
+	return nil, true, nil
+}
+
+// drawOnWhiteboard adds a stroke: a list of [x, y] points in a colour and
+// width. The oldest strokes go once the board is full.
+func drawOnWhiteboard(client *Client, object *types.RoomObject, data map[string]interface{}) (interface{}, bool, error) {
+	points, ok := data["points"].([]interface{})
+	if !ok || len(points) == 0 || len(points) > maxStrokePoints {
+		return nil, false, fmt.Errorf("points must list between 1 and %d points", maxStrokePoints)
+	}
+	for _, point := range points {
+		pair, ok := point.([]interface{})
+		if !ok || len(pair) != 2 {
+			return nil, false, errors.New("Each point must be an [x, y] pair")
+		}
+		for _, coordinate := range pair {
+			if _, ok := coordinate.(float64); !ok {
+				return nil, false, errors.New("Each point must be an [x, y] pair")
+			}
+		}
+	}
+	color, _ := data["color"].(string)
+	if color == "" || len(color) > maxStrokeColorLength {
+		return nil, false, errors.New("color is required")
+	}
+	width, _ := data["width"].(float64)
+	if width <= 0 || width > maxStrokeWidth {
+		return nil, false, fmt.Errorf("width must be between 0 and %d", maxStrokeWidth)
+	}
+
+	strokes, _ := object.State["strokes"].([]interface{})
+	strokes = append(strokes, map[string]interface{}{
+		"points": points,
+		"color":  color,
+		"width":  width,
+		"by":     client.publicID(),
+	})
+	if len(strokes) > maxWhiteboardStrokes {
+		strokes = strokes[len(strokes)-maxWhiteboardStrokes:]
+	}
+	object.State["strokes"] = strokes
+	return nil, true, nil
+}
+
+// announceStroke sends the room only the stroke just drawn, which is the
+// last one on the board, rather than the whole board.
+func announceStroke(saved *types.RoomObject) (string, interface{}) {
+	strokes, _ := saved.State["strokes"].([]interface{})
+	var stroke interface{}
+	if len(strokes) > 0 {
+		stroke = strokes[len(strokes)-1]
+	}
+	return "whiteboard-stroke", map[string]interface{}{
+		"objectId":  saved.ID,
+		"stroke":    stroke,
+		"updatedAt": saved.UpdatedAt,
+	}
+}
+
+func clearWhiteboard(client *Client, object *types.RoomObject, data map[string]interface{}) (interface{}, bool, error) {
+	object.State["strokes"] = []interface{}{}
+	return nil, true, nil
+}
+
+// portalDestination is where a portal leads. handleObjectInteract takes
+// the client there once the object is no longer locked.
+type portalDestination struct {
+	RoomID string
+	X      int
+	Y      int
+}
+
+func usePortal(client *Client, object *types.RoomObject, data map[string]interface{}) (interface{}, bool, error) {
+	roomID, _ := object.Properties["roomId"].(string)
+	x, _ := object.Properties["x"].(float64)
+	y, _ := object.Properties["y"].(float64)
+	return portalDestination{RoomID: roomID, X: int(x), Y: int(y)}, false, nil
+}
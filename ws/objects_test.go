@@ -0,0 +1,164 @@
+package ws
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"go-gather/roles"
+	"go-gather/types"
+)
+
+// newTestGuest is a client that needs no profile lookups.
+func newTestGuest(x, y int) *Client {
+	client := &Client{ID: "guest-test", Guest: true, DisplayName: "Tester"}
+	client.setPosition(x, y)
+	client.setRole(roles.Guest)
+	return client
+}
+
+func TestWithinReach(t *testing.T) {
+	object := &types.RoomObject{X: 10, Y: 10}
+	tests := []struct {
+		x, y int
+		want bool
+	}{
+		{10, 10, true},
+		{12, 12, true},
+		{8, 8, true},
+		{12, 8, true},
+		{13, 10, false},
+		{10, 7, false},
+		{0, 0, false},
+	}
+	for _, tt := range tests {
+		if got := withinReach(newTestGuest(tt.x, tt.y), object); got != tt.want {
+			t.Errorf("withinReach from %d,%d = %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+func stroke(points int) map[string]interface{} {
+	list := make([]interface{}, points)
+	for i := range list {
+		list[i] = []interface{}{float64(i), float64(i)}
+	}
+	return map[string]interface{}{"points": list, "color": "#000", "width": float64(2)}
+}
+
+func TestDrawOnWhiteboardLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(data map[string]interface{})
+		want string
+	}{
+		{"valid stroke", func(map[string]interface{}) {}, ""},
+		{"no points", func(d map[string]interface{}) { d["points"] = []interface{}{} }, "points must list"},
+		{"too many points", func(d map[string]interface{}) { d["points"] = stroke(maxStrokePoints + 1)["points"] }, "points must list"},
+		{"point is not a pair", func(d map[string]interface{}) { d["points"] = []interface{}{[]interface{}{1.0}} }, "[x, y] pair"},
+		{"point is not numeric", func(d map[string]interface{}) { d["points"] = []interface{}{[]interface{}{"a", 1.0}} }, "[x, y] pair"},
+		{"no color", func(d map[string]interface{}) { delete(d, "color") }, "color is required"},
+		{"long color", func(d map[string]interface{}) { d["color"] = strings.Repeat("f", maxStrokeColorLength+1) }, "color is required"},
+		{"zero width", func(d map[string]interface{}) { d["width"] = 0.0 }, "width must be"},
+		{"wide stroke", func(d map[string]interface{}) { d["width"] = float64(maxStrokeWidth + 1) }, "width must be"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := stroke(3)
+			tt.edit(data)
+			object := &types.RoomObject{State: map[string]interface{}{}}
+			_, changed, err := drawOnWhiteboard(newTestGuest(0, 0), object, data)
+			if tt.want == "" {
+				if err != nil || !changed {
+					t.Fatalf("got %v, %v; want the stroke drawn", changed, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) || changed {
+				t.Fatalf("got %v, %v; want an error containing %q", changed, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWhiteboardKeepsTheNewestStrokes(t *testing.T) {
+	object := &types.RoomObject{ID: "board", State: map[string]interface{}{}}
+	client := newTestGuest(0, 0)
+	for i := 0; i < maxWhiteboardStrokes+5; i++ {
+		data := stroke(1)
+		data["color"] = fmt.Sprint(i)
+		if _, _, err := drawOnWhiteboard(client, object, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	strokes := object.State["strokes"].([]interface{})
+	if len(strokes) != maxWhiteboardStrokes {
+		t.Fatalf("board holds %d strokes, want %d", len(strokes), maxWhiteboardStrokes)
+	}
+	if first := strokes[0].(map[string]interface{})["color"]; first != "5" {
+		t.Fatalf("oldest kept stroke is %v, want 5", first)
+	}
+
+	// The room only hears about the stroke just drawn.
+	eventType, payload := announceStroke(object)
+	announced := payload.(map[string]interface{})
+	if eventType != "whiteboard-stroke" || announced["objectId"] != "board" {
+		t.Fatalf("announced %s %v", eventType, announced)
+	}
+	if color := announced["stroke"].(map[string]interface{})["color"]; color != fmt.Sprint(maxWhiteboardStrokes+4) {
+		t.Fatalf("announced stroke %v, want the newest", color)
+	}
+}
+
+func TestClearingWhiteboardsNeedsEditMap(t *testing.T) {
+	clearAction := objectActions[types.ObjectWhiteboard]["clear"]
+	draw := objectActions[types.ObjectWhiteboard]["draw"]
+	for role, want := range map[roles.Role]bool{
+		roles.Guest:     false,
+		roles.Member:    false,
+		roles.Moderator: true,
+		roles.Owner:     true,
+	} {
+		client := newTestGuest(0, 0)
+		client.setRole(role)
+		if got := clearAction.allows(client); got != want {
+			t.Errorf("%s clearing: got %v, want %v", role, got, want)
+		}
+		if !draw.allows(client) {
+			t.Errorf("%s may not draw", role)
+		}
+	}
+}
+
+func TestPortals(t *testing.T) {
+	object := &types.RoomObject{Properties: map[string]interface{}{"roomId": "upstairs", "x": 3.0, "y": 4.0}}
+	result, changed, err := usePortal(newTestGuest(0, 0), object, nil)
+	if err != nil || changed {
+		t.Fatalf("usePortal: %v, %v", changed, err)
+	}
+	if destination := result.(portalDestination); destination != (portalDestination{RoomID: "upstairs", X: 3, Y: 4}) {
+		t.Fatalf("destination %+v", destination)
+	}
+
+	// A portal is a room switch, with the same refusals.
+	member := &Client{ID: "member@example.com"}
+	guest := newTestGuest(0, 0)
+	tests := []struct {
+		name   string
+		client *Client
+		target string
+		want   string
+	}{
+		{"portal without a room", member, "", "roomId is required"},
+		{"portal into the same room", member, "lobby", "already in this room"},
+		{"guest using a portal", guest, "upstairs", "Guests cannot switch rooms"},
+	}
+	for _, tt := range tests {
+		req := &eventRequest{wsManager: GetWebSocketInstance(), client: tt.client, roomID: "lobby"}
+		response := switchRoom(req, tt.target, 0, 0)
+		if response.Success || !strings.Contains(response.Error, tt.want) {
+			t.Errorf("%s: %+v, want an error containing %q", tt.name, response, tt.want)
+		}
+	}
+}
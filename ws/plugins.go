@@ -0,0 +1,472 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"unicode/utf8"
+
+	"go-gather/plugin"
+	"go-gather/types"
+)
+
+const (
+	// pluginBacklog is how many events a plugin can fall behind before
+	// new ones are dropped.
+	pluginBacklog = 256
+
+	maxPluginIDLength   = 64
+	maxNPCNameLength    = 32
+	maxNPCsPerPlugin    = 100
+	maxZonesPerPlugin   = 100
+	maxStateKeys        = 100
+	maxStateValueLength = 16 * 1024
+)
+
+var (
+	errUnknownUser = errors.New("user is not in the room")
+	errUnknownNPC  = errors.New("no such NPC")
+)
+
+// pluginKey names something a plugin owns: an NPC, a zone or a state key.
+type pluginKey struct {
+	plugin string
+	id     string
+}
+
+// pluginRoom is what plugins have added to one room.
+type pluginRoom struct {
+	npcs   map[pluginKey]plugin.NPC
+	state  map[pluginKey]json.RawMessage // kept encoded, so plugins cannot change it behind the lock
+	zones  map[string][]plugin.Zone      // plugin -> zones
+	inside map[string]map[pluginKey]bool // client ID -> zones they stand in
+}
+
+type runningPlugin struct {
+	name   string
+	plugin plugin.Plugin
+	events chan plugin.Event
+}
+
+type pluginHost struct {
+	lock    sync.Mutex
+	rooms   map[string]*pluginRoom
+	running []*runningPlugin
+}
+
+var plugins = &pluginHost{rooms: make(map[string]*pluginRoom)}
+
+// StartPlugins starts every registered plugin. It must be called once,
+// before the server accepts connections.
+func StartPlugins() error {
+	for _, p := range plugin.Registered() {
+		running := &runningPlugin{
+			name:   p.Name(),
+			plugin: p,
+			events: make(chan plugin.Event, pluginBacklog),
+		}
+		if err := p.Start(&pluginAPI{name: running.name}); err != nil {
+			return fmt.Errorf("starting plugin %s: %w", running.name, err)
+		}
+		plugins.running = append(plugins.running, running)
+		go running.run()
+		log.Println("Started plugin", running.name)
+	}
+	return nil
+}
+
+func (p *runningPlugin) run() {
+	for event := range p.events {
+		p.handle(event)
+	}
+}
+
+// handle keeps a panicking plugin from taking the server down with it.
+func (p *runningPlugin) handle(event plugin.Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Plugin %s panicked handling %s: %v", p.name, event.Type, r)
+		}
+	}()
+	p.plugin.HandleEvent(event)
+}
+
+func (p *runningPlugin) deliver(event plugin.Event) {
+	select {
+	case p.events <- event:
+	default:
+		log.Printf("Plugin %s is behind, dropping %s event", p.name, event.Type)
+	}
+}
+
+func (h *pluginHost) dispatch(event plugin.Event) {
+	for _, p := range h.running {
+		p.deliver(event)
+	}
+}
+
+func (h *pluginHost) dispatchTo(name string, event plugin.Event) {
+	for _, p := range h.running {
+		if p.name == name {
+			p.deliver(event)
+		}
+	}
+}
+
+// room returns the room's plugin data, creating it. The caller holds the
+// lock.
+func (h *pluginHost) room(roomID string) *pluginRoom {
+	room, ok := h.rooms[roomID]
+	if !ok {
+		room = &pluginRoom{
+			npcs:   make(map[pluginKey]plugin.NPC),
+			state:  make(map[pluginKey]json.RawMessage),
+			zones:  make(map[string][]plugin.Zone),
+			inside: make(map[string]map[pluginKey]bool),
+		}
+		h.rooms[roomID] = room
+	}
+	return room
+}
+
+// crossings records where the client now stands and returns the zones
+// they walked into and out of. A client that left the room stands in none.
+func (h *pluginHost) crossings(roomID, clientID string, x, y int, gone bool) (entered, left []pluginKey) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	room, ok := h.rooms[roomID]
+	if !ok {
+		return nil, nil
+	}
+	now := make(map[pluginKey]bool)
+	if !gone {
+		for name, zones := range room.zones {
+			for _, zone := range zones {
+				if zone.Contains(x, y) {
+					now[pluginKey{name, zone.Name}] = true
+				}
+			}
+		}
+	}
+	before := room.inside[clientID]
+	for key := range now {
+		if !before[key] {
+			entered = append(entered, key)
+		}
+	}
+	for key := range before {
+		if !now[key] {
+			left = append(left, key)
+		}
+	}
+	if len(now) == 0 {
+		delete(room.inside, clientID)
+	} else {
+		room.inside[clientID] = now
+	}
+	return entered, left
+}
+
+// roomEvent tells every plugin about something the client did, followed
+// by the zone events it caused.
+func roomEvent(client *Client, roomID, eventType string, message *types.ChatMessage) {
+	if len(plugins.running) == 0 {
+		return
+	}
+	x, y := client.position()
+	event := plugin.Event{
+		Type:   eventType,
+		RoomID: roomID,
+		User:   client.publicProfile(),
+		X:      x,
+		Y:      y,
+	}
+	if message != nil {
+		// Plugins get their own copy to keep.
+		copied := *message
+		event.Message = &copied
+	}
+	plugins.dispatch(event)
+
+	if eventType == plugin.EventChat {
+		return
+	}
+	entered, left := plugins.crossings(roomID, client.ID, x, y, eventType == plugin.EventLeave)
+	for _, key := range left {
+		zoneEvent := event
+		zoneEvent.Type, zoneEvent.Zone, zoneEvent.Message = plugin.EventZoneLeft, key.id, nil
+		plugins.dispatchTo(key.plugin, zoneEvent)
+	}
+	for _, key := range entered {
+		zoneEvent := event
+		zoneEvent.Type, zoneEvent.Zone, zoneEvent.Message = plugin.EventZoneEntered, key.id, nil
+		plugins.dispatchTo(key.plugin, zoneEvent)
+	}
+}
+
+// placedNPC is an NPC as clients see it.
+type placedNPC struct {
+	Plugin string `json:"plugin"`
+	plugin.NPC
+}
+
+// pluginSnapshot is what plugins have added to the room, for clients that
+// just joined.
+func pluginSnapshot(roomID string) ([]placedNPC, map[string]map[string]interface{}) {
+	plugins.lock.Lock()
+	defer plugins.lock.Unlock()
+
+	npcs := []placedNPC{}
+	state := make(map[string]map[string]interface{})
+	room, ok := plugins.rooms[roomID]
+	if !ok {
+		return npcs, state
+	}
+	for key, npc := range room.npcs {
+		npcs = append(npcs, placedNPC{Plugin: key.plugin, NPC: npc})
+	}
+	for key, value := range room.state {
+		if state[key.plugin] == nil {
+			state[key.plugin] = make(map[string]interface{})
+		}
+		state[key.plugin][key.id] = value
+	}
+	return npcs, state
+}
+
+// pluginAPI is the plugin.API handed to one plugin.
+type pluginAPI struct {
+	name string
+}
+
+func (a *pluginAPI) Users(roomID string) []types.Profile {
+	return roomProfiles(wsManager, roomID)
+}
+
+// member finds the joined client behind a public ID.
+func (a *pluginAPI) member(roomID, userID string) *Client {
+	for _, client := range wsManager.GetClientsInRoom(roomID) {
+		if client.Role() != "" && client.publicID() == userID {
+			return client
+		}
+	}
+	return nil
+}
+
+func (a *pluginAPI) Position(roomID, userID string) (int, int, bool) {
+	client := a.member(roomID, userID)
+	if client == nil {
+		return 0, 0, false
+	}
+	x, y := client.position()
+	return x, y, true
+}
+
+func (a *pluginAPI) SendChat(roomID, zone, body string) error {
+	if problem := checkChatBody(body); problem != "" {
+		return errors.New(problem)
+	}
+	saved, err := saveChatMessage(types.ChatMessage{
+		RoomID:     roomID,
+		Channel:    types.ChatChannel(types.ChatData{Zone: zone}),
+		SenderID:   "plugin:" + a.name,
+		SenderName: a.name,
+		Body:       body,
+	})
+	if reason, rejected := rejection(err); rejected {
+		return errors.New(reason)
+	}
+	if err != nil {
+		return err
+	}
+	wsManager.SendToRoom(roomID, "chat-message", publicChatMessage(saved))
+	return nil
+}
+
+func (a *pluginAPI) event(eventType string, data interface{}) map[string]interface{} {
+	return map[string]interface{}{"plugin": a.name, "type": eventType, "data": data}
+}
+
+func (a *pluginAPI) SendTo(roomID, userID, eventType string, data interface{}) error {
+	client := a.member(roomID, userID)
+	if client == nil {
+		return errUnknownUser
+	}
+	client.SendMessage("plugin-event", a.event(eventType, data))
+	return nil
+}
+
+func (a *pluginAPI) Broadcast(roomID, eventType string, data interface{}) {
+	wsManager.SendToRoom(roomID, "plugin-event", a.event(eventType, data))
+}
+
+func checkPluginID(what, id string) error {
+	if id == "" || len(id) > maxPluginIDLength {
+		return fmt.Errorf("%s must be 1 to %d bytes", what, maxPluginIDLength)
+	}
+	return nil
+}
+
+func (a *pluginAPI) PlaceNPC(roomID string, npc plugin.NPC) error {
+	if err := checkPluginID("NPC ID", npc.ID); err != nil {
+		return err
+	}
+	if utf8.RuneCountInString(npc.Name) > maxNPCNameLength {
+		return fmt.Errorf("NPC names are limited to %d characters", maxNPCNameLength)
+	}
+
+	plugins.lock.Lock()
+	room := plugins.room(roomID)
+	key := pluginKey{a.name, npc.ID}
+	if _, exists := room.npcs[key]; !exists && a.npcCount(room) >= maxNPCsPerPlugin {
+		plugins.lock.Unlock()
+		return fmt.Errorf("at most %d NPCs per room", maxNPCsPerPlugin)
+	}
+	room.npcs[key] = npc
+	plugins.lock.Unlock()
+
+	wsManager.SendToRoom(roomID, "npc-placed", placedNPC{Plugin: a.name, NPC: npc})
+	return nil
+}
+
+func (a *pluginAPI) npcCount(room *pluginRoom) int {
+	n := 0
+	for key := range room.npcs {
+		if key.plugin == a.name {
+			n++
+		}
+	}
+	return n
+}
+
+func (a *pluginAPI) MoveNPC(roomID, npcID string, x, y int) error {
+	plugins.lock.Lock()
+	room := plugins.room(roomID)
+	key := pluginKey{a.name, npcID}
+	npc, exists := room.npcs[key]
+	if !exists {
+		plugins.lock.Unlock()
+		return errUnknownNPC
+	}
+	npc.X, npc.Y = x, y
+	room.npcs[key] = npc
+	plugins.lock.Unlock()
+
+	wsManager.SendToRoom(roomID, "npc-moved", placedNPC{Plugin: a.name, NPC: npc})
+	return nil
+}
+
+func (a *pluginAPI) RemoveNPC(roomID, npcID string) error {
+	plugins.lock.Lock()
+	room := plugins.room(roomID)
+	key := pluginKey{a.name, npcID}
+	if _, exists := room.npcs[key]; !exists {
+		plugins.lock.Unlock()
+		return errUnknownNPC
+	}
+	delete(room.npcs, key)
+	plugins.lock.Unlock()
+
+	wsManager.SendToRoom(roomID, "npc-removed", map[string]string{"plugin": a.name, "id": npcID})
+	return nil
+}
+
+func (a *pluginAPI) SetZones(roomID string, zones []plugin.Zone) error {
+	if len(zones) > maxZonesPerPlugin {
+		return fmt.Errorf("at most %d zones per room", maxZonesPerPlugin)
+	}
+	defined := make(map[string]bool, len(zones))
+	for _, zone := range zones {
+		if err := checkPluginID("zone name", zone.Name); err != nil {
+			return err
+		}
+		if zone.Width <= 0 || zone.Height <= 0 {
+			return fmt.Errorf("zone %s has no area", zone.Name)
+		}
+		defined[zone.Name] = true
+	}
+
+	plugins.lock.Lock()
+	defer plugins.lock.Unlock()
+
+	room := plugins.room(roomID)
+	if len(zones) == 0 {
+		delete(room.zones, a.name)
+	} else {
+		room.zones[a.name] = append([]plugin.Zone(nil), zones...)
+	}
+	// Zones that are gone are forgotten without zone-left events.
+	for _, inside := range room.inside {
+		for key := range inside {
+			if key.plugin == a.name && !defined[key.id] {
+				delete(inside, key)
+			}
+		}
+	}
+	return nil
+}
+
+func (a *pluginAPI) SetState(roomID, key string, value interface{}) error {
+	if err := checkPluginID("state key", key); err != nil {
+		return err
+	}
+	var encoded json.RawMessage
+	if value != nil {
+		var err error
+		if encoded, err = json.Marshal(value); err != nil {
+			return err
+		}
+		if len(encoded) > maxStateValueLength {
+			return fmt.Errorf("state values are limited to %d bytes", maxStateValueLength)
+		}
+	}
+
+	plugins.lock.Lock()
+	room := plugins.room(roomID)
+	stateKey := pluginKey{a.name, key}
+	if value == nil {
+		delete(room.state, stateKey)
+	} else {
+		if _, exists := room.state[stateKey]; !exists && a.stateCount(room) >= maxStateKeys {
+			plugins.lock.Unlock()
+			return fmt.Errorf("at most %d state keys per room", maxStateKeys)
+		}
+		room.state[stateKey] = encoded
+	}
+	plugins.lock.Unlock()
+
+	wsManager.SendToRoom(roomID, "room-state", map[string]interface{}{"plugin": a.name, "key": key, "value": encoded})
+	return nil
+}
+
+func (a *pluginAPI) stateCount(room *pluginRoom) int {
+	n := 0
+	for key := range room.state {
+		if key.plugin == a.name {
+			n++
+		}
+	}
+	return n
+}
+
+func (a *pluginAPI) State(roomID, key string) (interface{}, bool) {
+	plugins.lock.Lock()
+	defer plugins.lock.Unlock()
+
+	room, ok := plugins.rooms[roomID]
+	if !ok {
+		return nil, false
+	}
+	encoded, ok := room.state[pluginKey{a.name, key}]
+	if !ok {
+		return nil, false
+	}
+	var value interface{}
+	if err := json.Unmarshal(encoded, &value); err != nil {
+		return nil, false
+	}
+	return value, true
+}
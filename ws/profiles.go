@@ -0,0 +1,317 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"go-gather/types"
+)
+
+const (
+	// profileTTL is how long a looked-up profile is trusted. Changes made
+	// through PATCH /me arrive as notifications, so this only bounds how
+	// stale a missed one can get.
+	profileTTL = 10 * time.Minute
+	// profileIdle is how long an entry nobody asked for stays cached.
+	profileIdle = time.Hour
+	// profileLookupBatch matches the HTTP service's limit per lookup.
+	profileLookupBatch = 200
+)
+
+// Users are named to each other by their public profile ID, never by
+// email. profileDirectory is the ws server's view of who is behind which
+// ID, filled from the HTTP service on demand.
+type profileDirectory struct {
+	lock    sync.Mutex
+	byEmail map[string]*cachedProfile
+	emails  map[string]string // public ID -> email
+}
+
+type cachedProfile struct {
+	profile types.Profile
+	fetched time.Time
+	used    time.Time
+}
+
+var profiles = &profileDirectory{
+	byEmail: make(map[string]*cachedProfile),
+	emails:  make(map[string]string),
+}
+
+type profileRecord struct {
+	Email string `json:"email"`
+	types.Profile
+}
+
+func (d *profileDirectory) put(email string, profile types.Profile) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := time.Now()
+	for cachedEmail, entry := range d.byEmail {
+		if now.Sub(entry.used) > profileIdle {
+			delete(d.emails, entry.profile.ID)
+			delete(d.byEmail, cachedEmail)
+		}
+	}
+	d.byEmail[email] = &cachedProfile{profile: profile, fetched: now, used: now}
+	d.emails[profile.ID] = email
+}
+
+// lookup returns the profiles of the emails that have an account. Entries
+// that are missing or stale are fetched in batches; when that fails a
+// stale entry is still better than none.
+func (d *profileDirectory) lookup(emails []string) map[string]types.Profile {
+	found := make(map[string]types.Profile, len(emails))
+	var missing []string
+	seen := make(map[string]bool, len(emails))
+
+	d.lock.Lock()
+	now := time.Now()
+	for _, email := range emails {
+		if seen[email] {
+			continue
+		}
+		seen[email] = true
+		entry, ok := d.byEmail[email]
+		if ok {
+			entry.used = now
+			found[email] = entry.profile
+		}
+		if !ok || now.Sub(entry.fetched) > profileTTL {
+			missing = append(missing, email)
+		}
+	}
+	d.lock.Unlock()
+
+	for start := 0; start < len(missing); start += profileLookupBatch {
+		end := start + profileLookupBatch
+		if end > len(missing) {
+			end = len(missing)
+		}
+		records, err := fetchProfiles(missing[start:end], nil)
+		if err != nil {
+			log.Println("Error looking up profiles:", err)
+			break
+		}
+		for _, record := range records {
+			d.put(record.Email, record.Profile)
+			found[record.Email] = record.Profile
+		}
+	}
+	return found
+}
+
+// emailOf resolves a public ID back to the email behind it.
+func (d *profileDirectory) emailOf(id string) (string, bool) {
+	d.lock.Lock()
+	email, ok := d.emails[id]
+	d.lock.Unlock()
+	if ok {
+		return email, true
+	}
+
+	records, err := fetchProfiles(nil, []string{id})
+	if err != nil {
+		log.Println("Error looking up profile:", err)
+		return "", false
+	}
+	for _, record := range records {
+		d.put(record.Email, record.Profile)
+		if record.ID == id {
+			return record.Email, true
+		}
+	}
+	return "", false
+}
+
+func fetchProfiles(emails, ids []string) ([]profileRecord, error) {
+	var result struct {
+		Profiles []profileRecord `json:"profiles"`
+	}
+	payload := map[string][]string{"emails": emails, "ids": ids}
+	if err := postInternal("/profiles", payload, &result); err != nil {
+		return nil, err
+	}
+	return result.Profiles, nil
+}
+
+// isAccountID tells account users, known by email, from guests, whose
+// guest IDs are already safe to show.
+func isAccountID(userID string) bool {
+	return strings.Contains(userID, "@")
+}
+
+// publicIDs maps user IDs to what other users may see. Guest IDs stay as
+// they are; emails without an account become "" rather than leak.
+func publicIDs(userIDs []string) map[string]string {
+	return publicIDsFrom(userIDs, profiles.lookup(accountIDs(userIDs)))
+}
+
+func accountIDs(userIDs []string) []string {
+	var emails []string
+	for _, userID := range userIDs {
+		if isAccountID(userID) {
+			emails = append(emails, userID)
+		}
+	}
+	return emails
+}
+
+func publicIDsFrom(userIDs []string, found map[string]types.Profile) map[string]string {
+	ids := make(map[string]string, len(userIDs))
+	for _, userID := range userIDs {
+		if !isAccountID(userID) {
+			ids[userID] = userID
+		} else {
+			ids[userID] = found[userID].ID
+		}
+	}
+	return ids
+}
+
+func publicID(userID string) string {
+	return publicIDs([]string{userID})[userID]
+}
+
+// resolveUserID turns a user named by a client back into the ID the
+// services use. Emails are still accepted from clients that know them.
+func resolveUserID(userID string) string {
+	if isAccountID(userID) {
+		return userID
+	}
+	if email, ok := profiles.emailOf(userID); ok {
+		return email
+	}
+	return userID
+}
+
+// publicProfile is how the client appears to everyone else.
+func (c *Client) publicProfile() types.Profile {
+	if c.Guest {
+		return types.Profile{ID: c.ID, DisplayName: c.DisplayName, Guest: true}
+	}
+	return profiles.lookup([]string{c.ID})[c.ID]
+}
+
+func (c *Client) publicID() string {
+	return c.publicProfile().ID
+}
+
+// roomProfiles lists the profiles of everyone who has joined the room.
+func roomProfiles(wsManager *WebSocketManager, roomID string) []types.Profile {
+	var joined []*Client
+	var userIDs []string
+	for _, client := range wsManager.GetClientsInRoom(roomID) {
+		if client.Role() != "" {
+			joined = append(joined, client)
+			userIDs = append(userIDs, client.ID)
+		}
+	}
+	found := profiles.lookup(accountIDs(userIDs))
+
+	members := make([]types.Profile, 0, len(joined))
+	for _, client := range joined {
+		if client.Guest {
+			members = append(members, client.publicProfile())
+		} else if profile, ok := found[client.ID]; ok {
+			members = append(members, profile)
+		}
+	}
+	return members
+}
+
+// publicChatMessages rewrites the senders and reactions of the messages
+// with public IDs and returns the profiles of the accounts involved, so
+// clients can show people who are not in the room right now.
+func publicChatMessages(messages []types.ChatMessage, markers []types.ReadMarker) ([]types.ChatMessage, []types.ReadMarker, map[string]types.Profile) {
+	var userIDs []string
+	for _, message := range messages {
+		userIDs = append(userIDs, message.SenderID)
+		for _, reaction := range message.Reactions {
+			userIDs = append(userIDs, reaction.Users...)
+		}
+	}
+	for _, marker := range markers {
+		userIDs = append(userIDs, marker.UserID)
+	}
+	found := profiles.lookup(accountIDs(userIDs))
+	ids := publicIDsFrom(userIDs, found)
+
+	public := make([]types.ChatMessage, len(messages))
+	for i, message := range messages {
+		message.SenderID = ids[message.SenderID]
+		message.Reactions = publicReactions(message.Reactions, ids)
+		public[i] = message
+	}
+	var publicMarkers []types.ReadMarker
+	if markers != nil {
+		publicMarkers = make([]types.ReadMarker, len(markers))
+		for i, marker := range markers {
+			marker.UserID = ids[marker.UserID]
+			publicMarkers[i] = marker
+		}
+	}
+
+	involved := make(map[string]types.Profile, len(found))
+	for _, profile := range found {
+		involved[profile.ID] = profile
+	}
+	return public, publicMarkers, involved
+}
+
+func publicReactions(reactions []types.Reaction, ids map[string]string) []types.Reaction {
+	if reactions == nil {
+		return nil
+	}
+	public := make([]types.Reaction, len(reactions))
+	for i, reaction := range reactions {
+		users := make([]string, len(reaction.Users))
+		for j, user := range reaction.Users {
+			users[j] = ids[user]
+		}
+		reaction.Users = users
+		public[i] = reaction
+	}
+	return public
+}
+
+func publicChatMessage(message *types.ChatMessage) *types.ChatMessage {
+	public, _, _ := publicChatMessages([]types.ChatMessage{*message}, nil)
+	return &public[0]
+}
+
+func publicChatPage(page *chatPage) *chatPage {
+	public := *page
+	public.Messages, public.ReadMarkers, public.Profiles = publicChatMessages(page.Messages, page.ReadMarkers)
+	return &public
+}
+
+func publicReadMarker(marker *types.ReadMarker) *types.ReadMarker {
+	public := *marker
+	public.UserID = publicID(marker.UserID)
+	return &public
+}
+
+// applyProfileUpdate takes a profile-updated notification from the HTTP
+// service and shows the new profile to every room the user is in.
+func applyProfileUpdate(wsManager *WebSocketManager, notification types.Notification) {
+	var profile types.Profile
+	dataBytes, _ := json.Marshal(notification.Data)
+	if err := json.Unmarshal(dataBytes, &profile); err != nil || profile.ID == "" {
+		log.Println("Invalid profile-updated notification:", err)
+		return
+	}
+
+	for _, email := range notification.UserIDs {
+		profiles.put(email, profile)
+		for _, client := range wsManager.GetClientsByID(email) {
+			if client.Role() != "" {
+				wsManager.SendToRoom(client.room(), "profile-updated", profile)
+			}
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package ws
+
+import (
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimit lets Burst messages through at once and refills at Rate per
+// second after that.
+type rateLimit struct {
+	Rate  float64
+	Burst float64
+}
+
+// defaultRateLimits covers the rate classes of the message types that fan
+// out to the whole room or hit the HTTP service; events.go says which
+// class each type draws from. "*" applies to every other type.
+var defaultRateLimits = map[string]rateLimit{
+	"*":                {Rate: 10, Burst: 30},
+	"move":             {Rate: 15, Burst: 30},
+	"send-message":     {Rate: 1, Burst: 5},
+	"send-dm":          {Rate: 1, Burst: 5},
+	"edit-message":     {Rate: 1, Burst: 5},
+	"delete-message":   {Rate: 1, Burst: 5},
+	"react":            {Rate: 2, Burst: 10},
+	"unreact":          {Rate: 2, Burst: 10},
+	"typing-start":     {Rate: 1, Burst: 5},
+	"typing-stop":      {Rate: 1, Burst: 5},
+	"chat-history":     {Rate: 1, Burst: 5},
+	"join":             {Rate: 0.2, Burst: 3},
+	"object-interact":  {Rate: 5, Burst: 20},
+	"webrtc-candidate": {Rate: 50, Burst: 100},
+}
+
+const (
+	defaultIPRateFactor = 4
+	defaultMaxStrikes   = 20
+	// strikeWindow is how long a rate-limited message counts against the
+	// client. Strikes older than that are forgiven.
+	strikeWindow = 30 * time.Second
+	// idleBucketTTL is how long an untouched per-IP bucket is kept.
+	idleBucketTTL = 10 * time.Minute
+)
+
+type rateConfig struct {
+	limits     map[string]rateLimit
+	ipFactor   float64
+	maxStrikes int
+}
+
+var (
+	rateSettings     rateConfig
+	rateSettingsOnce sync.Once
+)
+
+// rateLimits reads the limits from the environment once:
+//
+//	WS_RATE_LIMITS       overrides per class, e.g. "move=20/40,*=5/10"
+//	                     (messages per second / burst)
+//	WS_RATE_IP_FACTOR    how many clients' worth one IP may send (4)
+//	WS_RATE_MAX_STRIKES  rate-limited messages within 30s before the
+//	                     client is disconnected (20)
+func rateLimits() rateConfig {
+	rateSettingsOnce.Do(func() {
+		rateSettings = rateConfig{
+			limits:     make(map[string]rateLimit),
+			ipFactor:   defaultIPRateFactor,
+			maxStrikes: defaultMaxStrikes,
+		}
+		for class, limit := range defaultRateLimits {
+			rateSettings.limits[class] = limit
+		}
+
+		for _, entry := range strings.Split(os.Getenv("WS_RATE_LIMITS"), ",") {
+			if strings.TrimSpace(entry) == "" {
+				continue
+			}
+			class, limit, ok := parseRateLimit(entry)
+			if !ok {
+				log.Printf("Ignoring invalid WS_RATE_LIMITS entry %q", entry)
+				continue
+			}
+			rateSettings.limits[class] = limit
+		}
+
+		if value := os.Getenv("WS_RATE_IP_FACTOR"); value != "" {
+			if factor, err := strconv.ParseFloat(value, 64); err == nil && factor >= 1 {
+				rateSettings.ipFactor = factor
+			} else {
+				log.Printf("Ignoring invalid WS_RATE_IP_FACTOR %q", value)
+			}
+		}
+		if value := os.Getenv("WS_RATE_MAX_STRIKES"); value != "" {
+			if strikes, err := strconv.Atoi(value); err == nil && strikes > 0 {
+				rateSettings.maxStrikes = strikes
+			} else {
+				log.Printf("Ignoring invalid WS_RATE_MAX_STRIKES %q", value)
+			}
+		}
+	})
+	return rateSettings
+}
+
+// parseRateLimit reads one "class=rate/burst" entry.
+func parseRateLimit(entry string) (string, rateLimit, bool) {
+	class, value, found := strings.Cut(strings.TrimSpace(entry), "=")
+	if !found || class == "" {
+		return "", rateLimit{}, false
+	}
+	rateText, burstText, found := strings.Cut(value, "/")
+	if !found {
+		return "", rateLimit{}, false
+	}
+	rate, err := strconv.ParseFloat(rateText, 64)
+	if err != nil || rate <= 0 {
+		return "", rateLimit{}, false
+	}
+	burst, err := strconv.ParseFloat(burstText, 64)
+	if err != nil || burst < 1 {
+		return "", rateLimit{}, false
+	}
+	return class, rateLimit{Rate: rate, Burst: burst}, true
+}
+
+// bucketFor names the bucket a rate class draws from. Classes without a
+// limit of their own share "*".
+func (c rateConfig) bucketFor(class string) (string, rateLimit) {
+	if limit, ok := c.limits[class]; ok {
+		return class, limit
+	}
+	return "*", c.limits["*"]
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take spends a token if one is left. Otherwise it reports how long until
+// the next one.
+func (b *tokenBucket) take(now time.Time, limit rateLimit) (bool, time.Duration) {
+	if b.last.IsZero() {
+		b.tokens = limit.Burst
+	} else {
+		b.tokens = math.Min(limit.Burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// refund gives back a token spent on a message that was refused anyway.
+func (b *tokenBucket) refund(limit rateLimit) {
+	b.tokens = math.Min(limit.Burst, b.tokens+1)
+}
+
+// ipLimiter holds the buckets shared by every connection from one IP, so
+// opening more sockets does not buy more throughput.
+type ipLimiter struct {
+	lock      sync.Mutex
+	buckets   map[string]*tokenBucket // IP + " " + rate class
+	lastSweep time.Time
+}
+
+var ipLimits = &ipLimiter{buckets: make(map[string]*tokenBucket)}
+
+func (l *ipLimiter) take(now time.Time, ip, class string, limit rateLimit) (bool, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if now.Sub(l.lastSweep) > idleBucketTTL {
+		for key, bucket := range l.buckets {
+			if now.Sub(bucket.last) > idleBucketTTL {
+				delete(l.buckets, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	key := ip + " " + class
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{}
+		l.buckets[key] = bucket
+	}
+	return bucket.take(now, limit)
+}
+
+// clientLimiter meters one connection. It is only used from that
+// connection's read loop and needs no locking of its own.
+type clientLimiter struct {
+	ip      string
+	buckets map[string]*tokenBucket
+	strikes []time.Time
+}
+
+func newClientLimiter(ip string) *clientLimiter {
+	return &clientLimiter{ip: ip, buckets: make(map[string]*tokenBucket)}
+}
+
+// allow decides whether a message of the rate class may be handled now.
+// When it may not, it returns how long the client should wait.
+func (l *clientLimiter) allow(class string) (bool, time.Duration) {
+	return l.allowAt(time.Now(), rateLimits(), class)
+}
+
+func (l *clientLimiter) allowAt(now time.Time, config rateConfig, class string) (bool, time.Duration) {
+	key, limit := config.bucketFor(class)
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{}
+		l.buckets[key] = bucket
+	}
+	allowed, wait := bucket.take(now, limit)
+	if !allowed {
+		// Only the client's own excess counts towards disconnecting it.
+		l.strikes = append(l.strikes, now)
+		return false, wait
+	}
+
+	if l.ip != "" {
+		ipLimit := rateLimit{Rate: limit.Rate * config.ipFactor, Burst: limit.Burst * config.ipFactor}
+		if allowed, wait = ipLimits.take(now, l.ip, key, ipLimit); !allowed {
+			// Other sockets on the IP used up its share; this one did
+			// nothing wrong and keeps its token.
+			bucket.refund(limit)
+			return false, wait
+		}
+	}
+	return true, 0
+}
+
+// offending reports whether the client kept sending after being limited
+// often enough to be disconnected.
+func (l *clientLimiter) offending() bool {
+	return l.offendingAt(time.Now(), rateLimits().maxStrikes)
+}
+
+func (l *clientLimiter) offendingAt(now time.Time, maxStrikes int) bool {
+	cutoff := now.Add(-strikeWindow)
+	recent := l.strikes[:0]
+	for _, strike := range l.strikes {
+		if strike.After(cutoff) {
+			recent = append(recent, strike)
+		}
+	}
+	l.strikes = recent
+	return len(l.strikes) >= maxStrikes
+}
@@ -0,0 +1,143 @@
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	limit := rateLimit{Rate: 2, Burst: 3}
+	start := time.Unix(1700000000, 0)
+
+	steps := []struct {
+		after   time.Duration
+		allowed bool
+		wait    time.Duration
+	}{
+		{0, true, 0}, // a new bucket starts full
+		{0, true, 0},
+		{0, true, 0},
+		{0, false, 500 * time.Millisecond},
+		{250 * time.Millisecond, false, 250 * time.Millisecond},
+		{500 * time.Millisecond, true, 0},
+		{10 * time.Second, true, 0}, // refills no further than the burst
+		{10 * time.Second, true, 0},
+		{10 * time.Second, true, 0},
+		{10 * time.Second, false, 500 * time.Millisecond},
+	}
+
+	var bucket tokenBucket
+	for i, step := range steps {
+		allowed, wait := bucket.take(start.Add(step.after), limit)
+		if allowed != step.allowed || wait != step.wait {
+			t.Fatalf("take %d at +%v = %v, %v; want %v, %v", i, step.after, allowed, wait, step.allowed, step.wait)
+		}
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		entry string
+		class string
+		limit rateLimit
+		ok    bool
+	}{
+		{"move=20/40", "move", rateLimit{Rate: 20, Burst: 40}, true},
+		{" *=0.5/1 ", "*", rateLimit{Rate: 0.5, Burst: 1}, true},
+		{"move=20", "", rateLimit{}, false},
+		{"=20/40", "", rateLimit{}, false},
+		{"move", "", rateLimit{}, false},
+		{"move=0/40", "", rateLimit{}, false},
+		{"move=-1/40", "", rateLimit{}, false},
+		{"move=20/0.5", "", rateLimit{}, false},
+		{"move=fast/40", "", rateLimit{}, false},
+		{"move=20/lots", "", rateLimit{}, false},
+	}
+	for _, tt := range tests {
+		class, limit, ok := parseRateLimit(tt.entry)
+		if class != tt.class || limit != tt.limit || ok != tt.ok {
+			t.Errorf("parseRateLimit(%q) = %q, %+v, %v; want %q, %+v, %v", tt.entry, class, limit, ok, tt.class, tt.limit, tt.ok)
+		}
+	}
+}
+
+func TestBucketFor(t *testing.T) {
+	config := rateConfig{limits: map[string]rateLimit{
+		"*":    {Rate: 10, Burst: 30},
+		"move": {Rate: 15, Burst: 30},
+	}}
+	for class, want := range map[string]string{"move": "move", "kick": "*", "": "*"} {
+		if key, _ := config.bucketFor(class); key != want {
+			t.Errorf("bucketFor(%q) = %q, want %q", class, key, want)
+		}
+	}
+}
+
+func TestIPFactorSharesBucketsAcrossClients(t *testing.T) {
+	config := rateConfig{
+		limits:     map[string]rateLimit{"*": {Rate: 1, Burst: 2}},
+		ipFactor:   2,
+		maxStrikes: 1,
+	}
+	now := time.Unix(1700000000, 0)
+	ip := "203.0.113.50"
+
+	// Two clients use up the IP's four tokens between them.
+	first, second := newClientLimiter(ip), newClientLimiter(ip)
+	for _, client := range []*clientLimiter{first, first, second, second} {
+		if allowed, _ := client.allowAt(now, config, "chat"); !allowed {
+			t.Fatal("refused within the IP's burst")
+		}
+	}
+
+	third := newClientLimiter(ip)
+	allowed, wait := third.allowAt(now, config, "chat")
+	if allowed || wait != 500*time.Millisecond {
+		t.Fatalf("third client on the IP: %v, %v; want refused for 500ms", allowed, wait)
+	}
+	if third.offendingAt(now, config.maxStrikes) {
+		t.Fatal("a client refused for its IP's traffic took a strike")
+	}
+	if tokens := third.buckets["*"].tokens; tokens != 2 {
+		t.Fatalf("refused client has %v tokens left, want its token back", tokens)
+	}
+
+	// A client from another IP is not affected.
+	if allowed, _ := newClientLimiter("203.0.113.51").allowAt(now, config, "chat"); !allowed {
+		t.Fatal("client on another IP was refused")
+	}
+}
+
+func TestStrikeWindow(t *testing.T) {
+	config := rateConfig{
+		limits:     map[string]rateLimit{"*": {Rate: 1, Burst: 1}},
+		ipFactor:   1,
+		maxStrikes: 3,
+	}
+	start := time.Unix(1700000000, 0)
+	client := newClientLimiter("")
+
+	client.allowAt(start, config, "chat")
+	for i := 0; i < 2; i++ {
+		if allowed, _ := client.allowAt(start, config, "chat"); allowed {
+			t.Fatal("allowed past the burst")
+		}
+	}
+	if client.offendingAt(start, config.maxStrikes) {
+		t.Fatal("offending after two strikes")
+	}
+
+	// The first two strikes are forgiven by the time of the third.
+	later := start.Add(strikeWindow + time.Second)
+	client.allowAt(later, config, "chat")
+	client.allowAt(later, config, "chat")
+	if client.offendingAt(later, config.maxStrikes) {
+		t.Fatal("old strikes still count")
+	}
+
+	client.allowAt(later, config, "chat")
+	client.allowAt(later, config, "chat")
+	if !client.offendingAt(later, config.maxStrikes) {
+		t.Fatal("not offending after three strikes within the window")
+	}
+}
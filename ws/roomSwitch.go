@@ -0,0 +1,96 @@
+package ws
+
+import (
+	"log"
+
+	"go-gather/roles"
+	"go-gather/types"
+)
+
+// handleSwitchRoom moves the client to another room without a new
+// connection. It arrives where a fresh connection would, at 0,0.
+func handleSwitchRoom(req *eventRequest, data types.SwitchRoomData) types.Response {
+	return switchRoom(req, data.RoomID, 0, 0)
+}
+
+// switchRoom leaves the client's room the way leave-room does, which also
+// closes its peer connection, and joins the other room at x,y. Access is
+// checked first so that a refusal leaves the client where it was. The
+// answer is the new room's user-joined snapshot, after a room-switched
+// event telling the client whether it has to set up media again.
+func switchRoom(req *eventRequest, target string, x, y int) types.Response {
+	wsManager, client, from := req.wsManager, req.client, req.roomID
+	if target == "" {
+		return switchError("roomId is required")
+	}
+	if target == from {
+		return switchError("You are already in this room")
+	}
+	// Guest tokens are only good for the room they were issued for.
+	if client.Guest {
+		return switchError("Guests cannot switch rooms")
+	}
+	if wsManager.GetClientInRoom(target, client.ID) != nil {
+		return switchError("You are already connected to that room")
+	}
+	if !canSwitchTo(client, target) {
+		return types.Response{
+			Type:    "user-joining-failed",
+			Success: false,
+			Error:   "User does not have access to this room",
+		}
+	}
+
+	hadMedia := webrtcManager.HasPeerConnection(client.ID)
+	fromX, fromY := client.position()
+	handleLeaveRoom(wsManager, client, from)
+
+	client.setPosition(x, y)
+	joined := handleJoinEvent(&eventRequest{wsManager: wsManager, client: client, roomID: target, message: req.message})
+	if !joined.Success {
+		// Access went away in between; go back rather than be nowhere.
+		log.Printf("User %s could not switch to room %s, returning to %s\n", client.ID, target, from)
+		client.setPosition(fromX, fromY)
+		if back := handleJoinEvent(&eventRequest{wsManager: wsManager, client: client, roomID: from, message: req.message}); back.Success {
+			client.SendMessage(back.Type, back)
+		}
+		return joined
+	}
+
+	client.SendMessage("room-switched", map[string]interface{}{
+		"from":             from,
+		"to":               target,
+		"renegotiateMedia": hadMedia,
+	})
+	return joined
+}
+
+// canSwitchTo checks the client may join the room: as a member who is not
+// banned and, for clients that connected with a personal access token,
+// with a token that is still good for that room.
+func canSwitchTo(client *Client, roomID string) bool {
+	if client.accessToken != "" {
+		if _, err := verifyAccessToken(client.accessToken, roomID); err != nil {
+			log.Printf("Access token of %s is not valid for room %s: %v\n", client.ID, roomID, err)
+			return false
+		}
+	}
+	access, err := fetchRoomAccess(client.ID, roomID)
+	if err != nil {
+		log.Printf("Error checking room access: %v", err)
+		return false
+	}
+	if access.Banned {
+		return false
+	}
+	_, isAuthorized := roles.Parse(access.Role)
+	return isAuthorized
+}
+
+func switchError(message string) types.Response {
+	return types.Response{
+		Type:    "switch-room-failed",
+		Success: false,
+		Error:   message,
+	}
+}
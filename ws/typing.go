@@ -0,0 +1,97 @@
+package ws
+
+import (
+	"time"
+
+	"go-gather/types"
+)
+
+// typingTimeout is how long an indicator lasts without a fresh
+// typing-start. Clients repeat typing-start while the user keeps typing.
+const typingTimeout = 6 * time.Second
+
+type typingKey struct {
+	userID  string
+	channel string
+}
+
+func typingEvent(userID, channel string, typing bool) map[string]interface{} {
+	return map[string]interface{}{
+		"userId":  publicID(userID),
+		"channel": channel,
+		"typing":  typing,
+	}
+}
+
+// StartTyping shows the user as typing in the channel until typingTimeout
+// passes without another call. Only the first call announces it to the
+// room; later ones just push the expiry back.
+func (ws *WebSocketManager) StartTyping(roomID, userID, channel string) {
+	key := typingKey{userID: userID, channel: channel}
+
+	ws.lock.Lock()
+	room, exists := ws.rooms[roomID]
+	if !exists {
+		ws.lock.Unlock()
+		return
+	}
+
+	previous, wasTyping := room.typing[key]
+	if wasTyping {
+		previous.Stop()
+	}
+
+	// The callback checks it is still the current timer, since Stop cannot
+	// prevent a callback that already started.
+	var timer *time.Timer
+	timer = time.AfterFunc(typingTimeout, func() {
+		ws.lock.Lock()
+		current := room.typing[key] == timer
+		if current {
+			delete(room.typing, key)
+		}
+		ws.lock.Unlock()
+
+		if current {
+			ws.SendToRoom(roomID, "typing", typingEvent(userID, channel, false))
+		}
+	})
+	room.typing[key] = timer
+	ws.lock.Unlock()
+
+	if !wasTyping {
+		ws.SendToRoom(roomID, "typing", typingEvent(userID, channel, true))
+	}
+}
+
+func (ws *WebSocketManager) StopTyping(roomID, userID, channel string) {
+	key := typingKey{userID: userID, channel: channel}
+
+	ws.lock.Lock()
+	room, exists := ws.rooms[roomID]
+	var timer *time.Timer
+	if exists {
+		timer = room.typing[key]
+		delete(room.typing, key)
+	}
+	ws.lock.Unlock()
+
+	if timer != nil {
+		timer.Stop()
+		ws.SendToRoom(roomID, "typing", typingEvent(userID, channel, false))
+	}
+}
+
+func handleTyping(req *eventRequest, data types.ChatData) types.Response {
+	wsManager, client, roomID := req.wsManager, req.client, req.roomID
+	channel := types.ChatChannel(data)
+
+	if req.message.Type == "typing-stop" {
+		wsManager.StopTyping(roomID, client.ID, channel)
+	} else if !wsManager.IsMuted(roomID, client.ID) {
+		wsManager.StartTyping(roomID, client.ID, channel)
+	}
+
+	// Other clients hear about it through the typing event.
+	return types.Response{}
+}